@@ -3,6 +3,7 @@ module github.com/develar/app-builder
 go 1.21
 
 require (
+	github.com/Masterminds/semver/v3 v3.3.1
 	github.com/aclements/go-rabin v0.0.0-20170911142644-d0b643ea1a4c
 	github.com/alecthomas/kingpin v2.2.6+incompatible
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751 // indirect
@@ -39,6 +40,7 @@ require (
 	golang.org/x/image v0.12.0 // indirect
 	golang.org/x/sys v0.12.0 // indirect
 	gopkg.in/alessio/shellescape.v1 v1.0.0-20170105083845-52074bc9df61
+	gopkg.in/yaml.v3 v3.0.1
 	howett.net/plist v1.0.0
 )
 
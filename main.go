@@ -51,6 +51,11 @@ func main() {
 
 	node_modules.ConfigureCommand(app)
 	node_modules.ConfigureRebuildCommand(app)
+	node_modules.ConfigureStageCommand(app)
+	node_modules.ConfigureLicensesCommand(app)
+	node_modules.ConfigureSbomCommand(app)
+	node_modules.ConfigureTreeDiffCommand(app)
+	node_modules.ConfigureAuditCommand(app)
 	//codesign.ConfigureCommand(app)
 	publisher.ConfigurePublishToS3Command(app)
 	remoteBuild.ConfigureBuildCommand(app)
@@ -76,6 +81,8 @@ func main() {
 	if err != nil {
 		util.LogErrorAndExit(err)
 	}
+	icons.ConfigureValidateIcnsCommand(app)
+	icons.ConfigureLintIconCommand(app)
 
 	dmg.ConfigureCommand(app)
 	blockmap.ConfigureCommand(app)
@@ -215,7 +215,7 @@ func (t *Downloader) follow(initialUrl, userAgent, outFileName string) (*ActualL
 				currentUrl = loc.String()
 				return nil, nil
 			} else if response.StatusCode != http.StatusOK {
-				return nil, fmt.Errorf("cannot resolve %s: status code %d", initialUrl, response.StatusCode)
+				return nil, &StatusCodeError{Url: initialUrl, StatusCode: response.StatusCode}
 			}
 
 			actualLocation := NewResolvedLocation(currentUrl, response.ContentLength, outFileName, response.Header.Get("Accept-Ranges") != "")
@@ -248,6 +248,16 @@ func (t *Downloader) follow(initialUrl, userAgent, outFileName string) (*ActualL
 	}
 }
 
+// server responded with neither success nor redirect
+type StatusCodeError struct {
+	Url        string
+	StatusCode int
+}
+
+func (e *StatusCodeError) Error() string {
+	return fmt.Sprintf("cannot resolve %s: status code %d", e.Url, e.StatusCode)
+}
+
 func isRedirect(status int) bool {
 	return status > 299 && status < 400
 }
@@ -0,0 +1,145 @@
+package electron
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/develar/app-builder/pkg/archive/zipx"
+	"github.com/develar/app-builder/pkg/fs"
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"github.com/develar/go-fs-util"
+	"go.uber.org/zap"
+)
+
+// electronDist is a custom Electron build (e.g. patched one) - unpacked dir or zip file, used instead of downloaded release
+func unpackElectronDist(config *ElectronDownloadOptions, outputDir string, excludedFiles map[string]bool) error {
+	dist := config.ElectronDist
+	distInfo, err := os.Stat(dist)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("electronDist %s doesn't exist", dist)
+		}
+		return errors.WithStack(err)
+	}
+
+	log.Info("using custom electron dist", zap.String("path", dist), zap.Bool("isDir", distInfo.IsDir()))
+
+	var distVersion string
+	if distInfo.IsDir() {
+		distVersion, err = readDistDirVersion(dist)
+	} else {
+		distVersion, err = readDistZipVersion(dist)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = checkDistVersion(config.Version, distVersion, dist)
+	if err != nil {
+		return err
+	}
+
+	if len(distVersion) != 0 {
+		config.Version = distVersion
+	}
+
+	err = fsutil.EnsureEmptyDir(outputDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if distInfo.IsDir() {
+		fileCopier := &fs.FileCopier{
+			IsUseHardLinks: true,
+			ExcludedFiles:  excludedFiles,
+		}
+		return errors.WithStack(fileCopier.CopyDirOrFile(dist, outputDir))
+	}
+	return zipx.Unzip(dist, outputDir, excludedFiles)
+}
+
+func checkDistVersion(expectedVersion string, distVersion string, dist string) error {
+	if len(expectedVersion) == 0 {
+		return nil
+	}
+
+	if len(distVersion) == 0 {
+		log.Warn("cannot check version of custom electron dist, version file not found", zap.String("path", dist), zap.String("expectedVersion", expectedVersion))
+		return nil
+	}
+
+	if isExactVersion(expectedVersion) {
+		if strings.TrimPrefix(expectedVersion, "v") != distVersion {
+			return errors.Errorf("version of electronDist %s (%s) doesn't match the expected version %s", dist, distVersion, expectedVersion)
+		}
+		return nil
+	}
+
+	constraint, err := semver.NewConstraint(expectedVersion)
+	if err != nil {
+		// dist-tag cannot be checked without releases index, and it doesn't make sense for custom build
+		log.Debug("version of custom electron dist is not checked", zap.String("expectedVersion", expectedVersion), zap.String("distVersion", distVersion))
+		return nil
+	}
+
+	version, err := semver.NewVersion(distVersion)
+	if err != nil {
+		return errors.Errorf("version of electronDist %s (%s) is not valid: %s", dist, distVersion, err.Error())
+	}
+
+	if !constraint.Check(version) {
+		return errors.Errorf("version of electronDist %s (%s) doesn't satisfy the expected version range %s", dist, distVersion, expectedVersion)
+	}
+	return nil
+}
+
+// empty string if there is no version file
+func readDistDirVersion(dir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "version"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", errors.WithStack(err)
+	}
+	return normalizeDistVersion(string(data)), nil
+}
+
+// empty string if there is no version file
+func readDistZipVersion(file string) (string, error) {
+	reader, err := zip.OpenReader(file)
+	if err != nil {
+		return "", errors.WithMessage(err, "cannot open electronDist "+file)
+	}
+
+	defer util.Close(reader)
+
+	for _, zipFile := range reader.File {
+		if zipFile.Name != "version" {
+			continue
+		}
+
+		entryReader, err := zipFile.Open()
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		data, err := io.ReadAll(entryReader)
+		util.Close(entryReader)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		return normalizeDistVersion(string(data)), nil
+	}
+	return "", nil
+}
+
+func normalizeDistVersion(version string) string {
+	return strings.TrimPrefix(strings.TrimSpace(version), "v")
+}
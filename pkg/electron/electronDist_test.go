@@ -0,0 +1,122 @@
+package electron
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/log"
+	. "github.com/onsi/gomega"
+)
+
+// unpacked linux dist layout, default_app.asar and version file are excluded from the output
+var distFiles = map[string]string{
+	"electron":                       "binary",
+	"version":                        "v31.2.1\n",
+	"resources/default_app.asar":     "asar",
+	"resources/inspector/.htaccess":  "htaccess",
+	"resources/inspector/index.html": "html",
+	"locales/en-US.pak":              "pak",
+}
+
+func createDistDir(g *GomegaWithT, dir string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		g.Expect(os.MkdirAll(filepath.Dir(file), 0755)).To(Succeed())
+		g.Expect(os.WriteFile(file, []byte(content), 0644)).To(Succeed())
+	}
+}
+
+func createDistZip(g *GomegaWithT, file string, files map[string]string) {
+	output, err := os.Create(file)
+	g.Expect(err).NotTo(HaveOccurred())
+	writer := zip.NewWriter(output)
+	for name, content := range files {
+		entry, err := writer.Create(name)
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = entry.Write([]byte(content))
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+	g.Expect(writer.Close()).To(Succeed())
+	g.Expect(output.Close()).To(Succeed())
+}
+
+func expectUnpackedDist(g *GomegaWithT, outputDir string) {
+	data, err := os.ReadFile(filepath.Join(outputDir, "electron"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(data)).To(Equal("binary"))
+	g.Expect(filepath.Join(outputDir, "locales", "en-US.pak")).To(BeARegularFile())
+	g.Expect(filepath.Join(outputDir, "resources", "inspector", "index.html")).To(BeARegularFile())
+
+	g.Expect(filepath.Join(outputDir, "version")).NotTo(BeAnExistingFile())
+	g.Expect(filepath.Join(outputDir, "resources", "default_app.asar")).NotTo(BeAnExistingFile())
+	g.Expect(filepath.Join(outputDir, "resources", "inspector", ".htaccess")).NotTo(BeAnExistingFile())
+}
+
+func TestUnpackElectronDistDir(t *testing.T) {
+	log.InitLogger()
+	g := NewGomegaWithT(t)
+
+	dist := filepath.Join(t.TempDir(), "dist")
+	createDistDir(g, dist, distFiles)
+
+	outputDir := filepath.Join(t.TempDir(), "out")
+	config := &ElectronDownloadOptions{Version: "^31.0.0", ElectronDist: dist}
+	g.Expect(unpackElectronDist(config, outputDir, createExcludedFiles(outputDir, "Electron.app"))).To(Succeed())
+	// range is replaced by the actual version of the dist
+	g.Expect(config.Version).To(Equal("31.2.1"))
+	expectUnpackedDist(g, outputDir)
+
+	version, err := readDistDirVersion(dist)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(version).To(Equal("31.2.1"))
+
+	err = unpackElectronDist(&ElectronDownloadOptions{Version: "30.0.0", ElectronDist: dist}, outputDir, nil)
+	g.Expect(err).To(MatchError(ContainSubstring("doesn't match the expected version 30.0.0")))
+}
+
+func TestUnpackElectronDistZip(t *testing.T) {
+	log.InitLogger()
+	g := NewGomegaWithT(t)
+
+	dist := filepath.Join(t.TempDir(), "electron.zip")
+	createDistZip(g, dist, distFiles)
+
+	version, err := readDistZipVersion(dist)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(version).To(Equal("31.2.1"))
+
+	outputDir := filepath.Join(t.TempDir(), "out")
+	config := &ElectronDownloadOptions{Version: "v31.2.1", ElectronDist: dist}
+	g.Expect(unpackElectronDist(config, outputDir, createExcludedFiles(outputDir, "Electron.app"))).To(Succeed())
+	g.Expect(config.Version).To(Equal("31.2.1"))
+	expectUnpackedDist(g, outputDir)
+
+	err = unpackElectronDist(&ElectronDownloadOptions{Version: "^30", ElectronDist: dist}, outputDir, nil)
+	g.Expect(err).To(MatchError(ContainSubstring("doesn't satisfy the expected version range ^30")))
+}
+
+func TestUnpackElectronDistWithoutVersion(t *testing.T) {
+	log.InitLogger()
+	g := NewGomegaWithT(t)
+
+	dist := filepath.Join(t.TempDir(), "electron.zip")
+	createDistZip(g, dist, map[string]string{"electron": "binary"})
+
+	version, err := readDistZipVersion(dist)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(version).To(BeEmpty())
+
+	// version cannot be checked, configured one is kept
+	outputDir := filepath.Join(t.TempDir(), "out")
+	config := &ElectronDownloadOptions{Version: "31.2.1", ElectronDist: dist}
+	g.Expect(unpackElectronDist(config, outputDir, nil)).To(Succeed())
+	g.Expect(config.Version).To(Equal("31.2.1"))
+	g.Expect(filepath.Join(outputDir, "electron")).To(BeARegularFile())
+
+	_, err = readDistZipVersion(filepath.Join(t.TempDir(), "missing.zip"))
+	g.Expect(err).To(HaveOccurred())
+	err = unpackElectronDist(&ElectronDownloadOptions{ElectronDist: filepath.Join(t.TempDir(), "missing")}, outputDir, nil)
+	g.Expect(err).To(MatchError(ContainSubstring("doesn't exist")))
+}
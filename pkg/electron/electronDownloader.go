@@ -25,6 +25,17 @@ type ElectronDownloadOptions struct {
 
 	CustomDir      string `json:"customDir"`
 	CustomFilename string `json:"customFilename"`
+
+	// custom Electron build - dir or zip file, used as is instead of download
+	ElectronDist string `json:"electronDist"`
+
+	// used to resolve version ranges and dist-tags, url or local file
+	ReleasesIndexUrl string `json:"releasesIndexUrl"`
+}
+
+type ElectronDownloadResult struct {
+	Version string `json:"version"`
+	File    string `json:"file"`
 }
 
 func ConfigureCommand(app *kingpin.Application) {
@@ -37,8 +48,16 @@ func ConfigureCommand(app *kingpin.Application) {
 			return err
 		}
 
-		_, err = downloadElectron(configs)
-		return err
+		files, err := downloadElectron(configs)
+		if err != nil {
+			return err
+		}
+
+		result := make([]ElectronDownloadResult, len(configs))
+		for index, config := range configs {
+			result[index] = ElectronDownloadResult{Version: config.Version, File: files[index]}
+		}
+		return util.WriteJsonToStdOut(result)
 	})
 }
 
@@ -51,18 +70,20 @@ func parseConfig(jsonConfig *string) ([]ElectronDownloadOptions, error) {
 	return configs, nil
 }
 
+// version of config is replaced by resolved one if range or dist-tag is specified
 func downloadElectron(configs []ElectronDownloadOptions) ([]string, error) {
+	err := ResolveVersions(configs)
+	if err != nil {
+		return nil, err
+	}
+
 	result := make([]string, len(configs))
 	return result, util.MapAsync(len(configs), func(taskIndex int) (func() error, error) {
 		config := configs[taskIndex]
 		return func() error {
-			cacheDir := config.CacheDir
-			if cacheDir == "" {
-				var err error
-				cacheDir, err = download.GetCacheDirectory("electron", "ELECTRON_CACHE", false)
-				if err != nil {
-					return err
-				}
+			cacheDir, err := getCacheDir(&config)
+			if err != nil {
+				return err
 			}
 
 			electronDownloader := &ElectronDownloader{
@@ -82,7 +103,15 @@ func downloadElectron(configs []ElectronDownloadOptions) ([]string, error) {
 	})
 }
 
-func getBaseUrl(config *ElectronDownloadOptions) string {
+func getCacheDir(config *ElectronDownloadOptions) (string, error) {
+	if config.CacheDir != "" {
+		return config.CacheDir, nil
+	}
+	return download.GetCacheDirectory("electron", "ELECTRON_CACHE", false)
+}
+
+// empty string if mirror is not configured
+func getMirror(config *ElectronDownloadOptions) string {
 	v := config.Mirror
 	if len(v) == 0 {
 		v = os.Getenv("NPM_CONFIG_ELECTRON_MIRROR")
@@ -93,6 +122,11 @@ func getBaseUrl(config *ElectronDownloadOptions) string {
 	if len(v) == 0 {
 		v = os.Getenv("ELECTRON_MIRROR")
 	}
+	return v
+}
+
+func getBaseUrl(config *ElectronDownloadOptions) string {
+	v := getMirror(config)
 	if len(v) == 0 {
 		if strings.Contains(config.Version, "-nightly.") {
 			v = "https://github.com/electron/nightlies/releases/download/"
@@ -26,11 +26,23 @@ func ConfigureUnpackCommand(app *kingpin.Application) {
 		if err != nil {
 			return err
 		}
-		return UnpackElectron(configs, *outputDir, *distMacOsAppName, true)
+		err = UnpackElectron(configs, *outputDir, *distMacOsAppName, true)
+		if err != nil {
+			return err
+		}
+		return util.WriteJsonToStdOut(ElectronDownloadResult{Version: configs[0].Version})
 	})
 }
 
 func UnpackElectron(configs []ElectronDownloadOptions, outputDir string, distMacOsAppName string, isReDownloadOnFileReadError bool) error {
+	if len(distMacOsAppName) == 0 {
+		distMacOsAppName = "Electron.app"
+	}
+
+	if len(configs) != 0 && len(configs[0].ElectronDist) != 0 {
+		return unpackElectronDist(&configs[0], outputDir, createExcludedFiles(outputDir, distMacOsAppName))
+	}
+
 	cachedElectronZip := make(chan string, 1)
 	err := util.MapAsync(2, func(taskIndex int) (func() error, error) {
 		if taskIndex == 0 {
@@ -54,18 +66,7 @@ func UnpackElectron(configs []ElectronDownloadOptions, outputDir string, distMac
 		return err
 	}
 
-	if len(distMacOsAppName) == 0 {
-		distMacOsAppName = "Electron.app"
-	}
-
-	excludedFiles := make(map[string]bool)
-	excludedFiles[filepath.Join(outputDir, distMacOsAppName, "Contents", "Resources", "default_app.asar")] = true
-	excludedFiles[filepath.Join(outputDir, "resources", "default_app.asar")] = true
-
-	excludedFiles[filepath.Join(outputDir, distMacOsAppName, "Contents", "Resources", "inspector", ".htaccess")] = true
-	excludedFiles[filepath.Join(outputDir, "resources", "inspector", ".htaccess")] = true
-
-	excludedFiles[filepath.Join(outputDir, "version")] = true
+	excludedFiles := createExcludedFiles(outputDir, distMacOsAppName)
 
 	zipFile := <-cachedElectronZip
 	err = zipx.Unzip(zipFile, outputDir, excludedFiles)
@@ -86,3 +87,15 @@ func UnpackElectron(configs []ElectronDownloadOptions, outputDir string, distMac
 
 	return nil
 }
+
+func createExcludedFiles(outputDir string, distMacOsAppName string) map[string]bool {
+	excludedFiles := make(map[string]bool)
+	excludedFiles[filepath.Join(outputDir, distMacOsAppName, "Contents", "Resources", "default_app.asar")] = true
+	excludedFiles[filepath.Join(outputDir, "resources", "default_app.asar")] = true
+
+	excludedFiles[filepath.Join(outputDir, distMacOsAppName, "Contents", "Resources", "inspector", ".htaccess")] = true
+	excludedFiles[filepath.Join(outputDir, "resources", "inspector", ".htaccess")] = true
+
+	excludedFiles[filepath.Join(outputDir, "version")] = true
+	return excludedFiles
+}
@@ -0,0 +1,227 @@
+package electron
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/develar/app-builder/pkg/download"
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"github.com/develar/go-fs-util"
+	"github.com/json-iterator/go"
+	"go.uber.org/zap"
+)
+
+const defaultReleasesIndexUrl = "https://releases.electronjs.org/releases.json"
+
+// cached index is used as is if not older than this duration, otherwise downloaded again (stale copy is used only if download failed)
+const releasesIndexMaxAge = time.Hour
+
+type ElectronRelease struct {
+	Version string `json:"version"`
+	Date    string `json:"date"`
+	Node    string `json:"node"`
+	Modules string `json:"modules"`
+}
+
+// exact version (with or without "v" prefix) doesn't require releases index
+func isExactVersion(version string) bool {
+	_, err := semver.StrictNewVersion(strings.TrimPrefix(version, "v"))
+	return err == nil
+}
+
+// resolve version ranges ("^30", "~31.2") and dist-tags ("latest", "beta", "alpha", "nightly") in place
+func ResolveVersions(configs []ElectronDownloadOptions) error {
+	var releases []ElectronRelease
+	for index := range configs {
+		config := &configs[index]
+		if len(config.Version) == 0 || isExactVersion(config.Version) {
+			continue
+		}
+
+		if releases == nil {
+			var err error
+			releases, err = loadReleasesIndex(config)
+			if err != nil {
+				return err
+			}
+		}
+
+		version, err := resolveVersion(config.Version, releases)
+		if err != nil {
+			return err
+		}
+
+		log.Info("electron version resolved", zap.String("spec", config.Version), zap.String("version", version))
+		config.Version = version
+	}
+	return nil
+}
+
+func resolveVersion(spec string, releases []ElectronRelease) (string, error) {
+	spec = strings.TrimSpace(spec)
+
+	var isMatched func(version *semver.Version) bool
+	switch spec {
+	case "latest", "stable":
+		isMatched = func(version *semver.Version) bool {
+			return len(version.Prerelease()) == 0
+		}
+
+	case "beta", "alpha", "nightly":
+		isMatched = func(version *semver.Version) bool {
+			prerelease := version.Prerelease()
+			return prerelease == spec || strings.HasPrefix(prerelease, spec+".")
+		}
+
+	default:
+		constraint, err := semver.NewConstraint(spec)
+		if err != nil {
+			return "", errors.Errorf("electron version %s is neither exact version, nor range, nor dist-tag: %s", spec, err.Error())
+		}
+		isMatched = constraint.Check
+	}
+
+	var result *semver.Version
+	for _, release := range releases {
+		version, err := semver.NewVersion(release.Version)
+		if err != nil {
+			log.Debug("skip release with invalid version", zap.String("version", release.Version))
+			continue
+		}
+
+		if isMatched(version) && (result == nil || version.GreaterThan(result)) {
+			result = version
+		}
+	}
+
+	if result == nil {
+		return "", errors.Errorf("no electron release matches %s", spec)
+	}
+	return result.Original(), nil
+}
+
+// ReleasesIndexUrl from configuration, ELECTRON_RELEASES_INDEX_URL env, releases.json on the custom mirror or the official index
+func getReleasesIndexUrl(config *ElectronDownloadOptions) string {
+	v := config.ReleasesIndexUrl
+	if len(v) == 0 {
+		v = os.Getenv("ELECTRON_RELEASES_INDEX_URL")
+	}
+	if len(v) == 0 {
+		v = getMirrorReleasesIndexUrl(config)
+	}
+	if len(v) == 0 {
+		v = defaultReleasesIndexUrl
+	}
+	return v
+}
+
+// releases.json in the root of the mirror, empty if mirror is not set
+func getMirrorReleasesIndexUrl(config *ElectronDownloadOptions) string {
+	mirror := getMirror(config)
+	if len(mirror) == 0 {
+		return ""
+	}
+
+	// legacy mirror layout with version prefix segment (https://example.com/electron/v)
+	mirror = strings.TrimSuffix(strings.TrimSuffix(mirror, "/"), "/v")
+	return mirror + "/releases.json"
+}
+
+// local file path or file:// url is used as is (not cached)
+func toLocalFile(indexUrl string) string {
+	if strings.HasPrefix(indexUrl, "file://") {
+		parsed, err := url.Parse(indexUrl)
+		if err == nil {
+			return filepath.FromSlash(parsed.Path)
+		}
+	}
+	if !strings.Contains(indexUrl, "://") {
+		return indexUrl
+	}
+	return ""
+}
+
+func loadReleasesIndex(config *ElectronDownloadOptions) ([]ElectronRelease, error) {
+	indexUrl := getReleasesIndexUrl(config)
+	localFile := toLocalFile(indexUrl)
+	if len(localFile) != 0 {
+		return readReleasesIndex(localFile)
+	}
+
+	cacheDir, err := getCacheDir(config)
+	if err != nil {
+		return nil, err
+	}
+
+	cachedFile := filepath.Join(cacheDir, "releases.json")
+	fileInfo, err := os.Stat(cachedFile)
+	if err == nil && time.Since(fileInfo.ModTime()) < releasesIndexMaxAge {
+		releases, err := readReleasesIndex(cachedFile)
+		if err == nil {
+			return releases, nil
+		}
+		log.Debug("cannot read cached electron releases index", zap.Error(err))
+	}
+
+	err = downloadReleasesIndex(indexUrl, cacheDir, cachedFile)
+	if isNotFoundError(err) && indexUrl == getMirrorReleasesIndexUrl(config) {
+		// user-run mirror is not required to provide releases index
+		log.Warn("electron releases index not found on the mirror, official index is used", zap.String("url", indexUrl), zap.String("fallbackUrl", defaultReleasesIndexUrl))
+		indexUrl = defaultReleasesIndexUrl
+		err = downloadReleasesIndex(indexUrl, cacheDir, cachedFile)
+	}
+	if err != nil {
+		if fileInfo == nil {
+			return nil, err
+		}
+		log.Warn("cannot download electron releases index, cached copy is used", zap.String("url", indexUrl), zap.Error(err))
+	}
+	return readReleasesIndex(cachedFile)
+}
+
+func downloadReleasesIndex(indexUrl string, cacheDir string, cachedFile string) error {
+	err := fsutil.EnsureDir(cacheDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tempFile, err := util.TempFile(cacheDir, ".json")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = download.NewDownloader().Download(indexUrl, tempFile, "")
+	if err != nil {
+		_ = os.Remove(tempFile)
+		return err
+	}
+
+	// cannot use RenameToFinalFile - existing (stale) file must be replaced
+	return errors.WithStack(os.Rename(tempFile, cachedFile))
+}
+
+func isNotFoundError(err error) bool {
+	statusCodeError, ok := errors.Cause(err).(*download.StatusCodeError)
+	return ok && statusCodeError.StatusCode == http.StatusNotFound
+}
+
+func readReleasesIndex(file string) ([]ElectronRelease, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var releases []ElectronRelease
+	err = jsoniter.Unmarshal(data, &releases)
+	if err != nil {
+		return nil, errors.WithMessage(err, "cannot parse electron releases index "+file)
+	}
+	return releases, nil
+}
@@ -0,0 +1,81 @@
+package electron
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/log"
+	. "github.com/onsi/gomega"
+)
+
+func TestResolveVersion(t *testing.T) {
+	log.InitLogger()
+	g := NewGomegaWithT(t)
+
+	indexFile, err := filepath.Abs(filepath.Join("..", "..", "testData", "electron-releases.json"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	configs := []ElectronDownloadOptions{
+		{Version: "^30", ReleasesIndexUrl: indexFile},
+		{Version: "~31.2", ReleasesIndexUrl: indexFile},
+		{Version: "latest", ReleasesIndexUrl: indexFile},
+		{Version: "beta", ReleasesIndexUrl: indexFile},
+		{Version: "nightly", ReleasesIndexUrl: indexFile},
+		{Version: "31.0.0", ReleasesIndexUrl: indexFile},
+	}
+	err = ResolveVersions(configs)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	versions := make([]string, len(configs))
+	for index, config := range configs {
+		versions[index] = config.Version
+	}
+	g.Expect(versions).To(Equal([]string{"30.3.1", "31.2.1", "31.2.1", "32.0.0-beta.2", "33.0.0-nightly.20240826", "31.0.0"}))
+
+	err = ResolveVersions([]ElectronDownloadOptions{{Version: "^40", ReleasesIndexUrl: "file://" + filepath.ToSlash(indexFile)}})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestReleasesIndexUrl(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	t.Setenv("ELECTRON_MIRROR", "")
+	t.Setenv("NPM_CONFIG_ELECTRON_MIRROR", "")
+	t.Setenv("npm_config_electron_mirror", "")
+	t.Setenv("ELECTRON_RELEASES_INDEX_URL", "")
+
+	g.Expect(getReleasesIndexUrl(&ElectronDownloadOptions{})).To(Equal(defaultReleasesIndexUrl))
+	g.Expect(getReleasesIndexUrl(&ElectronDownloadOptions{Mirror: "https://npmmirror.com/mirrors/electron/"})).To(Equal("https://npmmirror.com/mirrors/electron/releases.json"))
+	g.Expect(getReleasesIndexUrl(&ElectronDownloadOptions{Mirror: "https://example.com/electron", ReleasesIndexUrl: "/tmp/releases.json"})).To(Equal("/tmp/releases.json"))
+	// legacy mirror layout with version prefix segment
+	g.Expect(getReleasesIndexUrl(&ElectronDownloadOptions{Mirror: "https://example.com/electron/v"})).To(Equal("https://example.com/electron/releases.json"))
+	g.Expect(getReleasesIndexUrl(&ElectronDownloadOptions{Mirror: "https://example.com/electron/v/"})).To(Equal("https://example.com/electron/releases.json"))
+}
+
+func TestDownloadMissingReleasesIndex(t *testing.T) {
+	log.InitLogger()
+	g := NewGomegaWithT(t)
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	cachedFile := filepath.Join(cacheDir, "releases.json")
+	err := downloadReleasesIndex(server.URL+"/releases.json", cacheDir, cachedFile)
+	g.Expect(isNotFoundError(err)).To(BeTrue())
+	g.Expect(cachedFile).NotTo(BeAnExistingFile())
+	g.Expect(isNotFoundError(nil)).To(BeFalse())
+}
+
+func TestCheckDistVersion(t *testing.T) {
+	log.InitLogger()
+	g := NewGomegaWithT(t)
+
+	g.Expect(checkDistVersion("v30.1.0", "30.1.0", "dist")).NotTo(HaveOccurred())
+	g.Expect(checkDistVersion("31.0.0", "30.1.0", "dist")).To(HaveOccurred())
+	g.Expect(checkDistVersion("^30", "30.1.0", "dist")).NotTo(HaveOccurred())
+	g.Expect(checkDistVersion("^31", "30.1.0", "dist")).To(HaveOccurred())
+	g.Expect(checkDistVersion("31.0.0", "", "dist")).NotTo(HaveOccurred())
+}
@@ -17,6 +17,8 @@ var bufferPool = bpool.NewBytePool(runtime.NumCPU(), 64*1024)
 
 type FileCopier struct {
 	IsUseHardLinks bool
+	// destination paths that must be not copied
+	ExcludedFiles map[string]bool
 }
 
 // go doesn't provide native copy operation (CoW)
@@ -65,6 +67,10 @@ func (t *FileCopier) CopyDirOrFile(from string, to string) error {
 }
 
 func (t *FileCopier) copyDirOrFile(from string, to string, isCreateParentDirs bool) error {
+	if t.ExcludedFiles != nil && t.ExcludedFiles[to] {
+		return nil
+	}
+
 	fromInfo, err := os.Lstat(from)
 	if err != nil {
 		return errors.WithStack(err)
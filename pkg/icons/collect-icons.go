@@ -30,7 +30,11 @@ func CollectIcons(sourceDir string) ([]IconInfo, string, error) {
 	var result []IconInfo
 	re := regexp.MustCompile("[0-9]+")
 	var iconFilename string
-	sizeToFileName := make(map[int]*IconInfo)
+	type sizeKey struct {
+		size     int
+		isRetina bool
+	}
+	sizeToFileName := make(map[sizeKey]*IconInfo)
 	for _, name := range files {
 		if !(strings.HasSuffix(name, ".png") || strings.HasSuffix(name, ".PNG")) {
 			continue
@@ -50,9 +54,15 @@ func CollectIcons(sourceDir string) ([]IconInfo, string, error) {
 			return nil, "", errors.WithStack(err)
 		}
 
+		isRetina := strings.Contains(name, "@2x")
+		if isRetina {
+			size *= 2
+		}
+
 		iconPath := filepath.Join(sourceDir, name)
 
-		existing := sizeToFileName[size]
+		key := sizeKey{size, isRetina}
+		existing := sizeToFileName[key]
 		if existing != nil {
 			// 16x16.png vs 16x16-dev.png - select shorter name
 			if len(name) >= len(filepath.Base(existing.File)) {
@@ -63,8 +73,8 @@ func CollectIcons(sourceDir string) ([]IconInfo, string, error) {
 			}
 		}
 
-		iconInfo := IconInfo{iconPath, size}
-		sizeToFileName[size] = &iconInfo
+		iconInfo := IconInfo{File: iconPath, Size: size, isRetina: isRetina}
+		sizeToFileName[key] = &iconInfo
 		result = append(result, iconInfo)
 	}
 
@@ -80,3 +90,22 @@ func CollectIcons(sourceDir string) ([]IconInfo, string, error) {
 	sortBySize(result)
 	return result, "", nil
 }
+
+// @2x image is used only if there is no 1x image of the same size in pixels
+func removeRetinaDuplicates(icons []IconInfo) []IconInfo {
+	sizes := make(map[int]bool)
+	for _, icon := range icons {
+		if !icon.isRetina {
+			sizes[icon.Size] = true
+		}
+	}
+
+	var result []IconInfo
+	for _, icon := range icons {
+		if !icon.isRetina || !sizes[icon.Size] {
+			result = append(result, icon)
+			sizes[icon.Size] = true
+		}
+	}
+	return result
+}
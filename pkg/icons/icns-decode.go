@@ -0,0 +1,263 @@
+package icons
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/develar/app-builder/pkg/jpeg2000"
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"go.uber.org/zap"
+)
+
+// 8-bit masks of legacy RLE compressed RGB types
+var legacyIcnsMaskTypes = map[string]string{
+	"is32": "s8mk",
+	"il32": "l8mk",
+	"ih32": "h8mk",
+	"it32": "t8mk",
+}
+
+// returns data of image entries by type, TOC and metadata entries are skipped
+func readIcnsEntries(data []byte) (map[string][]byte, error) {
+	if len(data) < 8 || !bytes.Equal(data[:4], icnsHeader) {
+		return nil, errors.New("not an ICNS file")
+	}
+
+	result := make(map[string][]byte)
+	for offset := 8; offset+8 <= len(data); {
+		osType := string(data[offset : offset+4])
+		length := int(binary.BigEndian.Uint32(data[offset+4:]))
+		if length < 8 || offset+length > len(data) {
+			return nil, errors.Errorf("entry %s at %d exceeds file", osType, offset)
+		}
+
+		switch osType {
+		case "TOC ", "info", "name", "icnV":
+		default:
+			result[osType] = data[offset+8 : offset+length]
+		}
+		offset += length
+	}
+	return result, nil
+}
+
+// size in pixels of entry that can be decoded, 0 if type is not supported (1-bit, 4-bit and 8-bit palette icons and masks)
+func getIcnsEntrySize(osType string) int {
+	if entryType := findIcnsEntryType(osType); entryType != nil {
+		return entryType.size
+	}
+	if _, ok := legacyIcnsMaskTypes[osType]; ok {
+		return legacyIcnsTypeToSize[osType]
+	}
+	return 0
+}
+
+// Decodes PNG, JPEG 2000, RLE compressed ARGB and legacy RLE compressed RGB (alpha is taken from the corresponding 8-bit mask entry).
+func decodeIcnsEntry(osType string, entries map[string][]byte) (image.Image, error) {
+	data := entries[osType]
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		result, err := png.Decode(bytes.NewReader(data))
+		return result, errors.WithStack(err)
+
+	case bytes.HasPrefix(data, []byte("\x00\x00\x00\x0cjP  \r\n\x87\n")) || bytes.HasPrefix(data, []byte{0xff, 0x4f, 0xff, 0x51}):
+		return decodeJpeg2000(data)
+
+	case bytes.HasPrefix(data, []byte("ARGB")):
+		size := getIcnsEntrySize(osType)
+		if size == 0 {
+			return nil, errors.Errorf("size of ARGB entry %s is unknown", osType)
+		}
+		return decodeIcnsArgb(data[4:], size)
+	}
+
+	if maskType, ok := legacyIcnsMaskTypes[osType]; ok {
+		return decodeLegacyIcnsRgb(osType, data, entries[maskType])
+	}
+	return nil, errors.Errorf("format of entry %s is not supported", osType)
+}
+
+// reverse of encodeIcnsArgb
+func decodeIcnsArgb(data []byte, size int) (image.Image, error) {
+	channels, _, err := unpackIcnsRle(data, size*size*4)
+	if err != nil {
+		return nil, err
+	}
+
+	result := image.NewNRGBA(image.Rect(0, 0, size, size))
+	pixelCount := size * size
+	for i := 0; i < pixelCount; i++ {
+		pixel := result.Pix[i*4 : i*4+4 : i*4+4]
+		pixel[0] = channels[pixelCount+i]
+		pixel[1] = channels[2*pixelCount+i]
+		pixel[2] = channels[3*pixelCount+i]
+		pixel[3] = channels[i]
+	}
+	return result, nil
+}
+
+// red, green and blue channels are compressed separately, it32 data starts with 4 zero bytes, mask is uncompressed
+func decodeLegacyIcnsRgb(osType string, data []byte, mask []byte) (image.Image, error) {
+	size := legacyIcnsTypeToSize[osType]
+	pixelCount := size * size
+
+	var channels []byte
+	if len(data) == pixelCount*4 {
+		// uncompressed, first byte of pixel is not used
+		channels = make([]byte, pixelCount*3)
+		for i := 0; i < pixelCount; i++ {
+			channels[i] = data[i*4+1]
+			channels[pixelCount+i] = data[i*4+2]
+			channels[2*pixelCount+i] = data[i*4+3]
+		}
+	} else {
+		if osType == "it32" && len(data) >= 4 && binary.BigEndian.Uint32(data) == 0 {
+			data = data[4:]
+		}
+
+		var err error
+		channels, _, err = unpackIcnsRle(data, pixelCount*3)
+		if err != nil {
+			return nil, errors.WithMessage(err, "cannot decode "+osType)
+		}
+	}
+
+	if len(mask) != pixelCount {
+		mask = nil
+	}
+
+	result := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for i := 0; i < pixelCount; i++ {
+		pixel := result.Pix[i*4 : i*4+4 : i*4+4]
+		pixel[0] = channels[i]
+		pixel[1] = channels[pixelCount+i]
+		pixel[2] = channels[2*pixelCount+i]
+		if mask == nil {
+			pixel[3] = 0xff
+		} else {
+			pixel[3] = mask[i]
+		}
+	}
+	return result, nil
+}
+
+// opj_decompress is used only if JPEG 2000 image uses features not supported by Go decoder
+func decodeJpeg2000(data []byte) (image.Image, error) {
+	result, err := jpeg2000.Decode(bytes.NewReader(data))
+	if _, ok := err.(jpeg2000.UnsupportedError); ok {
+		log.Debug("JPEG 2000 image cannot be decoded in Go, opj_decompress is used", zap.Error(err))
+		return decodeJpeg2000UsingOpenJpeg(data)
+	}
+	return result, errors.WithStack(err)
+}
+
+// the first entry suitable for ico, otherwise the biggest one
+func loadIcnsImage(file string) (image.Image, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	entries, err := readIcnsEntries(data)
+	if err != nil {
+		return nil, errors.WithMessage(err, file)
+	}
+
+	for _, osType := range icnsTypesForIco {
+		if _, ok := entries[osType]; ok {
+			return decodeIcnsEntry(osType, entries)
+		}
+	}
+
+	maxSize := 0
+	sizeToType := selectIcnsEntries(entries, file)
+	for size := range sizeToType {
+		maxSize = max(maxSize, size)
+	}
+	if maxSize == 0 {
+		return nil, NewImageSizeError(file, 256)
+	}
+	return decodeIcnsEntry(sizeToType[maxSize], entries)
+}
+
+// type to decode for each size, 1x modern types are preferred over retina and legacy ones
+func selectIcnsEntries(entries map[string][]byte, file string) map[int]string {
+	rank := func(osType string) int {
+		if entryType := findIcnsEntryType(osType); entryType != nil {
+			if entryType.isRetina {
+				return 1
+			}
+			return 0
+		}
+		return 2
+	}
+
+	result := make(map[int]string)
+	for osType := range entries {
+		size := getIcnsEntrySize(osType)
+		if size == 0 {
+			log.Debug("skip unsupported icns sub image format", zap.String("type", osType), zap.String("file", file))
+			continue
+		}
+
+		existing, ok := result[size]
+		if !ok || rank(osType) < rank(existing) || (rank(osType) == rank(existing) && osType < existing) {
+			result[size] = osType
+		}
+	}
+	return result
+}
+
+// Decodes the best entry of each size (PNG entries are written as is) without external tools, opj_decompress is used only as a fallback for unsupported JPEG 2000 features.
+func ConvertIcnsToPngUsingGo(icnsPath string, outDir string) ([]IconInfo, error) {
+	data, err := os.ReadFile(icnsPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	entries, err := readIcnsEntries(data)
+	if err != nil {
+		return nil, errors.WithMessage(err, icnsPath)
+	}
+
+	sizeToType := selectIcnsEntries(entries, icnsPath)
+	sizes := make([]int, 0, len(sizeToType))
+	for size := range sizeToType {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+
+	outFileNamePrefix := filepath.Join(outDir, strings.TrimSuffix(filepath.Base(icnsPath), filepath.Ext(icnsPath))) + "_"
+	result := make([]IconInfo, len(sizes))
+	err = util.MapAsync(len(sizes), func(taskIndex int) (func() error, error) {
+		size := sizes[taskIndex]
+		osType := sizeToType[size]
+		outFile := fmt.Sprintf("%s%d.png", outFileNamePrefix, size)
+		result[taskIndex] = IconInfo{File: outFile, Size: size}
+		return func() error {
+			entryData := entries[osType]
+			if bytes.HasPrefix(entryData, []byte("\x89PNG\r\n\x1a\n")) {
+				return errors.WithStack(os.WriteFile(outFile, entryData, 0644))
+			}
+
+			decoded, err := decodeIcnsEntry(osType, entries)
+			if err != nil {
+				return errors.WithMessagef(err, "cannot decode %s of %s", osType, icnsPath)
+			}
+			return SaveImage(decoded, outFile, PNG)
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -44,7 +44,7 @@ func ConvertIcnsToPng(inFile string, outDir string) ([]IconInfo, error) {
 			return nil, errors.WithStack(err)
 		}
 	} else {
-		result, err = ConvertIcnsToPngUsingOpenJpeg(inFile, outDir)
+		result, err = ConvertIcnsToPngUsingGo(inFile, outDir)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
@@ -90,7 +90,7 @@ func ConvertIcnsToPngUsingIconUtil(inFile string, outDir string, sizeList *[]int
 	for _, item := range icnsTypeToSize {
 		fileName := fmt.Sprintf("icon_%dx%d.png", item.Size, item.Size)
 		if util.ContainsString(iconFileNames, fileName) {
-			result = append(result, IconInfo{filepath.Join(outDir, fileName), item.Size})
+			result = append(result, IconInfo{File: filepath.Join(outDir, fileName), Size: item.Size})
 		} else {
 			*sizeList = append(*sizeList, item.Size)
 		}
@@ -0,0 +1,248 @@
+package icons
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/png"
+	"os"
+	"strings"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+)
+
+type IcnsEntryInfo struct {
+	Type   string `json:"type"`
+	Format string `json:"format"`
+	Length int    `json:"length"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+type IcnsProblem struct {
+	Code    string `json:"code"`
+	Type    string `json:"type,omitempty"`
+	Message string `json:"message"`
+}
+
+type IcnsValidationReport struct {
+	File     string          `json:"file"`
+	Entries  []IcnsEntryInfo `json:"entries"`
+	Problems []IcnsProblem   `json:"problems"`
+}
+
+// legacy types, RLE compressed RGB and 8-bit masks
+var legacyIcnsTypeToSize = map[string]int{
+	"is32": 16,
+	"s8mk": 16,
+	"il32": 32,
+	"l8mk": 32,
+	"ih32": 48,
+	"h8mk": 48,
+	"it32": 128,
+	"t8mk": 128,
+}
+
+func ConfigureValidateIcnsCommand(app *kingpin.Application) {
+	command := app.Command("icns-validate", "check that ICNS has TOC and all modern types and entries have expected size")
+	input := command.Flag("input", "ICNS file").Short('i').Required().String()
+
+	command.Action(func(context *kingpin.ParseContext) error {
+		report, err := ValidateIcns(*input)
+		if err != nil {
+			return err
+		}
+
+		err = util.WriteJsonToStdOut(report)
+		if err != nil {
+			return err
+		}
+
+		if len(report.Problems) != 0 {
+			var codes []string
+			for _, problem := range report.Problems {
+				codes = append(codes, problem.Code+" "+problem.Type)
+			}
+			return util.NewMessageError(fmt.Sprintf("%d problems found in %s: %s", len(report.Problems), *input, strings.Join(codes, ", ")), "ERR_ICNS_INVALID")
+		}
+		return nil
+	})
+}
+
+func ValidateIcns(file string) (*IcnsValidationReport, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(data) < 8 || !bytes.Equal(data[:4], icnsHeader) {
+		return nil, errors.WithStack(&ImageFormatError{file, "ERR_ICON_UNKNOWN_FORMAT"})
+	}
+
+	report := &IcnsValidationReport{File: file, Entries: make([]IcnsEntryInfo, 0), Problems: make([]IcnsProblem, 0)}
+	addProblem := func(code string, osType string, format string, args ...interface{}) {
+		report.Problems = append(report.Problems, IcnsProblem{Code: code, Type: osType, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if declaredLength := int(binary.BigEndian.Uint32(data[4:])); declaredLength != len(data) {
+		addProblem("ICNS_LENGTH_MISMATCH", "", "header declares length %d, but file length is %d", declaredLength, len(data))
+	}
+
+	var toc []byte
+	var tocEntries []IcnsEntryInfo
+	present := make(map[string]bool)
+	for offset := 8; offset < len(data); {
+		if offset+8 > len(data) {
+			addProblem("ICNS_TRUNCATED", "", "incomplete entry header at %d", offset)
+			break
+		}
+
+		osType := string(data[offset : offset+4])
+		length := int(binary.BigEndian.Uint32(data[offset+4:]))
+		if length < 8 || offset+length > len(data) {
+			addProblem("ICNS_TRUNCATED", osType, "entry length %d at %d exceeds file", length, offset)
+			break
+		}
+
+		entryData := data[offset+8 : offset+length]
+		offset += length
+
+		if present[osType] {
+			addProblem("ICNS_DUPLICATE_ENTRY", osType, "entry %s is specified several times", osType)
+		}
+		present[osType] = true
+
+		switch osType {
+		case "TOC ":
+			toc = entryData
+			continue
+		case "info", "name", "icnV":
+			continue
+		}
+
+		entry := inspectIcnsEntry(osType, entryData)
+		report.Entries = append(report.Entries, entry)
+		tocEntries = append(tocEntries, entry)
+
+		expectedSize := legacyIcnsTypeToSize[osType]
+		if entryType := findIcnsEntryType(osType); entryType != nil {
+			expectedSize = entryType.size
+		}
+
+		switch {
+		case entry.Format == "unknown":
+			addProblem("ICNS_ENTRY_INVALID", osType, "entry %s has unknown format", osType)
+		case entry.Format == "argb" && entry.Width == 0:
+			addProblem("ICNS_ENTRY_INVALID", osType, "entry %s is not valid RLE compressed ARGB image of size %d", osType, expectedSize)
+		case expectedSize != 0 && entry.Width != 0 && (entry.Width != expectedSize || entry.Height != expectedSize):
+			addProblem("ICNS_ENTRY_SIZE_MISMATCH", osType, "entry %s must be %dx%d, but it is %dx%d", osType, expectedSize, expectedSize, entry.Width, entry.Height)
+		}
+	}
+
+	for _, entryType := range icnsEntryTypes {
+		if !present[entryType.osType] {
+			addProblem("ICNS_ENTRY_MISSING", entryType.osType, "entry %s (%dx%d) is missing", entryType.osType, entryType.size, entryType.size)
+		}
+	}
+
+	if !present["TOC "] {
+		addProblem("ICNS_TOC_MISSING", "TOC ", "table of contents is missing")
+	} else {
+		var expected []byte
+		for _, entry := range tocEntries {
+			expected = append(expected, entry.Type...)
+			expected = binary.BigEndian.AppendUint32(expected, uint32(entry.Length+8))
+		}
+		if !bytes.Equal(toc, expected) {
+			addProblem("ICNS_TOC_MISMATCH", "TOC ", "table of contents doesn't match entries")
+		}
+	}
+	return report, nil
+}
+
+func inspectIcnsEntry(osType string, data []byte) IcnsEntryInfo {
+	result := IcnsEntryInfo{Type: osType, Length: len(data), Format: "unknown"}
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		result.Format = "png"
+		config, err := png.DecodeConfig(bytes.NewReader(data))
+		if err == nil {
+			result.Width, result.Height = config.Width, config.Height
+		} else {
+			result.Format = "unknown"
+		}
+
+	case bytes.HasPrefix(data, []byte("\x00\x00\x00\x0cjP  \r\n\x87\n")):
+		result.Format = "jpeg2000"
+		// image header box: height, width
+		if index := bytes.Index(data, []byte("ihdr")); index >= 0 && index+12 <= len(data) {
+			result.Height = int(binary.BigEndian.Uint32(data[index+4:]))
+			result.Width = int(binary.BigEndian.Uint32(data[index+8:]))
+		}
+
+	case bytes.HasPrefix(data, []byte{0xff, 0x4f, 0xff, 0x51}) && len(data) >= 24:
+		result.Format = "jpeg2000"
+		// SIZ marker segment: image size minus image offset
+		result.Width = int(binary.BigEndian.Uint32(data[8:]) - binary.BigEndian.Uint32(data[16:]))
+		result.Height = int(binary.BigEndian.Uint32(data[12:]) - binary.BigEndian.Uint32(data[20:]))
+
+	case bytes.HasPrefix(data, []byte("ARGB")):
+		result.Format = "argb"
+		if entryType := findIcnsEntryType(osType); entryType != nil {
+			size := entryType.size
+			_, consumed, err := unpackIcnsRle(data[4:], size*size*4)
+			if err == nil && consumed == len(data)-4 {
+				result.Width, result.Height = size, size
+			}
+		}
+
+	default:
+		if size, ok := legacyIcnsTypeToSize[osType]; ok {
+			if strings.HasSuffix(osType, "mk") {
+				result.Format = "mask"
+			} else {
+				result.Format = "rle"
+			}
+			// dimensions are defined by type only
+			result.Width, result.Height = size, size
+		}
+	}
+	return result
+}
+
+// reverse of packIcnsRle, returns unpacked data and number of consumed bytes
+func unpackIcnsRle(data []byte, expectedLength int) ([]byte, int, error) {
+	result := make([]byte, 0, expectedLength)
+	i := 0
+	for len(result) < expectedLength {
+		if i >= len(data) {
+			return nil, i, errors.Errorf("RLE data is truncated: %d of %d bytes unpacked", len(result), expectedLength)
+		}
+
+		control := int(data[i])
+		i++
+		if control < 0x80 {
+			count := control + 1
+			if i+count > len(data) {
+				return nil, i, errors.New("RLE literal run exceeds data")
+			}
+			result = append(result, data[i:i+count]...)
+			i += count
+		} else {
+			if i >= len(data) {
+				return nil, i, errors.New("RLE repeat run exceeds data")
+			}
+			for count := control - 0x80 + 3; count > 0; count-- {
+				result = append(result, data[i])
+			}
+			i++
+		}
+	}
+
+	if len(result) != expectedLength {
+		return nil, i, errors.Errorf("RLE data unpacks to %d bytes instead of %d", len(result), expectedLength)
+	}
+	return result, i, nil
+}
@@ -4,13 +4,20 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"image"
 	"image/png"
 	"io"
-	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/develar/app-builder/pkg/log"
 	"github.com/develar/errors"
 	"github.com/develar/go-fs-util"
 	"github.com/disintegration/imaging"
+	"go.uber.org/zap"
+	"howett.net/plist"
 )
 
 //noinspection GoSnakeCaseUsage
@@ -20,104 +27,105 @@ const (
 	ICNS_512        = "ic09"
 	ICNS_512_RETINA = "ic14"
 	ICNS_1024       = "ic10"
+
+	ICNS_32_RETINA_16 = "ic11"
+	ICNS_64_RETINA_32 = "ic12"
 )
 
-var (
-	icnsHeader = []byte{0x69, 0x63, 0x6e, 0x73}
+var icnsHeader = []byte{0x69, 0x63, 0x6e, 0x73}
+
+type icnsEntryType struct {
+	osType string
+	// in pixels
+	size int
+	// @2x variant of size / 2
+	isRetina bool
+	// RLE compressed ARGB channels, otherwise PNG
+	isArgb bool
+}
 
-	icnsExpectedSizes = []int{16, 32, 64, 128, 256, 512, 1024}
+// https://en.wikipedia.org/wiki/Apple_Icon_Image_format#Icon_types, written in this order
+var icnsEntryTypes = []icnsEntryType{
+	{osType: "ic04", size: 16, isArgb: true},
+	{osType: "icp4", size: 16},
+	{osType: "ic05", size: 32, isRetina: true, isArgb: true},
+	{osType: "icp5", size: 32},
+	{osType: ICNS_32_RETINA_16, size: 32, isRetina: true},
+	{osType: "icp6", size: 64},
+	{osType: ICNS_64_RETINA_32, size: 64, isRetina: true},
+	{osType: "ic07", size: 128},
+	{osType: ICNS_256, size: 256},
+	{osType: ICNS_256_RETINA, size: 256, isRetina: true},
+	{osType: ICNS_512, size: 512},
+	{osType: ICNS_512_RETINA, size: 512, isRetina: true},
+	{osType: ICNS_1024, size: 1024, isRetina: true},
+}
 
-	// all icon sizes mapped to their respective possible OSTypes, this includes old OSTypes such as ic08 recognized on 10.5
-	// https://github.com/electron-userland/electron-builder/issues/2533
-	// AppIcon Generator also doesn't produce 16x16 from 1024x1025 PNG source (only 16x16@2x "retina" icon)
-	sizeToType = map[int][]string{
-		16:   {"icp4"},
-		32:   {"ic11"}, // icp5 is not generated by AppIcon Generator
-		64:   {"ic12"}, // icp6 is not generated by AppIcon Generator
-		128:  {"ic07"},
-		256:  {ICNS_256, ICNS_256_RETINA},
-		512:  {ICNS_512, ICNS_512_RETINA},
-		1024: {ICNS_1024},
+func findIcnsEntryType(osType string) *icnsEntryType {
+	for i := range icnsEntryTypes {
+		if icnsEntryTypes[i].osType == osType {
+			return &icnsEntryTypes[i]
+		}
 	}
-)
+	return nil
+}
+
+type icnsChunk struct {
+	osType string
+	data   []byte
+}
 
+// Writes TOC, all modern types (sizes above the biggest input are not upscaled) and info plist with icon name.
+// Hand-drawn images are used for types of the same size (@2x images for retina types if provided), images that don't fit are resized.
 func ConvertToIcns(inputInfo InputFileInfo, outFilePath string) error {
-	// create a new buffer to hold the series of icons generated via resizing
-	icns := new(bytes.Buffer)
+	encoder := &icnsEncoder{inputInfo: &inputInfo, pngCache: make(map[string][]byte), imageCache: make(map[string]image.Image), misSizedFiles: make(map[string]bool)}
 
-	for _, size := range icnsExpectedSizes {
-		if size > inputInfo.MaxIconSize {
+	var chunks []icnsChunk
+	for _, entryType := range icnsEntryTypes {
+		if entryType.size > inputInfo.MaxIconSize {
 			// do not upscale
 			continue
 		}
 
-		var imageData []byte
-		var err error
-		existingFile, exists := inputInfo.SizeToPath[size]
-		if exists {
-			imageData, err = ioutil.ReadFile(existingFile)
-			if err != nil {
-				return errors.WithStack(err)
-			}
-		} else {
-			if size == 16 {
-				// https://github.com/electron-userland/electron-builder/issues/2533
-				// AppIcon Generator also doesn't produce 16x16 from 1024x1025 PNG source (only 16x16@2x "retina" icon)
-				continue
-			}
-
-			maxImage, err := inputInfo.GetMaxImage()
-			if err != nil {
-				return errors.WithStack(err)
-			}
-
-			imageBuffer := new(bytes.Buffer)
-			err = png.Encode(imageBuffer, imaging.Resize(maxImage, size, size, imaging.Lanczos))
-			if err != nil {
-				return errors.WithStack(err)
-			}
-
-			imageData = imageBuffer.Bytes()
-		}
-
-		// each icon type is prefixed with a 4-byte OSType marker and a 4-byte size header (which includes the ostype/size header).
-		// add the size of the total icon to lengthBytes in big-endian format.
-		lengthBytes := make([]byte, 4)
-		binary.BigEndian.PutUint32(lengthBytes, uint32(len(imageData)+8))
-
-		// iterate through every OSType and append the icon to icns
-		for _, ostype := range sizeToType[size] {
-			_, err = icns.Write([]byte(ostype))
-			if err != nil {
-				return errors.WithStack(err)
-			}
-			_, err = icns.Write(lengthBytes)
-			if err != nil {
-				return errors.WithStack(err)
-			}
-			_, err = icns.Write(imageData)
-			if err != nil {
-				return errors.WithStack(err)
-			}
+		data, err := encoder.encode(entryType)
+		if err != nil {
+			return errors.WithStack(err)
 		}
+		chunks = append(chunks, icnsChunk{osType: entryType.osType, data: data})
 	}
 
-	// each ICNS file is prefixed with a 4 byte header and 4 bytes marking the length of the file, MSB first
-	lengthBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(lengthBytes, uint32(icns.Len()+8))
+	// table of contents lists image entries (type and length of each)
+	toc := make([]byte, 0, len(chunks)*8)
+	for _, chunk := range chunks {
+		toc = append(toc, chunk.osType...)
+		toc = binary.BigEndian.AppendUint32(toc, uint32(len(chunk.data)+8))
+	}
 
-	outFile, err := fsutil.CreateFile(outFilePath)
+	info, err := plist.Marshal(map[string]string{"name": strings.TrimSuffix(filepath.Base(outFilePath), filepath.Ext(outFilePath))}, plist.BinaryFormat)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	_, err = outFile.Write(icnsHeader)
-	if err != nil {
-		return fsutil.CloseAndCheckError(err, outFile)
+	chunks = append([]icnsChunk{{osType: "TOC ", data: toc}}, chunks...)
+	chunks = append(chunks, icnsChunk{osType: "info", data: info})
+
+	// each chunk is prefixed with a 4-byte OSType and a 4-byte length (which includes the header), big-endian
+	icns := new(bytes.Buffer)
+	icns.Write(icnsHeader)
+	totalLength := 8
+	for _, chunk := range chunks {
+		totalLength += len(chunk.data) + 8
+	}
+	_ = binary.Write(icns, binary.BigEndian, uint32(totalLength))
+	for _, chunk := range chunks {
+		icns.WriteString(chunk.osType)
+		_ = binary.Write(icns, binary.BigEndian, uint32(len(chunk.data)+8))
+		icns.Write(chunk.data)
 	}
-	_, err = outFile.Write(lengthBytes)
+
+	outFile, err := fsutil.CreateFile(outFilePath)
 	if err != nil {
-		return fsutil.CloseAndCheckError(err, outFile)
+		return errors.WithStack(err)
 	}
 
 	_, err = io.Copy(outFile, icns)
@@ -129,6 +137,155 @@ func ConvertToIcns(inputInfo InputFileInfo, outFilePath string) error {
 	return nil
 }
 
+type icnsEncoder struct {
+	inputInfo *InputFileInfo
+
+	// the same data is used for 1x and @2x types of the same pixel size if they have the same source
+	pngCache   map[string][]byte
+	imageCache map[string]image.Image
+	// files that don't fit are reported once
+	misSizedFiles map[string]bool
+}
+
+// hand-drawn file for the type, empty if image should be produced from the biggest one
+func (t *icnsEncoder) findSourceFile(entryType icnsEntryType) string {
+	var candidates []string
+	if entryType.isRetina {
+		candidates = []string{t.inputInfo.retinaSizeToPath[entryType.size], t.inputInfo.SizeToPath[entryType.size]}
+	} else {
+		candidates = []string{t.inputInfo.SizeToPath[entryType.size], t.inputInfo.retinaSizeToPath[entryType.size]}
+	}
+
+	for _, file := range candidates {
+		if len(file) == 0 || strings.HasSuffix(file, ".svg") {
+			continue
+		}
+
+		config, err := DecodeImageConfig(file)
+		if err != nil {
+			log.Warn("cannot read icon", zap.String("file", file), zap.Error(err))
+			continue
+		}
+		if config.Width != entryType.size || config.Height != entryType.size {
+			if t.misSizedFiles[file] {
+				continue
+			}
+			t.misSizedFiles[file] = true
+			log.Warn("icon size doesn't match its name, image of expected size will be used", zap.String("file", file), zap.Int("expectedSize", entryType.size), zap.Int("width", config.Width), zap.Int("height", config.Height))
+			continue
+		}
+		return file
+	}
+	return ""
+}
+
+func (t *icnsEncoder) getImage(file string, size int) (image.Image, error) {
+	key := file + "\x00" + strconv.Itoa(size)
+	if result, ok := t.imageCache[key]; ok {
+		return result, nil
+	}
+
+	var result image.Image
+	var err error
+	if len(file) == 0 {
+		result, err = t.inputInfo.getGeneratedImage(size)
+	} else {
+		result, err = LoadImage(file)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	t.imageCache[key] = result
+	return result, nil
+}
+
+func (t *icnsEncoder) encode(entryType icnsEntryType) ([]byte, error) {
+	file := t.findSourceFile(entryType)
+	if entryType.isArgb {
+		sourceImage, err := t.getImage(file, entryType.size)
+		if err != nil {
+			return nil, err
+		}
+		return encodeIcnsArgb(sourceImage), nil
+	}
+
+	key := file + "\x00" + strconv.Itoa(entryType.size)
+	if result, ok := t.pngCache[key]; ok {
+		return result, nil
+	}
+
+	var result []byte
+	if len(file) != 0 && strings.HasSuffix(strings.ToLower(file), ".png") {
+		// PNG file is used as is
+		var err error
+		result, err = os.ReadFile(file)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	} else {
+		sourceImage, err := t.getImage(file, entryType.size)
+		if err != nil {
+			return nil, err
+		}
+
+		buffer := new(bytes.Buffer)
+		err = png.Encode(buffer, sourceImage)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		result = buffer.Bytes()
+	}
+
+	t.pngCache[key] = result
+	return result, nil
+}
+
+// "ARGB" followed by alpha, red, green and blue channels, each is compressed using ICNS RLE
+func encodeIcnsArgb(source image.Image) []byte {
+	nrgba := imaging.Clone(source)
+	width, height := nrgba.Rect.Dx(), nrgba.Rect.Dy()
+
+	result := []byte("ARGB")
+	channel := make([]byte, width*height)
+	for _, channelIndex := range []int{3, 0, 1, 2} {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				channel[y*width+x] = nrgba.Pix[y*nrgba.Stride+x*4+channelIndex]
+			}
+		}
+		result = packIcnsRle(result, channel)
+	}
+	return result
+}
+
+// control byte below 0x80 is followed by (n + 1) literal bytes, otherwise next byte is repeated (n - 0x80 + 3) times
+func packIcnsRle(result []byte, data []byte) []byte {
+	for i := 0; i < len(data); {
+		run := 1
+		for i+run < len(data) && run < 130 && data[i+run] == data[i] {
+			run++
+		}
+		if run >= 3 {
+			result = append(result, byte(run-3+0x80), data[i])
+			i += run
+			continue
+		}
+
+		// literal until the next run
+		start := i
+		for i < len(data) && i-start < 128 {
+			if i+2 < len(data) && data[i] == data[i+1] && data[i] == data[i+2] {
+				break
+			}
+			i++
+		}
+		result = append(result, byte(i-start-1))
+		result = append(result, data[start:i]...)
+	}
+	return result
+}
+
 func IsIcns(reader *bufio.Reader) (bool, error) {
 	data, err := reader.Peek(4)
 	if err != nil {
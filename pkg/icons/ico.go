@@ -1,7 +1,18 @@
 package icons
 
 import (
+	"bytes"
 	"encoding/binary"
+	"image"
+	"image/png"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/develar/errors"
+	"github.com/disintegration/imaging"
 )
 
 type Sizes struct {
@@ -33,3 +44,119 @@ func GetIcoSizes(data []byte) []Sizes {
 	}
 	return sizes
 }
+
+// https://docs.microsoft.com/en-us/windows/win32/uxguide/vis-icons#size-requirements
+var defaultIcoSizes = []int{16, 20, 24, 32, 40, 48, 64, 96, 128, 256}
+
+// "16,32,256" - sorted and deduplicated
+func ParseIcoSizes(value string) ([]int, error) {
+	var result []int
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		size, err := strconv.Atoi(item)
+		if err != nil || size < 1 || size > 256 {
+			return nil, errors.Errorf("invalid ICO size %q: must be a number from 1 to 256", item)
+		}
+		if !slices.Contains(result, size) {
+			result = append(result, size)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, errors.Errorf("ICO size list is empty")
+	}
+	sort.Ints(result)
+	return result, nil
+}
+
+// Entries of 256 and more are PNG compressed (Windows Vista+), smaller ones are 32-bit BMP with AND mask (recognized by all Windows versions).
+// Images are written in the given order.
+func EncodeIco(writer io.Writer, images []image.Image) error {
+	entries := make([][]byte, len(images))
+	for i, item := range images {
+		size := item.Bounds().Size()
+		if size.X > 256 || size.Y > 256 {
+			return errors.Errorf("ICO entry cannot be larger than 256x256 (%dx%d)", size.X, size.Y)
+		}
+
+		if size.X == 256 || size.Y == 256 {
+			buffer := new(bytes.Buffer)
+			err := png.Encode(buffer, item)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			entries[i] = buffer.Bytes()
+		} else {
+			entries[i] = encodeIcoBmp(item)
+		}
+	}
+
+	header := make([]byte, 6+16*len(images))
+	// reserved, type (1 - icon), count
+	binary.LittleEndian.PutUint16(header[2:], 1)
+	binary.LittleEndian.PutUint16(header[4:], uint16(len(images)))
+
+	offset := len(header)
+	for i, item := range images {
+		entry := header[6+16*i:]
+		size := item.Bounds().Size()
+		// 0 means 256
+		entry[0] = byte(size.X)
+		entry[1] = byte(size.Y)
+		// planes
+		binary.LittleEndian.PutUint16(entry[4:], 1)
+		// bits per pixel
+		binary.LittleEndian.PutUint16(entry[6:], 32)
+		binary.LittleEndian.PutUint32(entry[8:], uint32(len(entries[i])))
+		binary.LittleEndian.PutUint32(entry[12:], uint32(offset))
+		offset += len(entries[i])
+	}
+
+	_, err := writer.Write(header)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, data := range entries {
+		_, err = writer.Write(data)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// BITMAPINFOHEADER, height is doubled (XOR bitmap and AND mask), rows are bottom-up
+func encodeIcoBmp(source image.Image) []byte {
+	nrgba := imaging.Clone(source)
+	width, height := nrgba.Rect.Dx(), nrgba.Rect.Dy()
+	pixelDataSize := width * height * 4
+	// mask rows are aligned to 32 bits
+	maskRowSize := (width + 31) / 32 * 4
+
+	result := make([]byte, 40+pixelDataSize+maskRowSize*height)
+	binary.LittleEndian.PutUint32(result[0:], 40)
+	binary.LittleEndian.PutUint32(result[4:], uint32(width))
+	binary.LittleEndian.PutUint32(result[8:], uint32(height*2))
+	binary.LittleEndian.PutUint16(result[12:], 1)
+	binary.LittleEndian.PutUint16(result[14:], 32)
+	binary.LittleEndian.PutUint32(result[20:], uint32(len(result)-40))
+
+	pixels := result[40 : 40+pixelDataSize]
+	mask := result[40+pixelDataSize:]
+	for y := 0; y < height; y++ {
+		row := height - 1 - y
+		for x := 0; x < width; x++ {
+			source := nrgba.Pix[y*nrgba.Stride+x*4 : y*nrgba.Stride+x*4+4]
+			target := pixels[(row*width+x)*4:]
+			target[0], target[1], target[2], target[3] = source[2], source[1], source[0], source[3]
+			if source[3] == 0 {
+				mask[row*maskRowSize+x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+	}
+	return result
+}
@@ -1,6 +1,8 @@
 package icons
 
 import (
+	"bufio"
+	"fmt"
 	"image"
 	"path/filepath"
 	"strings"
@@ -10,6 +12,7 @@ import (
 	"github.com/develar/app-builder/pkg/log"
 	"github.com/develar/app-builder/pkg/util"
 	"github.com/develar/errors"
+	"github.com/develar/go-fs-util"
 	"github.com/disintegration/imaging"
 	"go.uber.org/zap"
 )
@@ -25,11 +28,18 @@ func ConfigureCommand(app *kingpin.Application) error {
 
 	iconOutFormat := command.Flag("format", "output format").Short('f').Required().Enum("icns", "ico", "set")
 	outDir := command.Flag("out", "output directory").Required().String()
+	icoSizes := command.Flag("ico-sizes", "comma-separated sizes of ICO entries (hand-drawn images of these sizes are used if available)").Default("16,20,24,32,40,48,64,96,128,256").String()
 
 	command.Action(func(context *kingpin.ParseContext) error {
 		configuration.OutputFormat = *iconOutFormat
 		configuration.OutputDir = *outDir
 
+		var err error
+		configuration.IcoSizes, err = ParseIcoSizes(*icoSizes)
+		if err != nil {
+			return err
+		}
+
 		result, err := ConvertIcon(configuration)
 		if err != nil {
 			switch t := errors.Cause(err).(type) {
@@ -53,7 +63,7 @@ func ConfigureCommand(app *kingpin.Application) error {
 }
 
 func ConvertIcon(configuration *IconConvertRequest) (*IconConvertResult, error) {
-	result, err := doConvertIcon(createCommonIconSources(*configuration.Sources, configuration.OutputFormat), *configuration.Roots, configuration.OutputFormat, configuration.OutputDir)
+	result, err := doConvertIcon(createCommonIconSources(*configuration.Sources, configuration.OutputFormat), *configuration.Roots, configuration.OutputFormat, configuration.OutputDir, configuration.IcoSizes)
 	if err != nil {
 		return nil, err
 	}
@@ -63,7 +73,7 @@ func ConvertIcon(configuration *IconConvertRequest) (*IconConvertResult, error)
 	// try using fallback sources
 	if result == nil {
 		log.Debug("no icons found, using provided fallback sources")
-		result, err = doConvertIcon(*configuration.FallbackSources, *configuration.Roots, configuration.OutputFormat, configuration.OutputDir)
+		result, err = doConvertIcon(*configuration.FallbackSources, *configuration.Roots, configuration.OutputFormat, configuration.OutputDir, configuration.IcoSizes)
 		if err != nil {
 			return nil, err
 		}
@@ -159,7 +169,7 @@ func outputFormatToSingleFileExtension(outputFormat string) string {
 	return "." + outputFormat
 }
 
-func doConvertIcon(sourceFiles []string, roots []string, outputFormat string, outDir string) ([]IconInfo, error) {
+func doConvertIcon(sourceFiles []string, roots []string, outputFormat string, outDir string, icoSizes []int) ([]IconInfo, error) {
 	// allowed to specify path to icns without extension, so, if file not resolved, try to add ".icns" extension
 	outExt := outputFormatToSingleFileExtension(outputFormat)
 	resolvedPath, fileInfo, err := resolveSourceFile(sourceFiles, roots)
@@ -176,11 +186,7 @@ func doConvertIcon(sourceFiles []string, roots []string, outputFormat string, ou
 	var inputInfo InputFileInfo
 	inputInfo.SizeToPath = make(map[int]string)
 
-	if outputFormat == "icns" {
-		inputInfo.recommendedMinSize = 512
-	} else {
-		inputInfo.recommendedMinSize = 256
-	}
+	inputInfo.recommendedMinSize = outputFormatRequirements[outputFormat].minSize
 
 	isOutputFormatIco := outputFormat == "ico"
 	if strings.HasSuffix(resolvedPath, outExt) {
@@ -218,10 +224,16 @@ func doConvertIcon(sourceFiles []string, roots []string, outputFormat string, ou
 		}
 
 		if outputFormat == "set" {
-			return icons, nil
+			return removeRetinaDuplicates(icons), nil
 		}
 
+		inputInfo.retinaSizeToPath = make(map[int]string)
 		for _, file := range icons {
+			if file.isRetina {
+				inputInfo.retinaSizeToPath[file.Size] = file.File
+			}
+		}
+		for _, file := range removeRetinaDuplicates(icons) {
 			inputInfo.SizeToPath[file.Size] = file.File
 		}
 
@@ -237,12 +249,7 @@ func doConvertIcon(sourceFiles []string, roots []string, outputFormat string, ou
 				}
 				return result, nil
 			} else if strings.HasSuffix(resolvedPath, ".svg") {
-				return []IconInfo{
-					{
-						File: resolvedPath,
-						Size: 1024,
-					},
-				}, nil
+				return convertSvgToPngSet(resolvedPath, outDir)
 			}
 		}
 
@@ -252,7 +259,7 @@ func doConvertIcon(sourceFiles []string, roots []string, outputFormat string, ou
 		}
 	}
 
-	return convertSingleFile(&inputInfo, filepath.Join(outDir, "icon"+outExt), outputFormat)
+	return convertSingleFile(&inputInfo, filepath.Join(outDir, "icon"+outExt), outputFormat, icoSizes)
 }
 
 // https://github.com/electron-userland/electron-builder/issues/2654#issuecomment-369972916
@@ -288,7 +295,35 @@ func resizePngForLinux(inputInfo *InputFileInfo, iconFileName string, outDir str
 	return result, nil
 }
 
-func convertSingleFile(inputInfo *InputFileInfo, outFile string, outputFormat string) ([]IconInfo, error) {
+// each size is rendered from vector data, downscaling of the biggest bitmap blurs small icons
+func convertSvgToPngSet(file string, outDir string) ([]IconInfo, error) {
+	document, err := loadSvg(file)
+	if err != nil {
+		return nil, err
+	}
+
+	sizeList := make([]int, 0, len(icnsTypeToSize)+1)
+	for _, item := range icnsTypeToSize {
+		sizeList = append(sizeList, item.Size)
+	}
+	sizeList = append(sizeList, svgDefaultSize)
+
+	result := make([]IconInfo, len(sizeList))
+	err = util.MapAsync(len(sizeList), func(taskIndex int) (func() error, error) {
+		size := sizeList[taskIndex]
+		outFilePath := filepath.Join(outDir, fmt.Sprintf("icon_%dx%d.png", size, size))
+		result[taskIndex] = IconInfo{File: outFilePath, Size: size}
+		return func() error {
+			return SaveImage(document.rasterize(size), outFilePath, PNG)
+		}, nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return result, nil
+}
+
+func convertSingleFile(inputInfo *InputFileInfo, outFile string, outputFormat string, icoSizes []int) ([]IconInfo, error) {
 	switch outputFormat {
 	case "icns":
 		err := ConvertToIcns(*inputInfo, outFile)
@@ -298,16 +333,43 @@ func convertSingleFile(inputInfo *InputFileInfo, outFile string, outputFormat st
 		return []IconInfo{{File: outFile, Size: inputInfo.MaxIconSize}}, err
 
 	case "ico":
-		maxImage, err := inputInfo.GetMaxImage()
+		if len(icoSizes) == 0 {
+			icoSizes = defaultIcoSizes
+		}
+
+		var images []image.Image
+		for _, size := range icoSizes {
+			if size > inputInfo.MaxIconSize {
+				// do not upscale
+				continue
+			}
+
+			sizedImage, err := inputInfo.getImage(size)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			images = append(images, sizedImage)
+		}
+
+		if len(images) == 0 {
+			return nil, errors.WithStack(NewImageSizeError(inputInfo.MaxIconPath, icoSizes[0]))
+		}
+
+		outFileWriter, err := fsutil.CreateFile(outFile)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
 
-		err = SaveImage(maxImage, outFile, ICO)
+		writer := bufio.NewWriter(outFileWriter)
+		err = EncodeIco(writer, images)
+		if err == nil {
+			err = writer.Flush()
+		}
+		err = fsutil.CloseAndCheckError(err, outFileWriter)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
-		return []IconInfo{{File: outFile, Size: inputInfo.MaxIconSize}}, nil
+		return []IconInfo{{File: outFile, Size: images[len(images)-1].Bounds().Dx()}}, nil
 
 	default:
 		return nil, errors.Errorf("unknown output format %s", outputFormat)
@@ -316,8 +378,19 @@ func convertSingleFile(inputInfo *InputFileInfo, outFile string, outputFormat st
 
 func configureInputInfoFromSingleFile(file string, isOutputFormatIco bool, inputInfo *InputFileInfo) error {
 	if strings.HasSuffix(file, ".svg") {
-		inputInfo.MaxIconSize = 1024
-		inputInfo.SizeToPath[inputInfo.MaxIconSize] = file
+		document, err := loadSvg(file)
+		if err != nil {
+			return err
+		}
+
+		inputInfo.MaxIconSize = svgDefaultSize
+		if isOutputFormatIco {
+			inputInfo.MaxIconSize = 256
+		}
+		// not added to SizeToPath - file content is not a bitmap
+		inputInfo.MaxIconPath = file
+		inputInfo.maxImage = document.rasterize(inputInfo.MaxIconSize)
+		inputInfo.svg = document
 		return nil
 	}
 
@@ -326,14 +399,19 @@ func configureInputInfoFromSingleFile(file string, isOutputFormatIco bool, input
 		return errors.WithStack(err)
 	}
 
+	isResized := false
 	if isOutputFormatIco && maxImage.Bounds().Max.X > 256 {
 		image256 := imaging.Resize(maxImage, 256, 256, imaging.Lanczos)
 		maxImage = image256
+		isResized = true
 	}
 
 	inputInfo.MaxIconSize = maxImage.Bounds().Max.X
+	inputInfo.MaxIconPath = file
 	inputInfo.maxImage = maxImage
-	inputInfo.SizeToPath[inputInfo.MaxIconSize] = file
+	if !isResized {
+		inputInfo.SizeToPath[inputInfo.MaxIconSize] = file
+	}
 
 	return nil
 }
@@ -1,7 +1,13 @@
 package icons
 
 import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -9,6 +15,7 @@ import (
 
 	"github.com/develar/app-builder/pkg/log"
 	"github.com/develar/app-builder/pkg/util"
+	"github.com/disintegration/imaging"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -40,6 +47,83 @@ func TestCommonSourcesNil(t *testing.T) {
 	g.Expect(result).To(Equal([]string{"icons", "icon.png", "icon.icns", "icon.ico"}))
 }
 
+func TestParseIcoSizes(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	sizes, err := ParseIcoSizes("256, 16,32,16")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(sizes).To(Equal([]int{16, 32, 256}))
+
+	_, err = ParseIcoSizes("16,512")
+	g.Expect(err).To(HaveOccurred())
+	_, err = ParseIcoSizes("")
+	g.Expect(err).To(HaveOccurred())
+}
+
+// JPEG 2000 and legacy RLE entries of the same icon must look the same
+func TestDecodeIcnsEntries(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	data, err := os.ReadFile(filepath.Join(getTestDataPath(), "icon-jpeg2.icns"))
+	g.Expect(err).NotTo(HaveOccurred())
+	entries, err := readIcnsEntries(data)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	jpeg2000Image, err := decodeIcnsEntry(ICNS_256, entries)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(jpeg2000Image.Bounds().Dx()).To(Equal(256))
+
+	legacyImage, err := decodeIcnsEntry("it32", entries)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(legacyImage.Bounds().Dx()).To(Equal(128))
+
+	// transparent corner, opaque center
+	g.Expect(color.NRGBAModel.Convert(legacyImage.At(0, 0)).(color.NRGBA).A).To(Equal(uint8(0)))
+	g.Expect(color.NRGBAModel.Convert(legacyImage.At(64, 20)).(color.NRGBA).A).To(Equal(uint8(255)))
+
+	resized := imaging.Resize(jpeg2000Image, 128, 128, imaging.Lanczos)
+	legacy := imaging.Clone(legacyImage)
+	difference := 0
+	for i := range resized.Pix {
+		difference += int(math.Abs(float64(resized.Pix[i]) - float64(legacy.Pix[i])))
+	}
+	g.Expect(float64(difference) / float64(len(resized.Pix))).To(BeNumerically("<", 4))
+
+	loaded, err := LoadImage(filepath.Join(getTestDataPath(), "icon-jpeg2.icns"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(loaded.Bounds().Dx()).To(Equal(256))
+}
+
+func TestIcnsRle(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	data := []byte{1, 1, 1, 1, 2, 3, 4, 4, 5, 5, 5}
+	for i := 0; i < 300; i++ {
+		data = append(data, 7)
+	}
+	for i := 0; i < 200; i++ {
+		data = append(data, byte(i))
+	}
+
+	packed := packIcnsRle(nil, data)
+	g.Expect(packed[:2]).To(Equal([]byte{0x81, 1}))
+	unpacked, consumed, err := unpackIcnsRle(packed, len(data))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(consumed).To(Equal(len(packed)))
+	g.Expect(unpacked).To(Equal(data))
+
+	_, _, err = unpackIcnsRle(packed[:len(packed)-1], len(data))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func toSquareSizes(sizes []int) []Sizes {
+	var result []Sizes
+	for _, size := range sizes {
+		result = append(result, Sizes{Width: size, Height: size})
+	}
+	return result
+}
+
 func getTestDataPath() string {
 	testDataPath, err := filepath.Abs(filepath.Join("..", "..", "testData"))
 	Expect(err).NotTo(HaveOccurred())
@@ -61,12 +145,12 @@ var _ = Describe("Blockmap", func() {
 	})
 
 	It("CheckIcoImageSize", func() {
-		_, err := doConvertIcon([]string{filepath.Join(getTestDataPath(), "icon.ico")}, nil, "ico", tmpDir)
+		_, err := doConvertIcon([]string{filepath.Join(getTestDataPath(), "icon.ico")}, nil, "ico", tmpDir, nil)
 		Expect(err).NotTo(HaveOccurred())
 	})
 
 	It("IcnsToIco", func() {
-		files, err := doConvertIcon([]string{filepath.Join(getTestDataPath(), "icon.icns")}, nil, "ico", tmpDir)
+		files, err := doConvertIcon([]string{filepath.Join(getTestDataPath(), "icon.icns")}, nil, "ico", tmpDir, nil)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(len(files)).To(Equal(1))
 		file := files[0].File
@@ -75,26 +159,172 @@ var _ = Describe("Blockmap", func() {
 
 		data, err := ioutil.ReadFile(file)
 		Expect(err).NotTo(HaveOccurred())
-		Expect(GetIcoSizes(data)).To(Equal([]Sizes{
-			{Width: 256, Height: 256},
-		}))
+		Expect(GetIcoSizes(data)).To(Equal(toSquareSizes(defaultIcoSizes)))
 	})
 
 	It("IcnsToPng", func() {
-		result, err := ConvertIcnsToPngUsingOpenJpeg(filepath.Join(getTestDataPath(), "icon.icns"), tmpDir)
+		result, err := ConvertIcnsToPngUsingGo(filepath.Join(getTestDataPath(), "icon.icns"), tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+		// retina duplicates are skipped, legacy is32 and it32 are decoded
+		Expect(len(result)).To(Equal(7))
+	})
+
+	It("Jpeg2000IcnsToPng", func() {
+		result, err := ConvertIcnsToPngUsingGo(filepath.Join(getTestDataPath(), "icon-jpeg2.icns"), tmpDir)
 		Expect(err).NotTo(HaveOccurred())
-		Expect(len(result)).To(Equal(5))
+
+		var sizes []int
+		for _, file := range result {
+			sizes = append(sizes, file.Size)
+			config, err := DecodeImageConfig(file.File)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Width).To(Equal(file.Size))
+		}
+		Expect(sizes).To(Equal([]int{16, 32, 48, 128, 256, 512}))
 	})
 
-	It("ConvertIcnsToPngUsingOpenJpeg", func() {
-		// todo opj_decompress not installed on Travis
-		//result, err := ConvertIcnsToPngUsingOpenJpeg(filepath.Join(getTestDataPath(), "icon-jpeg2.icns"), tmpDir)
-		//Expect(err).NotTo(HaveOccurred())
-		//Expect(len(result)).To(Equal(2))
+	It("Jpeg2000IcnsToIco", func() {
+		files, err := doConvertIcon([]string{filepath.Join(getTestDataPath(), "icon-jpeg2.icns")}, nil, "ico", tmpDir, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		data, err := ioutil.ReadFile(files[0].File)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(GetIcoSizes(data)).To(Equal(toSquareSizes(defaultIcoSizes)))
+	})
+
+	It("SvgToSet", func() {
+		files, err := doConvertIcon([]string{filepath.Join(getTestDataPath(), "icon.svg")}, nil, "set", tmpDir, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(files)).To(Equal(8))
+		for _, file := range files {
+			config, err := DecodeImageConfig(file.File)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Width).To(Equal(file.Size))
+		}
+	})
+
+	It("SvgToIcns", func() {
+		files, err := doConvertIcon([]string{filepath.Join(getTestDataPath(), "icon.svg")}, nil, "icns", tmpDir, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files[0].Size).To(Equal(1024))
+
+		reader, err := os.Open(files[0].File)
+		Expect(err).NotTo(HaveOccurred())
+		defer util.Close(reader)
+		subImages, err := ReadIcns(bufio.NewReader(reader))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subImages).To(HaveKey(ICNS_1024))
+		Expect(subImages).To(HaveKey("ic11"))
+
+		image, err := LoadImage(files[0].File)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(image.Bounds().Max.X).To(Equal(256))
+	})
+
+	It("SvgToIco", func() {
+		files, err := doConvertIcon([]string{filepath.Join(getTestDataPath(), "icon.svg")}, nil, "ico", tmpDir, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		data, err := ioutil.ReadFile(files[0].File)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(GetIcoSizes(data)).To(Equal(toSquareSizes(defaultIcoSizes)))
+	})
+
+	It("HandDrawnSizesToIco", func() {
+		iconDir := filepath.Join(tmpDir, "icons")
+		Expect(os.Mkdir(iconDir, 0755)).To(Succeed())
+		Expect(SaveImage(imaging.New(16, 16, color.NRGBA{R: 255, A: 255}), filepath.Join(iconDir, "16x16.png"), PNG)).To(Succeed())
+		Expect(SaveImage(imaging.New(256, 256, color.NRGBA{B: 255, A: 255}), filepath.Join(iconDir, "256x256.png"), PNG)).To(Succeed())
+
+		files, err := doConvertIcon([]string{iconDir}, nil, "ico", tmpDir, []int{16, 32, 256})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files[0].Size).To(Equal(256))
+
+		reader, err := os.Open(files[0].File)
+		Expect(err).NotTo(HaveOccurred())
+		defer util.Close(reader)
+		images, err := ico.DecodeAll(reader)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(images)).To(Equal(3))
+
+		// hand-drawn 16x16 is used, 32x32 is resized from the biggest one
+		r, _, b, _ := images[0].At(8, 8).RGBA()
+		Expect(r >> 8).To(Equal(uint32(255)))
+		Expect(b >> 8).To(Equal(uint32(0)))
+		r, _, b, _ = images[1].At(8, 8).RGBA()
+		Expect(r >> 8).To(Equal(uint32(0)))
+		Expect(b >> 8).To(Equal(uint32(255)))
+	})
+
+	It("PngToIcns", func() {
+		files, err := doConvertIcon([]string{filepath.Join(getTestDataPath(), "512x512.png")}, nil, "icns", tmpDir, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		report, err := ValidateIcns(files[0].File)
+		Expect(err).NotTo(HaveOccurred())
+		// not upscaled
+		Expect(report.Problems).To(Equal([]IcnsProblem{{Code: "ICNS_ENTRY_MISSING", Type: ICNS_1024, Message: "entry ic10 (1024x1024) is missing"}}))
+		Expect(report.Entries[0]).To(Equal(IcnsEntryInfo{Type: "ic04", Format: "argb", Length: report.Entries[0].Length, Width: 16, Height: 16}))
+		Expect(len(report.Entries)).To(Equal(len(icnsEntryTypes) - 1))
+	})
+
+	It("RetinaSourcesToIcns", func() {
+		iconDir := filepath.Join(tmpDir, "icons")
+		Expect(os.Mkdir(iconDir, 0755)).To(Succeed())
+		red, green, blue := color.NRGBA{R: 255, A: 255}, color.NRGBA{G: 255, A: 255}, color.NRGBA{B: 255, A: 255}
+		Expect(SaveImage(imaging.New(16, 16, red), filepath.Join(iconDir, "icon_16x16.png"), PNG)).To(Succeed())
+		Expect(SaveImage(imaging.New(32, 32, green), filepath.Join(iconDir, "icon_16x16@2x.png"), PNG)).To(Succeed())
+		Expect(SaveImage(imaging.New(32, 32, blue), filepath.Join(iconDir, "icon_32x32.png"), PNG)).To(Succeed())
+		// doesn't fit
+		Expect(SaveImage(imaging.New(60, 60, red), filepath.Join(iconDir, "icon_64x64.png"), PNG)).To(Succeed())
+		Expect(SaveImage(imaging.New(512, 512, blue), filepath.Join(iconDir, "icon_512x512.png"), PNG)).To(Succeed())
+
+		files, err := doConvertIcon([]string{iconDir}, nil, "icns", tmpDir, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		report, err := ValidateIcns(files[0].File)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Problems).To(HaveLen(1))
+		Expect(report.Problems[0].Type).To(Equal(ICNS_1024))
+
+		data, err := ioutil.ReadFile(files[0].File)
+		Expect(err).NotTo(HaveOccurred())
+		subImages, err := ReadIcns(bufio.NewReader(bytes.NewReader(data)))
+		Expect(err).NotTo(HaveOccurred())
+		decode := func(osType string) image.Image {
+			subImage := subImages[osType]
+			result, err := png.Decode(bytes.NewReader(data[subImage.Offset : subImage.Offset+subImage.Length]))
+			Expect(err).NotTo(HaveOccurred())
+			return result
+		}
+		colorAt := func(osType string) color.Color {
+			return color.NRGBAModel.Convert(decode(osType).At(1, 1))
+		}
+
+		Expect(colorAt("icp4")).To(Equal(red))
+		Expect(colorAt(ICNS_32_RETINA_16)).To(Equal(green))
+		Expect(colorAt("icp5")).To(Equal(blue))
+		Expect(decode("icp6").Bounds().Dx()).To(Equal(64))
+
+		// set doesn't contain @2x duplicate
+		icons, _, err := CollectIcons(iconDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(icons)).To(Equal(5))
+		Expect(len(removeRetinaDuplicates(icons))).To(Equal(4))
+	})
+
+	It("ValidateIcns", func() {
+		report, err := ValidateIcns(filepath.Join(getTestDataPath(), "icon.icns"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Entries).NotTo(BeEmpty())
+		Expect(report.Problems).To(ContainElement(IcnsProblem{Code: "ICNS_TOC_MISSING", Type: "TOC ", Message: "table of contents is missing"}))
+
+		_, err = ValidateIcns(filepath.Join(getTestDataPath(), "512x512.png"))
+		Expect(err).To(HaveOccurred())
 	})
 
 	It("LargePngTo256Ico", func() {
-		files, err := doConvertIcon([]string{filepath.Join(getTestDataPath(), "512x512.png")}, nil, "ico", tmpDir)
+		files, err := doConvertIcon([]string{filepath.Join(getTestDataPath(), "512x512.png")}, nil, "ico", tmpDir, nil)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(len(files)).To(Equal(1))
 		file := files[0].File
@@ -106,9 +336,12 @@ var _ = Describe("Blockmap", func() {
 		images, err := ico.DecodeAll(reader)
 		Expect(err).NotTo(HaveOccurred())
 
-		Expect(len(images)).To(Equal(1))
+		Expect(len(images)).To(Equal(len(defaultIcoSizes)))
+		for i, size := range defaultIcoSizes {
+			Expect(images[i].Bounds().Max.X).To(Equal(size))
+		}
 
-		imageSize := images[0].Bounds().Max
+		imageSize := images[len(images)-1].Bounds().Max
 		Expect(imageSize.X).To(Equal(256))
 		Expect(imageSize.Y).To(Equal(256))
 	})
@@ -0,0 +1,399 @@
+package icons
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/biessek/golang-ico"
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"go.uber.org/zap"
+)
+
+// requirements to source image of output format
+type iconFormatRequirements struct {
+	// smaller source is rejected by icon command
+	minSize int
+	// size of the biggest entry, smaller source is not upscaled and the entry is missing
+	recommendedSize int
+	// macOS Big Sur icon grid: artwork is 824x824 centered on 1024x1024 canvas, rest is used for shadow
+	requiresPadding bool
+}
+
+var outputFormatRequirements = map[string]iconFormatRequirements{
+	"icns": {minSize: 512, recommendedSize: 1024, requiresPadding: true},
+	"ico":  {minSize: 256, recommendedSize: 256},
+	"set":  {minSize: 256, recommendedSize: 512},
+}
+
+// pixels with lower alpha are considered as transparent by edge check (antialiasing and compression noise)
+const iconEdgeAlphaThreshold = 8
+
+type IconLintWarning struct {
+	Code    string `json:"code"`
+	File    string `json:"file"`
+	Format  string `json:"format,omitempty"`
+	Message string `json:"message"`
+}
+
+type IconLintReport struct {
+	Warnings []IconLintWarning `json:"warnings"`
+}
+
+func ConfigureLintIconCommand(app *kingpin.Application) {
+	command := app.Command("icon-lint", "check icon source files against platform guidelines")
+	inputs := command.Flag("input", "icon source file (PNG, JPEG, SVG, ICO or ICNS)").Short('i').Required().Strings()
+	formats := command.Flag("format", "output format to check against (all by default)").Short('f').Enums("icns", "ico", "set")
+	strict := command.Flag("strict", "fail if any warning is reported (report is written to stdout)").Bool()
+
+	command.Action(func(context *kingpin.ParseContext) error {
+		if len(*formats) == 0 {
+			*formats = []string{"icns", "ico", "set"}
+		}
+
+		report := &IconLintReport{Warnings: make([]IconLintWarning, 0)}
+		for _, input := range *inputs {
+			warnings, err := LintIcon(input, *formats)
+			if err != nil {
+				if t, ok := errors.Cause(err).(*ImageFormatError); ok {
+					log.Debug("cannot lint icon", zap.Error(err))
+					return writeUserError(t)
+				}
+				return err
+			}
+			report.Warnings = append(report.Warnings, warnings...)
+		}
+
+		err := util.WriteJsonToStdOut(report)
+		if err != nil {
+			return err
+		}
+
+		if *strict && len(report.Warnings) != 0 {
+			var codes []string
+			for _, warning := range report.Warnings {
+				codes = append(codes, warning.Code)
+			}
+			return util.NewMessageError(fmt.Sprintf("%d icon warnings: %s", len(report.Warnings), strings.Join(codes, ", ")), "ERR_ICON_LINT")
+		}
+		return nil
+	})
+}
+
+// Checks source file against requirements of each output format. Codes of warnings are stable and can be used to suppress or escalate them.
+func LintIcon(file string, formats []string) ([]IconLintWarning, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var img image.Image
+	// 0 for vector image
+	size := 0
+	var width, height int
+	var unsupportedSvgFeatures []string
+	switch {
+	case strings.HasSuffix(file, ".svg"):
+		document, err := loadSvg(file)
+		if err != nil {
+			return nil, err
+		}
+		img = document.rasterize(svgDefaultSize)
+		unsupportedSvgFeatures = document.getUnsupportedFeatures()
+		// aspect ratio of viewBox, image is fitted into square canvas
+		width, height = int(document.viewBoxWidth+0.5), int(document.viewBoxHeight+0.5)
+
+	case bytes.HasPrefix(data, icnsHeader):
+		img, err = loadBiggestIcnsImage(file, data)
+		if err != nil {
+			return nil, err
+		}
+
+	case len(data) > 4 && IsIco(data):
+		img, err = loadBiggestIcoImage(file, data)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		config, err := DecodeImageConfig(file)
+		if err != nil {
+			return nil, err
+		}
+		width, height = config.Width, config.Height
+
+		img, err = LoadImage(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if width == 0 {
+		width, height = img.Bounds().Dx(), img.Bounds().Dy()
+	}
+	if !strings.HasSuffix(file, ".svg") {
+		size = min(width, height)
+	}
+
+	var result []IconLintWarning
+	add := func(code string, format string, message string, args ...interface{}) {
+		result = append(result, IconLintWarning{Code: code, File: file, Format: format, Message: fmt.Sprintf(message, args...)})
+	}
+
+	if width != height {
+		add("ICON_NOT_SQUARE", "", "image is %dx%d, it is stretched or padded to square on conversion", width, height)
+	}
+
+	for _, feature := range unsupportedSvgFeatures {
+		add("ICON_SVG_UNSUPPORTED_FEATURE", "", "%s is not supported by SVG renderer, elements referencing it are drawn as if it is not specified", feature)
+	}
+
+	isOpaque := isImageOpaque(img)
+	if isOpaque {
+		add("ICON_NO_ALPHA", "", "image has no transparent pixels, rounded corners and shadow cannot be shown on macOS and Linux")
+	}
+
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16:
+		add("ICON_16_BIT", "", "image has 16 bits per channel, it is converted to 8 bits and some tools fail to read 16-bit PNG entries")
+	}
+
+	profile, err := findNonSrgbColorProfile(data)
+	if err != nil {
+		log.Debug("cannot read color profile", zap.String("file", file), zap.Error(err))
+	} else if profile != "" {
+		add("ICON_NOT_SRGB", "", "color profile %q is not sRGB, colors differ between platforms because profile is dropped on conversion", profile)
+	}
+
+	for _, format := range formats {
+		requirements := outputFormatRequirements[format]
+		if size != 0 {
+			if size < requirements.minSize {
+				add("ICON_TOO_SMALL", format, "image must be at least %dx%d, but is %dx%d", requirements.minSize, requirements.minSize, width, height)
+			} else if size < requirements.recommendedSize {
+				add("ICON_SMALLER_THAN_RECOMMENDED", format, "image is %dx%d, %dx%d is required to produce all sizes", width, height, requirements.recommendedSize, requirements.recommendedSize)
+			}
+		}
+
+		// opaque image touches edges too, it is reported as ICON_NO_ALPHA
+		if requirements.requiresPadding && !isOpaque && isContentTouchingEdges(img) {
+			add("ICON_CONTENT_TOUCHES_EDGES", format, "content touches image edges, macOS Big Sur icon grid expects 824x824 artwork centered on 1024x1024 canvas")
+		}
+	}
+	return result, nil
+}
+
+func loadBiggestIcnsImage(file string, data []byte) (image.Image, error) {
+	entries, err := readIcnsEntries(data)
+	if err != nil {
+		return nil, errors.WithMessage(err, file)
+	}
+
+	maxSize := 0
+	sizeToType := selectIcnsEntries(entries, file)
+	for size := range sizeToType {
+		maxSize = max(maxSize, size)
+	}
+	if maxSize == 0 {
+		return nil, errors.WithStack(&ImageFormatError{file, "ERR_ICON_UNKNOWN_FORMAT"})
+	}
+	return decodeIcnsEntry(sizeToType[maxSize], entries)
+}
+
+// ico decoder returns the first entry, not the biggest one
+func loadBiggestIcoImage(file string, data []byte) (image.Image, error) {
+	images, err := ico.DecodeAll(bytes.NewReader(data))
+	if err != nil || len(images) == 0 {
+		log.Debug("cannot decode ico", zap.String("file", file), zap.Error(err))
+		return nil, errors.WithStack(&ImageFormatError{file, "ERR_ICON_UNKNOWN_FORMAT"})
+	}
+
+	result := images[0]
+	for _, img := range images[1:] {
+		if img.Bounds().Dx() > result.Bounds().Dx() {
+			result = img
+		}
+	}
+	return result, nil
+}
+
+func isImageOpaque(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return opaque.Opaque()
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// only the outermost pixels are checked
+func isContentTouchingEdges(img image.Image) bool {
+	bounds := img.Bounds()
+	isVisible := func(x int, y int) bool {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).A > iconEdgeAlphaThreshold
+	}
+
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		if isVisible(x, bounds.Min.Y) || isVisible(x, bounds.Max.Y-1) {
+			return true
+		}
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		if isVisible(bounds.Min.X, y) || isVisible(bounds.Max.X-1, y) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns description of embedded ICC profile (PNG iCCP chunk or JPEG APP2 segments) or gamma if it is not sRGB, empty string if image is sRGB or color space is not specified.
+func findNonSrgbColorProfile(data []byte) (string, error) {
+	var profile []byte
+	var profileName string
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		gamma := uint32(0)
+	chunks:
+		for offset := 8; offset+8 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[offset:]))
+			chunkType := string(data[offset+4 : offset+8])
+			if length < 0 || offset+12+length > len(data) {
+				return "", errors.Errorf("chunk %s at %d exceeds file", chunkType, offset)
+			}
+
+			chunk := data[offset+8 : offset+8+length]
+			switch chunkType {
+			case "sRGB":
+				return "", nil
+			case "gAMA":
+				if length == 4 {
+					gamma = binary.BigEndian.Uint32(chunk)
+				}
+			case "iCCP":
+				nameEnd := bytes.IndexByte(chunk, 0)
+				if nameEnd < 0 || nameEnd+2 > len(chunk) {
+					return "", errors.New("invalid iCCP chunk")
+				}
+
+				profileName = string(chunk[:nameEnd])
+				reader, err := zlib.NewReader(bytes.NewReader(chunk[nameEnd+2:]))
+				if err != nil {
+					return "", errors.WithStack(err)
+				}
+				profile, err = io.ReadAll(reader)
+				if err != nil {
+					return "", errors.WithStack(err)
+				}
+			case "IDAT":
+				// color chunks precede image data
+				break chunks
+			}
+			offset += 12 + length
+		}
+
+		// sRGB gamma is 1/2.2, stored multiplied by 100000
+		if profile == nil && gamma != 0 && (gamma < 45000 || gamma > 46000) {
+			return fmt.Sprintf("gamma %.2f", 100000/float64(gamma)), nil
+		}
+
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		// profile can be split into several APP2 segments, each starts with "ICC_PROFILE\0", sequence number and count
+		for offset := 2; offset+4 <= len(data) && data[offset] == 0xff; {
+			marker := data[offset+1]
+			// start of scan or end of image
+			if marker == 0xda || marker == 0xd9 {
+				break
+			}
+
+			length := int(binary.BigEndian.Uint16(data[offset+2:]))
+			if length < 2 || offset+2+length > len(data) {
+				return "", errors.Errorf("segment %x at %d exceeds file", marker, offset)
+			}
+
+			segment := data[offset+4 : offset+2+length]
+			if marker == 0xe2 && len(segment) > 14 && bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00")) {
+				profile = append(profile, segment[14:]...)
+			}
+			offset += 2 + length
+		}
+	}
+
+	if profile == nil {
+		return "", nil
+	}
+
+	description := getIccProfileDescription(profile)
+	if description == "" {
+		description = profileName
+	}
+	if strings.Contains(strings.ToLower(description), "srgb") {
+		return "", nil
+	}
+	return description, nil
+}
+
+// text of "desc" tag, ICC v2 uses textDescriptionType and ICC v4 uses multiLocalizedUnicodeType (the first record is used)
+func getIccProfileDescription(profile []byte) string {
+	if len(profile) < 132 {
+		return ""
+	}
+
+	tagCount := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < tagCount && 132+i*12+12 <= len(profile); i++ {
+		entry := profile[132+i*12:]
+		if string(entry[:4]) != "desc" {
+			continue
+		}
+
+		offset := int(binary.BigEndian.Uint32(entry[4:]))
+		length := int(binary.BigEndian.Uint32(entry[8:]))
+		if offset < 0 || length < 12 || offset+length > len(profile) {
+			return ""
+		}
+
+		tag := profile[offset : offset+length]
+		switch string(tag[:4]) {
+		case "desc":
+			count := int(binary.BigEndian.Uint32(tag[8:]))
+			if count > len(tag)-12 || count < 0 {
+				return ""
+			}
+			return strings.TrimRight(string(tag[12:12+count]), "\x00")
+
+		case "mluc":
+			if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:]) == 0 {
+				return ""
+			}
+			textLength := int(binary.BigEndian.Uint32(tag[20:]))
+			textOffset := int(binary.BigEndian.Uint32(tag[24:]))
+			if textOffset < 0 || textLength < 0 || textOffset+textLength > len(tag) {
+				return ""
+			}
+
+			text := make([]uint16, textLength/2)
+			for j := range text {
+				text[j] = binary.BigEndian.Uint16(tag[textOffset+j*2:])
+			}
+			return string(utf16.Decode(text))
+		}
+		return ""
+	}
+	return ""
+}
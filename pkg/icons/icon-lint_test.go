@@ -0,0 +1,135 @@
+package icons
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	. "github.com/onsi/gomega"
+)
+
+func getLintCodes(warnings []IconLintWarning) []string {
+	var result []string
+	for _, warning := range warnings {
+		result = append(result, warning.Format+":"+warning.Code)
+	}
+	return result
+}
+
+func TestLintIcon(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	warnings, err := LintIcon(filepath.Join(getTestDataPath(), "icon.svg"), []string{"icns", "ico", "set"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(warnings).To(BeEmpty())
+
+	warnings, err = LintIcon(filepath.Join(getTestDataPath(), "icon-jpeg2.icns"), []string{"icns", "ico"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(getLintCodes(warnings)).To(Equal([]string{"icns:ICON_SMALLER_THAN_RECOMMENDED"}))
+
+	// 16-bit opaque PNG
+	opaque := image.NewRGBA64(image.Rect(0, 0, 300, 200))
+	for i := 0; i < len(opaque.Pix); i += 8 {
+		opaque.Pix[i+6], opaque.Pix[i+7] = 0xff, 0xff
+	}
+	file := filepath.Join(t.TempDir(), "opaque.png")
+	g.Expect(SaveImage(opaque, file, PNG)).NotTo(HaveOccurred())
+
+	warnings, err = LintIcon(file, []string{"icns", "ico", "set"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(getLintCodes(warnings)).To(Equal([]string{":ICON_NOT_SQUARE", ":ICON_NO_ALPHA", ":ICON_16_BIT", "icns:ICON_TOO_SMALL", "ico:ICON_TOO_SMALL", "set:ICON_TOO_SMALL"}))
+
+	// mask is ignored, so transparent padding is lost and content touches edges
+	file = filepath.Join(t.TempDir(), "mask.svg")
+	g.Expect(os.WriteFile(file, []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100">
+  <mask id="m"><circle cx="50" cy="50" r="40" fill="white"/></mask>
+  <rect width="100" height="100" fill="blue" mask="url(#m)"/>
+</svg>`), 0644)).To(Succeed())
+	warnings, err = LintIcon(file, []string{"icns"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(getLintCodes(warnings)).To(Equal([]string{":ICON_SVG_UNSUPPORTED_FEATURE", ":ICON_NO_ALPHA"}))
+	g.Expect(warnings[0].Message).To(HavePrefix("mask is not supported"))
+}
+
+func TestLintIconEdgesAndColorProfile(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	// full-bleed square with transparent center
+	img := image.NewNRGBA(image.Rect(0, 0, 1024, 1024))
+	for y := 0; y < 1024; y++ {
+		for x := 0; x < 1024; x++ {
+			if x < 100 || y < 100 || x >= 924 || y >= 924 {
+				img.SetNRGBA(x, y, color.NRGBA{R: 10, G: 20, B: 200, A: 255})
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	g.Expect(png.Encode(&buffer, img)).NotTo(HaveOccurred())
+	file := filepath.Join(t.TempDir(), "icon.png")
+	g.Expect(os.WriteFile(file, insertPngChunk(buffer.Bytes(), "iCCP", createIccpChunk("ICC Profile", "Display P3")), 0644)).NotTo(HaveOccurred())
+
+	warnings, err := LintIcon(file, []string{"icns", "ico", "set"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(getLintCodes(warnings)).To(Equal([]string{":ICON_NOT_SRGB", "icns:ICON_CONTENT_TOUCHES_EDGES"}))
+	g.Expect(warnings[0].Message).To(ContainSubstring("Display P3"))
+
+	profile, err := findNonSrgbColorProfile(insertPngChunk(buffer.Bytes(), "iCCP", createIccpChunk("ICC Profile", "sRGB IEC61966-2.1")))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(profile).To(BeEmpty())
+
+	gamma := make([]byte, 4)
+	binary.BigEndian.PutUint32(gamma, 55555)
+	profile, err = findNonSrgbColorProfile(insertPngChunk(buffer.Bytes(), "gAMA", gamma))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(profile).To(Equal("gamma 1.80"))
+}
+
+// chunk is inserted after IHDR
+func insertPngChunk(data []byte, chunkType string, chunk []byte) []byte {
+	var result bytes.Buffer
+	result.Write(data[:33])
+	_ = binary.Write(&result, binary.BigEndian, uint32(len(chunk)))
+	result.WriteString(chunkType)
+	result.Write(chunk)
+	_ = binary.Write(&result, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(chunkType), chunk...)))
+	result.Write(data[33:])
+	return result.Bytes()
+}
+
+// ICC v4 profile with only "desc" tag of multiLocalizedUnicodeType
+func createIccpChunk(name string, description string) []byte {
+	text := utf16.Encode([]rune(description))
+	tag := make([]byte, 28+len(text)*2)
+	copy(tag, "mluc")
+	binary.BigEndian.PutUint32(tag[8:], 1)
+	binary.BigEndian.PutUint32(tag[12:], 12)
+	copy(tag[16:], "enUS")
+	binary.BigEndian.PutUint32(tag[20:], uint32(len(text)*2))
+	binary.BigEndian.PutUint32(tag[24:], 28)
+	for i, c := range text {
+		binary.BigEndian.PutUint16(tag[28+i*2:], c)
+	}
+
+	profile := make([]byte, 144, 144+len(tag))
+	binary.BigEndian.PutUint32(profile[128:], 1)
+	copy(profile[132:], "desc")
+	binary.BigEndian.PutUint32(profile[136:], 144)
+	binary.BigEndian.PutUint32(profile[140:], uint32(len(tag)))
+	profile = append(profile, tag...)
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	_, _ = writer.Write(profile)
+	_ = writer.Close()
+	return append(append([]byte(name), 0, 0), compressed.Bytes()...)
+}
@@ -5,11 +5,15 @@ import (
 	"sort"
 
 	"github.com/develar/errors"
+	"github.com/disintegration/imaging"
 )
 
 type IconInfo struct {
 	File string `json:"file"`
 	Size int    `json:"size"`
+
+	// icon_16x16@2x.png, size is in pixels (32)
+	isRetina bool
 }
 
 func sortBySize(list []IconInfo) {
@@ -23,6 +27,8 @@ type IconConvertRequest struct {
 
 	OutputFormat string
 	OutputDir    string
+	// sizes of ICO entries, default sizes are used if empty
+	IcoSizes []int
 }
 
 type IconConvertResult struct {
@@ -39,8 +45,12 @@ type InputFileInfo struct {
 	MaxIconSize int
 	MaxIconPath string
 	SizeToPath  map[int]string
+	// @2x hand-drawn images by size in pixels
+	retinaSizeToPath map[int]string
 
 	maxImage image.Image
+	// set if source is SVG
+	svg *svgDocument
 
 	recommendedMinSize int
 }
@@ -55,3 +65,36 @@ func (t *InputFileInfo) GetMaxImage() (image.Image, error) {
 	}
 	return t.maxImage, nil
 }
+
+// hand-drawn image of the requested size is used as is, vector source is rendered at the requested size to keep small icons sharp
+func (t *InputFileInfo) getImage(size int) (image.Image, error) {
+	if file, ok := t.SizeToPath[size]; ok {
+		result, err := LoadImage(file)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if result.Bounds().Dx() == size && result.Bounds().Dy() == size {
+			return result, nil
+		}
+	}
+
+	return t.getGeneratedImage(size)
+}
+
+func (t *InputFileInfo) getGeneratedImage(size int) (image.Image, error) {
+	if t.svg != nil {
+		if size == t.MaxIconSize && t.maxImage != nil {
+			return t.maxImage, nil
+		}
+		return t.svg.rasterize(size), nil
+	}
+
+	maxImage, err := t.GetMaxImage()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if maxImage.Bounds().Dx() == size && maxImage.Bounds().Dy() == size {
+		return maxImage, nil
+	}
+	return imaging.Resize(maxImage, size, size, imaging.Lanczos), nil
+}
@@ -6,6 +6,7 @@ import (
 	"image/png"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/biessek/golang-ico"
 	"github.com/develar/app-builder/pkg/util"
@@ -22,6 +23,10 @@ const (
 var icnsTypesForIco = []string{ICNS_256, ICNS_256_RETINA, ICNS_512, ICNS_512_RETINA, ICNS_1024}
 
 func LoadImage(file string) (image.Image, error) {
+	if strings.HasSuffix(file, ".svg") {
+		return rasterizeSvgFile(file, svgDefaultSize)
+	}
+
 	reader, err := os.Open(file)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -37,25 +42,7 @@ func LoadImage(file string) (image.Image, error) {
 	}
 
 	if isIcns {
-		subImageInfoList, err := ReadIcns(bufferedReader)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-
-		for _, osType := range icnsTypesForIco {
-			subImage, ok := subImageInfoList[osType]
-			if ok {
-				_, err = reader.Seek(int64(subImage.Offset), 0)
-				if err != nil {
-					return nil, errors.WithStack(err)
-				}
-				bufferedReader.Reset(reader)
-				// golang doesn't support JPEG2000
-				return DecodeImageAndClose(bufferedReader, reader)
-			}
-		}
-
-		return nil, NewImageSizeError(file, 256)
+		return loadIcnsImage(file)
 	}
 
 	return DecodeImageAndClose(bufferedReader, reader)
@@ -0,0 +1,62 @@
+package icons
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/develar/app-builder/pkg/linuxTools"
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+)
+
+func decodeJpeg2000UsingOpenJpeg(data []byte) (image.Image, error) {
+	tempDir, err := os.MkdirTemp("", "jpeg2000-")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tempDir)
+	}()
+
+	jpeg2File := filepath.Join(tempDir, "image.jp2")
+	if data[0] == 0xff {
+		// raw codestream
+		jpeg2File = filepath.Join(tempDir, "image.j2k")
+	}
+	err = os.WriteFile(jpeg2File, data, 0644)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	opjDecompressPath := "opj_decompress"
+	opjLibPath := ""
+	if !util.IsEnvTrue("USE_SYSTEM_OPG") && runtime.GOOS == "linux" && runtime.GOARCH == "amd64" {
+		opjDecompressPath, err = linuxTools.GetLinuxTool("opj_decompress")
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		opjLibPath = filepath.Join(filepath.Dir(opjDecompressPath), "lib")
+	}
+
+	pngFile := filepath.Join(tempDir, "image.png")
+	command := exec.Command(opjDecompressPath, "-quiet", "-i", jpeg2File, "-o", pngFile)
+	if len(opjLibPath) != 0 {
+		env := os.Environ()
+		env = append(env,
+			fmt.Sprintf("LD_LIBRARY_PATH=%s", opjLibPath+":"+os.Getenv("LD_LIBRARY_PATH")),
+		)
+		command.Env = env
+	}
+
+	_, err = util.Execute(command)
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadImage(pngFile)
+}
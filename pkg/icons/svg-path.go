@@ -0,0 +1,441 @@
+package icons
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/develar/errors"
+)
+
+type svgPoint struct {
+	x float64
+	y float64
+}
+
+type svgPolyline struct {
+	points []svgPoint
+	closed bool
+}
+
+// curves are flattened to polylines in user space, tolerance is in user units (device tolerance divided by scale of transform)
+type svgPathBuilder struct {
+	tolerance float64
+
+	polylines []svgPolyline
+	current   []svgPoint
+
+	startX float64
+	startY float64
+	x      float64
+	y      float64
+}
+
+func newSvgPathBuilder(tolerance float64) *svgPathBuilder {
+	return &svgPathBuilder{tolerance: tolerance}
+}
+
+func (t *svgPathBuilder) flush(closed bool) {
+	if len(t.current) > 1 || (closed && len(t.current) == 1) {
+		t.polylines = append(t.polylines, svgPolyline{points: t.current, closed: closed})
+	}
+	t.current = nil
+}
+
+func (t *svgPathBuilder) moveTo(x float64, y float64) {
+	t.flush(false)
+	t.startX, t.startY = x, y
+	t.x, t.y = x, y
+	t.current = append(t.current, svgPoint{x, y})
+}
+
+func (t *svgPathBuilder) lineTo(x float64, y float64) {
+	if len(t.current) == 0 {
+		// command after close path starts from the start point of closed subpath
+		t.current = append(t.current, svgPoint{t.x, t.y})
+	}
+	t.x, t.y = x, y
+	t.current = append(t.current, svgPoint{x, y})
+}
+
+func (t *svgPathBuilder) closePath() {
+	if len(t.current) != 0 {
+		t.flush(true)
+	}
+	t.x, t.y = t.startX, t.startY
+}
+
+func (t *svgPathBuilder) finish() []svgPolyline {
+	t.flush(false)
+	return t.polylines
+}
+
+func (t *svgPathBuilder) segmentCount(deviation float64) int {
+	n := int(math.Ceil(math.Sqrt(deviation / t.tolerance)))
+	if n < 1 {
+		return 1
+	}
+	if n > 1000 {
+		return 1000
+	}
+	return n
+}
+
+func (t *svgPathBuilder) quadTo(x1 float64, y1 float64, x float64, y float64) {
+	x0, y0 := t.x, t.y
+	n := t.segmentCount(0.25 * math.Hypot(x0-2*x1+x, y0-2*y1+y))
+	for i := 1; i < n; i++ {
+		s := float64(i) / float64(n)
+		u := 1 - s
+		t.lineTo(u*u*x0+2*u*s*x1+s*s*x, u*u*y0+2*u*s*y1+s*s*y)
+	}
+	t.lineTo(x, y)
+}
+
+func (t *svgPathBuilder) cubicTo(x1 float64, y1 float64, x2 float64, y2 float64, x float64, y float64) {
+	x0, y0 := t.x, t.y
+	ddx := math.Max(math.Abs(x0-2*x1+x2), math.Abs(x1-2*x2+x))
+	ddy := math.Max(math.Abs(y0-2*y1+y2), math.Abs(y1-2*y2+y))
+	n := t.segmentCount(0.75 * math.Hypot(ddx, ddy))
+	for i := 1; i < n; i++ {
+		s := float64(i) / float64(n)
+		u := 1 - s
+		a, b, c, d := u*u*u, 3*u*u*s, 3*u*s*s, s*s*s
+		t.lineTo(a*x0+b*x1+c*x2+d*x, a*y0+b*y1+c*y2+d*y)
+	}
+	t.lineTo(x, y)
+}
+
+// https://www.w3.org/TR/SVG11/implnote.html#ArcConversionEndpointToCenter, arc is approximated by cubic curves (at most 90° each)
+func (t *svgPathBuilder) arcTo(rx float64, ry float64, angle float64, largeArc bool, sweep bool, x float64, y float64) {
+	x0, y0 := t.x, t.y
+	if x0 == x && y0 == y {
+		return
+	}
+
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 {
+		t.lineTo(x, y)
+		return
+	}
+
+	sinPhi, cosPhi := math.Sincos(angle * math.Pi / 180)
+	dx2, dy2 := (x0-x)/2, (y0-y)/2
+	x1p := cosPhi*dx2 + sinPhi*dy2
+	y1p := -sinPhi*dx2 + cosPhi*dy2
+
+	// radii are too small - scale up
+	lambda := (x1p*x1p)/(rx*rx) + (y1p*y1p)/(ry*ry)
+	if lambda > 1 {
+		s := math.Sqrt(lambda)
+		rx *= s
+		ry *= s
+	}
+
+	numerator := rx*rx*ry*ry - rx*rx*y1p*y1p - ry*ry*x1p*x1p
+	denominator := rx*rx*y1p*y1p + ry*ry*x1p*x1p
+	coefficient := 0.0
+	if numerator > 0 && denominator > 0 {
+		coefficient = math.Sqrt(numerator / denominator)
+	}
+	if largeArc == sweep {
+		coefficient = -coefficient
+	}
+
+	cxp := coefficient * rx * y1p / ry
+	cyp := -coefficient * ry * x1p / rx
+	cx := cosPhi*cxp - sinPhi*cyp + (x0+x)/2
+	cy := sinPhi*cxp + cosPhi*cyp + (y0+y)/2
+
+	ux, uy := (x1p-cxp)/rx, (y1p-cyp)/ry
+	vx, vy := (-x1p-cxp)/rx, (-y1p-cyp)/ry
+	startAngle := math.Atan2(uy, ux)
+	delta := math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+
+	n := int(math.Ceil(math.Abs(delta)/(math.Pi/2) - 1e-9))
+	if n < 1 {
+		n = 1
+	}
+	step := delta / float64(n)
+	k := 4.0 / 3.0 * math.Tan(step/4)
+
+	pointAt := func(a float64) (float64, float64, float64, float64) {
+		sinA, cosA := math.Sincos(a)
+		px := cx + rx*cosA*cosPhi - ry*sinA*sinPhi
+		py := cy + rx*cosA*sinPhi + ry*sinA*cosPhi
+		// derivative
+		dx := -rx*sinA*cosPhi - ry*cosA*sinPhi
+		dy := -rx*sinA*sinPhi + ry*cosA*cosPhi
+		return px, py, dx, dy
+	}
+
+	a1 := startAngle
+	p1x, p1y, d1x, d1y := pointAt(a1)
+	for i := 0; i < n; i++ {
+		a2 := a1 + step
+		p2x, p2y, d2x, d2y := pointAt(a2)
+		if i == n-1 {
+			p2x, p2y = x, y
+		}
+		t.cubicTo(p1x+k*d1x, p1y+k*d1y, p2x-k*d2x, p2y-k*d2y, p2x, p2y)
+		a1, p1x, p1y, d1x, d1y = a2, p2x, p2y, d2x, d2y
+	}
+}
+
+// kappa for circle approximation by 4 cubic curves
+const svgKappa = 0.5522847498307936
+
+func (t *svgPathBuilder) ellipse(cx float64, cy float64, rx float64, ry float64) {
+	kx, ky := rx*svgKappa, ry*svgKappa
+	t.moveTo(cx+rx, cy)
+	t.cubicTo(cx+rx, cy+ky, cx+kx, cy+ry, cx, cy+ry)
+	t.cubicTo(cx-kx, cy+ry, cx-rx, cy+ky, cx-rx, cy)
+	t.cubicTo(cx-rx, cy-ky, cx-kx, cy-ry, cx, cy-ry)
+	t.cubicTo(cx+kx, cy-ry, cx+rx, cy-ky, cx+rx, cy)
+	t.closePath()
+}
+
+func (t *svgPathBuilder) rect(x float64, y float64, width float64, height float64, rx float64, ry float64) {
+	if rx == 0 || ry == 0 {
+		t.moveTo(x, y)
+		t.lineTo(x+width, y)
+		t.lineTo(x+width, y+height)
+		t.lineTo(x, y+height)
+		t.closePath()
+		return
+	}
+
+	kx, ky := rx*(1-svgKappa), ry*(1-svgKappa)
+	t.moveTo(x+rx, y)
+	t.lineTo(x+width-rx, y)
+	t.cubicTo(x+width-kx, y, x+width, y+ky, x+width, y+ry)
+	t.lineTo(x+width, y+height-ry)
+	t.cubicTo(x+width, y+height-ky, x+width-kx, y+height, x+width-rx, y+height)
+	t.lineTo(x+rx, y+height)
+	t.cubicTo(x+kx, y+height, x, y+height-ky, x, y+height-ry)
+	t.lineTo(x, y+ry)
+	t.cubicTo(x, y+ky, x+kx, y, x+rx, y)
+	t.closePath()
+}
+
+type svgPathScanner struct {
+	data string
+	pos  int
+}
+
+func (t *svgPathScanner) skipSeparators() {
+	for t.pos < len(t.data) {
+		switch t.data[t.pos] {
+		case ' ', '\t', '\n', '\r', '\f', ',':
+			t.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (t *svgPathScanner) isAtNumber() bool {
+	t.skipSeparators()
+	if t.pos >= len(t.data) {
+		return false
+	}
+	c := t.data[t.pos]
+	return (c >= '0' && c <= '9') || c == '-' || c == '+' || c == '.'
+}
+
+func (t *svgPathScanner) number() (float64, error) {
+	if !t.isAtNumber() {
+		return 0, errors.Errorf("number expected at %d in path data", t.pos)
+	}
+
+	start := t.pos
+	if c := t.data[t.pos]; c == '-' || c == '+' {
+		t.pos++
+	}
+	t.skipDigits()
+	// second dot starts a new number: "0.5.5" is "0.5 .5"
+	if t.pos < len(t.data) && t.data[t.pos] == '.' {
+		t.pos++
+		t.skipDigits()
+	}
+	if t.pos < len(t.data) && (t.data[t.pos] == 'e' || t.data[t.pos] == 'E') {
+		exponentStart := t.pos
+		t.pos++
+		if t.pos < len(t.data) && (t.data[t.pos] == '-' || t.data[t.pos] == '+') {
+			t.pos++
+		}
+		digitStart := t.pos
+		t.skipDigits()
+		if digitStart == t.pos {
+			t.pos = exponentStart
+		}
+	}
+
+	result, err := strconv.ParseFloat(t.data[start:t.pos], 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid number %q in path data", t.data[start:t.pos])
+	}
+	return result, nil
+}
+
+func (t *svgPathScanner) skipDigits() {
+	for t.pos < len(t.data) && t.data[t.pos] >= '0' && t.data[t.pos] <= '9' {
+		t.pos++
+	}
+}
+
+// arc flags are allowed to be written without separators ("a1 1 0 011 1")
+func (t *svgPathScanner) flag() (bool, error) {
+	t.skipSeparators()
+	if t.pos < len(t.data) {
+		switch t.data[t.pos] {
+		case '0':
+			t.pos++
+			return false, nil
+		case '1':
+			t.pos++
+			return true, nil
+		}
+	}
+	return false, errors.Errorf("flag expected at %d in path data", t.pos)
+}
+
+func (t *svgPathScanner) numbers(result []float64) error {
+	for i := range result {
+		var err error
+		result[i], err = t.number()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// https://www.w3.org/TR/SVG11/paths.html#PathData, rendering stops at the first error as browsers do, but error is reported
+func parseSvgPathData(data string, builder *svgPathBuilder) error {
+	scanner := &svgPathScanner{data: data}
+	var command byte
+	var previousCommand byte
+	// last control point of previous curve for smooth curves
+	var controlX, controlY float64
+	args := make([]float64, 7)
+
+	for {
+		scanner.skipSeparators()
+		if scanner.pos >= len(data) {
+			return nil
+		}
+
+		c := data[scanner.pos]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			command = c
+			scanner.pos++
+		} else if command == 0 || command == 'z' || command == 'Z' {
+			return errors.Errorf("command expected at %d in path data", scanner.pos)
+		}
+
+		isRelative := command >= 'a' && command <= 'z'
+		var baseX, baseY float64
+		if isRelative {
+			baseX, baseY = builder.x, builder.y
+		}
+
+		upperCommand := command &^ 0x20
+		switch upperCommand {
+		case 'M':
+			if err := scanner.numbers(args[:2]); err != nil {
+				return err
+			}
+			builder.moveTo(baseX+args[0], baseY+args[1])
+			// subsequent pairs are implicit line commands
+			if isRelative {
+				command = 'l'
+			} else {
+				command = 'L'
+			}
+
+		case 'L':
+			if err := scanner.numbers(args[:2]); err != nil {
+				return err
+			}
+			builder.lineTo(baseX+args[0], baseY+args[1])
+
+		case 'H':
+			if err := scanner.numbers(args[:1]); err != nil {
+				return err
+			}
+			builder.lineTo(baseX+args[0], builder.y)
+
+		case 'V':
+			if err := scanner.numbers(args[:1]); err != nil {
+				return err
+			}
+			builder.lineTo(builder.x, baseY+args[0])
+
+		case 'C':
+			if err := scanner.numbers(args[:6]); err != nil {
+				return err
+			}
+			controlX, controlY = baseX+args[2], baseY+args[3]
+			builder.cubicTo(baseX+args[0], baseY+args[1], controlX, controlY, baseX+args[4], baseY+args[5])
+
+		case 'S':
+			if err := scanner.numbers(args[:4]); err != nil {
+				return err
+			}
+			x1, y1 := builder.x, builder.y
+			if previousCommand == 'C' || previousCommand == 'S' {
+				x1, y1 = 2*builder.x-controlX, 2*builder.y-controlY
+			}
+			controlX, controlY = baseX+args[0], baseY+args[1]
+			builder.cubicTo(x1, y1, controlX, controlY, baseX+args[2], baseY+args[3])
+
+		case 'Q':
+			if err := scanner.numbers(args[:4]); err != nil {
+				return err
+			}
+			controlX, controlY = baseX+args[0], baseY+args[1]
+			builder.quadTo(controlX, controlY, baseX+args[2], baseY+args[3])
+
+		case 'T':
+			if err := scanner.numbers(args[:2]); err != nil {
+				return err
+			}
+			if previousCommand == 'Q' || previousCommand == 'T' {
+				controlX, controlY = 2*builder.x-controlX, 2*builder.y-controlY
+			} else {
+				controlX, controlY = builder.x, builder.y
+			}
+			builder.quadTo(controlX, controlY, baseX+args[0], baseY+args[1])
+
+		case 'A':
+			if err := scanner.numbers(args[:3]); err != nil {
+				return err
+			}
+			largeArc, err := scanner.flag()
+			if err != nil {
+				return err
+			}
+			sweep, err := scanner.flag()
+			if err != nil {
+				return err
+			}
+			if err := scanner.numbers(args[3:5]); err != nil {
+				return err
+			}
+			builder.arcTo(args[0], args[1], args[2], largeArc, sweep, baseX+args[3], baseY+args[4])
+
+		case 'Z':
+			builder.closePath()
+
+		default:
+			return errors.Errorf("unknown command %q in path data", command)
+		}
+
+		previousCommand = upperCommand
+	}
+}
@@ -0,0 +1,434 @@
+package icons
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// premultiplied, 0..1
+type svgColor struct {
+	r float64
+	g float64
+	b float64
+	a float64
+}
+
+type svgPaint interface {
+	colorAt(x float64, y float64) svgColor
+}
+
+type svgSolidPaint struct {
+	color svgColor
+}
+
+func (t *svgSolidPaint) colorAt(x float64, y float64) svgColor {
+	return t.color
+}
+
+type svgGradientPaint struct {
+	isRadial bool
+	// device space to gradient space
+	inverse svgMatrix
+	spread  string
+
+	x1 float64
+	y1 float64
+	x2 float64
+	y2 float64
+
+	cx float64
+	cy float64
+	r  float64
+	fx float64
+	fy float64
+
+	colors [256]svgColor
+}
+
+func (t *svgGradientPaint) colorAt(x float64, y float64) svgColor {
+	x, y = t.inverse.apply(x, y)
+
+	var offset float64
+	if t.isRadial {
+		offset = t.radialOffset(x, y)
+	} else {
+		dx, dy := t.x2-t.x1, t.y2-t.y1
+		length := dx*dx + dy*dy
+		if length != 0 {
+			offset = ((x-t.x1)*dx + (y-t.y1)*dy) / length
+		}
+	}
+
+	switch t.spread {
+	case "repeat":
+		offset -= math.Floor(offset)
+	case "reflect":
+		offset = math.Mod(math.Abs(offset), 2)
+		if offset > 1 {
+			offset = 2 - offset
+		}
+	default:
+		offset = math.Max(0, math.Min(1, offset))
+	}
+	return t.colors[int(offset*255+0.5)]
+}
+
+// offset of the circle (interpolated from focal point to the gradient circle) that passes through the point
+func (t *svgGradientPaint) radialOffset(x float64, y float64) float64 {
+	dx, dy := x-t.fx, y-t.fy
+	a := dx*dx + dy*dy
+	if a == 0 {
+		return 0
+	}
+
+	fcx, fcy := t.fx-t.cx, t.fy-t.cy
+	b := 2 * (dx*fcx + dy*fcy)
+	c := fcx*fcx + fcy*fcy - t.r*t.r
+	discriminant := b*b - 4*a*c
+	if discriminant < 0 {
+		return 1
+	}
+
+	s := (-b + math.Sqrt(discriminant)) / (2 * a)
+	if s <= 0 {
+		return 1
+	}
+	return 1 / s
+}
+
+const svgSubsampleCount = 16
+
+type svgEdge struct {
+	x0 float64
+	y0 float64
+	x1 float64
+	y1 float64
+	// +1 or -1
+	direction int
+}
+
+// scanline rasterizer: vertical supersampling and exact horizontal coverage, polygons are implicitly closed
+func fillSvgPolygons(canvas *image.RGBA, polygons [][]svgPoint, isEvenOdd bool, paint svgPaint, opacity float64) {
+	width, height := canvas.Rect.Dx(), canvas.Rect.Dy()
+
+	var edges []svgEdge
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, polygon := range polygons {
+		for i, p0 := range polygon {
+			p1 := polygon[(i+1)%len(polygon)]
+			minX, maxX = math.Min(minX, p0.x), math.Max(maxX, p0.x)
+			minY, maxY = math.Min(minY, p0.y), math.Max(maxY, p0.y)
+			if p0.y == p1.y || math.IsNaN(p0.y) || math.IsNaN(p1.y) {
+				continue
+			}
+			if p0.y < p1.y {
+				edges = append(edges, svgEdge{p0.x, p0.y, p1.x, p1.y, 1})
+			} else {
+				edges = append(edges, svgEdge{p1.x, p1.y, p0.x, p0.y, -1})
+			}
+		}
+	}
+
+	if len(edges) == 0 {
+		return
+	}
+
+	rowStart := int(math.Max(0, math.Floor(minY)))
+	rowEnd := int(math.Min(float64(height), math.Ceil(maxY)))
+	columnStart := int(math.Max(0, math.Floor(minX)))
+	columnEnd := int(math.Min(float64(width), math.Ceil(maxX)))
+	if rowStart >= rowEnd || columnStart >= columnEnd {
+		return
+	}
+
+	sort.Slice(edges, func(i, j int) bool { return edges[i].y0 < edges[j].y0 })
+
+	// partial coverage of pixels and difference array for fully covered runs
+	coverage := make([]float64, width+1)
+	runs := make([]float64, width+1)
+	type crossing struct {
+		x         float64
+		direction int
+	}
+	var crossings []crossing
+	var active []svgEdge
+	nextEdge := 0
+	weight := 1.0 / svgSubsampleCount
+
+	for row := rowStart; row < rowEnd; row++ {
+		rowTop, rowBottom := float64(row), float64(row+1)
+
+		// drop finished edges and add started ones
+		n := 0
+		for _, edge := range active {
+			if edge.y1 > rowTop {
+				active[n] = edge
+				n++
+			}
+		}
+		active = active[:n]
+		for nextEdge < len(edges) && edges[nextEdge].y0 < rowBottom {
+			if edges[nextEdge].y1 > rowTop {
+				active = append(active, edges[nextEdge])
+			}
+			nextEdge++
+		}
+		if len(active) == 0 {
+			continue
+		}
+
+		for sample := 0; sample < svgSubsampleCount; sample++ {
+			sampleY := rowTop + (float64(sample)+0.5)/svgSubsampleCount
+
+			crossings = crossings[:0]
+			for _, edge := range active {
+				if sampleY < edge.y0 || sampleY >= edge.y1 {
+					continue
+				}
+				x := edge.x0 + (sampleY-edge.y0)*(edge.x1-edge.x0)/(edge.y1-edge.y0)
+				crossings = append(crossings, crossing{x, edge.direction})
+			}
+			if len(crossings) < 2 {
+				continue
+			}
+			sort.Slice(crossings, func(i, j int) bool { return crossings[i].x < crossings[j].x })
+
+			winding := 0
+			for i := 0; i < len(crossings)-1; i++ {
+				winding += crossings[i].direction
+				isInside := winding != 0
+				if isEvenOdd {
+					isInside = winding%2 != 0
+				}
+				if isInside {
+					addSvgSpan(coverage, runs, crossings[i].x, crossings[i+1].x, width, weight)
+				}
+			}
+		}
+
+		run := 0.0
+		y := row + canvas.Rect.Min.Y
+		for column := columnStart; column < columnEnd; column++ {
+			run += runs[column]
+			alpha := math.Min(1, coverage[column]+run)
+			coverage[column] = 0
+			runs[column] = 0
+			if alpha <= 0 {
+				continue
+			}
+
+			x := column + canvas.Rect.Min.X
+			color := paint.colorAt(float64(x)+0.5, float64(y)+0.5)
+			compositeSvgPixel(canvas, x, y, color, alpha*opacity)
+		}
+		runs[columnEnd] = 0
+		coverage[columnEnd] = 0
+	}
+}
+
+func addSvgSpan(coverage []float64, runs []float64, x0 float64, x1 float64, width int, weight float64) {
+	x0 = math.Max(0, x0)
+	x1 = math.Min(float64(width), x1)
+	if x0 >= x1 {
+		return
+	}
+
+	start, end := int(x0), int(x1)
+	if start == end {
+		coverage[start] += (x1 - x0) * weight
+		return
+	}
+
+	coverage[start] += (float64(start+1) - x0) * weight
+	runs[start+1] += weight
+	runs[end] -= weight
+	if end < width {
+		coverage[end] += (x1 - float64(end)) * weight
+	}
+}
+
+// source over
+func compositeSvgPixel(canvas *image.RGBA, x int, y int, color svgColor, alpha float64) {
+	sa := color.a * alpha
+	if sa <= 0 {
+		return
+	}
+
+	offset := canvas.PixOffset(x, y)
+	pixel := canvas.Pix[offset : offset+4 : offset+4]
+	inverse := 1 - sa
+	pixel[0] = toSvgChannel(color.r*alpha + float64(pixel[0])/255*inverse)
+	pixel[1] = toSvgChannel(color.g*alpha + float64(pixel[1])/255*inverse)
+	pixel[2] = toSvgChannel(color.b*alpha + float64(pixel[2])/255*inverse)
+	pixel[3] = toSvgChannel(sa + float64(pixel[3])/255*inverse)
+}
+
+func toSvgChannel(value float64) uint8 {
+	if value >= 1 {
+		return 255
+	}
+	if value <= 0 {
+		return 0
+	}
+	return uint8(value*255 + 0.5)
+}
+
+type svgStrokeStyle struct {
+	width      float64
+	lineCap    string
+	lineJoin   string
+	miterLimit float64
+	// user units
+	tolerance float64
+}
+
+// stroke outline as a set of polygons (segment quads, joins and caps) of the same orientation, so, union is filled using nonzero rule
+func strokeSvgPolylines(polylines []svgPolyline, style svgStrokeStyle) [][]svgPoint {
+	halfWidth := style.width / 2
+	var result [][]svgPoint
+	add := func(polygon ...svgPoint) {
+		result = append(result, orientSvgPolygon(polygon))
+	}
+
+	for _, polyline := range polylines {
+		points := dedupeSvgPoints(polyline.points, polyline.closed)
+		if len(points) == 1 {
+			// zero length subpath is painted only for round and square caps
+			p := points[0]
+			switch style.lineCap {
+			case "round":
+				add(svgCirclePolygon(p, halfWidth, style.tolerance)...)
+			case "square":
+				add(svgPoint{p.x - halfWidth, p.y - halfWidth}, svgPoint{p.x + halfWidth, p.y - halfWidth}, svgPoint{p.x + halfWidth, p.y + halfWidth}, svgPoint{p.x - halfWidth, p.y + halfWidth})
+			}
+			continue
+		}
+
+		segmentCount := len(points) - 1
+		if polyline.closed {
+			segmentCount++
+		}
+
+		normals := make([]svgPoint, segmentCount)
+		directions := make([]svgPoint, segmentCount)
+		for i := 0; i < segmentCount; i++ {
+			p0, p1 := points[i], points[(i+1)%len(points)]
+			length := math.Hypot(p1.x-p0.x, p1.y-p0.y)
+			d := svgPoint{(p1.x - p0.x) / length, (p1.y - p0.y) / length}
+			directions[i] = d
+			normals[i] = svgPoint{-d.y * halfWidth, d.x * halfWidth}
+			n := normals[i]
+			add(svgPoint{p0.x + n.x, p0.y + n.y}, svgPoint{p1.x + n.x, p1.y + n.y}, svgPoint{p1.x - n.x, p1.y - n.y}, svgPoint{p0.x - n.x, p0.y - n.y})
+		}
+
+		// joins
+		for i := 0; i < segmentCount; i++ {
+			if !polyline.closed && i == segmentCount-1 {
+				break
+			}
+			next := (i + 1) % segmentCount
+			p := points[next%len(points)]
+			addSvgJoin(p, normals[i], normals[next], directions[i], directions[next], halfWidth, style, add)
+		}
+
+		if !polyline.closed {
+			addSvgCap(points[0], directions[0], normals[0], true, halfWidth, style, add)
+			addSvgCap(points[len(points)-1], directions[segmentCount-1], normals[segmentCount-1], false, halfWidth, style, add)
+		}
+	}
+	return result
+}
+
+func addSvgJoin(p svgPoint, n0 svgPoint, n1 svgPoint, d0 svgPoint, d1 svgPoint, halfWidth float64, style svgStrokeStyle, add func(polygon ...svgPoint)) {
+	cross := d0.x*d1.y - d0.y*d1.x
+	if math.Abs(cross) < 1e-12 && d0.x*d1.x+d0.y*d1.y > 0 {
+		// collinear
+		return
+	}
+
+	if style.lineJoin == "round" {
+		add(svgCirclePolygon(p, halfWidth, style.tolerance)...)
+		return
+	}
+
+	// outer side is opposite to the turn direction
+	sign := 1.0
+	if cross > 0 {
+		sign = -1
+	}
+	a := svgPoint{p.x + sign*n0.x, p.y + sign*n0.y}
+	b := svgPoint{p.x + sign*n1.x, p.y + sign*n1.y}
+
+	if style.lineJoin != "bevel" && style.lineJoin != "miter-clip" {
+		// miter length ratio is 1 / sin(θ / 2), θ is the angle between segments
+		cosTheta := -(d0.x*d1.x + d0.y*d1.y)
+		sinHalf := math.Sqrt(math.Max(0, (1-cosTheta)/2))
+		if sinHalf > 1e-12 && 1/sinHalf <= style.miterLimit {
+			bisectorX, bisectorY := a.x+b.x-2*p.x, a.y+b.y-2*p.y
+			length := math.Hypot(bisectorX, bisectorY)
+			if length > 0 {
+				miterLength := halfWidth / sinHalf
+				tip := svgPoint{p.x + bisectorX/length*miterLength, p.y + bisectorY/length*miterLength}
+				add(p, a, tip, b)
+				return
+			}
+		}
+	}
+	add(p, a, b)
+}
+
+func addSvgCap(p svgPoint, d svgPoint, n svgPoint, isStart bool, halfWidth float64, style svgStrokeStyle, add func(polygon ...svgPoint)) {
+	switch style.lineCap {
+	case "round":
+		add(svgCirclePolygon(p, halfWidth, style.tolerance)...)
+	case "square":
+		ex, ey := d.x*halfWidth, d.y*halfWidth
+		if isStart {
+			ex, ey = -ex, -ey
+		}
+		add(svgPoint{p.x + n.x, p.y + n.y}, svgPoint{p.x + n.x + ex, p.y + n.y + ey}, svgPoint{p.x - n.x + ex, p.y - n.y + ey}, svgPoint{p.x - n.x, p.y - n.y})
+	}
+}
+
+func svgCirclePolygon(center svgPoint, radius float64, tolerance float64) []svgPoint {
+	n := 8
+	if radius > tolerance {
+		n = int(math.Ceil(math.Pi / math.Acos(1-tolerance/radius)))
+	}
+	n = max(8, min(n, 256))
+
+	result := make([]svgPoint, n)
+	for i := range result {
+		sin, cos := math.Sincos(2 * math.Pi * float64(i) / float64(n))
+		result[i] = svgPoint{center.x + radius*cos, center.y + radius*sin}
+	}
+	return result
+}
+
+func dedupeSvgPoints(points []svgPoint, isClosed bool) []svgPoint {
+	result := make([]svgPoint, 0, len(points))
+	for _, p := range points {
+		if len(result) == 0 || result[len(result)-1] != p {
+			result = append(result, p)
+		}
+	}
+	if isClosed && len(result) > 1 && result[0] == result[len(result)-1] {
+		result = result[:len(result)-1]
+	}
+	return result
+}
+
+func orientSvgPolygon(polygon []svgPoint) []svgPoint {
+	area := 0.0
+	for i, p0 := range polygon {
+		p1 := polygon[(i+1)%len(polygon)]
+		area += p0.x*p1.y - p1.x*p0.y
+	}
+	if area < 0 {
+		for i, j := 0, len(polygon)-1; i < j; i, j = i+1, j-1 {
+			polygon[i], polygon[j] = polygon[j], polygon[i]
+		}
+	}
+	return polygon
+}
@@ -0,0 +1,1131 @@
+package icons
+
+import (
+	"bufio"
+	"encoding/xml"
+	"image"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"go.uber.org/zap"
+)
+
+// size of bitmap produced from SVG when size is not dictated by output format
+const svgDefaultSize = 1024
+
+// max distance in device pixels between curve and its flattened polyline
+const svgFlatteningTolerance = 0.1
+
+// Subset of SVG 1.1 that is enough for icons produced by design tools: path and basic shapes, groups, use, transforms,
+// solid colors, linear and radial gradients, fill rules, strokes (without dashes), opacity and simple style sheets (tag, class and id selectors).
+// Not supported (ignored): text, images, filters, masks and clip paths, patterns, markers. Group opacity is applied to each child separately.
+type svgDocument struct {
+	root *svgNode
+	ids  map[string]*svgNode
+
+	// clip-path, mask and filter referenced by rendered elements, element is drawn without it (document is rasterized concurrently)
+	unsupportedFeatures      map[string]bool
+	unsupportedFeaturesMutex sync.Mutex
+
+	viewBoxX      float64
+	viewBoxY      float64
+	viewBoxWidth  float64
+	viewBoxHeight float64
+
+	preserveAspectRatio string
+}
+
+type svgNode struct {
+	name       string
+	attributes map[string]string
+	children   []*svgNode
+	// content of style element
+	text string
+}
+
+func loadSvg(file string) (*svgDocument, error) {
+	reader, err := os.Open(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer util.Close(reader)
+
+	document, err := decodeSvg(bufio.NewReader(reader))
+	if err != nil {
+		log.Debug("cannot parse svg", zap.String("file", file), zap.Error(err))
+		return nil, errors.WithStack(&ImageFormatError{file, "ERR_ICON_UNKNOWN_FORMAT"})
+	}
+	return document, nil
+}
+
+func rasterizeSvgFile(file string, size int) (image.Image, error) {
+	document, err := loadSvg(file)
+	if err != nil {
+		return nil, err
+	}
+	return document.rasterize(size), nil
+}
+
+func decodeSvg(reader io.Reader) (*svgDocument, error) {
+	decoder := xml.NewDecoder(reader)
+	// files exported by Illustrator use entities declared in DTD
+	decoder.Strict = false
+	// only ASCII is significant for rendering
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var root *svgNode
+	var stack []*svgNode
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			node := &svgNode{name: token.Name.Local, attributes: make(map[string]string, len(token.Attr))}
+			for _, attribute := range token.Attr {
+				if attribute.Name.Space != "xmlns" && attribute.Name.Local != "xmlns" {
+					node.attributes[attribute.Name.Local] = attribute.Value
+				}
+			}
+
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, errors.New("several root elements")
+				}
+				root = node
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			}
+			stack = append(stack, node)
+
+		case xml.EndElement:
+			if len(stack) != 0 {
+				stack = stack[:len(stack)-1]
+			}
+
+		case xml.CharData:
+			if len(stack) != 0 && stack[len(stack)-1].name == "style" {
+				stack[len(stack)-1].text += string(token)
+			}
+		}
+	}
+
+	if root == nil || root.name != "svg" {
+		return nil, errors.New("svg element not found")
+	}
+
+	document := &svgDocument{root: root, ids: make(map[string]*svgNode)}
+	document.applyStyles()
+
+	viewBox := strings.Fields(strings.ReplaceAll(root.attributes["viewBox"], ",", " "))
+	if len(viewBox) == 4 {
+		var values [4]float64
+		for i, value := range viewBox {
+			var err error
+			values[i], err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, errors.Errorf("invalid viewBox %q", root.attributes["viewBox"])
+			}
+		}
+		document.viewBoxX, document.viewBoxY, document.viewBoxWidth, document.viewBoxHeight = values[0], values[1], values[2], values[3]
+	} else {
+		document.viewBoxWidth, _ = parseSvgLength(root.attributes["width"], 0)
+		document.viewBoxHeight, _ = parseSvgLength(root.attributes["height"], 0)
+	}
+
+	if document.viewBoxWidth <= 0 || document.viewBoxHeight <= 0 {
+		return nil, errors.New("svg must specify viewBox or width and height")
+	}
+
+	document.preserveAspectRatio = strings.TrimSpace(root.attributes["preserveAspectRatio"])
+	return document, nil
+}
+
+// rendered into square bitmap, content is scaled and aligned according to preserveAspectRatio (default is centered, aspect ratio is kept)
+func (t *svgDocument) rasterize(size int) *image.RGBA {
+	canvas := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	scaleX := float64(size) / t.viewBoxWidth
+	scaleY := float64(size) / t.viewBoxHeight
+	alignX, alignY := 0.5, 0.5
+	if t.preserveAspectRatio == "none" {
+		alignX, alignY = 0, 0
+	} else {
+		fields := strings.Fields(t.preserveAspectRatio)
+		if len(fields) != 0 && len(fields[0]) == 8 {
+			alignX = svgAlignment(fields[0][1:4])
+			alignY = svgAlignment(fields[0][5:8])
+		}
+
+		if len(fields) > 1 && fields[1] == "slice" {
+			scaleX = math.Max(scaleX, scaleY)
+		} else {
+			scaleX = math.Min(scaleX, scaleY)
+		}
+		scaleY = scaleX
+	}
+
+	transform := svgMatrix{
+		a: scaleX,
+		d: scaleY,
+		e: -t.viewBoxX*scaleX + alignX*(float64(size)-t.viewBoxWidth*scaleX),
+		f: -t.viewBoxY*scaleY + alignY*(float64(size)-t.viewBoxHeight*scaleY),
+	}
+
+	renderer := &svgRenderer{document: t, canvas: canvas}
+	renderer.render(t.root, svgState{transform: transform, opacity: 1, style: defaultSvgStyle()})
+	return canvas
+}
+
+func svgAlignment(value string) float64 {
+	switch value {
+	case "Min":
+		return 0
+	case "Max":
+		return 1
+	default:
+		return 0.5
+	}
+}
+
+type svgCssRule struct {
+	selector     string
+	specificity  int
+	declarations map[string]string
+}
+
+var svgSimpleSelector = regexp.MustCompile(`^(\*|[#.]?[A-Za-z_][\w-]*)$`)
+
+// presentation attributes are overridden by style sheet rules and then by style attribute, resolved values are stored as attributes
+func (t *svgDocument) applyStyles() {
+	var rules []svgCssRule
+	var collectRules func(node *svgNode)
+	collectRules = func(node *svgNode) {
+		if node.name == "style" {
+			rules = append(rules, parseSvgStyleSheet(node.text)...)
+		}
+		for _, child := range node.children {
+			collectRules(child)
+		}
+	}
+	collectRules(t.root)
+
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].specificity < rules[j].specificity })
+
+	var apply func(node *svgNode)
+	apply = func(node *svgNode) {
+		if id := node.attributes["id"]; len(id) != 0 {
+			t.ids[id] = node
+		}
+
+		for _, rule := range rules {
+			if rule.matches(node) {
+				for name, value := range rule.declarations {
+					node.attributes[name] = value
+				}
+			}
+		}
+
+		if style, ok := node.attributes["style"]; ok {
+			for name, value := range parseSvgDeclarations(style) {
+				node.attributes[name] = value
+			}
+		}
+
+		for _, child := range node.children {
+			apply(child)
+		}
+	}
+	apply(t.root)
+}
+
+func (t *svgCssRule) matches(node *svgNode) bool {
+	switch {
+	case t.selector == "*":
+		return true
+	case strings.HasPrefix(t.selector, "#"):
+		return node.attributes["id"] == t.selector[1:]
+	case strings.HasPrefix(t.selector, "."):
+		for _, class := range strings.Fields(node.attributes["class"]) {
+			if class == t.selector[1:] {
+				return true
+			}
+		}
+		return false
+	default:
+		return node.name == t.selector
+	}
+}
+
+// only simple selectors are supported, rules with other selectors are ignored
+func parseSvgStyleSheet(text string) []svgCssRule {
+	for {
+		start := strings.Index(text, "/*")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(text[start+2:], "*/")
+		if end < 0 {
+			text = text[:start]
+			break
+		}
+		text = text[:start] + text[start+2+end+2:]
+	}
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "<![CDATA[", ""), "]]>", "")
+
+	var result []svgCssRule
+	for _, block := range strings.Split(text, "}") {
+		index := strings.Index(block, "{")
+		if index < 0 {
+			continue
+		}
+
+		declarations := parseSvgDeclarations(block[index+1:])
+		for _, selector := range strings.Split(block[:index], ",") {
+			selector = strings.TrimSpace(selector)
+			if !svgSimpleSelector.MatchString(selector) {
+				continue
+			}
+
+			specificity := 1
+			if selector == "*" {
+				specificity = 0
+			} else if selector[0] == '.' {
+				specificity = 2
+			} else if selector[0] == '#' {
+				specificity = 3
+			}
+			result = append(result, svgCssRule{selector: selector, specificity: specificity, declarations: declarations})
+		}
+	}
+	return result
+}
+
+func parseSvgDeclarations(text string) map[string]string {
+	result := make(map[string]string)
+	for _, declaration := range strings.Split(text, ";") {
+		index := strings.Index(declaration, ":")
+		if index < 0 {
+			continue
+		}
+
+		name := strings.TrimSpace(declaration[:index])
+		value := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(declaration[index+1:]), "!important"))
+		if len(name) != 0 {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+// inherited properties
+type svgStyle struct {
+	fill          string
+	fillOpacity   float64
+	fillRule      string
+	stroke        string
+	strokeOpacity float64
+	strokeWidth   float64
+	lineCap       string
+	lineJoin      string
+	miterLimit    float64
+	color         string
+	isVisible     bool
+}
+
+func defaultSvgStyle() svgStyle {
+	return svgStyle{
+		fill:          "black",
+		fillOpacity:   1,
+		fillRule:      "nonzero",
+		stroke:        "none",
+		strokeOpacity: 1,
+		strokeWidth:   1,
+		lineCap:       "butt",
+		lineJoin:      "miter",
+		miterLimit:    4,
+		color:         "black",
+		isVisible:     true,
+	}
+}
+
+type svgState struct {
+	transform svgMatrix
+	// product of opacity of element and its ancestors
+	opacity float64
+	style   svgStyle
+}
+
+type svgRenderer struct {
+	document *svgDocument
+	canvas   *image.RGBA
+	useDepth int
+}
+
+func (t *svgRenderer) computeState(node *svgNode, state svgState) svgState {
+	attributes := node.attributes
+	value := func(name string) (string, bool) {
+		result, ok := attributes[name]
+		if !ok || result == "inherit" {
+			return "", false
+		}
+		return result, true
+	}
+
+	style := &state.style
+	if v, ok := value("fill"); ok {
+		style.fill = v
+	}
+	if v, ok := value("stroke"); ok {
+		style.stroke = v
+	}
+	if v, ok := value("color"); ok {
+		style.color = v
+	}
+	if v, ok := value("fill-rule"); ok {
+		style.fillRule = v
+	}
+	if v, ok := value("stroke-linecap"); ok {
+		style.lineCap = v
+	}
+	if v, ok := value("stroke-linejoin"); ok {
+		style.lineJoin = v
+	}
+	if v, ok := value("visibility"); ok {
+		style.isVisible = v == "visible"
+	}
+	if v, ok := value("fill-opacity"); ok {
+		style.fillOpacity = parseSvgOpacity(v)
+	}
+	if v, ok := value("stroke-opacity"); ok {
+		style.strokeOpacity = parseSvgOpacity(v)
+	}
+	if v, ok := value("stroke-width"); ok {
+		if width, ok := parseSvgLength(v, t.diagonal()); ok {
+			style.strokeWidth = width
+		}
+	}
+	if v, ok := value("stroke-miterlimit"); ok {
+		if limit, err := strconv.ParseFloat(v, 64); err == nil && limit >= 1 {
+			style.miterLimit = limit
+		}
+	}
+	if v, ok := value("opacity"); ok {
+		state.opacity *= parseSvgOpacity(v)
+	}
+	if v, ok := attributes["transform"]; ok {
+		transform, err := parseSvgTransform(v)
+		if err != nil {
+			log.Debug("cannot parse svg transform", zap.String("transform", v), zap.Error(err))
+		} else {
+			state.transform = state.transform.multiply(transform)
+		}
+	}
+	return state
+}
+
+func (t *svgRenderer) render(node *svgNode, state svgState) {
+	if node.attributes["display"] == "none" {
+		return
+	}
+
+	state = t.computeState(node, state)
+	if state.opacity <= 0 {
+		return
+	}
+
+	for _, name := range svgUnsupportedReferences {
+		if value, ok := node.attributes[name]; ok && value != "none" {
+			t.document.addUnsupportedFeature(name, node)
+		}
+	}
+
+	switch node.name {
+	case "svg":
+		if node != t.document.root {
+			state.transform = state.transform.multiply(svgMatrix{a: 1, d: 1, e: t.length(node, "x", t.document.viewBoxWidth), f: t.length(node, "y", t.document.viewBoxHeight)})
+		}
+		t.renderChildren(node, state)
+
+	case "g", "a", "switch":
+		t.renderChildren(node, state)
+
+	case "use":
+		t.renderUse(node, state)
+
+	case "path", "rect", "circle", "ellipse", "line", "polyline", "polygon":
+		if state.style.isVisible {
+			t.drawShape(node, state)
+		}
+
+	case "defs", "symbol", "linearGradient", "radialGradient", "stop", "style", "title", "desc", "metadata", "clipPath", "mask", "pattern", "marker", "filter":
+		// not rendered directly
+
+	default:
+		log.Debug("svg element is not supported", zap.String("element", node.name))
+	}
+}
+
+// presentation attributes referencing element that is not rendered
+var svgUnsupportedReferences = []string{"clip-path", "mask", "filter"}
+
+// reported once per document, not for each rasterized size
+func (t *svgDocument) addUnsupportedFeature(name string, node *svgNode) {
+	t.unsupportedFeaturesMutex.Lock()
+	defer t.unsupportedFeaturesMutex.Unlock()
+	if t.unsupportedFeatures[name] {
+		return
+	}
+
+	if t.unsupportedFeatures == nil {
+		t.unsupportedFeatures = make(map[string]bool)
+	}
+	t.unsupportedFeatures[name] = true
+	log.Warn("svg feature is not supported, element is rendered without it", zap.String("feature", name), zap.String("element", node.name), zap.String("value", node.attributes[name]))
+}
+
+// sorted names of unsupported features found by rasterize
+func (t *svgDocument) getUnsupportedFeatures() []string {
+	t.unsupportedFeaturesMutex.Lock()
+	defer t.unsupportedFeaturesMutex.Unlock()
+	var result []string
+	for name := range t.unsupportedFeatures {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (t *svgRenderer) renderChildren(node *svgNode, state svgState) {
+	for _, child := range node.children {
+		t.render(child, state)
+	}
+}
+
+func (t *svgRenderer) renderUse(node *svgNode, state svgState) {
+	target := t.document.ids[strings.TrimPrefix(node.attributes["href"], "#")]
+	// guard against reference cycles
+	if target == nil || t.useDepth > 16 {
+		return
+	}
+
+	state.transform = state.transform.multiply(svgMatrix{a: 1, d: 1, e: t.length(node, "x", t.document.viewBoxWidth), f: t.length(node, "y", t.document.viewBoxHeight)})
+
+	t.useDepth++
+	defer func() { t.useDepth-- }()
+	if target.name == "symbol" {
+		t.renderChildren(target, t.computeState(target, state))
+	} else {
+		t.render(target, state)
+	}
+}
+
+func (t *svgRenderer) diagonal() float64 {
+	width, height := t.document.viewBoxWidth, t.document.viewBoxHeight
+	return math.Sqrt((width*width + height*height) / 2)
+}
+
+func (t *svgRenderer) length(node *svgNode, name string, reference float64) float64 {
+	result, _ := parseSvgLength(node.attributes[name], reference)
+	return result
+}
+
+func (t *svgRenderer) buildShape(node *svgNode, builder *svgPathBuilder) {
+	width, height := t.document.viewBoxWidth, t.document.viewBoxHeight
+	switch node.name {
+	case "path":
+		err := parseSvgPathData(node.attributes["d"], builder)
+		if err != nil {
+			// rendered up to the first error as browsers do
+			log.Debug("cannot parse svg path data", zap.Error(err))
+		}
+
+	case "rect":
+		w, h := t.length(node, "width", width), t.length(node, "height", height)
+		if w <= 0 || h <= 0 {
+			return
+		}
+
+		rx, isRxSpecified := parseSvgLength(node.attributes["rx"], width)
+		ry, isRySpecified := parseSvgLength(node.attributes["ry"], height)
+		if !isRySpecified {
+			ry = rx
+		} else if !isRxSpecified {
+			rx = ry
+		}
+		rx = math.Max(0, math.Min(rx, w/2))
+		ry = math.Max(0, math.Min(ry, h/2))
+		builder.rect(t.length(node, "x", width), t.length(node, "y", height), w, h, rx, ry)
+
+	case "circle":
+		r := t.length(node, "r", t.diagonal())
+		if r > 0 {
+			builder.ellipse(t.length(node, "cx", width), t.length(node, "cy", height), r, r)
+		}
+
+	case "ellipse":
+		rx, ry := t.length(node, "rx", width), t.length(node, "ry", height)
+		if rx > 0 && ry > 0 {
+			builder.ellipse(t.length(node, "cx", width), t.length(node, "cy", height), rx, ry)
+		}
+
+	case "line":
+		builder.moveTo(t.length(node, "x1", width), t.length(node, "y1", height))
+		builder.lineTo(t.length(node, "x2", width), t.length(node, "y2", height))
+
+	case "polyline", "polygon":
+		scanner := &svgPathScanner{data: node.attributes["points"]}
+		for i := 0; scanner.isAtNumber(); i++ {
+			x, err := scanner.number()
+			if err != nil {
+				break
+			}
+			y, err := scanner.number()
+			if err != nil {
+				break
+			}
+			if i == 0 {
+				builder.moveTo(x, y)
+			} else {
+				builder.lineTo(x, y)
+			}
+		}
+		if node.name == "polygon" {
+			builder.closePath()
+		}
+	}
+}
+
+func (t *svgRenderer) drawShape(node *svgNode, state svgState) {
+	scale := state.transform.scale()
+	if scale == 0 {
+		return
+	}
+
+	tolerance := svgFlatteningTolerance / scale
+	builder := newSvgPathBuilder(tolerance)
+	t.buildShape(node, builder)
+	polylines := builder.finish()
+	if len(polylines) == 0 {
+		return
+	}
+
+	style := state.style
+	if paint := t.resolvePaint(style.fill, style.fillOpacity, state, polylines); paint != nil {
+		polygons := make([][]svgPoint, len(polylines))
+		for i, polyline := range polylines {
+			polygons[i] = state.transform.applyToPoints(polyline.points)
+		}
+		fillSvgPolygons(t.canvas, polygons, style.fillRule == "evenodd", paint, state.opacity)
+	}
+
+	if style.strokeWidth > 0 {
+		if paint := t.resolvePaint(style.stroke, style.strokeOpacity, state, polylines); paint != nil {
+			outlines := strokeSvgPolylines(polylines, svgStrokeStyle{
+				width:      style.strokeWidth,
+				lineCap:    style.lineCap,
+				lineJoin:   style.lineJoin,
+				miterLimit: style.miterLimit,
+				tolerance:  tolerance,
+			})
+			for i, outline := range outlines {
+				outlines[i] = state.transform.applyToPoints(outline)
+			}
+			fillSvgPolygons(t.canvas, outlines, false, paint, state.opacity)
+		}
+	}
+}
+
+// nil if nothing should be painted
+func (t *svgRenderer) resolvePaint(value string, opacity float64, state svgState, polylines []svgPolyline) svgPaint {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "url(") {
+		end := strings.Index(value, ")")
+		if end < 0 {
+			return nil
+		}
+
+		id := strings.Trim(strings.TrimSpace(value[4:end]), `"'`)
+		node := t.document.ids[strings.TrimPrefix(id, "#")]
+		if node != nil && (node.name == "linearGradient" || node.name == "radialGradient") {
+			return t.createGradientPaint(node, opacity, state, polylines)
+		}
+
+		// fallback color
+		value = strings.TrimSpace(value[end+1:])
+	}
+
+	if value == "currentColor" {
+		value = state.style.color
+	}
+	if len(value) == 0 || value == "none" {
+		return nil
+	}
+
+	color, ok := parseSvgColor(value)
+	if !ok {
+		log.Debug("svg color is not supported", zap.String("color", value))
+		return nil
+	}
+	return &svgSolidPaint{color: color.premultiply(opacity)}
+}
+
+func (t *svgRenderer) createGradientPaint(node *svgNode, opacity float64, state svgState, polylines []svgPolyline) svgPaint {
+	// attributes and stops are inherited from referenced gradient
+	chain := []*svgNode{node}
+	for len(chain) < 16 {
+		next := t.document.ids[strings.TrimPrefix(chain[len(chain)-1].attributes["href"], "#")]
+		if next == nil || (next.name != "linearGradient" && next.name != "radialGradient") {
+			break
+		}
+		chain = append(chain, next)
+	}
+
+	attribute := func(name string) (string, bool) {
+		for _, item := range chain {
+			if value, ok := item.attributes[name]; ok {
+				return value, true
+			}
+		}
+		return "", false
+	}
+
+	var stops []*svgNode
+	for _, item := range chain {
+		for _, child := range item.children {
+			if child.name == "stop" {
+				stops = append(stops, child)
+			}
+		}
+		if len(stops) != 0 {
+			break
+		}
+	}
+
+	colors, offsets := t.readGradientStops(stops, state)
+	if len(colors) == 0 {
+		return nil
+	}
+	if len(colors) == 1 {
+		return &svgSolidPaint{color: colors[0].premultiply(opacity)}
+	}
+
+	units, _ := attribute("gradientUnits")
+	isBoundingBox := units != "userSpaceOnUse"
+	matrix := state.transform
+	if isBoundingBox {
+		minX, minY, maxX, maxY := svgBounds(polylines)
+		if maxX <= minX || maxY <= minY {
+			return nil
+		}
+		matrix = matrix.multiply(svgMatrix{a: maxX - minX, d: maxY - minY, e: minX, f: minY})
+	}
+	if value, ok := attribute("gradientTransform"); ok {
+		transform, err := parseSvgTransform(value)
+		if err == nil {
+			matrix = matrix.multiply(transform)
+		}
+	}
+
+	inverse, ok := matrix.invert()
+	if !ok {
+		return nil
+	}
+
+	coordinate := func(name string, defaultValue string, reference float64) float64 {
+		value, ok := attribute(name)
+		if !ok {
+			value = defaultValue
+		}
+		if isBoundingBox {
+			reference = 1
+		}
+		result, _ := parseSvgLength(value, reference)
+		return result
+	}
+
+	width, height := t.document.viewBoxWidth, t.document.viewBoxHeight
+	paint := &svgGradientPaint{inverse: inverse}
+	paint.spread, _ = attribute("spreadMethod")
+	if node.name == "radialGradient" {
+		paint.isRadial = true
+		paint.cx = coordinate("cx", "50%", width)
+		paint.cy = coordinate("cy", "50%", height)
+		paint.r = coordinate("r", "50%", t.diagonal())
+		if paint.r <= 0 {
+			return &svgSolidPaint{color: colors[len(colors)-1].premultiply(opacity)}
+		}
+
+		if _, ok := attribute("fx"); ok {
+			paint.fx = coordinate("fx", "", width)
+		} else {
+			paint.fx = paint.cx
+		}
+		if _, ok := attribute("fy"); ok {
+			paint.fy = coordinate("fy", "", height)
+		} else {
+			paint.fy = paint.cy
+		}
+
+		// focal point outside of circle is moved onto the circle
+		dx, dy := paint.fx-paint.cx, paint.fy-paint.cy
+		if distance := math.Hypot(dx, dy); distance > paint.r*0.99 {
+			paint.fx = paint.cx + dx/distance*paint.r*0.99
+			paint.fy = paint.cy + dy/distance*paint.r*0.99
+		}
+	} else {
+		paint.x1 = coordinate("x1", "0%", width)
+		paint.y1 = coordinate("y1", "0%", height)
+		paint.x2 = coordinate("x2", "100%", width)
+		paint.y2 = coordinate("y2", "0%", height)
+	}
+
+	// colors are interpolated in premultiplied space
+	stopIndex := 0
+	for i := range paint.colors {
+		offset := float64(i) / float64(len(paint.colors)-1)
+		for stopIndex < len(offsets)-1 && offsets[stopIndex+1] < offset {
+			stopIndex++
+		}
+
+		if offset <= offsets[0] {
+			paint.colors[i] = colors[0].premultiply(opacity)
+		} else if stopIndex == len(offsets)-1 {
+			paint.colors[i] = colors[stopIndex].premultiply(opacity)
+		} else {
+			from, to := colors[stopIndex].premultiply(opacity), colors[stopIndex+1].premultiply(opacity)
+			fraction := 0.0
+			if distance := offsets[stopIndex+1] - offsets[stopIndex]; distance > 0 {
+				fraction = (offset - offsets[stopIndex]) / distance
+			}
+			paint.colors[i] = svgColor{
+				r: from.r + (to.r-from.r)*fraction,
+				g: from.g + (to.g-from.g)*fraction,
+				b: from.b + (to.b-from.b)*fraction,
+				a: from.a + (to.a-from.a)*fraction,
+			}
+		}
+	}
+	return paint
+}
+
+// not premultiplied colors and increasing offsets
+func (t *svgRenderer) readGradientStops(stops []*svgNode, state svgState) ([]svgColor, []float64) {
+	var colors []svgColor
+	var offsets []float64
+	for _, stop := range stops {
+		offset, _ := parseSvgLength(stop.attributes["offset"], 1)
+		offset = math.Max(0, math.Min(1, offset))
+		if len(offsets) != 0 && offset < offsets[len(offsets)-1] {
+			offset = offsets[len(offsets)-1]
+		}
+
+		value := strings.TrimSpace(stop.attributes["stop-color"])
+		if value == "currentColor" {
+			value = state.style.color
+		}
+		color, ok := parseSvgColor(value)
+		if !ok {
+			color = svgColor{a: 1}
+		}
+		if opacity, ok := stop.attributes["stop-opacity"]; ok {
+			color.a *= parseSvgOpacity(opacity)
+		}
+
+		colors = append(colors, color)
+		offsets = append(offsets, offset)
+	}
+	return colors, offsets
+}
+
+func svgBounds(polylines []svgPolyline) (float64, float64, float64, float64) {
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, polyline := range polylines {
+		for _, p := range polyline.points {
+			minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+			minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+		}
+	}
+	return minX, minY, maxX, maxY
+}
+
+// x' = a*x + c*y + e, y' = b*x + d*y + f
+type svgMatrix struct {
+	a float64
+	b float64
+	c float64
+	d float64
+	e float64
+	f float64
+}
+
+// result applies n first, then t
+func (t svgMatrix) multiply(n svgMatrix) svgMatrix {
+	return svgMatrix{
+		a: t.a*n.a + t.c*n.b,
+		b: t.b*n.a + t.d*n.b,
+		c: t.a*n.c + t.c*n.d,
+		d: t.b*n.c + t.d*n.d,
+		e: t.a*n.e + t.c*n.f + t.e,
+		f: t.b*n.e + t.d*n.f + t.f,
+	}
+}
+
+func (t svgMatrix) apply(x float64, y float64) (float64, float64) {
+	return t.a*x + t.c*y + t.e, t.b*x + t.d*y + t.f
+}
+
+func (t svgMatrix) applyToPoints(points []svgPoint) []svgPoint {
+	result := make([]svgPoint, len(points))
+	for i, p := range points {
+		result[i].x, result[i].y = t.apply(p.x, p.y)
+	}
+	return result
+}
+
+func (t svgMatrix) invert() (svgMatrix, bool) {
+	determinant := t.a*t.d - t.b*t.c
+	if determinant == 0 || math.IsNaN(determinant) {
+		return svgMatrix{}, false
+	}
+	return svgMatrix{
+		a: t.d / determinant,
+		b: -t.b / determinant,
+		c: -t.c / determinant,
+		d: t.a / determinant,
+		e: (t.c*t.f - t.d*t.e) / determinant,
+		f: (t.b*t.e - t.a*t.f) / determinant,
+	}, true
+}
+
+// the largest scale factor along axes, used to convert device tolerance to user units
+func (t svgMatrix) scale() float64 {
+	return math.Sqrt(math.Max(t.a*t.a+t.b*t.b, t.c*t.c+t.d*t.d))
+}
+
+func parseSvgTransform(value string) (svgMatrix, error) {
+	result := svgMatrix{a: 1, d: 1}
+	rest := value
+	for {
+		rest = strings.TrimLeft(rest, " \t\r\n,")
+		if len(rest) == 0 {
+			return result, nil
+		}
+
+		start := strings.Index(rest, "(")
+		end := strings.Index(rest, ")")
+		if start < 0 || end < start {
+			return result, errors.Errorf("invalid transform %q", value)
+		}
+
+		name := strings.TrimSpace(rest[:start])
+		scanner := &svgPathScanner{data: rest[start+1 : end]}
+		rest = rest[end+1:]
+
+		var args []float64
+		for scanner.isAtNumber() {
+			number, err := scanner.number()
+			if err != nil {
+				return result, err
+			}
+			args = append(args, number)
+		}
+
+		var m svgMatrix
+		switch {
+		case name == "matrix" && len(args) == 6:
+			m = svgMatrix{args[0], args[1], args[2], args[3], args[4], args[5]}
+		case name == "translate" && len(args) == 1:
+			m = svgMatrix{a: 1, d: 1, e: args[0]}
+		case name == "translate" && len(args) == 2:
+			m = svgMatrix{a: 1, d: 1, e: args[0], f: args[1]}
+		case name == "scale" && len(args) == 1:
+			m = svgMatrix{a: args[0], d: args[0]}
+		case name == "scale" && len(args) == 2:
+			m = svgMatrix{a: args[0], d: args[1]}
+		case name == "rotate" && (len(args) == 1 || len(args) == 3):
+			sin, cos := math.Sincos(args[0] * math.Pi / 180)
+			m = svgMatrix{a: cos, b: sin, c: -sin, d: cos}
+			if len(args) == 3 {
+				m = svgMatrix{a: 1, d: 1, e: args[1], f: args[2]}.multiply(m).multiply(svgMatrix{a: 1, d: 1, e: -args[1], f: -args[2]})
+			}
+		case name == "skewX" && len(args) == 1:
+			m = svgMatrix{a: 1, c: math.Tan(args[0] * math.Pi / 180), d: 1}
+		case name == "skewY" && len(args) == 1:
+			m = svgMatrix{a: 1, b: math.Tan(args[0] * math.Pi / 180), d: 1}
+		default:
+			return result, errors.Errorf("invalid transform %q", value)
+		}
+		result = result.multiply(m)
+	}
+}
+
+// percentage is relative to reference, absolute units are converted to pixels (96 dpi)
+func parseSvgLength(value string, reference float64) (float64, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0, false
+	}
+
+	unitScale := 1.0
+	if strings.HasSuffix(value, "%") {
+		value = value[:len(value)-1]
+		unitScale = reference / 100
+	} else if len(value) > 2 {
+		switch value[len(value)-2:] {
+		case "px":
+			unitScale = 1
+		case "pt":
+			unitScale = 4.0 / 3.0
+		case "pc":
+			unitScale = 16
+		case "mm":
+			unitScale = 96 / 25.4
+		case "cm":
+			unitScale = 96 / 2.54
+		case "in":
+			unitScale = 96
+		case "em":
+			unitScale = 16
+		case "ex":
+			unitScale = 8
+		default:
+			unitScale = 0
+		}
+
+		if unitScale == 0 {
+			unitScale = 1
+		} else {
+			value = value[:len(value)-2]
+		}
+	}
+
+	result, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, false
+	}
+	return result * unitScale, true
+}
+
+func parseSvgOpacity(value string) float64 {
+	result, ok := parseSvgLength(value, 1)
+	if !ok {
+		return 1
+	}
+	return math.Max(0, math.Min(1, result))
+}
+
+func (t svgColor) premultiply(opacity float64) svgColor {
+	a := t.a * opacity
+	return svgColor{r: t.r * a, g: t.g * a, b: t.b * a, a: a}
+}
+
+var svgNamedColors = map[string]uint32{
+	"black":   0x000000,
+	"white":   0xffffff,
+	"red":     0xff0000,
+	"green":   0x008000,
+	"lime":    0x00ff00,
+	"blue":    0x0000ff,
+	"yellow":  0xffff00,
+	"cyan":    0x00ffff,
+	"aqua":    0x00ffff,
+	"magenta": 0xff00ff,
+	"fuchsia": 0xff00ff,
+	"gray":    0x808080,
+	"grey":    0x808080,
+	"silver":  0xc0c0c0,
+	"maroon":  0x800000,
+	"olive":   0x808000,
+	"navy":    0x000080,
+	"purple":  0x800080,
+	"teal":    0x008080,
+	"orange":  0xffa500,
+	"pink":    0xffc0cb,
+	"brown":   0xa52a2a,
+	"gold":    0xffd700,
+	"indigo":  0x4b0082,
+	"violet":  0xee82ee,
+}
+
+// not premultiplied: hex (#rgb, #rgba, #rrggbb, #rrggbbaa), rgb() and rgba() functions and basic named colors
+func parseSvgColor(value string) (svgColor, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "transparent" {
+		return svgColor{}, true
+	}
+
+	if rgb, ok := svgNamedColors[value]; ok {
+		return svgColor{r: float64(rgb>>16) / 255, g: float64(rgb>>8&0xff) / 255, b: float64(rgb&0xff) / 255, a: 1}, true
+	}
+
+	if strings.HasPrefix(value, "#") {
+		hex := value[1:]
+		if len(hex) == 3 || len(hex) == 4 {
+			expanded := make([]byte, 0, 8)
+			for i := 0; i < len(hex); i++ {
+				expanded = append(expanded, hex[i], hex[i])
+			}
+			hex = string(expanded)
+		}
+		if len(hex) == 6 {
+			hex += "ff"
+		}
+		if len(hex) != 8 {
+			return svgColor{}, false
+		}
+
+		rgba, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return svgColor{}, false
+		}
+		return svgColor{r: float64(rgba>>24) / 255, g: float64(rgba>>16&0xff) / 255, b: float64(rgba>>8&0xff) / 255, a: float64(rgba&0xff) / 255}, true
+	}
+
+	if strings.HasPrefix(value, "rgb") && strings.HasSuffix(value, ")") {
+		start := strings.Index(value, "(")
+		if start < 0 {
+			return svgColor{}, false
+		}
+
+		components := strings.FieldsFunc(value[start+1:len(value)-1], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '/' || r == '\t'
+		})
+		if len(components) != 3 && len(components) != 4 {
+			return svgColor{}, false
+		}
+
+		var channels [4]float64
+		channels[3] = 1
+		for i, component := range components {
+			reference := 255.0
+			if i == 3 {
+				reference = 1
+			}
+			channel, ok := parseSvgLength(component, reference)
+			if !ok {
+				return svgColor{}, false
+			}
+			channels[i] = math.Max(0, math.Min(1, channel/reference))
+		}
+		return svgColor{r: channels[0], g: channels[1], b: channels[2], a: channels[3]}, true
+	}
+	return svgColor{}, false
+}
@@ -0,0 +1,93 @@
+package icons
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRasterizeSvg(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	testDataPath, err := filepath.Abs(filepath.Join("..", "..", "testData"))
+	g.Expect(err).NotTo(HaveOccurred())
+	document, err := loadSvg(filepath.Join(testDataPath, "icon.svg"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	canvas := document.rasterize(512)
+	// outside of rounded rect
+	g.Expect(canvas.RGBAAt(0, 0).A).To(Equal(uint8(0)))
+	g.Expect(canvas.RGBAAt(40, 40).A).To(Equal(uint8(0)))
+
+	// linear gradient from top to bottom
+	top, bottom := canvas.RGBAAt(256, 40), canvas.RGBAAt(256, 470)
+	g.Expect(top.A).To(Equal(uint8(255)))
+	g.Expect(top.B).To(BeNumerically(">", bottom.B))
+
+	// rotated ring is white, hole (evenodd) is not
+	g.Expect(canvas.RGBAAt(324, 324)).To(Equal(canvas.RGBAAt(188, 188)))
+	ring := canvas.RGBAAt(324, 324)
+	g.Expect([]uint8{ring.R, ring.G, ring.B}).To(Equal([]uint8{255, 255, 255}))
+	g.Expect(canvas.RGBAAt(256, 256).R).To(BeNumerically("<", 255))
+
+	// top of stroked arc
+	arc := canvas.RGBAAt(256, 336)
+	g.Expect([]uint8{arc.R, arc.G, arc.B}).To(Equal([]uint8{255, 200, 0}))
+}
+
+func TestSvgPathData(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	builder := newSvgPathBuilder(0.1)
+	// compact notation: implicit commands, numbers without separators, arc flags without separators
+	g.Expect(parseSvgPathData("M10-10.5.5.5L20,20h-5v5zm1 1l2 2a1 1 0 011 1", builder)).To(Succeed())
+	polylines := builder.finish()
+	g.Expect(polylines).To(HaveLen(2))
+	g.Expect(polylines[0].points[:3]).To(Equal([]svgPoint{{10, -10.5}, {0.5, 0.5}, {20, 20}}))
+	g.Expect(polylines[0].closed).To(BeTrue())
+	// relative move after close path starts from the start point of closed subpath
+	g.Expect(polylines[1].points[0]).To(Equal(svgPoint{11, -9.5}))
+	last := polylines[1].points[len(polylines[1].points)-1]
+	g.Expect(last.x).To(BeNumerically("~", 14, 1e-9))
+	g.Expect(last.y).To(BeNumerically("~", -6.5, 1e-9))
+
+	g.Expect(parseSvgPathData("M0 0 L10", newSvgPathBuilder(0.1))).NotTo(Succeed())
+
+	matrix, err := parseSvgTransform("translate(10, 20) rotate(90) scale(2)")
+	g.Expect(err).NotTo(HaveOccurred())
+	x, y := matrix.apply(1, 0)
+	g.Expect(x).To(BeNumerically("~", 10, 1e-9))
+	g.Expect(y).To(BeNumerically("~", 22, 1e-9))
+
+	_, err = decodeSvg(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg"/>`))
+	g.Expect(err).To(HaveOccurred())
+
+	color, ok := parseSvgColor("rgba(255, 0, 0, 50%)")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(color).To(Equal(svgColor{r: 1, a: 0.5}))
+}
+
+func TestSvgUnsupportedFeatures(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	document, err := decodeSvg(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">
+  <style>.shadow { filter: url(#blur) }</style>
+  <defs>
+    <clipPath id="clip"><rect width="5" height="5"/></clipPath>
+    <filter id="blur"><feGaussianBlur stdDeviation="1"/></filter>
+  </defs>
+  <rect width="10" height="10" clip-path="url(#clip)"/>
+  <circle class="shadow" cx="5" cy="5" r="2"/>
+  <rect width="1" height="1" mask="none"/>
+  <g display="none"><rect width="1" height="1" mask="url(#mask)"/></g>
+</svg>`))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(document.getUnsupportedFeatures()).To(BeEmpty())
+
+	// element is still drawn, without clipping
+	canvas := document.rasterize(10)
+	g.Expect(canvas.RGBAAt(8, 8).A).To(Equal(uint8(255)))
+	g.Expect(document.getUnsupportedFeatures()).To(Equal([]string{"clip-path", "filter"}))
+}
@@ -0,0 +1,542 @@
+package jpeg2000
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// markers (ITU-T T.800 Annex A)
+const (
+	markerSOC = 0xff4f
+	markerSIZ = 0xff51
+	markerCOD = 0xff52
+	markerCOC = 0xff53
+	markerQCD = 0xff5c
+	markerQCC = 0xff5d
+	markerRGN = 0xff5e
+	markerPOC = 0xff5f
+	markerPPM = 0xff60
+	markerPPT = 0xff61
+	markerSOT = 0xff90
+	markerSOD = 0xff93
+	markerEOC = 0xffd9
+)
+
+// progression orders
+const (
+	progressionLRCP = iota
+	progressionRLCP
+	progressionRPCL
+	progressionPCRL
+	progressionCPRL
+)
+
+// code-block style flags
+const (
+	codeBlockBypass           = 0x01
+	codeBlockResetContexts    = 0x02
+	codeBlockTerminateAll     = 0x04
+	codeBlockVerticallyCausal = 0x08
+	codeBlockSegmentation     = 0x20
+)
+
+// quantization styles
+const (
+	quantizationNone = iota
+	quantizationDerived
+	quantizationExpounded
+)
+
+type imageSize struct {
+	x0, y0, x1, y1 int
+
+	tileX0, tileY0        int
+	tileWidth, tileHeight int
+	tilesWide, tilesHigh  int
+}
+
+type componentInfo struct {
+	precision int
+	signed    bool
+}
+
+// SPcod / SPcoc
+type componentCodingStyle struct {
+	levels             int
+	codeBlockWidthExp  int
+	codeBlockHeightExp int
+	codeBlockStyle     int
+	reversible         bool
+	// per resolution level, low 4 bits are width exponent, high 4 bits are height exponent
+	precincts []byte
+}
+
+// Scod and SGcod
+type codingStyle struct {
+	progression int
+	layers      int
+	mct         bool
+	sop         bool
+	eph         bool
+
+	component *componentCodingStyle
+}
+
+type quantizationStep struct {
+	exponent int
+	mantissa int
+}
+
+type quantization struct {
+	guardBits int
+	style     int
+	steps     []quantizationStep
+}
+
+// coding parameters of main header or tile header, nil means not specified
+type codingParameters struct {
+	cod *codingStyle
+	coc map[int]*componentCodingStyle
+	qcd *quantization
+	qcc map[int]*quantization
+}
+
+type tile struct {
+	index          int
+	x0, y0, x1, y1 int
+	params         codingParameters
+	// tile-part bodies
+	parts [][]byte
+}
+
+type decoder struct {
+	size       imageSize
+	components []componentInfo
+	main       codingParameters
+	tiles      map[int]*tile
+
+	// decoded samples per component, image area only
+	planes [][]int32
+}
+
+func (d *decoder) parseMainHeader(data []byte) error {
+	if len(data) < 4 || binary.BigEndian.Uint16(data) != markerSOC {
+		return FormatError("missing SOC marker")
+	}
+
+	d.tiles = make(map[int]*tile)
+	offset := 2
+	for {
+		marker, segment, next, err := readMarkerSegment(data, offset)
+		if err != nil {
+			return err
+		}
+
+		switch marker {
+		case markerSIZ:
+			err = d.parseSize(segment)
+		case markerCOD, markerCOC, markerQCD, markerQCC:
+			err = d.parseCodingParameters(&d.main, marker, segment)
+		case markerRGN:
+			err = UnsupportedError("region of interest")
+		case markerPOC:
+			err = UnsupportedError("progression order change")
+		case markerPPM:
+			err = UnsupportedError("packed packet headers")
+		case markerSOT:
+			if d.components == nil {
+				return FormatError("missing SIZ marker")
+			}
+			if d.main.cod == nil || d.main.qcd == nil {
+				return FormatError("missing COD or QCD marker")
+			}
+			return d.parseTileParts(data, offset)
+		}
+		if err != nil {
+			return err
+		}
+		offset = next
+	}
+}
+
+// returns marker, marker segment parameters and offset of the next marker
+func readMarkerSegment(data []byte, offset int) (int, []byte, int, error) {
+	if offset+2 > len(data) {
+		return 0, nil, 0, FormatError("unexpected end of codestream")
+	}
+
+	marker := int(binary.BigEndian.Uint16(data[offset:]))
+	if marker>>8 != 0xff {
+		return 0, nil, 0, FormatError("marker expected at " + strconv.Itoa(offset))
+	}
+
+	// markers without parameters
+	if marker == markerSOD || marker == markerEOC || (marker >= 0xff30 && marker <= 0xff3f) {
+		return marker, nil, offset + 2, nil
+	}
+
+	if offset+4 > len(data) {
+		return 0, nil, 0, FormatError("unexpected end of codestream")
+	}
+	length := int(binary.BigEndian.Uint16(data[offset+2:]))
+	if length < 2 || offset+2+length > len(data) {
+		return 0, nil, 0, FormatError("invalid length of marker segment " + strconv.FormatInt(int64(marker), 16))
+	}
+	return marker, data[offset+4 : offset+2+length], offset + 2 + length, nil
+}
+
+func (d *decoder) parseSize(data []byte) error {
+	if len(data) < 36 {
+		return FormatError("SIZ marker segment is too short")
+	}
+
+	u32 := func(offset int) int {
+		return int(binary.BigEndian.Uint32(data[offset:]))
+	}
+
+	size := imageSize{
+		x1:         u32(2),
+		y1:         u32(6),
+		x0:         u32(10),
+		y0:         u32(14),
+		tileWidth:  u32(18),
+		tileHeight: u32(22),
+		tileX0:     u32(26),
+		tileY0:     u32(30),
+	}
+	if size.x0 >= size.x1 || size.y0 >= size.y1 || size.tileWidth == 0 || size.tileHeight == 0 || size.tileX0 > size.x0 || size.tileY0 > size.y0 {
+		return FormatError("invalid image or tile size")
+	}
+	// limit memory usage on corrupted data
+	if (size.x1-size.x0)*(size.y1-size.y0) > 1<<26 {
+		return UnsupportedError("image is too large")
+	}
+
+	size.tilesWide = ceilDiv(size.x1-size.tileX0, size.tileWidth)
+	size.tilesHigh = ceilDiv(size.y1-size.tileY0, size.tileHeight)
+	if size.tilesWide*size.tilesHigh > 65535 {
+		return FormatError("too many tiles")
+	}
+
+	count := int(binary.BigEndian.Uint16(data[34:]))
+	if count == 0 || len(data) < 36+count*3 {
+		return FormatError("invalid number of components")
+	}
+
+	d.components = make([]componentInfo, count)
+	for i := range d.components {
+		entry := data[36+i*3:]
+		d.components[i] = componentInfo{
+			precision: int(entry[0]&0x7f) + 1,
+			signed:    entry[0]&0x80 != 0,
+		}
+		if d.components[i].precision > 16 {
+			return UnsupportedError("component precision " + strconv.Itoa(d.components[i].precision))
+		}
+		if entry[1] != 1 || entry[2] != 1 {
+			return UnsupportedError("component subsampling")
+		}
+	}
+	d.size = size
+	return nil
+}
+
+func (d *decoder) parseCodingParameters(params *codingParameters, marker int, data []byte) error {
+	if d.components == nil {
+		return FormatError("SIZ marker must be the first one")
+	}
+
+	switch marker {
+	case markerCOD:
+		if len(data) < 5 {
+			return FormatError("COD marker segment is too short")
+		}
+		component, err := parseComponentCodingStyle(data[5:], data[0]&1 != 0)
+		if err != nil {
+			return err
+		}
+		params.cod = &codingStyle{
+			progression: int(data[1]),
+			layers:      int(binary.BigEndian.Uint16(data[2:])),
+			mct:         data[4] != 0,
+			sop:         data[0]&2 != 0,
+			eph:         data[0]&4 != 0,
+			component:   component,
+		}
+		if params.cod.progression > progressionCPRL {
+			return FormatError("unknown progression order")
+		}
+		if params.cod.layers == 0 {
+			return FormatError("number of layers must be positive")
+		}
+
+	case markerCOC:
+		index, rest, err := d.readComponentIndex(data)
+		if err != nil {
+			return err
+		}
+		if len(rest) < 1 {
+			return FormatError("COC marker segment is too short")
+		}
+		component, err := parseComponentCodingStyle(rest[1:], rest[0]&1 != 0)
+		if err != nil {
+			return err
+		}
+		if params.coc == nil {
+			params.coc = make(map[int]*componentCodingStyle)
+		}
+		params.coc[index] = component
+
+	case markerQCD:
+		q, err := parseQuantization(data)
+		if err != nil {
+			return err
+		}
+		params.qcd = q
+
+	case markerQCC:
+		index, rest, err := d.readComponentIndex(data)
+		if err != nil {
+			return err
+		}
+		q, err := parseQuantization(rest)
+		if err != nil {
+			return err
+		}
+		if params.qcc == nil {
+			params.qcc = make(map[int]*quantization)
+		}
+		params.qcc[index] = q
+	}
+	return nil
+}
+
+// component index is 8-bit if there are less than 257 components
+func (d *decoder) readComponentIndex(data []byte) (int, []byte, error) {
+	var index int
+	if len(d.components) < 257 {
+		if len(data) < 1 {
+			return 0, nil, FormatError("marker segment is too short")
+		}
+		index, data = int(data[0]), data[1:]
+	} else {
+		if len(data) < 2 {
+			return 0, nil, FormatError("marker segment is too short")
+		}
+		index, data = int(binary.BigEndian.Uint16(data)), data[2:]
+	}
+
+	if index >= len(d.components) {
+		return 0, nil, FormatError("invalid component index")
+	}
+	return index, data, nil
+}
+
+func parseComponentCodingStyle(data []byte, precinctsDefined bool) (*componentCodingStyle, error) {
+	if len(data) < 5 {
+		return nil, FormatError("coding style parameters are too short")
+	}
+
+	result := &componentCodingStyle{
+		levels:             int(data[0]),
+		codeBlockWidthExp:  int(data[1]&0xf) + 2,
+		codeBlockHeightExp: int(data[2]&0xf) + 2,
+		codeBlockStyle:     int(data[3]),
+		reversible:         data[4] == 1,
+	}
+	if result.levels > 32 || result.codeBlockWidthExp+result.codeBlockHeightExp > 12 {
+		return nil, FormatError("invalid coding style parameters")
+	}
+
+	switch {
+	case result.codeBlockStyle&codeBlockBypass != 0:
+		return nil, UnsupportedError("selective arithmetic coding bypass")
+	case result.codeBlockStyle&codeBlockTerminateAll != 0:
+		return nil, UnsupportedError("termination on each coding pass")
+	case result.codeBlockStyle&0xc0 != 0:
+		return nil, UnsupportedError("high throughput block coding")
+	}
+
+	result.precincts = make([]byte, result.levels+1)
+	if precinctsDefined {
+		if len(data) < 5+len(result.precincts) {
+			return nil, FormatError("precinct sizes are missing")
+		}
+		copy(result.precincts, data[5:])
+		for r, value := range result.precincts {
+			if r > 0 && (value&0xf == 0 || value>>4 == 0) {
+				return nil, FormatError("invalid precinct size")
+			}
+		}
+	} else {
+		for r := range result.precincts {
+			result.precincts[r] = 0xff
+		}
+	}
+	return result, nil
+}
+
+func parseQuantization(data []byte) (*quantization, error) {
+	if len(data) < 1 {
+		return nil, FormatError("quantization marker segment is too short")
+	}
+
+	result := &quantization{
+		guardBits: int(data[0] >> 5),
+		style:     int(data[0] & 0x1f),
+	}
+	data = data[1:]
+	switch result.style {
+	case quantizationNone:
+		for _, value := range data {
+			result.steps = append(result.steps, quantizationStep{exponent: int(value >> 3)})
+		}
+	case quantizationDerived, quantizationExpounded:
+		for i := 0; i+1 < len(data); i += 2 {
+			value := int(binary.BigEndian.Uint16(data[i:]))
+			result.steps = append(result.steps, quantizationStep{exponent: value >> 11, mantissa: value & 0x7ff})
+		}
+	default:
+		return nil, FormatError("unknown quantization style")
+	}
+
+	if len(result.steps) == 0 {
+		return nil, FormatError("quantization step sizes are missing")
+	}
+	return result, nil
+}
+
+func (d *decoder) parseTileParts(data []byte, offset int) error {
+	for offset < len(data) {
+		marker, segment, next, err := readMarkerSegment(data, offset)
+		if err != nil {
+			return err
+		}
+
+		switch marker {
+		case markerEOC:
+			return nil
+		case markerSOT:
+		default:
+			return FormatError("SOT marker expected")
+		}
+
+		if len(segment) < 8 {
+			return FormatError("SOT marker segment is too short")
+		}
+
+		index := int(binary.BigEndian.Uint16(segment))
+		if index >= d.size.tilesWide*d.size.tilesHigh {
+			return FormatError("invalid tile index")
+		}
+
+		partEnd := len(data)
+		if partLength := int(binary.BigEndian.Uint32(segment[2:])); partLength != 0 {
+			// truncated codestream, use as much data as available
+			partEnd = min(offset+partLength, len(data))
+		}
+
+		t := d.tiles[index]
+		isFirstPart := t == nil
+		if isFirstPart {
+			t = d.newTile(index)
+			d.tiles[index] = t
+		}
+
+		offset = next
+		for {
+			marker, segment, next, err = readMarkerSegment(data, offset)
+			if err != nil {
+				return err
+			}
+			offset = next
+
+			if marker == markerSOD {
+				break
+			}
+
+			switch marker {
+			case markerCOD, markerCOC, markerQCD, markerQCC:
+				if !isFirstPart {
+					return FormatError("coding parameters are allowed only in the first tile-part")
+				}
+				err = d.parseCodingParameters(&t.params, marker, segment)
+			case markerRGN:
+				err = UnsupportedError("region of interest")
+			case markerPOC:
+				err = UnsupportedError("progression order change")
+			case markerPPT:
+				err = UnsupportedError("packed packet headers")
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if offset > partEnd {
+			return FormatError("tile-part header exceeds tile-part")
+		}
+		t.parts = append(t.parts, data[offset:partEnd])
+		offset = partEnd
+	}
+	return nil
+}
+
+func (d *decoder) newTile(index int) *tile {
+	size := &d.size
+	p := index % size.tilesWide
+	q := index / size.tilesWide
+	return &tile{
+		index: index,
+		x0:    max(size.tileX0+p*size.tileWidth, size.x0),
+		y0:    max(size.tileY0+q*size.tileHeight, size.y0),
+		x1:    min(size.tileX0+(p+1)*size.tileWidth, size.x1),
+		y1:    min(size.tileY0+(q+1)*size.tileHeight, size.y1),
+	}
+}
+
+// tile-part COD overrides main header COC, tile-part COC overrides everything
+func (t *tile) codingStyle(main *codingParameters) *codingStyle {
+	if t.params.cod != nil {
+		return t.params.cod
+	}
+	return main.cod
+}
+
+func (t *tile) componentCodingStyle(main *codingParameters, component int) *componentCodingStyle {
+	if style, ok := t.params.coc[component]; ok {
+		return style
+	}
+	if t.params.cod != nil {
+		return t.params.cod.component
+	}
+	if style, ok := main.coc[component]; ok {
+		return style
+	}
+	return main.cod.component
+}
+
+func (t *tile) quantization(main *codingParameters, component int) *quantization {
+	if q, ok := t.params.qcc[component]; ok {
+		return q
+	}
+	if t.params.qcd != nil {
+		return t.params.qcd
+	}
+	if q, ok := main.qcc[component]; ok {
+		return q
+	}
+	return main.qcd
+}
+
+func floorDiv(a int, b int) int {
+	result := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		result--
+	}
+	return result
+}
+
+func ceilDiv(a int, b int) int {
+	return -floorDiv(-a, b)
+}
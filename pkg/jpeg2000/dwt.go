@@ -0,0 +1,105 @@
+package jpeg2000
+
+import "math"
+
+// lifting coefficients of the irreversible 9-7 filter (ITU-T T.800 Table F.4)
+const (
+	liftAlpha = -1.586134342059924
+	liftBeta  = -0.052980118572961
+	liftGamma = 0.882911075530934
+	liftDelta = 0.443506852043971
+	liftK     = 1.230174104914001
+)
+
+// extension length on each side, enough for both filters
+const filterPadding = 4
+
+// inverse 2D transform of one resolution level (ITU-T T.800 F.3.2), samples is interleaved resolution of width x height, x0 and y0 define parity
+func inverseTransform2D(samples []float64, width int, height int, x0 int, y0 int, reversible bool) {
+	buffer := make([]float64, max(width, height)+2*filterPadding)
+	for y := 0; y < height; y++ {
+		row := samples[y*width : (y+1)*width]
+		copy(buffer[filterPadding:], row)
+		inverseTransform1D(buffer, width, x0, reversible)
+		copy(row, buffer[filterPadding:filterPadding+width])
+	}
+
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			buffer[filterPadding+y] = samples[y*width+x]
+		}
+		inverseTransform1D(buffer, height, y0, reversible)
+		for y := 0; y < height; y++ {
+			samples[y*width+x] = buffer[filterPadding+y]
+		}
+	}
+}
+
+// buffer contains n samples starting at filterPadding, i0 is the absolute coordinate of the first sample (even samples are low-pass)
+func inverseTransform1D(buffer []float64, n int, i0 int, reversible bool) {
+	if n == 1 {
+		if i0&1 != 0 {
+			buffer[filterPadding] /= 2
+		}
+		return
+	}
+
+	// periodic symmetric extension
+	for k := 1; k <= filterPadding; k++ {
+		buffer[filterPadding-k] = buffer[filterPadding+mirrorIndex(-k, n)]
+		buffer[filterPadding+n-1+k] = buffer[filterPadding+mirrorIndex(n-1+k, n)]
+	}
+
+	x := buffer
+	// first index p in [from, to) such that absolute coordinate i0 + p has given parity
+	lift := func(from int, to int, odd bool, step func(p int)) {
+		p := from
+		if ((i0+p)&1 == 1) != odd {
+			p++
+		}
+		for ; p < to; p += 2 {
+			step(filterPadding + p)
+		}
+	}
+
+	if reversible {
+		lift(-filterPadding+1, n+filterPadding-1, false, func(i int) {
+			x[i] -= math.Floor((x[i-1] + x[i+1] + 2) / 4)
+		})
+		lift(0, n, true, func(i int) {
+			x[i] += math.Floor((x[i-1] + x[i+1]) / 2)
+		})
+		return
+	}
+
+	lift(-filterPadding, n+filterPadding, false, func(i int) {
+		x[i] *= liftK
+	})
+	lift(-filterPadding, n+filterPadding, true, func(i int) {
+		x[i] /= liftK
+	})
+	lift(-3, n+3, false, func(i int) {
+		x[i] -= liftDelta * (x[i-1] + x[i+1])
+	})
+	lift(-2, n+2, true, func(i int) {
+		x[i] -= liftGamma * (x[i-1] + x[i+1])
+	})
+	lift(-1, n+1, false, func(i int) {
+		x[i] -= liftBeta * (x[i-1] + x[i+1])
+	})
+	lift(0, n, true, func(i int) {
+		x[i] -= liftAlpha * (x[i-1] + x[i+1])
+	})
+}
+
+func mirrorIndex(i int, n int) int {
+	period := 2 * (n - 1)
+	i %= period
+	if i < 0 {
+		i += period
+	}
+	if i >= n {
+		i = period - i
+	}
+	return i
+}
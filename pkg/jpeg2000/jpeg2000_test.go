@@ -0,0 +1,151 @@
+package jpeg2000
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+// JP2 entries of test ICNS file are produced by JasPer: 4 components, reversible transform, 64x64 code-blocks
+func readIcnsEntry(g *WithT, osType string) []byte {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testData", "icon-jpeg2.icns"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	for offset := 8; offset < len(data); {
+		length := int(binary.BigEndian.Uint32(data[offset+4:]))
+		if string(data[offset:offset+4]) == osType {
+			return data[offset+8 : offset+length]
+		}
+		offset += length
+	}
+	g.Expect(osType).To(BeEmpty(), "entry is not found")
+	return nil
+}
+
+func TestDecode(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	data := readIcnsEntry(g, "ic09")
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(format).To(Equal("jpeg2000"))
+	g.Expect(config.Width).To(Equal(512))
+	g.Expect(config.Height).To(Equal(512))
+	g.Expect(config.ColorModel).To(Equal(color.NRGBAModel))
+
+	result, err := Decode(bytes.NewReader(data))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Bounds()).To(Equal(image.Rect(0, 0, 512, 512)))
+
+	nrgba := result.(*image.NRGBA)
+	// transparent corner of rounded rect
+	g.Expect(nrgba.NRGBAAt(0, 0).A).To(Equal(uint8(0)))
+	// blue background and white text
+	background := nrgba.NRGBAAt(256, 40)
+	g.Expect(background.A).To(Equal(uint8(255)))
+	g.Expect(background.B).To(BeNumerically(">", background.R+100))
+	g.Expect(nrgba.NRGBAAt(150, 256)).To(Equal(color.NRGBA{R: 255, G: 255, B: 255, A: 255}))
+}
+
+func TestDecodeCodestream(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	data := readIcnsEntry(g, "ic08")
+	codestream, _, err := parseFile(data)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	fromFile, err := Decode(bytes.NewReader(data))
+	g.Expect(err).NotTo(HaveOccurred())
+	// without channel definition the 4th component is alpha too
+	fromCodestream, err := Decode(bytes.NewReader(codestream))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(fromCodestream.Bounds().Dx()).To(Equal(256))
+	g.Expect(fromCodestream.(*image.NRGBA).Pix).To(Equal(fromFile.(*image.NRGBA).Pix))
+
+	_, err = Decode(bytes.NewReader(codestream[:len(codestream)/2]))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = Decode(bytes.NewReader([]byte("not an image")))
+	g.Expect(err).To(BeAssignableToTypeOf(FormatError("")))
+}
+
+// forward transforms are inverse of lifting steps of inverseTransform1D
+func forwardTransform1D(x []float64, i0 int, reversible bool) {
+	n := len(x)
+	if n == 1 {
+		if i0&1 != 0 {
+			x[0] *= 2
+		}
+		return
+	}
+
+	lift := func(odd bool, step func(p int, left float64, right float64)) {
+		for p := 0; p < n; p++ {
+			if ((i0+p)&1 == 1) == odd {
+				step(p, x[mirrorIndex(p-1, n)], x[mirrorIndex(p+1, n)])
+			}
+		}
+	}
+
+	if reversible {
+		lift(true, func(p int, left float64, right float64) { x[p] -= math.Floor((left + right) / 2) })
+		lift(false, func(p int, left float64, right float64) { x[p] += math.Floor((left + right + 2) / 4) })
+		return
+	}
+
+	lift(true, func(p int, left float64, right float64) { x[p] += liftAlpha * (left + right) })
+	lift(false, func(p int, left float64, right float64) { x[p] += liftBeta * (left + right) })
+	lift(true, func(p int, left float64, right float64) { x[p] += liftGamma * (left + right) })
+	lift(false, func(p int, left float64, right float64) { x[p] += liftDelta * (left + right) })
+	lift(true, func(p int, left float64, right float64) { x[p] *= liftK })
+	lift(false, func(p int, left float64, right float64) { x[p] /= liftK })
+}
+
+func TestInverseTransform(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	for _, reversible := range []bool{true, false} {
+		for n := 1; n < 12; n++ {
+			for i0 := 0; i0 < 2; i0++ {
+				original := make([]float64, n)
+				for i := range original {
+					original[i] = float64((i*37 + 11) % 255)
+				}
+
+				samples := append([]float64(nil), original...)
+				forwardTransform1D(samples, i0, reversible)
+
+				buffer := make([]float64, n+2*filterPadding)
+				copy(buffer[filterPadding:], samples)
+				inverseTransform1D(buffer, n, i0, reversible)
+				for i, value := range buffer[filterPadding : filterPadding+n] {
+					g.Expect(value).To(BeNumerically("~", original[i], 1e-6), "n=%d, i0=%d, reversible=%v", n, i0, reversible)
+				}
+			}
+		}
+	}
+}
+
+func TestInverseComponentTransform(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	r, gr, b := []float64{200, 0, 17}, []float64{10, 255, 99}, []float64{30, 128, 250}
+	c0, c1, c2 := make([]float64, 3), make([]float64, 3), make([]float64, 3)
+	for i := range r {
+		c0[i] = math.Floor((r[i] + 2*gr[i] + b[i]) / 4)
+		c1[i] = b[i] - gr[i]
+		c2[i] = r[i] - gr[i]
+	}
+
+	inverseComponentTransform(c0, c1, c2, true)
+	g.Expect(c0).To(Equal(r))
+	g.Expect(c1).To(Equal(gr))
+	g.Expect(c2).To(Equal(b))
+}
@@ -0,0 +1,170 @@
+package jpeg2000
+
+type mqState struct {
+	qe        uint32
+	nmps      uint8
+	nlps      uint8
+	switchMps bool
+}
+
+// ITU-T T.800 Table C.2
+var mqStates = [47]mqState{
+	{0x5601, 1, 1, true},
+	{0x3401, 2, 6, false},
+	{0x1801, 3, 9, false},
+	{0x0ac1, 4, 12, false},
+	{0x0521, 5, 29, false},
+	{0x0221, 38, 33, false},
+	{0x5601, 7, 6, true},
+	{0x5401, 8, 14, false},
+	{0x4801, 9, 14, false},
+	{0x3801, 10, 14, false},
+	{0x3001, 11, 17, false},
+	{0x2401, 12, 18, false},
+	{0x1c01, 13, 20, false},
+	{0x1601, 29, 21, false},
+	{0x5601, 15, 14, true},
+	{0x5401, 16, 14, false},
+	{0x5101, 17, 15, false},
+	{0x4801, 18, 16, false},
+	{0x3801, 19, 17, false},
+	{0x3401, 20, 18, false},
+	{0x3001, 21, 19, false},
+	{0x2801, 22, 19, false},
+	{0x2401, 23, 20, false},
+	{0x2201, 24, 21, false},
+	{0x1c01, 25, 22, false},
+	{0x1801, 26, 23, false},
+	{0x1601, 27, 24, false},
+	{0x1401, 28, 25, false},
+	{0x1201, 29, 26, false},
+	{0x1101, 30, 27, false},
+	{0x0ac1, 31, 28, false},
+	{0x09c1, 32, 29, false},
+	{0x08a1, 33, 30, false},
+	{0x0521, 34, 31, false},
+	{0x0441, 35, 32, false},
+	{0x02a1, 36, 33, false},
+	{0x0221, 37, 34, false},
+	{0x0141, 38, 35, false},
+	{0x0111, 39, 36, false},
+	{0x0085, 40, 37, false},
+	{0x0049, 41, 38, false},
+	{0x0025, 42, 39, false},
+	{0x0015, 43, 40, false},
+	{0x0009, 44, 41, false},
+	{0x0005, 45, 42, false},
+	{0x0001, 45, 43, false},
+	{0x5601, 46, 46, false},
+}
+
+// MQ arithmetic decoder (ITU-T T.800 Annex C), context is state index << 1 | MPS
+type mqDecoder struct {
+	data  []byte
+	pos   int
+	chigh uint32
+	clow  uint32
+	a     uint32
+	ct    int
+}
+
+func (m *mqDecoder) init(data []byte) {
+	m.data = data
+	m.pos = 0
+	m.chigh = m.byteAt(0)
+	m.clow = 0
+	m.byteIn()
+	m.chigh = ((m.chigh << 7) & 0xffff) | ((m.clow >> 9) & 0x7f)
+	m.clow = (m.clow << 7) & 0xffff
+	m.ct -= 7
+	m.a = 0x8000
+}
+
+// data is terminated by implicit 0xffff
+func (m *mqDecoder) byteAt(i int) uint32 {
+	if i < len(m.data) {
+		return uint32(m.data[i])
+	}
+	return 0xff
+}
+
+func (m *mqDecoder) byteIn() {
+	if m.byteAt(m.pos) == 0xff {
+		if m.byteAt(m.pos+1) > 0x8f {
+			m.clow += 0xff00
+			m.ct = 8
+		} else {
+			m.pos++
+			m.clow += m.byteAt(m.pos) << 9
+			m.ct = 7
+		}
+	} else {
+		m.pos++
+		m.clow += m.byteAt(m.pos) << 8
+		m.ct = 8
+	}
+
+	if m.clow > 0xffff {
+		m.chigh += m.clow >> 16
+		m.clow &= 0xffff
+	}
+}
+
+func (m *mqDecoder) decode(contexts []uint8, cx int) int {
+	index := contexts[cx] >> 1
+	mps := int(contexts[cx] & 1)
+	state := &mqStates[index]
+
+	var d int
+	a := m.a - state.qe
+	if m.chigh < state.qe {
+		// LPS exchange
+		if a < state.qe {
+			d = mps
+			index = state.nmps
+		} else {
+			d = 1 ^ mps
+			if state.switchMps {
+				mps = d
+			}
+			index = state.nlps
+		}
+		a = state.qe
+	} else {
+		m.chigh -= state.qe
+		if a&0x8000 != 0 {
+			m.a = a
+			return mps
+		}
+
+		// MPS exchange
+		if a < state.qe {
+			d = 1 ^ mps
+			if state.switchMps {
+				mps = d
+			}
+			index = state.nlps
+		} else {
+			d = mps
+			index = state.nmps
+		}
+	}
+
+	// renormalization
+	for {
+		if m.ct == 0 {
+			m.byteIn()
+		}
+		a <<= 1
+		m.chigh = ((m.chigh << 1) & 0xffff) | ((m.clow >> 15) & 1)
+		m.clow = (m.clow << 1) & 0xffff
+		m.ct--
+		if a&0x8000 != 0 {
+			break
+		}
+	}
+
+	m.a = a
+	contexts[cx] = index<<1 | uint8(mps)
+	return d
+}
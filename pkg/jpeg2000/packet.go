@@ -0,0 +1,452 @@
+package jpeg2000
+
+// subband orientations, order matches order of subbands in resolution and in quantization parameters
+const (
+	bandLL = iota
+	bandHL
+	bandLH
+	bandHH
+)
+
+type tileComponent struct {
+	x0, y0, x1, y1 int
+	style          *componentCodingStyle
+	quantization   *quantization
+	resolutions    []*resolution
+}
+
+type resolution struct {
+	x0, y0, x1, y1 int
+	precinctsWide  int
+	precinctsHigh  int
+	bands          []*subband
+}
+
+type subband struct {
+	orientation    int
+	x0, y0, x1, y1 int
+	// decomposition level
+	level int
+
+	codeBlockWidthExp  int
+	codeBlockHeightExp int
+	// indexed by precinct number
+	precincts []*precinctBand
+}
+
+// code-blocks of subband that belong to precinct
+type precinctBand struct {
+	blocksWide    int
+	blocks        []*codeBlock
+	inclusion     *tagTree
+	zeroBitPlanes *tagTree
+}
+
+type codeBlock struct {
+	x0, y0, x1, y1 int
+
+	included      bool
+	lengthBits    int
+	zeroBitPlanes int
+	passes        int
+	data          []byte
+}
+
+func newTileComponent(t *tile, style *componentCodingStyle, q *quantization) *tileComponent {
+	result := &tileComponent{x0: t.x0, y0: t.y0, x1: t.x1, y1: t.y1, style: style, quantization: q}
+	levels := style.levels
+	for r := 0; r <= levels; r++ {
+		scale := 1 << (levels - r)
+		res := &resolution{
+			x0: ceilDiv(result.x0, scale),
+			y0: ceilDiv(result.y0, scale),
+			x1: ceilDiv(result.x1, scale),
+			y1: ceilDiv(result.y1, scale),
+		}
+
+		precinctWidthExp := int(style.precincts[r] & 0xf)
+		precinctHeightExp := int(style.precincts[r] >> 4)
+		if res.x1 > res.x0 && res.y1 > res.y0 {
+			res.precinctsWide = ceilDiv(res.x1, 1<<precinctWidthExp) - floorDiv(res.x0, 1<<precinctWidthExp)
+			res.precinctsHigh = ceilDiv(res.y1, 1<<precinctHeightExp) - floorDiv(res.y0, 1<<precinctHeightExp)
+		}
+
+		if r == 0 {
+			res.bands = []*subband{newSubband(result, res, bandLL, levels, precinctWidthExp, precinctHeightExp)}
+		} else {
+			// precincts and code-blocks are halved in subbands of higher resolution levels
+			for orientation := bandHL; orientation <= bandHH; orientation++ {
+				res.bands = append(res.bands, newSubband(result, res, orientation, levels-r+1, precinctWidthExp-1, precinctHeightExp-1))
+			}
+		}
+		result.resolutions = append(result.resolutions, res)
+	}
+	return result
+}
+
+func newSubband(tc *tileComponent, res *resolution, orientation int, level int, precinctWidthExp int, precinctHeightExp int) *subband {
+	xOffset, yOffset := 0, 0
+	if orientation == bandHL || orientation == bandHH {
+		xOffset = 1 << (level - 1)
+	}
+	if orientation == bandLH || orientation == bandHH {
+		yOffset = 1 << (level - 1)
+	}
+
+	band := &subband{
+		orientation:        orientation,
+		level:              level,
+		x0:                 ceilDiv(tc.x0-xOffset, 1<<level),
+		y0:                 ceilDiv(tc.y0-yOffset, 1<<level),
+		x1:                 ceilDiv(tc.x1-xOffset, 1<<level),
+		y1:                 ceilDiv(tc.y1-yOffset, 1<<level),
+		codeBlockWidthExp:  min(tc.style.codeBlockWidthExp, precinctWidthExp),
+		codeBlockHeightExp: min(tc.style.codeBlockHeightExp, precinctHeightExp),
+		precincts:          make([]*precinctBand, res.precinctsWide*res.precinctsHigh),
+	}
+
+	// precinct partition is anchored at the origin of reference grid, index of the first precinct is computed in resolution coordinates
+	resolutionShift := 0
+	if orientation != bandLL {
+		resolutionShift = 1
+	}
+	firstPrecinctX := floorDiv(res.x0, 1<<(precinctWidthExp+resolutionShift))
+	firstPrecinctY := floorDiv(res.y0, 1<<(precinctHeightExp+resolutionShift))
+	blockWidth := 1 << band.codeBlockWidthExp
+	blockHeight := 1 << band.codeBlockHeightExp
+	for py := 0; py < res.precinctsHigh; py++ {
+		for px := 0; px < res.precinctsWide; px++ {
+			pb := &precinctBand{}
+			band.precincts[px+py*res.precinctsWide] = pb
+
+			x0 := max((firstPrecinctX+px)<<precinctWidthExp, band.x0)
+			y0 := max((firstPrecinctY+py)<<precinctHeightExp, band.y0)
+			x1 := min((firstPrecinctX+px+1)<<precinctWidthExp, band.x1)
+			y1 := min((firstPrecinctY+py+1)<<precinctHeightExp, band.y1)
+			if x0 >= x1 || y0 >= y1 {
+				pb.inclusion = newTagTree(0, 0)
+				pb.zeroBitPlanes = newTagTree(0, 0)
+				continue
+			}
+
+			blockX0, blockX1 := floorDiv(x0, blockWidth), ceilDiv(x1, blockWidth)
+			blockY0, blockY1 := floorDiv(y0, blockHeight), ceilDiv(y1, blockHeight)
+			pb.blocksWide = blockX1 - blockX0
+			for j := blockY0; j < blockY1; j++ {
+				for i := blockX0; i < blockX1; i++ {
+					pb.blocks = append(pb.blocks, &codeBlock{
+						x0:         max(i*blockWidth, x0),
+						y0:         max(j*blockHeight, y0),
+						x1:         min((i+1)*blockWidth, x1),
+						y1:         min((j+1)*blockHeight, y1),
+						lengthBits: 3,
+					})
+				}
+			}
+			pb.inclusion = newTagTree(pb.blocksWide, blockY1-blockY0)
+			pb.zeroBitPlanes = newTagTree(pb.blocksWide, blockY1-blockY0)
+		}
+	}
+	return band
+}
+
+// reads packets of tile in progression order, truncated data is not an error - the rest of code-blocks are decoded as is
+func readPackets(data []byte, cod *codingStyle, components []*tileComponent) error {
+	offset := 0
+	maxLevels := 0
+	for _, tc := range components {
+		maxLevels = max(maxLevels, tc.style.levels)
+	}
+
+	read := func(layer int, r int, tc *tileComponent) error {
+		if r >= len(tc.resolutions) {
+			return nil
+		}
+
+		res := tc.resolutions[r]
+		for precinct := 0; precinct < res.precinctsWide*res.precinctsHigh; precinct++ {
+			if offset >= len(data) {
+				return nil
+			}
+
+			var err error
+			offset, err = readPacket(data, offset, layer, res, precinct, cod)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch cod.progression {
+	case progressionLRCP:
+		for layer := 0; layer < cod.layers; layer++ {
+			for r := 0; r <= maxLevels; r++ {
+				for _, tc := range components {
+					if err := read(layer, r, tc); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+	case progressionRLCP:
+		for r := 0; r <= maxLevels; r++ {
+			for layer := 0; layer < cod.layers; layer++ {
+				for _, tc := range components {
+					if err := read(layer, r, tc); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+	default:
+		// position driven progressions are equal to simple loops if there is only one precinct per resolution
+		for _, tc := range components {
+			for _, res := range tc.resolutions {
+				if res.precinctsWide*res.precinctsHigh > 1 {
+					return UnsupportedError("position driven progression order with several precincts")
+				}
+			}
+		}
+
+		if cod.progression == progressionRPCL {
+			for r := 0; r <= maxLevels; r++ {
+				for _, tc := range components {
+					for layer := 0; layer < cod.layers; layer++ {
+						if err := read(layer, r, tc); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		} else {
+			for _, tc := range components {
+				for r := 0; r <= maxLevels; r++ {
+					for layer := 0; layer < cod.layers; layer++ {
+						if err := read(layer, r, tc); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type packetContribution struct {
+	block  *codeBlock
+	passes int
+	length int
+}
+
+// returns offset of the next packet (ITU-T T.800 B.10)
+func readPacket(data []byte, offset int, layer int, res *resolution, precinct int, cod *codingStyle) (int, error) {
+	// start of packet marker segment
+	if cod.sop && offset+6 <= len(data) && data[offset] == 0xff && data[offset+1] == 0x91 {
+		offset += 6
+	}
+
+	reader := &bitReader{data: data, pos: offset}
+	var contributions []packetContribution
+	if reader.readBit() == 1 {
+		for _, band := range res.bands {
+			pb := band.precincts[precinct]
+			for index, block := range pb.blocks {
+				x := index % pb.blocksWide
+				y := index / pb.blocksWide
+
+				var isIncluded bool
+				if block.included {
+					isIncluded = reader.readBit() == 1
+				} else {
+					isIncluded = pb.inclusion.decode(reader, x, y, layer+1)
+				}
+				if !isIncluded {
+					continue
+				}
+
+				if !block.included {
+					threshold := 1
+					for !pb.zeroBitPlanes.decode(reader, x, y, threshold) {
+						threshold++
+						if threshold > 74 {
+							return 0, FormatError("invalid number of zero bit-planes")
+						}
+					}
+					block.zeroBitPlanes = threshold - 1
+					block.included = true
+				}
+
+				passes := readPassCount(reader)
+				for reader.readBit() == 1 {
+					block.lengthBits++
+				}
+				length := reader.readBits(block.lengthBits + floorLog2(passes))
+				contributions = append(contributions, packetContribution{block: block, passes: passes, length: length})
+
+				if reader.pos > len(data) {
+					return 0, FormatError("packet header exceeds data")
+				}
+			}
+		}
+	}
+
+	// truncated packet header
+	offset = min(reader.align(), len(data))
+	// end of packet header marker
+	if cod.eph && offset+2 <= len(data) && data[offset] == 0xff && data[offset+1] == 0x92 {
+		offset += 2
+	}
+
+	for _, contribution := range contributions {
+		end := min(offset+contribution.length, len(data))
+		contribution.block.data = append(contribution.block.data, data[offset:end]...)
+		contribution.block.passes += contribution.passes
+		offset = end
+	}
+	return offset, nil
+}
+
+// ITU-T T.800 Table B.4
+func readPassCount(reader *bitReader) int {
+	if reader.readBit() == 0 {
+		return 1
+	}
+	if reader.readBit() == 0 {
+		return 2
+	}
+	if value := reader.readBits(2); value != 3 {
+		return 3 + value
+	}
+	if value := reader.readBits(5); value != 31 {
+		return 6 + value
+	}
+	return 37 + reader.readBits(7)
+}
+
+func floorLog2(value int) int {
+	result := 0
+	for value > 1 {
+		value >>= 1
+		result++
+	}
+	return result
+}
+
+// packet header reader, bit after 0xff byte is stuffed
+type bitReader struct {
+	data    []byte
+	pos     int
+	current byte
+	bits    uint
+	afterFF bool
+}
+
+func (r *bitReader) readBit() int {
+	if r.bits == 0 {
+		var value byte
+		if r.pos < len(r.data) {
+			value = r.data[r.pos]
+		}
+		r.pos++
+
+		if r.afterFF {
+			r.bits = 7
+		} else {
+			r.bits = 8
+		}
+		r.afterFF = value == 0xff
+		r.current = value
+	}
+	r.bits--
+	return int(r.current>>r.bits) & 1
+}
+
+func (r *bitReader) readBits(count int) int {
+	result := 0
+	for ; count > 0; count-- {
+		result = result<<1 | r.readBit()
+	}
+	return result
+}
+
+// skips the rest of byte and returns position of the next byte
+func (r *bitReader) align() int {
+	r.bits = 0
+	if r.afterFF {
+		r.pos++
+		r.afterFF = false
+	}
+	return r.pos
+}
+
+const tagTreeUnknown = 1 << 30
+
+type tagTreeNode struct {
+	parent *tagTreeNode
+	value  int
+	low    int
+}
+
+// ITU-T T.800 B.10.2
+type tagTree struct {
+	width  int
+	leaves []*tagTreeNode
+}
+
+func newTagTree(width int, height int) *tagTree {
+	result := &tagTree{width: width}
+	level := make([]*tagTreeNode, width*height)
+	for i := range level {
+		level[i] = &tagTreeNode{value: tagTreeUnknown}
+	}
+	result.leaves = level
+
+	for width > 1 || height > 1 {
+		parentWidth := (width + 1) / 2
+		parentHeight := (height + 1) / 2
+		parents := make([]*tagTreeNode, parentWidth*parentHeight)
+		for i := range parents {
+			parents[i] = &tagTreeNode{value: tagTreeUnknown}
+		}
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				level[x+y*width].parent = parents[x/2+(y/2)*parentWidth]
+			}
+		}
+		level, width, height = parents, parentWidth, parentHeight
+	}
+	return result
+}
+
+// reports whether value of leaf is less than threshold
+func (t *tagTree) decode(reader *bitReader, x int, y int, threshold int) bool {
+	leaf := t.leaves[x+y*t.width]
+	var path []*tagTreeNode
+	for node := leaf; node != nil; node = node.parent {
+		path = append(path, node)
+	}
+
+	low := 0
+	for i := len(path) - 1; i >= 0; i-- {
+		node := path[i]
+		if low > node.low {
+			node.low = low
+		} else {
+			low = node.low
+		}
+
+		for low < threshold && low < node.value {
+			if reader.readBit() == 1 {
+				node.value = low
+			} else {
+				low++
+			}
+		}
+		node.low = low
+	}
+	return leaf.value < threshold
+}
@@ -0,0 +1,220 @@
+// Package jpeg2000 implements a decoder for JPEG 2000 (ITU-T T.800) images as used in ICNS files.
+//
+// Baseline Part 1 codestreams (raw or wrapped into JP2 file format) are supported: both wavelet transforms, both component transforms,
+// all quantization styles, user defined precincts, multiple tiles and layers. Selective arithmetic coding bypass, termination on each coding pass,
+// region of interest, progression order changes, packed packet headers, component subsampling and palettes are not supported, UnsupportedError is returned for such images.
+package jpeg2000
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+)
+
+const (
+	jp2Signature        = "\x00\x00\x00\x0cjP  \r\n\x87\n"
+	codestreamSignature = "\xff\x4f\xff\x51"
+)
+
+// A FormatError reports that the input is not a valid JPEG 2000 image.
+type FormatError string
+
+func (e FormatError) Error() string {
+	return "jpeg2000: invalid format: " + string(e)
+}
+
+// An UnsupportedError reports that the input uses a valid but unimplemented JPEG 2000 feature.
+type UnsupportedError string
+
+func (e UnsupportedError) Error() string {
+	return "jpeg2000: unsupported feature: " + string(e)
+}
+
+func init() {
+	image.RegisterFormat("jpeg2000", jp2Signature, Decode, DecodeConfig)
+	image.RegisterFormat("jpeg2000", codestreamSignature, Decode, DecodeConfig)
+}
+
+// channel definition from JP2 cdef box
+type channelDefinition struct {
+	channelType int
+	association int
+}
+
+type jp2Header struct {
+	colorSpace int
+	channels   map[int]channelDefinition
+}
+
+// Decode reads a JPEG 2000 image (JP2 file or raw codestream) from r and returns it as an image.Image.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	codestream, header, err := parseFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{}
+	err = d.parseMainHeader(codestream)
+	if err != nil {
+		return nil, err
+	}
+
+	layout, err := d.resolveLayout(header)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.decodeTiles()
+	if err != nil {
+		return nil, err
+	}
+	return d.createImage(layout), nil
+}
+
+// DecodeConfig returns the color model and dimensions of a JPEG 2000 image without decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	codestream, header, err := parseFile(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	d := &decoder{}
+	err = d.parseMainHeader(codestream)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	layout, err := d.resolveLayout(header)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	var colorModel color.Model
+	switch {
+	case len(layout.colors) == 1 && layout.alpha < 0:
+		colorModel = color.GrayModel
+	case layout.alpha >= 0 && layout.premultiplied:
+		colorModel = color.RGBAModel
+	case layout.alpha >= 0:
+		colorModel = color.NRGBAModel
+	default:
+		colorModel = color.RGBAModel
+	}
+	return image.Config{ColorModel: colorModel, Width: d.size.x1 - d.size.x0, Height: d.size.y1 - d.size.y0}, nil
+}
+
+// returns codestream and JP2 header (nil for raw codestream)
+func parseFile(data []byte) ([]byte, *jp2Header, error) {
+	if bytes.HasPrefix(data, []byte(codestreamSignature)) {
+		return data, nil, nil
+	}
+	if !bytes.HasPrefix(data, []byte(jp2Signature)) {
+		return nil, nil, FormatError("neither JP2 signature nor codestream")
+	}
+
+	header := &jp2Header{}
+	var codestream []byte
+	err := readBoxes(data, func(boxType string, content []byte) error {
+		switch boxType {
+		case "jp2h":
+			return readBoxes(content, func(boxType string, content []byte) error {
+				return header.readBox(boxType, content)
+			})
+		case "jp2c":
+			if codestream == nil {
+				codestream = content
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if codestream == nil {
+		return nil, nil, FormatError("contiguous codestream box is missing")
+	}
+	return codestream, header, nil
+}
+
+func readBoxes(data []byte, consumer func(boxType string, content []byte) error) error {
+	for offset := 0; offset < len(data); {
+		if offset+8 > len(data) {
+			return FormatError("truncated box header")
+		}
+
+		length := uint64(binary.BigEndian.Uint32(data[offset:]))
+		boxType := string(data[offset+4 : offset+8])
+		headerLength := uint64(8)
+		switch length {
+		case 0:
+			length = uint64(len(data) - offset)
+		case 1:
+			if offset+16 > len(data) {
+				return FormatError("truncated box header")
+			}
+			length = binary.BigEndian.Uint64(data[offset+8:])
+			headerLength = 16
+		}
+
+		if length < headerLength || length > uint64(len(data)-offset) {
+			return FormatError("invalid length of box " + boxType)
+		}
+
+		err := consumer(boxType, data[offset+int(headerLength):offset+int(length)])
+		if err != nil {
+			return err
+		}
+		offset += int(length)
+	}
+	return nil
+}
+
+func (h *jp2Header) readBox(boxType string, content []byte) error {
+	switch boxType {
+	case "colr":
+		if len(content) < 3 {
+			return FormatError("colour specification box is too short")
+		}
+		// only the first colour specification is used, ICC profiles are ignored
+		if h.colorSpace == 0 && content[0] == 1 {
+			if len(content) < 7 {
+				return FormatError("colour specification box is too short")
+			}
+			h.colorSpace = int(binary.BigEndian.Uint32(content[3:]))
+		}
+
+	case "cdef":
+		if len(content) < 2 {
+			return FormatError("channel definition box is too short")
+		}
+		n := int(binary.BigEndian.Uint16(content))
+		if len(content) < 2+n*6 {
+			return FormatError("channel definition box is too short")
+		}
+		h.channels = make(map[int]channelDefinition, n)
+		for i := 0; i < n; i++ {
+			entry := content[2+i*6:]
+			h.channels[int(binary.BigEndian.Uint16(entry))] = channelDefinition{
+				channelType: int(binary.BigEndian.Uint16(entry[2:])),
+				association: int(binary.BigEndian.Uint16(entry[4:])),
+			}
+		}
+
+	case "pclr", "cmap":
+		return UnsupportedError("palette")
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package jpeg2000
+
+// contexts of code-block coding (ITU-T T.800 Annex D)
+const (
+	// 0-8 are zero coding contexts, 9-13 are sign coding contexts, 14-16 are magnitude refinement contexts
+	contextSign      = 9
+	contextMagnitude = 14
+	contextRunLength = 17
+	contextUniform   = 18
+	contextCount     = 19
+)
+
+// state flags of coefficient
+const (
+	flagSignificant = 1 << iota
+	flagNegative
+	flagRefined
+	// coded in significance propagation pass of the current bit-plane
+	flagVisited
+)
+
+// decodes one code-block, flags, magnitudes and number of decoded bit-planes are stored with a border of one coefficient to avoid bound checks on neighbours
+type blockDecoder struct {
+	width       int
+	height      int
+	stride      int
+	orientation int
+	style       int
+
+	mq       mqDecoder
+	contexts [contextCount]uint8
+
+	flags       []uint8
+	magnitude   []int32
+	bitsDecoded []uint8
+}
+
+func newBlockDecoder(width int, height int, orientation int, style int, zeroBitPlanes int) *blockDecoder {
+	d := &blockDecoder{
+		width:       width,
+		height:      height,
+		stride:      width + 2,
+		orientation: orientation,
+		style:       style,
+	}
+
+	size := (width + 2) * (height + 2)
+	d.flags = make([]uint8, size)
+	d.magnitude = make([]int32, size)
+	d.bitsDecoded = make([]uint8, size)
+	for i := range d.bitsDecoded {
+		d.bitsDecoded[i] = uint8(zeroBitPlanes)
+	}
+	d.resetContexts()
+	return d
+}
+
+func (d *blockDecoder) resetContexts() {
+	for i := range d.contexts {
+		d.contexts[i] = 0
+	}
+	d.contexts[0] = 4 << 1
+	d.contexts[contextRunLength] = 3 << 1
+	d.contexts[contextUniform] = 46 << 1
+}
+
+func (d *blockDecoder) index(x int, y int) int {
+	return (y+1)*d.stride + x + 1
+}
+
+// coding passes start with cleanup pass of the first non-zero bit-plane
+func (d *blockDecoder) decode(data []byte, passes int) {
+	d.mq.init(data)
+	passType := 2
+	for i := 0; i < passes; i++ {
+		switch passType {
+		case 0:
+			d.significancePropagationPass()
+		case 1:
+			d.magnitudeRefinementPass()
+		case 2:
+			d.cleanupPass()
+			if d.style&codeBlockSegmentation != 0 {
+				for j := 0; j < 4; j++ {
+					d.mq.decode(d.contexts[:], contextUniform)
+				}
+			}
+		}
+
+		if d.style&codeBlockResetContexts != 0 {
+			d.resetContexts()
+		}
+		passType = (passType + 1) % 3
+	}
+}
+
+// neighbours in the next stripe are not considered in vertically causal mode
+func (d *blockDecoder) isCausalBoundary(y int) bool {
+	return d.style&codeBlockVerticallyCausal != 0 && y%4 == 3
+}
+
+// returns number of significant horizontal, vertical and diagonal neighbours
+func (d *blockDecoder) neighbours(i int, y int) (int, int, int) {
+	f := d.flags
+	s := d.stride
+	h := int(f[i-1]&flagSignificant + f[i+1]&flagSignificant)
+	v := int(f[i-s] & flagSignificant)
+	diagonal := int(f[i-s-1]&flagSignificant + f[i-s+1]&flagSignificant)
+	if !d.isCausalBoundary(y) {
+		v += int(f[i+s] & flagSignificant)
+		diagonal += int(f[i+s-1]&flagSignificant + f[i+s+1]&flagSignificant)
+	}
+	return h, v, diagonal
+}
+
+// ITU-T T.800 Table D.1
+func (d *blockDecoder) zeroCodingContext(h int, v int, diagonal int) int {
+	switch d.orientation {
+	case bandHH:
+		hv := h + v
+		switch {
+		case diagonal >= 3:
+			return 8
+		case diagonal == 2:
+			if hv >= 1 {
+				return 7
+			}
+			return 6
+		case diagonal == 1:
+			return 3 + min(hv, 2)
+		default:
+			return min(hv, 2)
+		}
+	case bandHL:
+		h, v = v, h
+	}
+
+	switch h {
+	case 2:
+		return 8
+	case 1:
+		switch {
+		case v >= 1:
+			return 7
+		case diagonal >= 1:
+			return 6
+		default:
+			return 5
+		}
+	default:
+		switch {
+		case v == 2:
+			return 4
+		case v == 1:
+			return 3
+		default:
+			return min(diagonal, 2)
+		}
+	}
+}
+
+func signContribution(flags uint8) int {
+	switch {
+	case flags&flagSignificant == 0:
+		return 0
+	case flags&flagNegative != 0:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// ITU-T T.800 Table D.3
+func (d *blockDecoder) decodeSign(i int, y int) {
+	f := d.flags
+	s := d.stride
+	h := max(-1, min(1, signContribution(f[i-1])+signContribution(f[i+1])))
+	v := signContribution(f[i-s])
+	if !d.isCausalBoundary(y) {
+		v += signContribution(f[i+s])
+	}
+	v = max(-1, min(1, v))
+
+	var context int
+	xor := 0
+	if h == 0 {
+		context = contextSign + v*v
+		if v < 0 {
+			xor = 1
+		}
+	} else {
+		context = contextSign + 3 + h*v
+		if h < 0 {
+			xor = 1
+		}
+	}
+
+	f[i] |= flagSignificant
+	if d.mq.decode(d.contexts[:], context)^xor == 1 {
+		f[i] |= flagNegative
+	}
+	d.magnitude[i] = 1
+}
+
+func (d *blockDecoder) significancePropagationPass() {
+	for y0 := 0; y0 < d.height; y0 += 4 {
+		for x := 0; x < d.width; x++ {
+			for y := y0; y < min(y0+4, d.height); y++ {
+				i := d.index(x, y)
+				if d.flags[i]&flagSignificant != 0 {
+					continue
+				}
+
+				h, v, diagonal := d.neighbours(i, y)
+				if h+v+diagonal == 0 {
+					continue
+				}
+
+				if d.mq.decode(d.contexts[:], d.zeroCodingContext(h, v, diagonal)) == 1 {
+					d.decodeSign(i, y)
+				}
+				d.bitsDecoded[i]++
+				d.flags[i] |= flagVisited
+			}
+		}
+	}
+}
+
+func (d *blockDecoder) magnitudeRefinementPass() {
+	for y0 := 0; y0 < d.height; y0 += 4 {
+		for x := 0; x < d.width; x++ {
+			for y := y0; y < min(y0+4, d.height); y++ {
+				i := d.index(x, y)
+				if d.flags[i]&(flagSignificant|flagVisited) != flagSignificant {
+					continue
+				}
+
+				context := contextMagnitude + 2
+				if d.flags[i]&flagRefined == 0 {
+					context = contextMagnitude
+					if h, v, diagonal := d.neighbours(i, y); h+v+diagonal != 0 {
+						context++
+					}
+				}
+
+				d.magnitude[i] = d.magnitude[i]<<1 | int32(d.mq.decode(d.contexts[:], context))
+				d.bitsDecoded[i]++
+				d.flags[i] |= flagRefined
+			}
+		}
+	}
+}
+
+func (d *blockDecoder) cleanupPass() {
+	for y0 := 0; y0 < d.height; y0 += 4 {
+		y1 := min(y0+4, d.height)
+		for x := 0; x < d.width; x++ {
+			y := y0
+			if y1-y0 == 4 && d.canUseRunLength(x, y0) {
+				if d.mq.decode(d.contexts[:], contextRunLength) == 0 {
+					for ; y < y1; y++ {
+						d.bitsDecoded[d.index(x, y)]++
+					}
+					continue
+				}
+
+				run := d.mq.decode(d.contexts[:], contextUniform) << 1
+				run |= d.mq.decode(d.contexts[:], contextUniform)
+				for ; y < y0+run; y++ {
+					d.bitsDecoded[d.index(x, y)]++
+				}
+
+				i := d.index(x, y)
+				d.decodeSign(i, y)
+				d.bitsDecoded[i]++
+				y++
+			}
+
+			for ; y < y1; y++ {
+				i := d.index(x, y)
+				if d.flags[i]&(flagSignificant|flagVisited) != 0 {
+					continue
+				}
+
+				h, v, diagonal := d.neighbours(i, y)
+				if d.mq.decode(d.contexts[:], d.zeroCodingContext(h, v, diagonal)) == 1 {
+					d.decodeSign(i, y)
+				}
+				d.bitsDecoded[i]++
+			}
+		}
+	}
+
+	for i := range d.flags {
+		d.flags[i] &^= flagVisited
+	}
+}
+
+// run-length mode is used if all four coefficients of column in stripe are insignificant, not coded yet and have no significant neighbours
+func (d *blockDecoder) canUseRunLength(x int, y0 int) bool {
+	for y := y0; y < y0+4; y++ {
+		i := d.index(x, y)
+		if d.flags[i]&(flagSignificant|flagVisited) != 0 {
+			return false
+		}
+		if h, v, diagonal := d.neighbours(i, y); h+v+diagonal != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,344 @@
+package jpeg2000
+
+import (
+	"image"
+	"math"
+	"strconv"
+	"sync"
+)
+
+// how components are mapped to channels of image
+type channelLayout struct {
+	// gray or red, green and blue
+	colors        []int
+	alpha         int
+	premultiplied bool
+}
+
+func (d *decoder) resolveLayout(header *jp2Header) (*channelLayout, error) {
+	result := &channelLayout{alpha: -1}
+	count := len(d.components)
+	if header != nil && header.channels != nil {
+		colors := make(map[int]int)
+		for component, definition := range header.channels {
+			if component >= count {
+				return nil, FormatError("channel definition refers to missing component")
+			}
+
+			switch definition.channelType {
+			case 0:
+				if definition.association > 0 {
+					colors[definition.association-1] = component
+				}
+			case 1, 2:
+				result.alpha = component
+				result.premultiplied = definition.channelType == 2
+			}
+		}
+		for i := 0; i < len(colors); i++ {
+			component, ok := colors[i]
+			if !ok {
+				return nil, FormatError("invalid channel definition")
+			}
+			result.colors = append(result.colors, component)
+		}
+	} else {
+		switch count {
+		case 1:
+			result.colors = []int{0}
+		case 2:
+			result.colors = []int{0}
+			result.alpha = 1
+		case 3:
+			result.colors = []int{0, 1, 2}
+		default:
+			result.colors = []int{0, 1, 2}
+			result.alpha = 3
+		}
+	}
+
+	if len(result.colors) != 1 && len(result.colors) != 3 {
+		return nil, UnsupportedError("color space with " + strconv.Itoa(len(result.colors)) + " colors")
+	}
+
+	if header != nil {
+		switch header.colorSpace {
+		// not specified or ICC profile, sRGB, greyscale
+		case 0, 16, 17:
+		default:
+			return nil, UnsupportedError("enumerated color space")
+		}
+	}
+	return result, nil
+}
+
+func (d *decoder) decodeTiles() error {
+	width := d.size.x1 - d.size.x0
+	height := d.size.y1 - d.size.y0
+	d.planes = make([][]int32, len(d.components))
+	for i := range d.planes {
+		d.planes[i] = make([]int32, width*height)
+	}
+
+	for _, t := range d.tiles {
+		err := d.decodeTile(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) decodeTile(t *tile) error {
+	cod := t.codingStyle(&d.main)
+	components := make([]*tileComponent, len(d.components))
+	for c := range components {
+		components[c] = newTileComponent(t, t.componentCodingStyle(&d.main, c), t.quantization(&d.main, c))
+	}
+
+	var data []byte
+	if len(t.parts) == 1 {
+		data = t.parts[0]
+	} else {
+		for _, part := range t.parts {
+			data = append(data, part...)
+		}
+	}
+
+	err := readPackets(data, cod, components)
+	if err != nil {
+		return err
+	}
+
+	samples := make([][]float64, len(components))
+	var waitGroup sync.WaitGroup
+	for c := range components {
+		waitGroup.Add(1)
+		go func(c int) {
+			defer waitGroup.Done()
+			samples[c] = reconstructComponent(components[c], d.components[c].precision)
+		}(c)
+	}
+	waitGroup.Wait()
+
+	if cod.mct && len(components) >= 3 {
+		if components[0].style.reversible != components[1].style.reversible || components[0].style.reversible != components[2].style.reversible {
+			return FormatError("component transform requires the same wavelet transform for the first three components")
+		}
+		inverseComponentTransform(samples[0], samples[1], samples[2], components[0].style.reversible)
+	}
+
+	tileWidth := t.x1 - t.x0
+	imageWidth := d.size.x1 - d.size.x0
+	for c, info := range d.components {
+		maxValue := float64(int(1)<<info.precision - 1)
+		shift := float64(int(1) << (info.precision - 1))
+		plane := d.planes[c]
+		for y := t.y0; y < t.y1; y++ {
+			row := samples[c][(y-t.y0)*tileWidth : (y-t.y0+1)*tileWidth]
+			offset := (y-d.size.y0)*imageWidth + t.x0 - d.size.x0
+			for x, value := range row {
+				// DC level shift is applied to signed components too, they are displayed as unsigned
+				value = math.Round(value + shift)
+				plane[offset+x] = int32(max(0, min(maxValue, value)))
+			}
+		}
+	}
+	return nil
+}
+
+// returns samples of tile-component (ITU-T T.800 Annex E and F)
+func reconstructComponent(tc *tileComponent, precision int) []float64 {
+	style := tc.style
+	var samples []float64
+	for r, res := range tc.resolutions {
+		width := res.x1 - res.x0
+		height := res.y1 - res.y0
+		current := make([]float64, width*height)
+
+		// low-pass samples are located at even coordinates
+		lowX := res.x0 & 1
+		lowY := res.y0 & 1
+		if r > 0 && samples != nil {
+			lowWidth := (res.x1+1)/2 - (res.x0+1)/2
+			for i := 0; i < len(samples); i++ {
+				current[(2*(i/lowWidth)+lowY)*width+2*(i%lowWidth)+lowX] = samples[i]
+			}
+		}
+
+		for _, band := range res.bands {
+			offsetX, offsetY := 0, 0
+			step := 1
+			if r > 0 {
+				step = 2
+				offsetX, offsetY = lowX, lowY
+				if band.orientation == bandHL || band.orientation == bandHH {
+					offsetX = 1 - lowX
+				}
+				if band.orientation == bandLH || band.orientation == bandHH {
+					offsetY = 1 - lowY
+				}
+			}
+
+			delta, magnitudeBits := tc.quantization.bandParameters(band, r, style, precision)
+			for _, pb := range band.precincts {
+				for _, block := range pb.blocks {
+					decodeCodeBlock(block, band, style, delta, magnitudeBits, func(x int, y int, value float64) {
+						current[(step*(y-band.y0)+offsetY)*width+step*(x-band.x0)+offsetX] = value
+					})
+				}
+			}
+		}
+
+		if r > 0 && width > 0 && height > 0 {
+			inverseTransform2D(current, width, height, res.x0, res.y0, style.reversible)
+		}
+		samples = current
+	}
+	return samples
+}
+
+// returns quantization step size and number of magnitude bits of subband (ITU-T T.800 E.1)
+func (q *quantization) bandParameters(band *subband, r int, style *componentCodingStyle, precision int) (float64, int) {
+	index := 0
+	if r > 0 {
+		index = 1 + 3*(r-1) + band.orientation - bandHL
+	}
+
+	var step quantizationStep
+	if q.style == quantizationDerived {
+		step = q.steps[0]
+		step.exponent -= style.levels - band.level
+	} else {
+		step = q.steps[min(index, len(q.steps)-1)]
+	}
+
+	magnitudeBits := q.guardBits + step.exponent - 1
+	if style.reversible {
+		return 1, magnitudeBits
+	}
+
+	gain := 0
+	switch band.orientation {
+	case bandHL, bandLH:
+		gain = 1
+	case bandHH:
+		gain = 2
+	}
+	return math.Ldexp(1+float64(step.mantissa)/2048, precision+gain-step.exponent), magnitudeBits
+}
+
+func decodeCodeBlock(block *codeBlock, band *subband, style *componentCodingStyle, delta float64, magnitudeBits int, consumer func(x int, y int, value float64)) {
+	width := block.x1 - block.x0
+	height := block.y1 - block.y0
+	if block.passes == 0 || width <= 0 || height <= 0 {
+		return
+	}
+
+	// cleanup pass of each bit-plane is preceded by significance propagation and magnitude refinement passes, except the first one
+	passes := min(block.passes, 3*(magnitudeBits-block.zeroBitPlanes)-2)
+	if passes <= 0 {
+		return
+	}
+
+	decoder := newBlockDecoder(width, height, band.orientation, style.codeBlockStyle, block.zeroBitPlanes)
+	decoder.decode(block.data, passes)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := decoder.index(x, y)
+			magnitude := decoder.magnitude[i]
+			if magnitude == 0 {
+				continue
+			}
+
+			// missing bit-planes are reconstructed to the middle of the interval
+			shift := magnitudeBits - int(decoder.bitsDecoded[i])
+			value := float64(magnitude)
+			if style.reversible {
+				if shift > 0 {
+					value = math.Ldexp(value+0.5, shift)
+				}
+			} else {
+				value = math.Ldexp(value+0.5, shift) * delta
+			}
+
+			if decoder.flags[i]&flagNegative != 0 {
+				value = -value
+			}
+			consumer(block.x0+x, block.y0+y, value)
+		}
+	}
+}
+
+// ITU-T T.800 G.2 and G.3
+func inverseComponentTransform(c0 []float64, c1 []float64, c2 []float64, reversible bool) {
+	for i := range c0 {
+		y, cb, cr := c0[i], c1[i], c2[i]
+		if reversible {
+			g := y - math.Floor((cr+cb)/4)
+			c0[i], c1[i], c2[i] = cr+g, g, cb+g
+		} else {
+			c0[i] = y + 1.402*cr
+			c1[i] = y - 0.34413*cb - 0.71414*cr
+			c2[i] = y + 1.772*cb
+		}
+	}
+}
+
+func (d *decoder) createImage(layout *channelLayout) image.Image {
+	width := d.size.x1 - d.size.x0
+	height := d.size.y1 - d.size.y0
+	rect := image.Rect(0, 0, width, height)
+
+	// samples are scaled to 8 bits
+	channel := func(component int, i int) uint8 {
+		precision := d.components[component].precision
+		value := d.planes[component][i]
+		if precision == 8 {
+			return uint8(value)
+		}
+		maxValue := int32(1)<<precision - 1
+		return uint8((value*255 + maxValue/2) / maxValue)
+	}
+
+	if len(layout.colors) == 1 && layout.alpha < 0 {
+		result := image.NewGray(rect)
+		for i := range result.Pix {
+			result.Pix[i] = channel(layout.colors[0], i)
+		}
+		return result
+	}
+
+	colorComponents := layout.colors
+	if len(colorComponents) == 1 {
+		colorComponents = []int{colorComponents[0], colorComponents[0], colorComponents[0]}
+	}
+
+	var pix []uint8
+	var result image.Image
+	if layout.alpha >= 0 && !layout.premultiplied {
+		nrgba := image.NewNRGBA(rect)
+		pix, result = nrgba.Pix, nrgba
+	} else {
+		rgba := image.NewRGBA(rect)
+		pix, result = rgba.Pix, rgba
+	}
+
+	for i := 0; i < width*height; i++ {
+		p := pix[i*4 : i*4+4 : i*4+4]
+		p[0] = channel(colorComponents[0], i)
+		p[1] = channel(colorComponents[1], i)
+		p[2] = channel(colorComponents[2], i)
+		if layout.alpha >= 0 {
+			p[3] = channel(layout.alpha, i)
+			if layout.premultiplied {
+				// guard against invalid premultiplied data
+				p[0], p[1], p[2] = min(p[0], p[3]), min(p[1], p[3]), min(p[2], p[3])
+			}
+		} else {
+			p[3] = 0xff
+		}
+	}
+	return result
+}
@@ -0,0 +1,283 @@
+package node_modules
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"github.com/dustin/go-humanize"
+)
+
+type SizeReport struct {
+	// sum of package sizes (as copied into the app)
+	Size int64 `json:"size"`
+	// hard links are counted once (e.g. pnpm store)
+	DiskSize int64 `json:"diskSize"`
+
+	// sorted by total size
+	Packages   []*PackageSize       `json:"packages"`
+	Duplicates []*DuplicatedPackage `json:"duplicates"`
+	Treemap    *TreemapNode         `json:"treemap"`
+
+	// not installed packages are not counted, size of the app is smaller than expected
+	Unresolved   []*UnresolvedDependency   `json:"unresolved"`
+	Incompatible []*IncompatibleDependency `json:"incompatible"`
+}
+
+type PackageSize struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Dir     string `json:"dir"`
+	// files of the package itself (nested node_modules is not included)
+	Size int64 `json:"size"`
+	// with all transitive dependencies (each counted once)
+	TotalSize int64 `json:"totalSize"`
+	FileCount int   `json:"fileCount"`
+
+	dependency *Dependency
+	fileIds    map[fileId]int64
+}
+
+// package with several versions (not hoisted instances are listed in conflictDependency)
+type DuplicatedPackage struct {
+	Name      string             `json:"name"`
+	Instances []*PackageInstance `json:"instances"`
+	// size of all instances except the largest one
+	WastedSize int64 `json:"wastedSize"`
+}
+
+type PackageInstance struct {
+	Version string `json:"version"`
+	Dir     string `json:"dir"`
+	Size    int64  `json:"size"`
+}
+
+// each package is listed once under the nearest to the root dependent
+type TreemapNode struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// own size
+	Size int64 `json:"size"`
+	// own size and size of children
+	Value    int64          `json:"value"`
+	Children []*TreemapNode `json:"children,omitempty"`
+}
+
+func (t *Collector) analyze() (*SizeReport, error) {
+	t.processHoistDependencyMap()
+
+	var packages []*PackageSize
+	dependencyToPackage := make(map[*Dependency]*PackageSize)
+	for _, dependency := range t.allDependencies {
+		if _, ok := dependencyToPackage[dependency]; ok {
+			continue
+		}
+
+		info := &PackageSize{Name: dependency.alias, Version: dependency.Version, Dir: dependency.dir, dependency: dependency}
+		dependencyToPackage[dependency] = info
+		packages = append(packages, info)
+	}
+
+	err := util.MapAsync(len(packages), func(taskIndex int) (func() error, error) {
+		info := packages[taskIndex]
+		return func() error {
+			return computePackageSize(info)
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SizeReport{Packages: packages, Unresolved: t.getUnresolvedDependencies(), Incompatible: t.getIncompatibleDependencies()}
+	seenFileIds := make(map[fileId]bool)
+	for _, info := range packages {
+		report.Size += info.Size
+		report.DiskSize += info.Size
+		for id, size := range info.fileIds {
+			if seenFileIds[id] {
+				report.DiskSize -= size
+			} else {
+				seenFileIds[id] = true
+			}
+		}
+	}
+
+	for _, info := range packages {
+		info.TotalSize = computeTotalSize(info.dependency, dependencyToPackage)
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].TotalSize != packages[j].TotalSize {
+			return packages[i].TotalSize > packages[j].TotalSize
+		}
+		return packages[i].Dir < packages[j].Dir
+	})
+
+	report.Duplicates = t.findDuplicates(dependencyToPackage)
+	report.Treemap = t.buildTreemap(dependencyToPackage)
+	return report, nil
+}
+
+func computePackageSize(info *PackageSize) error {
+	info.fileIds = make(map[fileId]int64)
+	return filepath.WalkDir(info.Dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if entry.Name() == "node_modules" && file != info.Dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if id, ok := getFileId(fileInfo); ok {
+			if _, isCounted := info.fileIds[id]; isCounted {
+				return nil
+			}
+			info.fileIds[id] = fileInfo.Size()
+		}
+
+		info.Size += fileInfo.Size()
+		info.FileCount++
+		return nil
+	})
+}
+
+func computeTotalSize(root *Dependency, dependencyToPackage map[*Dependency]*PackageSize) int64 {
+	var result int64
+	visited := make(map[*Dependency]bool)
+	stack := []*Dependency{root}
+	for len(stack) != 0 {
+		dependency := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[dependency] {
+			continue
+		}
+
+		visited[dependency] = true
+		if info := dependencyToPackage[dependency]; info != nil {
+			result += info.Size
+		}
+		stack = append(stack, dependency.children...)
+	}
+	return result
+}
+
+func (t *Collector) findDuplicates(dependencyToPackage map[*Dependency]*PackageSize) []*DuplicatedPackage {
+	nameToInstances := make(map[string][]*PackageSize)
+	var collectInstances func(dependencyMap map[string]*Dependency)
+	collectInstances = func(dependencyMap map[string]*Dependency) {
+		for alias, dependency := range dependencyMap {
+			if info := dependencyToPackage[dependency]; info != nil {
+				nameToInstances[alias] = append(nameToInstances[alias], info)
+			}
+			collectInstances(dependency.conflictDependency)
+		}
+	}
+	collectInstances(t.HoiestedDependencyMap)
+
+	var result []*DuplicatedPackage
+	for name, instances := range nameToInstances {
+		versions := make(map[string]bool)
+		for _, instance := range instances {
+			versions[instance.Version] = true
+		}
+		if len(versions) < 2 {
+			continue
+		}
+
+		duplicate := &DuplicatedPackage{Name: name}
+		var maxSize int64
+		for _, instance := range instances {
+			duplicate.Instances = append(duplicate.Instances, &PackageInstance{Version: instance.Version, Dir: instance.Dir, Size: instance.Size})
+			duplicate.WastedSize += instance.Size
+			maxSize = max(maxSize, instance.Size)
+		}
+		duplicate.WastedSize -= maxSize
+
+		sort.Slice(duplicate.Instances, func(i, j int) bool {
+			return duplicate.Instances[i].Dir < duplicate.Instances[j].Dir
+		})
+		result = append(result, duplicate)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].WastedSize != result[j].WastedSize {
+			return result[i].WastedSize > result[j].WastedSize
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// breadth-first, so package is placed under the nearest to the root dependent
+func (t *Collector) buildTreemap(dependencyToPackage map[*Dependency]*PackageSize) *TreemapNode {
+	root := &TreemapNode{Name: t.rootDependency.Name, Version: t.rootDependency.Version}
+	dependencyToNode := map[*Dependency]*TreemapNode{t.rootDependency: root}
+	var order []*TreemapNode
+	for queue := []*Dependency{t.rootDependency}; len(queue) != 0; queue = queue[1:] {
+		dependency := queue[0]
+		node := dependencyToNode[dependency]
+		order = append(order, node)
+		for _, child := range dependency.children {
+			if _, ok := dependencyToNode[child]; ok {
+				continue
+			}
+
+			childNode := &TreemapNode{Name: child.alias, Version: child.Version}
+			if info := dependencyToPackage[child]; info != nil {
+				childNode.Size = info.Size
+			}
+			dependencyToNode[child] = childNode
+			node.Children = append(node.Children, childNode)
+			queue = append(queue, child)
+		}
+	}
+
+	// children are added after parent, so reversed order computes value bottom-up
+	for index := len(order) - 1; index >= 0; index-- {
+		node := order[index]
+		node.Value = node.Size
+		for _, child := range node.Children {
+			node.Value += child.Value
+		}
+		sort.Slice(node.Children, func(i, j int) bool {
+			return node.Children[i].Value > node.Children[j].Value
+		})
+	}
+	return root
+}
+
+func writeSizeReportText(writer io.Writer, report *SizeReport) error {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%-10s %-10s %s\n", "size", "total", "package"))
+	for _, info := range report.Packages {
+		builder.WriteString(fmt.Sprintf("%-10s %-10s %s@%s\n", humanize.Bytes(uint64(info.Size)), humanize.Bytes(uint64(info.TotalSize)), info.Name, info.Version))
+	}
+
+	if len(report.Duplicates) != 0 {
+		builder.WriteString("\nseveral versions:\n")
+		for _, duplicate := range report.Duplicates {
+			builder.WriteString(fmt.Sprintf("%s (%s could be saved)\n", duplicate.Name, humanize.Bytes(uint64(duplicate.WastedSize))))
+			for _, instance := range duplicate.Instances {
+				builder.WriteString(fmt.Sprintf("  %-10s %-10s %s\n", instance.Version, humanize.Bytes(uint64(instance.Size)), instance.Dir))
+			}
+		}
+	}
+
+	builder.WriteString(fmt.Sprintf("\ntotal: %s (%s on disk)\n", humanize.Bytes(uint64(report.Size)), humanize.Bytes(uint64(report.DiskSize))))
+	_, err := io.WriteString(writer, builder.String())
+	return errors.WithStack(err)
+}
@@ -0,0 +1,59 @@
+package node_modules
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/log"
+	. "github.com/onsi/gomega"
+)
+
+func TestAnalyze(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	collector, err := collect(&collectOptions{dir: path.Join(Dirname(), "stage-demo")})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	report, err := collector.analyze()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(report.Packages).To(HaveLen(3))
+
+	b := report.Packages[0]
+	g.Expect(b.Name).To(Equal("b"))
+	// nested a@2.0.0 is not included in the own size
+	g.Expect(b.TotalSize).To(Equal(b.Size + report.Packages[2].Size))
+
+	g.Expect(report.Duplicates).To(HaveLen(1))
+	g.Expect(report.Duplicates[0].Name).To(Equal("a"))
+	g.Expect(report.Duplicates[0].Instances).To(HaveLen(2))
+
+	g.Expect(report.Treemap.Value).To(Equal(report.Size))
+	g.Expect(report.Treemap.Children).To(HaveLen(2))
+}
+
+func TestAnalyzeHardLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard links are not detected on windows")
+	}
+
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	dir := t.TempDir()
+	writeTestFile(g, filepath.Join(dir, "package.json"), `{"name": "app", "version": "1.0.0", "dependencies": {"x": "1.0.0", "y": "1.0.0"}}`)
+	writeTestFile(g, filepath.Join(dir, "node_modules", "x", "package.json"), `{"name": "x", "version": "1.0.0"}`)
+	writeTestFile(g, filepath.Join(dir, "node_modules", "x", "data.bin"), "0123456789")
+	writeTestFile(g, filepath.Join(dir, "node_modules", "y", "package.json"), `{"name": "y", "version": "1.0.0"}`)
+	g.Expect(os.Link(filepath.Join(dir, "node_modules", "x", "data.bin"), filepath.Join(dir, "node_modules", "y", "data.bin"))).To(Succeed())
+
+	collector, err := collect(&collectOptions{dir: dir})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	report, err := collector.analyze()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(report.Size - report.DiskSize).To(Equal(int64(10)))
+}
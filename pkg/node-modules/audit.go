@@ -0,0 +1,395 @@
+package node_modules
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// unknown severity (advisory without severity and CVSS vector) is always reported
+var severityLevels = map[string]int{
+	"low":      1,
+	"moderate": 2,
+	"high":     3,
+	"critical": 4,
+}
+
+// YAML or JSON file
+type AuditIgnoreFile struct {
+	Ignored []AuditIgnoreRule `yaml:"ignored" json:"ignored"`
+}
+
+type AuditIgnoreRule struct {
+	// advisory id or alias (GHSA-..., CVE-...)
+	Id string `yaml:"id" json:"id"`
+	// if specified, advisory is ignored only for this package
+	Package string `yaml:"package" json:"package"`
+	Reason  string `yaml:"reason" json:"reason"`
+	// YYYY-MM-DD, rule is not applied after this date
+	Until string `yaml:"until" json:"until"`
+}
+
+type AuditReport struct {
+	PackageCount    int             `json:"packageCount"`
+	AdvisoryCount   int             `json:"advisoryCount"`
+	IgnoredCount    int             `json:"ignoredCount"`
+	Vulnerabilities []*AuditFinding `json:"vulnerabilities"`
+}
+
+type AuditFinding struct {
+	Id       string   `json:"id"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Summary  string   `json:"summary"`
+	Severity string   `json:"severity"`
+	// CVSS v3 base score, 0 if not specified
+	Score float64 `json:"score,omitempty"`
+
+	Package       string   `json:"package"`
+	Version       string   `json:"version"`
+	FixedVersions []string `json:"fixedVersions,omitempty"`
+	// top-level dependencies that bring the package
+	Via []string `json:"via"`
+	Url string   `json:"url,omitempty"`
+
+	details string
+}
+
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html, only fields used by code scanning tools
+type SarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []SarifRun `json:"runs"`
+}
+
+type SarifRun struct {
+	Tool    SarifTool     `json:"tool"`
+	Results []SarifResult `json:"results"`
+}
+
+type SarifTool struct {
+	Driver SarifDriver `json:"driver"`
+}
+
+type SarifDriver struct {
+	Name           string      `json:"name"`
+	InformationUri string      `json:"informationUri"`
+	Rules          []SarifRule `json:"rules"`
+}
+
+type SarifRule struct {
+	Id               string              `json:"id"`
+	ShortDescription SarifMessage        `json:"shortDescription"`
+	FullDescription  *SarifMessage       `json:"fullDescription,omitempty"`
+	HelpUri          string              `json:"helpUri,omitempty"`
+	Properties       SarifRuleProperties `json:"properties"`
+}
+
+type SarifRuleProperties struct {
+	// GitHub code scanning maps score to severity
+	SecuritySeverity string   `json:"security-severity,omitempty"`
+	Tags             []string `json:"tags"`
+}
+
+type SarifMessage struct {
+	Text string `json:"text"`
+}
+
+type SarifResult struct {
+	RuleId    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   SarifMessage    `json:"message"`
+	Locations []SarifLocation `json:"locations"`
+}
+
+type SarifLocation struct {
+	PhysicalLocation SarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type SarifPhysicalLocation struct {
+	ArtifactLocation SarifArtifactLocation `json:"artifactLocation"`
+}
+
+type SarifArtifactLocation struct {
+	Uri string `json:"uri"`
+}
+
+func ConfigureAuditCommand(app *kingpin.Application) {
+	command := app.Command("audit", "check production dependencies against local advisory database (OSV JSON format), exit code is not zero if vulnerabilities are found")
+
+	dir := command.Flag("dir", "project dir").Required().String()
+	database := command.Flag("db", "dir or zip archive with OSV advisories (e.g. mirror of https://osv-vulnerabilities.storage.googleapis.com/npm/all.zip)").Required().String()
+	minSeverity := command.Flag("severity", "minimum severity to report").Default("low").Enum("low", "moderate", "high", "critical")
+	ignoreFile := command.Flag("ignore", "file (YAML or JSON) with ignored advisories").String()
+	sarifFile := command.Flag("sarif", "SARIF 2.1.0 output file").String()
+	jsonFile := command.Flag("json", "JSON output file").String()
+	useLockfile := command.Flag("lockfile", "build tree from lockfile (package-lock.json, yarn.lock or pnpm-lock.yaml) instead of walking node_modules").Bool()
+	excludedDependencies := command.Flag("exclude-dep", "").Strings()
+	getPlatform := configurePlatformFlags(command)
+
+	command.Action(func(context *kingpin.ParseContext) error {
+		var ignoreRules []AuditIgnoreRule
+		if len(*ignoreFile) != 0 {
+			var err error
+			ignoreRules, err = readAuditIgnoreFile(*ignoreFile)
+			if err != nil {
+				return err
+			}
+		}
+
+		collector, err := collect(&collectOptions{
+			dir:                  *dir,
+			excludedDependencies: *excludedDependencies,
+			useLockfile:          *useLockfile,
+			platform:             getPlatform(),
+		})
+		if err != nil {
+			return err
+		}
+
+		report, err := audit(collector.createSnapshot(), *database, severityLevels[*minSeverity], ignoreRules, time.Now())
+		if err != nil {
+			return err
+		}
+
+		if len(*jsonFile) == 0 && len(*sarifFile) == 0 {
+			err = util.WriteJsonToStdOut(report)
+			if err != nil {
+				return err
+			}
+		}
+		if len(*jsonFile) != 0 {
+			err = writeJsonFile(*jsonFile, report)
+			if err != nil {
+				return err
+			}
+		}
+		if len(*sarifFile) != 0 {
+			err = writeJsonFile(*sarifFile, report.toSarif())
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(report.Vulnerabilities) != 0 {
+			var names []string
+			for _, finding := range report.Vulnerabilities {
+				names = append(names, finding.Package+"@"+finding.Version+" ("+finding.Id+")")
+			}
+			return util.NewMessageError(fmt.Sprintf("%d vulnerabilities found in production dependencies: %s", len(report.Vulnerabilities), strings.Join(names, ", ")), "ERR_VULNERABLE_DEPENDENCIES")
+		}
+		return nil
+	})
+}
+
+func readAuditIgnoreFile(file string) ([]AuditIgnoreRule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// JSON is a valid YAML
+	var ignoreFile AuditIgnoreFile
+	err = yaml.Unmarshal(data, &ignoreFile)
+	if err != nil {
+		return nil, errors.WithMessage(err, "cannot parse audit ignore file "+file)
+	}
+
+	for _, rule := range ignoreFile.Ignored {
+		if len(rule.Id) == 0 {
+			return nil, errors.New("advisory id is not specified in the audit ignore file " + file)
+		}
+		if len(rule.Until) != 0 {
+			_, err = time.Parse(time.DateOnly, rule.Until)
+			if err != nil {
+				return nil, errors.Errorf("until of %s must be in YYYY-MM-DD format: %s", rule.Id, rule.Until)
+			}
+		}
+	}
+	return ignoreFile.Ignored, nil
+}
+
+// findings are sorted by severity (critical first), package and advisory id
+func audit(snapshot *DependencyTreeSnapshot, databasePath string, minSeverity int, ignoreRules []AuditIgnoreRule, now time.Time) (*AuditReport, error) {
+	packageNames := make(map[string]bool)
+	for _, info := range snapshot.Packages {
+		packageNames[info.Name] = true
+	}
+
+	database, err := readOsvDatabase(databasePath, packageNames)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AuditReport{PackageCount: len(snapshot.Packages), AdvisoryCount: database.advisoryCount, Vulnerabilities: make([]*AuditFinding, 0)}
+	for _, info := range snapshot.Packages {
+		for _, advisory := range database.packageToAdvisories[info.Name] {
+			affected := advisory.findAffected(info.Name, info.Version)
+			if affected == nil {
+				continue
+			}
+
+			rule := findAuditIgnoreRule(ignoreRules, advisory, info.Name, now)
+			if rule != nil {
+				log.Debug("advisory is ignored", zap.String("id", advisory.Id), zap.String("package", info.Name), zap.String("reason", rule.Reason))
+				report.IgnoredCount++
+				continue
+			}
+
+			severity, score := advisory.getSeverity(affected)
+			if len(severity) != 0 && severityLevels[severity] < minSeverity {
+				continue
+			}
+
+			finding := &AuditFinding{
+				Id:            advisory.Id,
+				Aliases:       advisory.Aliases,
+				Summary:       advisory.Summary,
+				Severity:      severity,
+				Score:         score,
+				Package:       info.Name,
+				Version:       info.Version,
+				FixedVersions: affected.getFixedVersions(),
+				Via:           info.Via,
+				details:       advisory.Details,
+			}
+			if len(finding.Severity) == 0 {
+				finding.Severity = "unknown"
+			}
+			for _, reference := range advisory.References {
+				if reference.Type == "ADVISORY" || len(finding.Url) == 0 {
+					finding.Url = reference.Url
+				}
+			}
+			report.Vulnerabilities = append(report.Vulnerabilities, finding)
+		}
+	}
+
+	sort.SliceStable(report.Vulnerabilities, func(i, j int) bool {
+		a := report.Vulnerabilities[i]
+		b := report.Vulnerabilities[j]
+		if severityLevels[a.Severity] != severityLevels[b.Severity] {
+			return severityLevels[a.Severity] > severityLevels[b.Severity]
+		}
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		return a.Id < b.Id
+	})
+	return report, nil
+}
+
+// expired rule is not applied
+func findAuditIgnoreRule(rules []AuditIgnoreRule, advisory *osvAdvisory, packageName string, now time.Time) *AuditIgnoreRule {
+	for index := range rules {
+		rule := &rules[index]
+		if len(rule.Package) != 0 && rule.Package != packageName {
+			continue
+		}
+		if rule.Id != advisory.Id && !util.ContainsString(advisory.Aliases, rule.Id) {
+			continue
+		}
+
+		if len(rule.Until) != 0 {
+			until, err := time.Parse(time.DateOnly, rule.Until)
+			if err == nil && !now.Before(until.AddDate(0, 0, 1)) {
+				log.Warn("audit ignore rule is expired", zap.String("id", rule.Id), zap.String("until", rule.Until))
+				continue
+			}
+		}
+		return rule
+	}
+	return nil
+}
+
+// rule per advisory, result per vulnerable package instance, location is the project package.json
+func (t *AuditReport) toSarif() *SarifLog {
+	run := SarifRun{
+		Tool: SarifTool{Driver: SarifDriver{
+			Name:           "app-builder audit",
+			InformationUri: "https://github.com/develar/app-builder",
+			Rules:          make([]SarifRule, 0),
+		}},
+		Results: make([]SarifResult, 0, len(t.Vulnerabilities)),
+	}
+
+	ruleIds := make(map[string]bool)
+	for _, finding := range t.Vulnerabilities {
+		if !ruleIds[finding.Id] {
+			ruleIds[finding.Id] = true
+			rule := SarifRule{
+				Id:               finding.Id,
+				ShortDescription: SarifMessage{Text: finding.Summary},
+				HelpUri:          finding.Url,
+				Properties:       SarifRuleProperties{SecuritySeverity: toSecuritySeverity(finding), Tags: []string{"security", "vulnerability", "npm"}},
+			}
+			if len(rule.ShortDescription.Text) == 0 {
+				rule.ShortDescription.Text = finding.Id
+			}
+			if len(finding.details) != 0 {
+				rule.FullDescription = &SarifMessage{Text: finding.details}
+			}
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rule)
+		}
+
+		message := fmt.Sprintf("%s@%s is vulnerable (%s, %s)", finding.Package, finding.Version, finding.Id, finding.Severity)
+		if len(finding.FixedVersions) != 0 {
+			message += ", fixed in " + strings.Join(finding.FixedVersions, ", ")
+		}
+		if len(finding.Via) != 0 {
+			message += ", introduced by " + strings.Join(finding.Via, ", ")
+		}
+
+		run.Results = append(run.Results, SarifResult{
+			RuleId:    finding.Id,
+			Level:     toSarifLevel(finding.Severity),
+			Message:   SarifMessage{Text: message},
+			Locations: []SarifLocation{{PhysicalLocation: SarifPhysicalLocation{ArtifactLocation: SarifArtifactLocation{Uri: "package.json"}}}},
+		})
+	}
+
+	return &SarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []SarifRun{run},
+	}
+}
+
+func toSarifLevel(severity string) string {
+	switch severity {
+	case "critical", "high":
+		return "error"
+	case "low":
+		return "note"
+	default:
+		return "warning"
+	}
+}
+
+// empty if neither score nor severity is known
+func toSecuritySeverity(finding *AuditFinding) string {
+	if finding.Score > 0 {
+		return fmt.Sprintf("%.1f", finding.Score)
+	}
+
+	switch finding.Severity {
+	case "critical":
+		return "9.0"
+	case "high":
+		return "7.0"
+	case "moderate":
+		return "4.0"
+	case "low":
+		return "0.1"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,109 @@
+package node_modules
+
+import (
+	"archive/zip"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/develar/app-builder/pkg/log"
+	. "github.com/onsi/gomega"
+)
+
+func TestAudit(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	collector, err := collect(&collectOptions{dir: path.Join(Dirname(), "sbom-demo"), useLockfile: true})
+	g.Expect(err).NotTo(HaveOccurred())
+	snapshot := collector.createSnapshot()
+
+	databaseDir := filepath.Join(Dirname(), "audit-demo", "osv")
+	now := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	report, err := audit(snapshot, databaseDir, severityLevels["low"], nil, now)
+	g.Expect(err).NotTo(HaveOccurred())
+	// withdrawn advisory is not counted
+	g.Expect(report.AdvisoryCount).To(Equal(3))
+	g.Expect(report.Vulnerabilities).To(HaveLen(2))
+
+	high := report.Vulnerabilities[0]
+	g.Expect(high.Id).To(Equal("GHSA-2p5x-8m4g-7q3c"))
+	g.Expect(high.Severity).To(Equal("high"))
+	g.Expect(high.Package).To(Equal("b"))
+	g.Expect(high.FixedVersions).To(Equal([]string{"2.0.1"}))
+	g.Expect(high.Via).To(Equal([]string{"@scope/a"}))
+	g.Expect(high.Url).To(Equal("https://github.com/advisories/GHSA-2p5x-8m4g-7q3c"))
+
+	// severity computed from CVSS vector
+	g.Expect(report.Vulnerabilities[1].Severity).To(Equal("moderate"))
+	g.Expect(report.Vulnerabilities[1].Score).To(Equal(5.3))
+
+	report, err = audit(snapshot, databaseDir, severityLevels["high"], nil, now)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(report.Vulnerabilities).To(HaveLen(1))
+
+	// ignored by alias, second rule is expired
+	ignoreRules, err := readAuditIgnoreFile(filepath.Join(Dirname(), "audit-demo", "audit-ignore.yaml"))
+	g.Expect(err).NotTo(HaveOccurred())
+	report, err = audit(snapshot, databaseDir, severityLevels["low"], ignoreRules, now)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(report.IgnoredCount).To(Equal(1))
+	g.Expect(report.Vulnerabilities).To(HaveLen(1))
+
+	sarif := report.toSarif()
+	g.Expect(sarif.Runs[0].Tool.Driver.Rules[0].Properties.SecuritySeverity).To(Equal("7.0"))
+	g.Expect(sarif.Runs[0].Results[0].Level).To(Equal("error"))
+	g.Expect(sarif.Runs[0].Results[0].Message.Text).To(Equal("b@2.0.0 is vulnerable (GHSA-2p5x-8m4g-7q3c, high), fixed in 2.0.1, introduced by @scope/a"))
+
+	// zip archive
+	zipFile := filepath.Join(t.TempDir(), "all.zip")
+	writeZip(g, zipFile, filepath.Join(databaseDir, "b"))
+	report, err = audit(snapshot, zipFile, severityLevels["low"], nil, now)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(report.AdvisoryCount).To(Equal(2))
+	g.Expect(report.Vulnerabilities).To(HaveLen(2))
+}
+
+func TestOsvRange(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	isAffected := func(version string, events ...osvEvent) bool {
+		return (&osvRange{Type: "SEMVER", Events: events}).isAffected(semver.MustParse(version))
+	}
+
+	g.Expect(isAffected("1.0.0", osvEvent{Introduced: "0"}, osvEvent{Fixed: "1.0.1"})).To(BeTrue())
+	g.Expect(isAffected("1.0.1", osvEvent{Introduced: "0"}, osvEvent{Fixed: "1.0.1"})).To(BeFalse())
+	g.Expect(isAffected("1.2.0", osvEvent{Introduced: "1.0.0"}, osvEvent{LastAffected: "1.2.0"})).To(BeTrue())
+	g.Expect(isAffected("1.2.1", osvEvent{Introduced: "1.0.0"}, osvEvent{LastAffected: "1.2.0"})).To(BeFalse())
+	g.Expect(isAffected("0.9.0", osvEvent{Introduced: "1.0.0"})).To(BeFalse())
+	// several intervals
+	events := []osvEvent{{Introduced: "1.0.0"}, {Fixed: "1.0.5"}, {Introduced: "2.0.0"}, {Fixed: "2.1.0"}}
+	g.Expect(isAffected("1.5.0", events...)).To(BeFalse())
+	g.Expect(isAffected("2.0.3", events...)).To(BeTrue())
+	g.Expect(isAffected("2.0.0-beta.1", events...)).To(BeFalse())
+
+	g.Expect(computeCvss3Score("CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H")).To(Equal(9.8))
+	g.Expect(computeCvss3Score("CVSS:3.1/AV:N/AC:L/PR:L/UI:R/S:C/C:L/I:L/A:N")).To(Equal(5.4))
+	g.Expect(computeCvss3Score("CVSS:3.1/AV:N")).To(Equal(-1.0))
+}
+
+func writeZip(g *GomegaWithT, file string, dir string) {
+	out, err := os.Create(file)
+	g.Expect(err).NotTo(HaveOccurred())
+	writer := zip.NewWriter(out)
+	entries, err := os.ReadDir(dir)
+	g.Expect(err).NotTo(HaveOccurred())
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		g.Expect(err).NotTo(HaveOccurred())
+		entryWriter, err := writer.Create("osv/" + entry.Name())
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = entryWriter.Write(data)
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+	g.Expect(writer.Close()).To(Succeed())
+	g.Expect(out.Close()).To(Succeed())
+}
@@ -37,12 +37,14 @@ func nodeDepPath(t *testing.T, dir string) {
 		fmt.Println("err", err)
 	}
 	g.Expect(err).NotTo(HaveOccurred())
-	var j []NodePathItem
+	var j struct {
+		Dependencies []NodePathItem `json:"dependencies"`
+	}
 	json.Unmarshal(output, &j)
 	dependencies := make([]NodePathItem, 4)
 	names := make([]string, 4)
 	index := 0
-	for _, d := range j {
+	for _, d := range j.Dependencies {
 		dependencies[index] = d
 		names[index] = d.Name
 		index++
@@ -61,9 +63,11 @@ func nodeDepTree(t *testing.T, dir string) {
 		fmt.Println("err", err)
 	}
 	g.Expect(err).NotTo(HaveOccurred())
-	var j []NodeTreeItem
+	var j struct {
+		Dependencies []NodeTreeItem `json:"dependencies"`
+	}
 	json.Unmarshal(output, &j)
-	r := lo.FlatMap(j, func(it NodeTreeItem, i int) []string {
+	r := lo.FlatMap(j.Dependencies, func(it NodeTreeItem, i int) []string {
 		return lo.Map(it.Deps, func(it NodeTreeDepItem, i int) string {
 			return it.Name
 		})
@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package node_modules
+
+import (
+	"os"
+)
+
+type fileId struct {
+	device uint64
+	inode  uint64
+}
+
+// file index is not provided by os.FileInfo on windows, hard links are counted several times
+func getFileId(_ os.FileInfo) (fileId, bool) {
+	return fileId{}, false
+}
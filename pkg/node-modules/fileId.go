@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package node_modules
+
+import (
+	"os"
+	"syscall"
+)
+
+type fileId struct {
+	device uint64
+	inode  uint64
+}
+
+// hard links have the same id, false if id is not available
+func getFileId(info os.FileInfo) (fileId, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink <= 1 {
+		return fileId{}, false
+	}
+	return fileId{device: uint64(stat.Dev), inode: stat.Ino}, true
+}
@@ -0,0 +1,90 @@
+package node_modules
+
+import (
+	"path"
+	"strings"
+)
+
+// glob relative to the package root: "*" doesn't match "/", "**" matches any number of dirs,
+// pattern without "/" matches the base name at any depth (as .npmignore does), trailing "/" matches only dirs
+type globPattern struct {
+	segments  []string
+	isDirOnly bool
+	isNegated bool
+}
+
+func compileGlobs(patterns []string) []globPattern {
+	result := make([]globPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) == 0 || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		var compiled globPattern
+		if strings.HasPrefix(pattern, "!") {
+			compiled.isNegated = true
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			compiled.isDirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+
+		pattern = strings.TrimPrefix(pattern, "./")
+		if strings.HasPrefix(pattern, "/") {
+			pattern = pattern[1:]
+		} else if !strings.Contains(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+
+		compiled.segments = strings.Split(pattern, "/")
+		result = append(result, compiled)
+	}
+	return result
+}
+
+func (t *globPattern) match(relativePath string, isDir bool) bool {
+	if t.isDirOnly && !isDir {
+		return false
+	}
+	return matchSegments(t.segments, strings.Split(relativePath, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) != 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for index := 0; index <= len(name); index++ {
+				if matchSegments(rest, name[index:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		matched, err := path.Match(pattern[0], name[0])
+		if err != nil || !matched {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
+
+// the last matched pattern wins (negated pattern re-includes)
+func matchGlobs(patterns []globPattern, relativePath string, isDir bool) bool {
+	result := false
+	for index := range patterns {
+		pattern := &patterns[index]
+		if pattern.isNegated == result && pattern.match(relativePath, isDir) {
+			result = !pattern.isNegated
+		}
+	}
+	return result
+}
@@ -1,11 +1,21 @@
 package node_modules
 
 import (
+	"os"
 	"path"
+	"path/filepath"
 	"runtime"
+
+	. "github.com/onsi/gomega"
 )
 
 func Dirname() string {
 	_, filename, _, _ := runtime.Caller(1)
 	return path.Dir(filename)
 }
+
+// parent dirs are created
+func writeTestFile(g *GomegaWithT, file string, content string) {
+	g.Expect(os.MkdirAll(filepath.Dir(file), 0755)).To(Succeed())
+	g.Expect(os.WriteFile(file, []byte(content), 0644)).To(Succeed())
+}
@@ -0,0 +1,401 @@
+package node_modules
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"github.com/develar/go-fs-util"
+	jsoniter "github.com/json-iterator/go"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+const unknownLicense = "UNKNOWN"
+
+// YAML or JSON file
+type LicensePolicy struct {
+	// SPDX license ids (glob, case-insensitive), if not empty only these licenses are allowed
+	Allowed []string `yaml:"allowed" json:"allowed"`
+	Denied  []string `yaml:"denied" json:"denied"`
+	// license is not specified or is not a valid SPDX expression
+	AllowUnknown bool `yaml:"allowUnknown" json:"allowUnknown"`
+	// package name or name@version to license expression (for packages with missing or wrong metadata)
+	Overrides map[string]string `yaml:"overrides" json:"overrides"`
+	// package name or name@version that are not checked
+	Ignored []string `yaml:"ignored" json:"ignored"`
+}
+
+type LicenseReport struct {
+	Packages       []*PackageLicense `json:"packages"`
+	ViolationCount int               `json:"violationCount"`
+}
+
+type PackageLicense struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	License    string `json:"license"`
+	Repository string `json:"repository,omitempty"`
+	Dir        string `json:"dir"`
+	// LICENSE, NOTICE and COPYING files in the package dir
+	Files     []string `json:"files"`
+	Violation string   `json:"violation,omitempty"`
+
+	texts []string
+}
+
+type packageLicenseInfo struct {
+	License    jsoniter.RawMessage `json:"license"`
+	Licenses   jsoniter.RawMessage `json:"licenses"`
+	Repository jsoniter.RawMessage `json:"repository"`
+}
+
+func ConfigureLicensesCommand(app *kingpin.Application) {
+	command := app.Command("licenses", "report licenses of production dependencies, check them against the policy and write third-party notices")
+
+	dir := command.Flag("dir", "project dir").Required().String()
+	outDir := command.Flag("out", "dir to write licenses.json, third-party-notices.txt and third-party-notices.html").String()
+	policyFile := command.Flag("policy", "policy file (YAML or JSON) with allowed and denied SPDX licenses, exit code is not zero on violations").String()
+	useLockfile := command.Flag("lockfile", "build tree from lockfile (package-lock.json, yarn.lock or pnpm-lock.yaml) instead of walking node_modules").Bool()
+	excludedDependencies := command.Flag("exclude-dep", "").Strings()
+	getPlatform := configurePlatformFlags(command)
+
+	command.Action(func(context *kingpin.ParseContext) error {
+		var policy *LicensePolicy
+		if len(*policyFile) != 0 {
+			var err error
+			policy, err = readLicensePolicy(*policyFile)
+			if err != nil {
+				return err
+			}
+		}
+
+		collector, err := collect(&collectOptions{
+			dir:                  *dir,
+			excludedDependencies: *excludedDependencies,
+			useLockfile:          *useLockfile,
+			platform:             getPlatform(),
+		})
+		if err != nil {
+			return err
+		}
+
+		report, err := collectLicenses(collector, policy)
+		if err != nil {
+			return err
+		}
+
+		if len(*outDir) != 0 {
+			err = writeLicenseFiles(report, *outDir)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = util.WriteJsonToStdOut(report)
+		if err != nil {
+			return err
+		}
+
+		if report.ViolationCount != 0 {
+			var names []string
+			for _, info := range report.Packages {
+				if len(info.Violation) != 0 {
+					names = append(names, info.Name+"@"+info.Version+" ("+info.License+")")
+				}
+			}
+			return util.NewMessageError(fmt.Sprintf("%d dependencies violate license policy: %s", report.ViolationCount, strings.Join(names, ", ")), "ERR_LICENSE_POLICY_VIOLATION")
+		}
+		return nil
+	})
+}
+
+func readLicensePolicy(file string) (*LicensePolicy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// JSON is a valid YAML
+	var policy LicensePolicy
+	err = yaml.Unmarshal(data, &policy)
+	if err != nil {
+		return nil, errors.WithMessage(err, "cannot parse license policy "+file)
+	}
+	return &policy, nil
+}
+
+// one entry per name@version, sorted by name
+func collectLicenses(collector *Collector, policy *LicensePolicy) (*LicenseReport, error) {
+	keyToPackage := make(map[string]*PackageLicense)
+	var packages []*PackageLicense
+	for _, dependency := range collector.allDependencies {
+		key := dependency.Name + "@" + dependency.Version
+		if _, ok := keyToPackage[key]; ok {
+			continue
+		}
+
+		info := &PackageLicense{Name: dependency.Name, Version: dependency.Version, Dir: dependency.dir}
+		keyToPackage[key] = info
+		packages = append(packages, info)
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Name == packages[j].Name {
+			return packages[i].Version < packages[j].Version
+		}
+		return packages[i].Name < packages[j].Name
+	})
+
+	err := util.MapAsync(len(packages), func(taskIndex int) (func() error, error) {
+		info := packages[taskIndex]
+		return func() error {
+			return readPackageLicense(info)
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &LicenseReport{Packages: packages}
+	if policy != nil {
+		for _, info := range packages {
+			info.Violation = policy.check(info)
+			if len(info.Violation) != 0 {
+				report.ViolationCount++
+				log.Warn("license policy violation", zap.String("name", info.Name), zap.String("version", info.Version), zap.String("license", info.License), zap.String("reason", info.Violation))
+			}
+		}
+	}
+	return report, nil
+}
+
+func readPackageLicense(info *PackageLicense) error {
+	data, err := os.ReadFile(filepath.Join(info.Dir, "package.json"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var packageJson packageLicenseInfo
+	err = jsoniter.Unmarshal(data, &packageJson)
+	if err != nil {
+		return errors.WithMessage(err, "cannot read "+filepath.Join(info.Dir, "package.json"))
+	}
+
+	info.License = toLicenseExpression(packageJson.License, packageJson.Licenses)
+	info.Repository = toRepositoryUrl(packageJson.Repository)
+
+	fileNames, err := fsutil.ReadDirContent(info.Dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	sort.Strings(fileNames)
+	info.Files = make([]string, 0)
+	for _, name := range fileNames {
+		if !isLicenseFile(name) {
+			continue
+		}
+
+		text, err := os.ReadFile(filepath.Join(info.Dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			// LICENSE can be a dir
+			log.Debug("cannot read license file", zap.String("dir", info.Dir), zap.String("file", name), zap.Error(err))
+			continue
+		}
+
+		info.Files = append(info.Files, name)
+		info.texts = append(info.texts, strings.TrimSpace(string(text)))
+	}
+	return nil
+}
+
+// "license": "MIT" | {"type": "MIT"}, deprecated "licenses": [{"type": "MIT"}, "Apache-2.0"] (any of)
+func toLicenseExpression(license jsoniter.RawMessage, licenses jsoniter.RawMessage) string {
+	var types []string
+	for _, raw := range []jsoniter.RawMessage{license, licenses} {
+		if len(raw) == 0 {
+			continue
+		}
+
+		var list []jsoniter.RawMessage
+		if jsoniter.Unmarshal(raw, &list) != nil {
+			list = []jsoniter.RawMessage{raw}
+		}
+
+		for _, item := range list {
+			var value string
+			if jsoniter.Unmarshal(item, &value) != nil {
+				var object struct {
+					Type string `json:"type"`
+				}
+				_ = jsoniter.Unmarshal(item, &object)
+				value = object.Type
+			}
+
+			value = strings.TrimSpace(value)
+			if len(value) != 0 {
+				types = append(types, value)
+			}
+		}
+
+		if len(types) != 0 {
+			break
+		}
+	}
+
+	switch len(types) {
+	case 0:
+		return unknownLicense
+	case 1:
+		return types[0]
+	default:
+		return "(" + strings.Join(types, " OR ") + ")"
+	}
+}
+
+func toRepositoryUrl(raw jsoniter.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+
+	var value string
+	if jsoniter.Unmarshal(raw, &value) == nil {
+		return value
+	}
+
+	var object struct {
+		Url string `json:"url"`
+	}
+	_ = jsoniter.Unmarshal(raw, &object)
+	return object.Url
+}
+
+// empty if license is allowed, otherwise reason
+func (t *LicensePolicy) check(info *PackageLicense) string {
+	if t.findPackageEntry(info, t.Ignored) {
+		return ""
+	}
+
+	license := info.License
+	for key, override := range t.Overrides {
+		if key == info.Name || key == info.Name+"@"+info.Version {
+			license = override
+			info.License = override
+			break
+		}
+	}
+
+	if license == unknownLicense {
+		if t.AllowUnknown {
+			return ""
+		}
+		return "license is not specified"
+	}
+
+	expression, err := parseSpdxExpression(license)
+	if err != nil {
+		if t.AllowUnknown {
+			return ""
+		}
+		return "license is not a valid SPDX expression"
+	}
+
+	if expression.isSatisfied(t.isAllowed) {
+		return ""
+	}
+	return "license is not allowed by policy"
+}
+
+func (t *LicensePolicy) findPackageEntry(info *PackageLicense, list []string) bool {
+	for _, entry := range list {
+		if entry == info.Name || entry == info.Name+"@"+info.Version {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *LicensePolicy) isAllowed(license string) bool {
+	if isLicenseMatched(t.Denied, license) {
+		return false
+	}
+	return len(t.Allowed) == 0 || isLicenseMatched(t.Allowed, license)
+}
+
+// license is a license id of the parsed SPDX expression (AND and OR are handled by spdxExpression.isSatisfied),
+// "Apache-2.0+" is matched by "Apache-2.0", "GPL-2.0 WITH Classpath-exception-2.0" is matched by "GPL-2.0" and by the full expression
+func isLicenseMatched(patterns []string, license string) bool {
+	license = strings.ToUpper(license)
+	candidates := []string{license, strings.TrimSuffix(license, "+")}
+	if id, _, found := strings.Cut(license, " WITH "); found {
+		candidates = append(candidates, id, strings.TrimSuffix(id, "+"))
+	}
+
+	for _, pattern := range patterns {
+		// "GPL-2.0  with Classpath-exception-2.0" is normalized as parsed license
+		pattern = strings.Join(strings.Fields(strings.ToUpper(pattern)), " ")
+		for _, candidate := range candidates {
+			matched, err := path.Match(pattern, candidate)
+			if err == nil && matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func writeLicenseFiles(report *LicenseReport, outDir string) error {
+	err := fsutil.EnsureDir(outDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	data, err := jsoniter.ConfigFastest.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = os.WriteFile(filepath.Join(outDir, "licenses.json"), data, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var text bytes.Buffer
+	var htmlText bytes.Buffer
+	htmlText.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Third-Party Notices</title>\n</head>\n<body>\n<h1>Third-Party Notices</h1>\n")
+	for _, info := range report.Packages {
+		text.WriteString(info.Name + " " + info.Version + "\n")
+		text.WriteString("License: " + info.License + "\n")
+		htmlText.WriteString("<h2>" + html.EscapeString(info.Name+" "+info.Version) + "</h2>\n")
+		htmlText.WriteString("<p>License: " + html.EscapeString(info.License) + "</p>\n")
+		if len(info.Repository) != 0 {
+			text.WriteString("Repository: " + info.Repository + "\n")
+			htmlText.WriteString("<p>Repository: " + html.EscapeString(info.Repository) + "</p>\n")
+		}
+
+		for _, licenseText := range info.texts {
+			text.WriteString("\n" + licenseText + "\n")
+			htmlText.WriteString("<pre>" + html.EscapeString(licenseText) + "</pre>\n")
+		}
+		text.WriteString("\n----------------------------------------------------------------------\n\n")
+	}
+	htmlText.WriteString("</body>\n</html>\n")
+
+	err = os.WriteFile(filepath.Join(outDir, "third-party-notices.txt"), text.Bytes(), 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(filepath.Join(outDir, "third-party-notices.html"), htmlText.Bytes(), 0644))
+}
@@ -0,0 +1,89 @@
+package node_modules
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/log"
+	. "github.com/onsi/gomega"
+)
+
+func TestLicenses(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	collector, err := collect(&collectOptions{dir: path.Join(Dirname(), "licenses-demo")})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	policy := &LicensePolicy{Allowed: []string{"MIT", "Apache-*"}, Denied: []string{"GPL-*"}}
+	report, err := collectLicenses(collector, policy)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(report.Packages).To(HaveLen(4))
+	g.Expect(report.Packages[0].Repository).To(Equal("https://github.com/example/a.git"))
+	g.Expect(report.Packages[0].Files).To(Equal([]string{"LICENSE"}))
+	g.Expect(report.Packages[1].License).To(Equal("(MIT OR GPL-3.0-or-later)"))
+	g.Expect(report.Packages[1].Violation).To(BeEmpty())
+	g.Expect(report.Packages[2].Violation).To(Equal("license is not allowed by policy"))
+	g.Expect(report.Packages[3].Violation).To(Equal("license is not specified"))
+	g.Expect(report.ViolationCount).To(Equal(2))
+
+	policy.Overrides = map[string]string{"d@1.0.0": "MIT"}
+	policy.Ignored = []string{"c"}
+	report, err = collectLicenses(collector, policy)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(report.ViolationCount).To(Equal(0))
+
+	outDir := t.TempDir()
+	g.Expect(writeLicenseFiles(report, outDir)).To(Succeed())
+	notices, err := os.ReadFile(filepath.Join(outDir, "third-party-notices.html"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(notices)).To(ContainSubstring("<pre>MIT License\n\nCopyright (c) a &lt;authors&gt; &amp; contributors</pre>"))
+	g.Expect(filepath.Join(outDir, "licenses.json")).To(BeAnExistingFile())
+}
+
+func TestSpdxExpression(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	isAllowed := func(license string) bool {
+		return license == "MIT" || license == "GPL-2.0-only WITH Classpath-exception-2.0"
+	}
+
+	for expression, expected := range map[string]bool{
+		"MIT":                           true,
+		"(MIT OR GPL-3.0)":              true,
+		"MIT AND GPL-3.0":               false,
+		"MIT AND (BSD-2-Clause OR MIT)": true,
+		"GPL-2.0-only WITH Classpath-exception-2.0": true,
+	} {
+		parsed, err := parseSpdxExpression(expression)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(parsed.isSatisfied(isAllowed)).To(Equal(expected), expression)
+	}
+
+	for _, expression := range []string{"", "MIT OR", "(MIT", "MIT WITH", "SEE LICENSE IN LICENSE.txt"} {
+		_, err := parseSpdxExpression(expression)
+		g.Expect(err).To(HaveOccurred(), expression)
+	}
+}
+
+func TestLicensePolicyIds(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	policy := &LicensePolicy{Allowed: []string{"MIT", "Apache-2.0", "GPL-2.0"}, Denied: []string{"GPL-3.0*"}}
+	for license, expected := range map[string]string{
+		"GPL-2.0 WITH Classpath-exception-2.0":  "",
+		"GPL-2.0+ WITH Classpath-exception-2.0": "",
+		"(MIT OR Apache-2.0)":                   "",
+		"(GPL-3.0-only OR MIT)":                 "",
+		"(MIT AND LGPL-2.1)":                    "license is not allowed by policy",
+		"GPL-3.0 WITH GCC-exception-3.1":        "license is not allowed by policy",
+		"BSD-3-Clause":                          "license is not allowed by policy",
+	} {
+		g.Expect(policy.check(&PackageLicense{Name: "a", Version: "1.0.0", License: license})).To(Equal(expected), license)
+	}
+
+	g.Expect(isLicenseMatched([]string{"gpl-2.0  with classpath-exception-2.0"}, "GPL-2.0 WITH Classpath-exception-2.0")).To(BeTrue())
+	g.Expect(isLicenseMatched([]string{"GPL-2.0 WITH Classpath-exception-2.0"}, "GPL-2.0")).To(BeFalse())
+}
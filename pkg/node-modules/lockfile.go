@@ -0,0 +1,255 @@
+package node_modules
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/errors"
+	"go.uber.org/zap"
+)
+
+// lockfile resolves dependencies using package manager lockfile instead of node_modules walking
+type lockfile interface {
+	// set lock key of root (project) dependency
+	configureRoot(root *Dependency)
+
+	// returns nil if dependency is not in the lockfile (node_modules is used to resolve it in this case),
+	// otherwise resolved dependency and node_modules dir where it is located (for the writeResult)
+	resolve(parent *Dependency, name string, spec string) (*Dependency, string, error)
+}
+
+// sorted by priority (if project dir contains several lockfiles)
+var lockfileNames = []string{"pnpm-lock.yaml", "yarn.lock", "npm-shrinkwrap.json", "package-lock.json"}
+
+// nil if lockfile not found in the project dir or parent dirs (workspace root)
+func findLockfile(projectDir string) (lockfile, error) {
+	dir := projectDir
+	guardCount := 0
+	for len(dir) != 0 {
+		for _, name := range lockfileNames {
+			file := filepath.Join(dir, name)
+			_, err := os.Stat(file)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, errors.WithStack(err)
+			}
+
+			log.Debug("lockfile found", zap.String("file", file))
+			return readLockfile(file)
+		}
+
+		dir = getParentDir(dir)
+
+		guardCount++
+		if guardCount > 999 {
+			return nil, errors.New("infinite loop: " + dir)
+		}
+	}
+	return nil, nil
+}
+
+func readLockfile(file string) (lockfile, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	dir := filepath.Dir(file)
+	var result lockfile
+	switch filepath.Base(file) {
+	case "pnpm-lock.yaml":
+		result, err = parsePnpmLockfile(data, dir)
+	case "yarn.lock":
+		result, err = parseYarnLockfile(data, dir)
+	default:
+		result, err = parseNpmLockfile(data, dir)
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "cannot read lockfile "+file)
+	}
+	return result, nil
+}
+
+func (t *Collector) readLockfileDependencyTree(dependency *Dependency) error {
+	if t.rootDependency == nil {
+		t.rootDependency = dependency
+		t.allDependenciesMap = make(map[string]*Dependency)
+		t.lockfile.configureRoot(dependency)
+	} else {
+		key := dependency.alias + dependency.Version + dependency.dir
+		if _, ok := t.allDependenciesMap[key]; ok {
+			return nil
+		}
+		t.allDependenciesMap[key] = dependency
+		t.allDependencies = append(t.allDependencies, dependency)
+	}
+
+	queue, err := t.processLockfileDependencies(dependency, dependency.Dependencies, false, nil)
+	if err != nil {
+		return err
+	}
+
+	queue, err = t.processLockfileDependencies(dependency, dependency.OptionalDependencies, true, queue)
+	if err != nil {
+		return err
+	}
+
+	dependency.children = queue
+
+	for _, child := range queue {
+		err = t.readLockfileDependencyTree(child)
+		if err != nil {
+			return err
+		}
+		child.parent = dependency
+	}
+	return nil
+}
+
+func (t *Collector) processLockfileDependencies(parent *Dependency, list map[string]string, isOptional bool, queue []*Dependency) ([]*Dependency, error) {
+	names := make([]string, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if strings.HasPrefix(name, "@types/") {
+			continue
+		}
+
+		if t.excludedDependencies != nil && t.excludedDependencies[name] {
+			continue
+		}
+
+		childDependency, nodeModuleDir, err := t.lockfile.resolve(parent, name, list[name])
+		if err != nil {
+			return queue, err
+		}
+
+		if childDependency == nil {
+			// not in the lockfile (e.g. workspace package for yarn classic)
+			childDependency, err = t.resolveDependencyUsingNodeModules(parent, name, list[name], isOptional)
+			if err != nil {
+				return queue, err
+			}
+			if childDependency == nil {
+				continue
+			}
+		} else {
+			childDependency, err = t.registerLockfileDependency(childDependency, parent, list[name], nodeModuleDir, isOptional)
+			if err != nil {
+				return queue, err
+			}
+			if childDependency == nil {
+				continue
+			}
+		}
+
+		if !t.isPlatformCompatible(childDependency, isOptional) {
+			continue
+		}
+
+		correctOptionalState(isOptional, childDependency)
+		queue = append(queue, childDependency)
+	}
+	return queue, nil
+}
+
+// nil if optional dependency is not installed (e.g. for another platform)
+func (t *Collector) registerLockfileDependency(dependency *Dependency, parent *Dependency, spec string, nodeModuleDir string, isOptional bool) (*Dependency, error) {
+	dependencyNameToDependency := t.NodeModuleDirToDependencyMap[nodeModuleDir]
+	if dependencyNameToDependency != nil {
+		existing := (*dependencyNameToDependency)[dependency.alias]
+		if existing != nil {
+			return existing, nil
+		}
+	}
+
+	// the lockfile is trusted and defines the tree, but not installed package is reported as unresolved (install is broken or outdated).
+	// binary (napi versions) is not stored in the lockfile and is read from package.json as on node_modules walking
+	packageJson, err := readPackageJson(dependency.dir)
+	if err == nil {
+		dependency.Binary = packageJson.Binary
+	} else {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if isOptional {
+			log.Debug("optional dependency is not installed", zap.String("name", dependency.Name), zap.String("dir", dependency.dir))
+			return nil, nil
+		}
+
+		t.unresolvedDependencies[parent.dir+"\x00"+dependency.alias] = &UnresolvedDependency{
+			Name:          dependency.alias,
+			Spec:          spec,
+			RequiredBy:    parent.Name + "@" + parent.Version,
+			RequiredByDir: parent.dir,
+		}
+	}
+
+	if dependency.Name == "libui-node" {
+		// remove because production app doesn't need to download libui
+		//noinspection SpellCheckingInspection
+		delete(dependency.Dependencies, "libui-download")
+	}
+
+	if dependencyNameToDependency == nil {
+		m := make(map[string]*Dependency)
+		t.NodeModuleDirToDependencyMap[nodeModuleDir] = &m
+		dependencyNameToDependency = &m
+	}
+	(*dependencyNameToDependency)[dependency.alias] = dependency
+	return dependency, nil
+}
+
+func (t *Collector) resolveDependencyUsingNodeModules(parent *Dependency, name string, spec string, isOptional bool) (*Dependency, error) {
+	nodeModuleDir, err := findNearestNodeModuleDir(parent.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nodeModuleDir) == 0 {
+		// not installed - only workspace, link: and file: dependencies can be resolved
+		nodeModuleDir = filepath.Join(parent.dir, "node_modules")
+	}
+
+	queue := make([]*Dependency, 1)
+	queueIndex, err := t.processDependencies(&map[string]string{name: spec}, parent, nodeModuleDir, isOptional, &queue, 0)
+	if err != nil || queueIndex == 0 {
+		return nil, err
+	}
+	return queue[0], nil
+}
+
+// "@scope/name@range" -> "@scope/name", "range"
+func splitDescriptor(descriptor string) (string, string) {
+	// package name cannot contain "@" except the scope prefix
+	index := strings.Index(descriptor[min(1, len(descriptor)):], "@")
+	if index < 0 {
+		return descriptor, ""
+	}
+	index++
+	return descriptor[:index], descriptor[index+1:]
+}
+
+// "npm:@scope/real@1.0.0" -> "@scope/real", "1.0.0"; empty name if spec is not an alias
+func getAliasTarget(spec string) (string, string) {
+	if !strings.HasPrefix(spec, "npm:") {
+		return "", spec
+	}
+
+	target := spec[len("npm:"):]
+	index := strings.LastIndex(target, "@")
+	if index <= 0 {
+		// "npm:^1.0.0" (yarn berry) - not an alias
+		return "", target
+	}
+	return target[:index], target[index+1:]
+}
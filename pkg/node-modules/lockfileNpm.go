@@ -0,0 +1,131 @@
+package node_modules
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/develar/errors"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// package-lock.json or npm-shrinkwrap.json (lockfileVersion 2 or 3)
+type npmLockfile struct {
+	LockfileVersion int                        `json:"lockfileVersion"`
+	Packages        map[string]*npmLockPackage `json:"packages"`
+
+	dir string
+}
+
+type npmLockPackage struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Resolved  string `json:"resolved"`
+	Integrity string `json:"integrity"`
+	Link      bool   `json:"link"`
+
+	Dependencies         map[string]string `json:"dependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+
+	Os   []string `json:"os"`
+	Cpu  []string `json:"cpu"`
+	Libc []string `json:"libc"`
+}
+
+func parseNpmLockfile(data []byte, dir string) (*npmLockfile, error) {
+	result := &npmLockfile{dir: dir}
+	err := jsoniter.Unmarshal(data, result)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if result.LockfileVersion < 2 || result.Packages == nil {
+		return nil, errors.Errorf("lockfileVersion %d is not supported (only 2 and 3), please run npm install using npm 7 or later", result.LockfileVersion)
+	}
+	return result, nil
+}
+
+func (t *npmLockfile) configureRoot(root *Dependency) {
+	root.lockKey = t.toKey(root.dir)
+}
+
+func (t *npmLockfile) toKey(dir string) string {
+	relativePath, err := filepath.Rel(t.dir, dir)
+	if err != nil || relativePath == "." {
+		return ""
+	}
+	return filepath.ToSlash(relativePath)
+}
+
+func (t *npmLockfile) resolve(parent *Dependency, name string, _ string) (*Dependency, string, error) {
+	key := t.locate(parent.lockKey, name)
+	if len(key) == 0 {
+		return nil, "", nil
+	}
+
+	nodeModuleDir := filepath.Join(t.dir, filepath.FromSlash(strings.TrimSuffix(key, "/"+name)))
+
+	entry := t.Packages[key]
+	if entry.Link {
+		// workspace package - dir is the real path as for the node_modules walking
+		key = entry.Resolved
+		entry = t.Packages[key]
+		if entry == nil {
+			return nil, "", nil
+		}
+	}
+
+	packageName := entry.Name
+	if len(packageName) == 0 {
+		packageName = name
+	}
+
+	return &Dependency{
+		Name:                 packageName,
+		Version:              entry.Version,
+		Dependencies:         entry.Dependencies,
+		OptionalDependencies: entry.OptionalDependencies,
+		Os:                   entry.Os,
+		Cpu:                  entry.Cpu,
+		Libc:                 entry.Libc,
+
+		dir:       filepath.Join(t.dir, filepath.FromSlash(key)),
+		alias:     name,
+		lockKey:   key,
+		integrity: entry.Integrity,
+	}, nodeModuleDir, nil
+}
+
+// the same algorithm as node uses - nearest node_modules dir that contains package, but using lockfile keys instead of file system
+func (t *npmLockfile) locate(parentKey string, name string) string {
+	base := parentKey
+	for {
+		var candidate string
+		if len(base) == 0 {
+			candidate = "node_modules/" + name
+		} else {
+			candidate = base + "/node_modules/" + name
+		}
+
+		if _, ok := t.Packages[candidate]; ok {
+			return candidate
+		}
+
+		if len(base) == 0 {
+			return ""
+		}
+
+		index := strings.LastIndex(base, "/node_modules/")
+		switch {
+		case index >= 0:
+			base = base[:index]
+		case strings.HasPrefix(base, "node_modules/"):
+			base = ""
+		default:
+			base = path.Dir(base)
+			if base == "." {
+				base = ""
+			}
+		}
+	}
+}
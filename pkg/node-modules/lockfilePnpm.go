@@ -0,0 +1,262 @@
+package node_modules
+
+import (
+	"crypto/md5"
+	"encoding/base32"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/develar/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const pnpmImporterKeyPrefix = "importer:"
+
+// pnpm-lock.yaml (lockfileVersion 6 or 9), packages are located in the virtual store (node_modules/.pnpm)
+type pnpmLockfile struct {
+	LockfileVersion string                   `yaml:"lockfileVersion"`
+	Importers       map[string]*pnpmImporter `yaml:"importers"`
+	Packages        map[string]*pnpmPackage  `yaml:"packages"`
+	// lockfileVersion 9, resolved dependencies are stored separately from package metadata
+	Snapshots map[string]*pnpmPackage `yaml:"snapshots"`
+
+	// lockfileVersion 6 without workspaces
+	Dependencies         map[string]pnpmImporterDependency `yaml:"dependencies"`
+	OptionalDependencies map[string]pnpmImporterDependency `yaml:"optionalDependencies"`
+
+	dir                      string
+	virtualStoreDir          string
+	virtualStoreDirMaxLength int
+}
+
+type pnpmImporter struct {
+	Dependencies         map[string]pnpmImporterDependency `yaml:"dependencies"`
+	OptionalDependencies map[string]pnpmImporterDependency `yaml:"optionalDependencies"`
+}
+
+type pnpmImporterDependency struct {
+	Specifier string `yaml:"specifier"`
+	Version   string `yaml:"version"`
+}
+
+type pnpmPackage struct {
+	Resolution struct {
+		Integrity string `yaml:"integrity"`
+		Tarball   string `yaml:"tarball"`
+	} `yaml:"resolution"`
+
+	Dependencies         map[string]string `yaml:"dependencies"`
+	OptionalDependencies map[string]string `yaml:"optionalDependencies"`
+	PeerDependencies     map[string]string `yaml:"peerDependencies"`
+	Optional             bool              `yaml:"optional"`
+
+	Os   []string `yaml:"os"`
+	Cpu  []string `yaml:"cpu"`
+	Libc []string `yaml:"libc"`
+}
+
+// node_modules/.modules.yaml written by pnpm
+type pnpmModulesState struct {
+	VirtualStoreDir          string `yaml:"virtualStoreDir"`
+	VirtualStoreDirMaxLength int    `yaml:"virtualStoreDirMaxLength"`
+}
+
+func parsePnpmLockfile(data []byte, dir string) (*pnpmLockfile, error) {
+	result := &pnpmLockfile{
+		dir:                      dir,
+		virtualStoreDir:          filepath.Join(dir, "node_modules", ".pnpm"),
+		virtualStoreDirMaxLength: 120,
+	}
+	err := yaml.Unmarshal(data, result)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !strings.HasPrefix(result.LockfileVersion, "6.") && !strings.HasPrefix(result.LockfileVersion, "9.") {
+		return nil, errors.Errorf("lockfileVersion %s is not supported (only 6 and 9), please run install using pnpm 8 or later", result.LockfileVersion)
+	}
+
+	if result.Importers == nil {
+		result.Importers = map[string]*pnpmImporter{
+			".": {Dependencies: result.Dependencies, OptionalDependencies: result.OptionalDependencies},
+		}
+	}
+
+	if result.Snapshots == nil {
+		// lockfileVersion 6 - "/name@version(peers)" keys, metadata and dependencies in the same entry
+		packages := make(map[string]*pnpmPackage, len(result.Packages))
+		for key, info := range result.Packages {
+			packages[strings.TrimPrefix(key, "/")] = info
+		}
+		result.Packages = packages
+		result.Snapshots = packages
+	}
+
+	modulesStateData, err := os.ReadFile(filepath.Join(dir, "node_modules", ".modules.yaml"))
+	if err == nil {
+		var modulesState pnpmModulesState
+		if yaml.Unmarshal(modulesStateData, &modulesState) == nil {
+			if len(modulesState.VirtualStoreDir) != 0 {
+				if filepath.IsAbs(modulesState.VirtualStoreDir) {
+					result.virtualStoreDir = modulesState.VirtualStoreDir
+				} else {
+					result.virtualStoreDir = filepath.Join(dir, "node_modules", modulesState.VirtualStoreDir)
+				}
+			}
+			if modulesState.VirtualStoreDirMaxLength > 0 {
+				result.virtualStoreDirMaxLength = modulesState.VirtualStoreDirMaxLength
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, errors.WithStack(err)
+	}
+	return result, nil
+}
+
+func (t *pnpmLockfile) configureRoot(root *Dependency) {
+	root.lockKey = t.toImporterKey(root.dir)
+}
+
+func (t *pnpmLockfile) toImporterKey(dir string) string {
+	relativePath, err := filepath.Rel(t.dir, dir)
+	if err != nil {
+		return ""
+	}
+	return pnpmImporterKeyPrefix + filepath.ToSlash(relativePath)
+}
+
+func (t *pnpmLockfile) resolve(parent *Dependency, name string, _ string) (*Dependency, string, error) {
+	var ref string
+	var nodeModuleDir string
+	if strings.HasPrefix(parent.lockKey, pnpmImporterKeyPrefix) {
+		importer := t.Importers[strings.TrimPrefix(parent.lockKey, pnpmImporterKeyPrefix)]
+		if importer == nil {
+			return nil, "", nil
+		}
+
+		info, ok := importer.Dependencies[name]
+		if !ok {
+			info, ok = importer.OptionalDependencies[name]
+			if !ok {
+				return nil, "", nil
+			}
+		}
+		ref = info.Version
+		nodeModuleDir = filepath.Join(parent.dir, "node_modules")
+	} else {
+		snapshot := t.Snapshots[parent.lockKey]
+		if snapshot == nil {
+			return nil, "", nil
+		}
+
+		ref = snapshot.Dependencies[name]
+		if len(ref) == 0 {
+			ref = snapshot.OptionalDependencies[name]
+			if len(ref) == 0 {
+				return nil, "", nil
+			}
+		}
+		// dependencies are linked next to the package in the virtual store
+		nodeModuleDir = strings.TrimSuffix(parent.dir, string(filepath.Separator)+filepath.FromSlash(parent.Name))
+	}
+
+	if strings.HasPrefix(ref, "link:") {
+		// workspace package - dir is the real path as for the node_modules walking
+		workspaceDir := filepath.Join(parent.dir, filepath.FromSlash(strings.TrimPrefix(ref, "link:")))
+		dependency, err := readPackageJson(workspaceDir)
+		if err != nil {
+			return nil, "", errors.WithStack(err)
+		}
+		dependency.dir = workspaceDir
+		dependency.alias = name
+		dependency.lockKey = t.toImporterKey(workspaceDir)
+		return dependency, nodeModuleDir, nil
+	}
+
+	key := ref
+	if len(ref) != 0 && (unicode.IsDigit(rune(ref[0])) || strings.HasPrefix(ref, "file:")) {
+		key = name + "@" + ref
+	}
+	key = strings.TrimPrefix(key, "/")
+
+	snapshot := t.Snapshots[key]
+	if snapshot == nil {
+		return nil, "", nil
+	}
+
+	packageName, version := splitDescriptor(stripPnpmPeers(key))
+	dependency := &Dependency{
+		Name:                 packageName,
+		Version:              version,
+		Dependencies:         snapshot.Dependencies,
+		OptionalDependencies: snapshot.OptionalDependencies,
+
+		dir:     filepath.Join(t.virtualStoreDir, depPathToFilename(key, t.virtualStoreDirMaxLength), "node_modules", filepath.FromSlash(packageName)),
+		alias:   name,
+		lockKey: key,
+	}
+
+	info := t.Packages[stripPnpmPeers(key)]
+	if info != nil {
+		dependency.Os = info.Os
+		dependency.Cpu = info.Cpu
+		dependency.Libc = info.Libc
+		dependency.integrity = info.Resolution.Integrity
+	}
+
+	// resolved peer dependencies are listed as dependencies, but node_modules walking doesn't include them
+	if info != nil && len(info.PeerDependencies) != 0 && len(dependency.Dependencies) != 0 {
+		dependencies := make(map[string]string, len(dependency.Dependencies))
+		for dependencyName, dependencyRef := range dependency.Dependencies {
+			if _, isPeer := info.PeerDependencies[dependencyName]; !isPeer {
+				dependencies[dependencyName] = dependencyRef
+			}
+		}
+		dependency.Dependencies = dependencies
+	}
+	return dependency, nodeModuleDir, nil
+}
+
+// "@electron/remote@2.1.2(electron@31.0.0)" -> "@electron/remote@2.1.2"
+func stripPnpmPeers(key string) string {
+	index := strings.IndexRune(key, '(')
+	if index < 0 {
+		return key
+	}
+	return key[:index]
+}
+
+// the same as depPathToFilename in @pnpm/dependency-path
+func depPathToFilename(depPath string, maxLengthWithoutHash int) string {
+	var filename string
+	if strings.HasPrefix(depPath, "file:") {
+		filename = strings.Replace(depPath, ":", "+", 1)
+	} else {
+		filename = strings.TrimPrefix(depPath, "/")
+	}
+
+	filename = strings.Map(func(r rune) rune {
+		switch r {
+		case '\\', '/', ':', '*', '?', '"', '<', '>', '|':
+			return '+'
+		default:
+			return r
+		}
+	}, filename)
+
+	if strings.Contains(filename, "(") {
+		filename = strings.TrimSuffix(filename, ")")
+		filename = strings.ReplaceAll(filename, ")(", "_")
+		filename = strings.ReplaceAll(filename, "(", "_")
+		filename = strings.ReplaceAll(filename, ")", "_")
+	}
+
+	if len(filename) > maxLengthWithoutHash || (filename != strings.ToLower(filename) && !strings.HasPrefix(filename, "file+")) {
+		hash := md5.Sum([]byte(filename))
+		encoded := strings.ToLower(strings.TrimRight(base32.StdEncoding.EncodeToString(hash[:]), "="))
+		return filename[:min(len(filename), maxLengthWithoutHash-27)] + "_" + encoded
+	}
+	return filename
+}
@@ -0,0 +1,319 @@
+package node_modules
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// yarn.lock of yarn classic (v1) or berry (v2+, YAML), descriptor ("name@range") is mapped to the package
+type yarnLockfile struct {
+	descriptorToPackage map[string]*yarnLockPackage
+	// to check on disk only if several versions of package are installed
+	nameToVersionCount map[string]int
+
+	dir     string
+	isBerry bool
+}
+
+type yarnLockPackage struct {
+	Version    string `yaml:"version"`
+	Resolution string `yaml:"resolution"`
+	Checksum   string `yaml:"checksum"`
+	LinkType   string `yaml:"linkType"`
+	// berry, e.g. "os=darwin & cpu=arm64"
+	Conditions string `yaml:"conditions"`
+
+	Dependencies         map[string]string `yaml:"dependencies"`
+	OptionalDependencies map[string]string `yaml:"optionalDependencies"`
+	DependenciesMeta     map[string]struct {
+		Optional bool `yaml:"optional"`
+	} `yaml:"dependenciesMeta"`
+
+	// classic only
+	resolved  string
+	integrity string
+}
+
+func parseYarnLockfile(data []byte, dir string) (*yarnLockfile, error) {
+	result := &yarnLockfile{
+		descriptorToPackage: make(map[string]*yarnLockPackage),
+		nameToVersionCount:  make(map[string]int),
+		dir:                 dir,
+	}
+
+	var keyToPackage map[string]*yarnLockPackage
+	var err error
+	if bytes.Contains(data, []byte("\n__metadata:")) || bytes.HasPrefix(data, []byte("__metadata:")) {
+		result.isBerry = true
+		err = yaml.Unmarshal(data, &keyToPackage)
+		delete(keyToPackage, "__metadata")
+	} else {
+		keyToPackage, err = parseYarnClassicLockfile(data)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for key, info := range keyToPackage {
+		if result.isBerry {
+			// berry stores optional dependencies in dependencies with the meta
+			for name, meta := range info.DependenciesMeta {
+				spec, ok := info.Dependencies[name]
+				if meta.Optional && ok {
+					if info.OptionalDependencies == nil {
+						info.OptionalDependencies = make(map[string]string)
+					}
+					info.OptionalDependencies[name] = spec
+					delete(info.Dependencies, name)
+				}
+			}
+		}
+
+		isCounted := false
+		for _, descriptor := range strings.Split(key, ",") {
+			descriptor = strings.TrimSpace(descriptor)
+			if len(descriptor) == 0 {
+				continue
+			}
+
+			result.descriptorToPackage[descriptor] = info
+			if !isCounted {
+				name, _ := splitDescriptor(descriptor)
+				result.nameToVersionCount[name]++
+				isCounted = true
+			}
+		}
+	}
+	return result, nil
+}
+
+func (t *yarnLockfile) configureRoot(_ *Dependency) {
+}
+
+func (t *yarnLockfile) findPackage(name string, spec string) *yarnLockPackage {
+	info := t.descriptorToPackage[name+"@"+spec]
+	if info == nil && t.isBerry && !strings.Contains(spec, ":") {
+		info = t.descriptorToPackage[name+"@npm:"+spec]
+	}
+	return info
+}
+
+func (t *yarnLockfile) resolve(parent *Dependency, name string, spec string) (*Dependency, string, error) {
+	info := t.findPackage(name, spec)
+	if info == nil {
+		return nil, "", nil
+	}
+
+	packageName := name
+	aliasTarget, _ := getAliasTarget(spec)
+	if len(aliasTarget) != 0 {
+		packageName = aliasTarget
+	} else if len(info.Resolution) != 0 {
+		packageName, _ = splitDescriptor(info.Resolution)
+	}
+
+	dependency := &Dependency{
+		Name:                 packageName,
+		Version:              info.Version,
+		Dependencies:         info.Dependencies,
+		OptionalDependencies: info.OptionalDependencies,
+		alias:                name,
+		// berry checksum is a hash of the zip archive, not of the npm tarball
+		integrity: info.integrity,
+	}
+	applyYarnConditions(dependency, info.Conditions)
+
+	_, resolution := splitDescriptor(info.Resolution)
+	if strings.HasPrefix(resolution, "workspace:") {
+		// workspace package - dir is the real path as for the node_modules walking, version is not meaningful in the lockfile
+		workspaceDir := filepath.Join(t.dir, filepath.FromSlash(strings.TrimPrefix(resolution, "workspace:")))
+		packageJson, err := readPackageJson(workspaceDir)
+		if err != nil {
+			return nil, "", errors.WithStack(err)
+		}
+		dependency.Version = packageJson.Version
+		dependency.dir = workspaceDir
+		nodeModuleDir, err := t.locate(parent.dir, name, "")
+		if err != nil {
+			return nil, "", err
+		}
+		return dependency, nodeModuleDir, nil
+	}
+
+	// yarn doesn't store location, hoisting result is checked on disk (without reading package.json if only one version is installed)
+	version := ""
+	if t.nameToVersionCount[packageName] > 1 || len(aliasTarget) != 0 {
+		version = info.Version
+	}
+	nodeModuleDir, err := t.locate(parent.dir, name, version)
+	if err != nil {
+		return nil, "", err
+	}
+	dependency.dir = resolvePath(filepath.Join(nodeModuleDir, name))
+	return dependency, nodeModuleDir, nil
+}
+
+// nearest node_modules dir that contains package (and version if specified), hoisted location if package is not installed
+func (t *yarnLockfile) locate(fromDir string, name string, version string) (string, error) {
+	dir := fromDir
+	for len(dir) != 0 {
+		nodeModuleDir := filepath.Join(dir, "node_modules")
+		dependencyDir := filepath.Join(nodeModuleDir, name)
+		_, err := os.Stat(dependencyDir)
+		if err == nil {
+			if len(version) == 0 {
+				return nodeModuleDir, nil
+			}
+
+			packageJson, err := readPackageJson(dependencyDir)
+			if err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+			if packageJson != nil && packageJson.Version == version {
+				return nodeModuleDir, nil
+			}
+		} else if !os.IsNotExist(err) {
+			return "", errors.WithStack(err)
+		}
+
+		if dir == t.dir {
+			break
+		}
+		dir = getParentDir(dir)
+	}
+
+	log.Debug("package is not installed, hoisted location is used", zap.String("name", name), zap.String("version", version))
+	return filepath.Join(t.dir, "node_modules"), nil
+}
+
+func applyYarnConditions(dependency *Dependency, conditions string) {
+	for _, condition := range strings.Split(conditions, "&") {
+		key, value, found := strings.Cut(strings.Trim(strings.TrimSpace(condition), "()"), "=")
+		if !found {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "os":
+			dependency.Os = append(dependency.Os, value)
+		case "cpu":
+			dependency.Cpu = append(dependency.Cpu, value)
+		case "libc":
+			dependency.Libc = append(dependency.Libc, value)
+		}
+	}
+}
+
+// yarn classic lockfile is not a YAML
+func parseYarnClassicLockfile(data []byte) (map[string]*yarnLockPackage, error) {
+	result := make(map[string]*yarnLockPackage)
+
+	var current *yarnLockPackage
+	var section *map[string]string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		trimmedLine := strings.TrimSpace(line)
+		if len(trimmedLine) == 0 || strings.HasPrefix(trimmedLine, "#") {
+			continue
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		switch {
+		case indent == 0:
+			if !strings.HasSuffix(trimmedLine, ":") {
+				return nil, errors.Errorf("unexpected line %d: %s", lineNumber, line)
+			}
+
+			current = &yarnLockPackage{}
+			section = nil
+			var keys []string
+			for _, descriptor := range strings.Split(strings.TrimSuffix(trimmedLine, ":"), ",") {
+				keys = append(keys, unquoteYarnValue(strings.TrimSpace(descriptor)))
+			}
+			result[strings.Join(keys, ", ")] = current
+
+		case current == nil:
+			return nil, errors.Errorf("unexpected line %d: %s", lineNumber, line)
+
+		case indent == 2:
+			section = nil
+			if strings.HasSuffix(trimmedLine, ":") {
+				switch strings.TrimSuffix(trimmedLine, ":") {
+				case "dependencies":
+					current.Dependencies = make(map[string]string)
+					section = &current.Dependencies
+				case "optionalDependencies":
+					current.OptionalDependencies = make(map[string]string)
+					section = &current.OptionalDependencies
+				}
+				continue
+			}
+
+			key, value := splitYarnLine(trimmedLine)
+			switch key {
+			case "version":
+				current.Version = value
+			case "resolved":
+				current.resolved = value
+			case "integrity":
+				current.integrity = value
+			}
+
+		default:
+			if section != nil {
+				key, value := splitYarnLine(trimmedLine)
+				(*section)[key] = value
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return result, nil
+}
+
+func splitYarnLine(line string) (string, string) {
+	var key string
+	if strings.HasPrefix(line, "\"") {
+		end := strings.Index(line[1:], "\"")
+		if end < 0 {
+			return unquoteYarnValue(line), ""
+		}
+		key = line[1 : end+1]
+		line = line[end+2:]
+	} else {
+		index := strings.IndexAny(line, " :")
+		if index < 0 {
+			return line, ""
+		}
+		key = line[:index]
+		line = line[index:]
+	}
+	return key, unquoteYarnValue(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), ":")))
+}
+
+func unquoteYarnValue(value string) string {
+	if strings.HasPrefix(value, "\"") {
+		unquoted, err := strconv.Unquote(value)
+		if err == nil {
+			return unquoted
+		}
+		return strings.Trim(value, "\"")
+	}
+	return value
+}
@@ -0,0 +1,213 @@
+package node_modules
+
+import (
+	"bytes"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/log"
+	jsoniter "github.com/json-iterator/go"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+)
+
+func collectUsingLockfile(g *GomegaWithT, dir string) *Collector {
+	log.InitLogger()
+	collector, err := collect(&collectOptions{dir: dir, useLockfile: true})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(collector.lockfile).NotTo(BeNil())
+	collector.processHoistDependencyMap()
+	return collector
+}
+
+func TestReadLockfileDependencyTreeByPnpm(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir := path.Join(Dirname(), "pnpm-demo")
+	collector := collectUsingLockfile(g, dir)
+
+	r := lo.FlatMap(lo.Values(collector.NodeModuleDirToDependencyMap), func(it *map[string]*Dependency, i int) []string {
+		return lo.Keys(*it)
+	})
+	g.Expect(r).To(ConsistOf([]string{
+		"js-tokens", "react", "remote", "loose-envify",
+	}))
+
+	remoteModule := collector.HoiestedDependencyMap["remote"]
+	g.Expect(remoteModule.Name).To(Equal("@electron/remote"))
+	g.Expect(remoteModule.alias).To(Equal("remote"))
+	g.Expect(remoteModule.dir).To(Equal(filepath.Join(dir, "node_modules/.pnpm/@electron+remote@2.1.2_electron@31.0.0/node_modules/@electron/remote")))
+
+	reactModule := collector.HoiestedDependencyMap["react"]
+	g.Expect(reactModule.dir).To(Equal(filepath.Join(dir, "node_modules/.pnpm/react@18.2.0/node_modules/react")))
+}
+
+func TestReadLockfileDependencyTreeByNpm(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	collector := collectUsingLockfile(g, path.Join(Dirname(), "npm-demo"))
+
+	r := lo.FlatMap(lo.Values(collector.NodeModuleDirToDependencyMap), func(it *map[string]*Dependency, i int) []string {
+		return lo.Keys(*it)
+	})
+	g.Expect(r).To(ConsistOf([]string{
+		"js-tokens", "react", "remote", "loose-envify",
+	}))
+	remoteModule := collector.HoiestedDependencyMap["remote"]
+	g.Expect(remoteModule.alias).To(Equal("remote"))
+	g.Expect(remoteModule.Name).To(Equal("@electron/remote"))
+}
+
+func TestReadLockfileDependencyTreeForTar(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir := path.Join(Dirname(), "tar-demo")
+	collector := collectUsingLockfile(g, dir)
+
+	r := lo.FlatMap(lo.Values(collector.NodeModuleDirToDependencyMap), func(it *map[string]*Dependency, i int) []string {
+		return lo.Keys(*it)
+	})
+	// optional @pkgjs/parseargs and bare-events are not installed (fixture without node_modules)
+	g.Expect(len(r)).To(Equal(97 - 2))
+	g.Expect(r).NotTo(ContainElement("bare-events"))
+
+	tarModule := collector.HoiestedDependencyMap["tar"]
+	g.Expect(tarModule.dir).To(Equal(filepath.Join(dir, "node_modules/tar")))
+	g.Expect(tarModule.conflictDependency["minipass"].Version).To(Equal("7.1.2"))
+	g.Expect(tarModule.conflictDependency["minizlib"].Version).To(Equal("3.0.1"))
+
+	g.Expect(collector.HoiestedDependencyMap["archiver-utils"].dir).To(Equal(filepath.Join(dir, "node_modules/archiver-utils")))
+	g.Expect(collector.HoiestedDependencyMap["archiver-utils"].Version).To(Equal("5.0.2"))
+}
+
+func TestLockfileNotInstalledDependency(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir := t.TempDir()
+	writeTestFile(g, filepath.Join(dir, "package.json"), `{"name": "app", "version": "1.0.0", "dependencies": {"installed": "^1.0.0", "missing": "^2.0.0"}, "optionalDependencies": {"optional-missing": "^1.0.0"}}`)
+	writeTestFile(g, filepath.Join(dir, "package-lock.json"), `{
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"name": "app", "version": "1.0.0"},
+    "node_modules/installed": {"version": "1.0.0"},
+    "node_modules/missing": {"version": "2.0.0"},
+    "node_modules/optional-missing": {"version": "1.0.0", "optional": true}
+  }
+}`)
+	writeTestFile(g, filepath.Join(dir, "node_modules", "installed", "package.json"), `{"name": "installed", "version": "1.0.0"}`)
+
+	collector := collectUsingLockfile(g, dir)
+	unresolved := collector.getUnresolvedDependencies()
+	g.Expect(unresolved).To(HaveLen(1))
+	g.Expect(unresolved[0].Name).To(Equal("missing"))
+	g.Expect(unresolved[0].Spec).To(Equal("^2.0.0"))
+	g.Expect(unresolved[0].RequiredBy).To(Equal("app@1.0.0"))
+	g.Expect(collector.HoiestedDependencyMap).NotTo(HaveKey("optional-missing"))
+}
+
+// napi versions are read from package.json of every package as on node_modules walking, not only for prebuild-install users (node-pre-gyp)
+func TestLockfileNapiVersions(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir := t.TempDir()
+	writeTestFile(g, filepath.Join(dir, "package.json"), `{"name": "app", "version": "1.0.0", "dependencies": {"napi-addon": "^1.0.0"}}`)
+	writeTestFile(g, filepath.Join(dir, "package-lock.json"), `{
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"name": "app", "version": "1.0.0"},
+    "node_modules/napi-addon": {"version": "1.0.0", "hasInstallScript": true}
+  }
+}`)
+	writeTestFile(g, filepath.Join(dir, "node_modules", "napi-addon", "package.json"), `{"name": "napi-addon", "version": "1.0.0", "binary": {"napi_versions": [3, 6]}}`)
+
+	collector := collectUsingLockfile(g, dir)
+	g.Expect(collector.HoiestedDependencyMap["napi-addon"].Binary.NapiVersions).To(Equal([]uint{3, 6}))
+
+	walkCollector, err := collect(&collectOptions{dir: dir})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(writeTreeJson(g, collector, true)).To(Equal(writeTreeJson(g, walkCollector, true)))
+}
+
+func writeTreeJson(g *GomegaWithT, collector *Collector, flatten bool) string {
+	buffer := &bytes.Buffer{}
+	jsonWriter := jsoniter.NewStream(jsoniter.ConfigFastest, buffer, 1024)
+	writeTreeResult(jsonWriter, collector, flatten)
+	g.Expect(jsonWriter.Flush()).To(Succeed())
+	return buffer.String()
+}
+
+func TestYarnClassicLockfile(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	file, err := readLockfile(path.Join(Dirname(), "yarn-demo", "yarn.lock"))
+	g.Expect(err).NotTo(HaveOccurred())
+	lockfile := file.(*yarnLockfile)
+	g.Expect(lockfile.isBerry).To(BeFalse())
+	g.Expect(lockfile.descriptorToPackage).To(HaveLen(2))
+	g.Expect(lockfile.findPackage("ms", "2.0.0").Version).To(Equal("2.0.0"))
+	g.Expect(lockfile.findPackage("ms", "2.1.1").integrity).To(HavePrefix("sha512-tgp+dl5cGk28"))
+	g.Expect(lockfile.nameToVersionCount["ms"]).To(Equal(2))
+
+	expectYarnLockfileTree(g, path.Join(Dirname(), "yarn-classic-demo"), false)
+}
+
+func TestYarnBerryLockfile(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir := path.Join(Dirname(), "yarn-berry-demo")
+	file, err := readLockfile(path.Join(dir, "yarn.lock"))
+	g.Expect(err).NotTo(HaveOccurred())
+	lockfile := file.(*yarnLockfile)
+	g.Expect(lockfile.isBerry).To(BeTrue())
+	g.Expect(lockfile.descriptorToPackage).NotTo(HaveKey("__metadata"))
+	// optional dependency is moved from dependencies using dependenciesMeta
+	root := lockfile.descriptorToPackage["yarn-berry-demo@workspace:."]
+	g.Expect(root.Dependencies).NotTo(HaveKey("fsevents"))
+	g.Expect(root.OptionalDependencies).To(Equal(map[string]string{"fsevents": "npm:^2.3.3"}))
+	g.Expect(lockfile.findPackage("fsevents", "^2.3.3").Conditions).To(Equal("os=darwin"))
+
+	expectYarnLockfileTree(g, dir, true)
+}
+
+// fixture is installed using node-modules linker (debug requires another version of ms than hoisted one), optional fsevents is not installed
+func expectYarnLockfileTree(g *GomegaWithT, dir string, isBerry bool) {
+	collector := collectUsingLockfile(g, dir)
+	g.Expect(collector.lockfile.(*yarnLockfile).isBerry).To(Equal(isBerry))
+	g.Expect(collector.getUnresolvedDependencies()).To(BeEmpty())
+
+	getVersions := func(nodeModuleDir string) map[string]string {
+		dependencyMap := collector.NodeModuleDirToDependencyMap[nodeModuleDir]
+		g.Expect(dependencyMap).NotTo(BeNil(), nodeModuleDir)
+		return lo.MapValues(*dependencyMap, func(it *Dependency, _ string) string {
+			return it.Version
+		})
+	}
+	g.Expect(collector.NodeModuleDirToDependencyMap).To(HaveLen(2))
+	g.Expect(getVersions(filepath.Join(dir, "node_modules"))).To(Equal(map[string]string{"debug": "4.3.4", "ms": "2.1.3"}))
+	g.Expect(getVersions(filepath.Join(dir, "node_modules", "debug", "node_modules"))).To(Equal(map[string]string{"ms": "2.1.2"}))
+
+	debug := collector.HoiestedDependencyMap["debug"]
+	g.Expect(debug.dir).To(Equal(filepath.Join(dir, "node_modules", "debug")))
+	g.Expect(debug.conflictDependency["ms"].Version).To(Equal("2.1.2"))
+	g.Expect(debug.conflictDependency["ms"].dir).To(Equal(filepath.Join(dir, "node_modules", "debug", "node_modules", "ms")))
+	g.Expect(collector.HoiestedDependencyMap["ms"].dir).To(Equal(filepath.Join(dir, "node_modules", "ms")))
+	if !isBerry {
+		g.Expect(debug.integrity).To(HavePrefix("sha512-"))
+	}
+
+	// the same tree as node_modules walking
+	walkCollector, err := collect(&collectOptions{dir: dir})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(walkCollector.lockfile).To(BeNil())
+	g.Expect(writeTreeJson(g, collector, false)).To(Equal(writeTreeJson(g, walkCollector, false)))
+}
+
+func TestDepPathToFilename(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(depPathToFilename("@electron/remote@2.1.2(electron@31.0.0)", 120)).To(Equal("@electron+remote@2.1.2_electron@31.0.0"))
+	g.Expect(depPathToFilename("/foo@1.0.0(react@18.2.0)(react-dom@18.2.0)", 120)).To(Equal("foo@1.0.0_react@18.2.0_react-dom@18.2.0"))
+	g.Expect(depPathToFilename("JSONStream@1.3.5", 120)).To(HavePrefix("JSONStream@1.3.5_"))
+	g.Expect(depPathToFilename("JSONStream@1.3.5", 120)).To(HaveLen(len("JSONStream@1.3.5_") + 26))
+}
@@ -0,0 +1,270 @@
+package node_modules
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// binary for the target platform is already in the package, nothing to do
+	nativeKindPrebuilt = "prebuilt"
+	// binary is downloaded using prebuild-install, build from sources is a fallback
+	nativeKindPrebuildInstall = "prebuild-install"
+	nativeKindCompile         = "compile"
+)
+
+const (
+	buildSystemNodeGyp = "node-gyp"
+	buildSystemCmakeJs = "cmake-js"
+	buildSystemNapiRs  = "napi-rs"
+)
+
+type NativeModuleReport struct {
+	Platform string          `json:"platform"`
+	Arch     string          `json:"arch"`
+	Modules  []*NativeModule `json:"modules"`
+}
+
+type NativeModule struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Dir      string `json:"dir"`
+	Optional bool   `json:"optional"`
+
+	// prebuilt, prebuild-install or compile
+	Kind string `json:"kind"`
+	// node-gyp, cmake-js or napi-rs, empty if package doesn't contain sources
+	BuildSystem string `json:"buildSystem,omitempty"`
+	// *.node files for the target platform (relative to the package dir)
+	Binaries []string `json:"binaries,omitempty"`
+	Reason   string   `json:"reason"`
+}
+
+type nativePackageInfo struct {
+	Scripts              map[string]string   `json:"scripts"`
+	Dependencies         map[string]string   `json:"dependencies"`
+	DevDependencies      map[string]string   `json:"devDependencies"`
+	OptionalDependencies map[string]string   `json:"optionalDependencies"`
+	Napi                 jsoniter.RawMessage `json:"napi"`
+}
+
+func (t *nativePackageInfo) hasDependency(name string) bool {
+	return t.Dependencies[name] != "" || t.DevDependencies[name] != "" || t.OptionalDependencies[name] != ""
+}
+
+func (t *nativePackageInfo) isInstallScriptContains(command string) bool {
+	for _, name := range []string{"preinstall", "install", "postinstall"} {
+		if strings.Contains(t.Scripts[name], command) {
+			return true
+		}
+	}
+	return false
+}
+
+// nil if package is not a native module
+func detectNativeModule(dependency *DepInfo, configuration *RebuildConfiguration) (*NativeModule, error) {
+	data, err := os.ReadFile(filepath.Join(dependency.dir, "package.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	var info nativePackageInfo
+	err = jsoniter.Unmarshal(data, &info)
+	if err != nil {
+		return nil, errors.WithMessage(err, "cannot read "+filepath.Join(dependency.dir, "package.json"))
+	}
+
+	result := &NativeModule{Name: dependency.Name, Version: dependency.Version, Dir: dependency.dir, Optional: dependency.Optional}
+	switch {
+	case isFile(filepath.Join(dependency.dir, "binding.gyp")):
+		result.BuildSystem = buildSystemNodeGyp
+	case isFile(filepath.Join(dependency.dir, "CMakeLists.txt")) && (info.hasDependency("cmake-js") || info.isInstallScriptContains("cmake-js")):
+		result.BuildSystem = buildSystemCmakeJs
+	case len(info.Napi) != 0 && isFile(filepath.Join(dependency.dir, "Cargo.toml")):
+		result.BuildSystem = buildSystemNapiRs
+	}
+
+	platform := configuration.Platform
+	arch := toNodeArch(configuration.Arch)
+
+	// node-gyp-build and prebuildify: prebuilds/<platform>-<arch>/*.node
+	prebuilds, err := findNodeFiles(filepath.Join(dependency.dir, "prebuilds", platform+"-"+arch))
+	if err != nil {
+		return nil, err
+	}
+	// ABI specific binaries (node.abi115.node) are not suitable for Electron, N-API ones are
+	prebuilds = filterPrebuilds(prebuilds, len(dependency.NapiVersions) != 0)
+	if len(prebuilds) != 0 {
+		result.Kind = nativeKindPrebuilt
+		result.Binaries = toRelativePaths(dependency.dir, prebuilds)
+		result.Reason = "prebuilds dir contains binary for " + platform + "-" + arch
+		return result, nil
+	}
+
+	// napi-rs: binary is published as optional platform package (foo-darwin-arm64) or placed next to the index.js (foo.darwin-arm64.node)
+	if len(info.Napi) != 0 {
+		platformPackage := findNapiRsPlatformPackage(dependency, &info, platform, arch)
+		if len(platformPackage) != 0 {
+			result.Kind = nativeKindPrebuilt
+			result.Reason = "platform package " + platformPackage + " is installed"
+			return result, nil
+		}
+
+		binaries, err := findNodeFilesWithMarker(dependency.dir, "."+platform+"-"+arch)
+		if err != nil {
+			return nil, err
+		}
+		if len(binaries) != 0 {
+			result.Kind = nativeKindPrebuilt
+			result.Binaries = toRelativePaths(dependency.dir, binaries)
+			result.Reason = "package contains binary for " + platform + "-" + arch
+			return result, nil
+		}
+
+		result.Kind = nativeKindCompile
+		result.Reason = "platform package for " + platform + "-" + arch + " is not installed"
+		return result, nil
+	}
+
+	if len(result.BuildSystem) != 0 {
+		if dependency.HasPrebuildInstall || info.hasDependency("prebuild-install") || info.isInstallScriptContains("prebuild-install") {
+			result.Kind = nativeKindPrebuildInstall
+			result.Reason = "prebuild-install is used, build from sources is a fallback"
+		} else {
+			result.Kind = nativeKindCompile
+			result.Reason = result.BuildSystem + " project without prebuilt binary for " + platform + "-" + arch
+		}
+		return result, nil
+	}
+
+	// module without sources that ships binaries as is
+	binaries, err := findNodeFilesWithMarker(dependency.dir, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(binaries) == 0 {
+		if isDir(filepath.Join(dependency.dir, "prebuilds")) {
+			result.Kind = nativeKindCompile
+			result.Reason = "prebuilds dir doesn't contain binary for " + platform + "-" + arch
+			return result, nil
+		}
+		return nil, nil
+	}
+
+	result.Kind = nativeKindPrebuilt
+	result.Binaries = toRelativePaths(dependency.dir, binaries)
+	result.Reason = "package contains prebuilt binaries and no sources"
+	return result, nil
+}
+
+func filterPrebuilds(files []string, isNapi bool) []string {
+	var result []string
+	for _, file := range files {
+		name := filepath.Base(file)
+		if isNapi || strings.Contains(name, "napi") || strings.HasPrefix(name, "electron") || !strings.Contains(name, ".abi") {
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+func findNapiRsPlatformPackage(dependency *DepInfo, info *nativePackageInfo, platform string, arch string) string {
+	var names []string
+	for name := range info.OptionalDependencies {
+		if strings.Contains(name, "-"+platform+"-"+arch) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, dir := range []string{filepath.Join(dependency.dir, "node_modules", name), filepath.Join(dependency.parentDir, name)} {
+			if isFile(filepath.Join(dir, "package.json")) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+func findNodeFiles(dir string) ([]string, error) {
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	var result []string
+	for _, entry := range names {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".node") {
+			result = append(result, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return result, nil
+}
+
+// nested node_modules and prebuilds for other platforms are not checked, depth is limited to not walk big packages
+func findNodeFilesWithMarker(dir string, marker string) ([]string, error) {
+	const maxDepth = 4
+	var result []string
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if file != dir && (entry.Name() == "node_modules" || entry.Name() == "prebuilds" || strings.Count(file[len(dir):], string(filepath.Separator)) >= maxDepth) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if strings.HasSuffix(entry.Name(), ".node") && strings.Contains(entry.Name(), marker) {
+			result = append(result, file)
+		}
+		return nil
+	})
+	return result, errors.WithStack(err)
+}
+
+func toRelativePaths(dir string, files []string) []string {
+	result := make([]string, 0, len(files))
+	for _, file := range files {
+		relativePath, err := filepath.Rel(dir, file)
+		if err != nil {
+			relativePath = file
+		}
+		result = append(result, filepath.ToSlash(relativePath))
+	}
+	return result
+}
+
+func isFile(file string) bool {
+	info, err := os.Stat(file)
+	return err == nil && !info.IsDir()
+}
+
+func isDir(file string) bool {
+	info, err := os.Stat(file)
+	return err == nil && info.IsDir()
+}
+
+func writeNativeModuleReport(dependencies []*DepInfo, configuration *RebuildConfiguration) error {
+	report := &NativeModuleReport{Platform: configuration.Platform, Arch: configuration.Arch, Modules: make([]*NativeModule, 0, len(dependencies))}
+	for _, dependency := range dependencies {
+		report.Modules = append(report.Modules, dependency.nativeModule)
+	}
+	return util.WriteJsonToStdOut(report)
+}
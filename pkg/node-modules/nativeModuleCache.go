@@ -0,0 +1,267 @@
+package node_modules
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	appfs "github.com/develar/app-builder/pkg/fs"
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/errors"
+	"github.com/develar/go-fs-util"
+	"go.uber.org/zap"
+)
+
+// bump to invalidate all entries if layout or key is changed
+const nativeCacheVersion = "1"
+
+// paths and npm internals that don't affect the build result
+var nativeCacheIgnoredEnv = map[string]bool{
+	"npm_config_cache":        true,
+	"npm_config_globalconfig": true,
+	"npm_config_userconfig":   true,
+	"npm_config_prefix":       true,
+	"npm_config_local_prefix": true,
+	"npm_config_init_module":  true,
+	"npm_config_user_agent":   true,
+	"npm_config_npm_version":  true,
+	"npm_config_node_gyp":     true,
+}
+
+// build intermediates are not cached
+var nativeOutputFileRegExp = regexp.MustCompile(`(?i)(\.node|\.so(\.\d+)*|\.dylib|\.dll)$`)
+
+type nativeModuleCache struct {
+	dir string
+}
+
+type nativeCacheEntry struct {
+	dependency *DepInfo
+	dir        string
+}
+
+func newNativeModuleCache(dir string) *nativeModuleCache {
+	if len(dir) == 0 {
+		return nil
+	}
+	return &nativeModuleCache{dir: dir}
+}
+
+func (t *nativeModuleCache) entry(dependency *DepInfo, configuration *RebuildConfiguration) (*nativeCacheEntry, error) {
+	key, err := computeNativeCacheKey(dependency, configuration, os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	// scoped name contains /
+	name := strings.ReplaceAll(dependency.Name, "/", "+")
+	return &nativeCacheEntry{dependency: dependency, dir: filepath.Join(t.dir, name, dependency.Version+"-"+key)}, nil
+}
+
+// target and runtime (electronVersion or npm_config_target, npm_config_runtime) define ABI, toolchain env is passed only to the build command and so is not in environ
+func computeNativeCacheKey(dependency *DepInfo, configuration *RebuildConfiguration, environ []string) (string, error) {
+	hasher := sha256.New()
+	writeKeyPart := func(name string, value string) {
+		_, _ = io.WriteString(hasher, name+"="+value+"\n")
+	}
+
+	writeKeyPart("version", nativeCacheVersion)
+	writeKeyPart("name", dependency.Name)
+	writeKeyPart("packageVersion", dependency.Version)
+	writeKeyPart("platform", configuration.Platform)
+	writeKeyPart("arch", configuration.Arch)
+	writeKeyPart("args", strings.Join(configuration.AdditionalArgs, " "))
+	writeKeyPart("electronVersion", configuration.ElectronVersion)
+	writeKeyPart("headersUrl", configuration.HeadersUrl)
+	writeKeyPart("useNodeGyp", strconv.FormatBool(configuration.UseNodeGyp))
+
+	runtime := "electron"
+	for _, item := range environ {
+		if value, ok := strings.CutPrefix(item, "npm_config_runtime="); ok && len(value) != 0 {
+			runtime = value
+		}
+	}
+	writeKeyPart("runtime", runtime)
+
+	for _, item := range configuration.getToolchainEnv() {
+		writeKeyPart("toolchainEnv", item)
+	}
+
+	var env []string
+	for _, item := range environ {
+		separatorIndex := strings.IndexRune(item, '=')
+		if separatorIndex <= 0 {
+			continue
+		}
+
+		// env names are case-insensitive on Windows
+		name := strings.ToLower(item[:separatorIndex])
+		if strings.HasPrefix(name, "npm_config_") && !nativeCacheIgnoredEnv[name] {
+			env = append(env, name+"="+item[separatorIndex+1:])
+		}
+	}
+	sort.Strings(env)
+	for _, item := range env {
+		writeKeyPart("env", item)
+	}
+
+	err := hashSourceFiles(dependency.dir, hasher)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil))[:32], nil
+}
+
+// build output, installed dependencies and prebuilt binaries are not part of the source
+func hashSourceFiles(dir string, hasher hash.Hash) error {
+	return filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(dir, file)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		relativePath = filepath.ToSlash(relativePath)
+
+		if entry.IsDir() {
+			switch relativePath {
+			case "build", "node_modules", "prebuilds", ".git":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		_, _ = io.WriteString(hasher, "file="+relativePath+"\n")
+		reader, err := os.Open(file)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		_, err = io.Copy(hasher, reader)
+		_ = reader.Close()
+		return errors.WithStack(err)
+	})
+}
+
+// files of build/Release (only binaries), relative to the package dir
+func findNativeOutputFiles(packageDir string, modifiedAfter time.Time) ([]string, error) {
+	outDir := filepath.Join(packageDir, "build", "Release")
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	var result []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !nativeOutputFileRegExp.MatchString(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if info.ModTime().Before(modifiedAfter) {
+			continue
+		}
+		result = append(result, "build/Release/"+entry.Name())
+	}
+	return result, nil
+}
+
+// false if entry doesn't exist
+func (t *nativeCacheEntry) restore() (bool, error) {
+	files, err := findNativeOutputFiles(t.dir, time.Time{})
+	if err != nil || len(files) == 0 {
+		return false, err
+	}
+
+	for _, file := range files {
+		err = appfs.CopyDirOrFile(filepath.Join(t.dir, filepath.FromSlash(file)), filepath.Join(t.dependency.dir, filepath.FromSlash(file)))
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// only files produced by this build are stored (build can fail for optional dependency)
+func (t *nativeCacheEntry) store(buildStartTime time.Time) error {
+	files, err := findNativeOutputFiles(t.dependency.dir, buildStartTime)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		log.Debug("native module cache is not updated, no build output", zap.String("name", t.dependency.Name), zap.String("dir", t.dependency.dir))
+		return nil
+	}
+
+	// entry is written to temp dir and renamed, so concurrent builds (shared cache on CI) never see partial entry
+	tempDir := t.dir + ".tmp-" + strconv.Itoa(os.Getpid())
+	err = fsutil.EnsureEmptyDir(tempDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, file := range files {
+		err = appfs.CopyDirOrFile(filepath.Join(t.dependency.dir, filepath.FromSlash(file)), filepath.Join(tempDir, filepath.FromSlash(file)))
+		if err != nil {
+			_ = os.RemoveAll(tempDir)
+			return err
+		}
+	}
+
+	err = os.Rename(tempDir, t.dir)
+	if err != nil {
+		_ = os.RemoveAll(tempDir)
+		if _, statError := os.Stat(t.dir); statError == nil {
+			// stored by another process
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+
+	log.Debug("native module is cached", zap.String("name", t.dependency.Name), zap.String("version", t.dependency.Version), zap.String("dir", t.dir), zap.Strings("files", files))
+	return nil
+}
+
+// restored dependencies are removed from the list, returned entries must be stored after the build
+func (t *nativeModuleCache) restore(dependencies []*DepInfo, configuration *RebuildConfiguration) ([]*DepInfo, []*nativeCacheEntry, error) {
+	var result []*DepInfo
+	var entries []*nativeCacheEntry
+	for _, dependency := range dependencies {
+		entry, err := t.entry(dependency, configuration)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		isRestored, err := entry.restore()
+		if err != nil {
+			log.Warn("cannot restore native module from cache", zap.String("name", dependency.Name), zap.String("dir", entry.dir), zap.Error(err))
+		}
+		if isRestored {
+			log.Info("native dependency restored from cache", zap.String("name", dependency.Name), zap.String("version", dependency.Version))
+			continue
+		}
+
+		result = append(result, dependency)
+		entries = append(entries, entry)
+	}
+	return result, entries, nil
+}
@@ -0,0 +1,78 @@
+package node_modules
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/develar/app-builder/pkg/log"
+	. "github.com/onsi/gomega"
+)
+
+func TestNativeModuleCache(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	tempDir := t.TempDir()
+	packageDir := filepath.Join(tempDir, "node_modules", "addon")
+	writeTestFile(g, filepath.Join(packageDir, "package.json"), `{"name": "addon", "version": "1.0.0"}`)
+	writeTestFile(g, filepath.Join(packageDir, "binding.gyp"), `{}`)
+	writeTestFile(g, filepath.Join(packageDir, "src", "addon.cc"), `int main() {}`)
+
+	dependency := &DepInfo{Name: "addon", Version: "1.0.0", dir: packageDir}
+	configuration := &RebuildConfiguration{Platform: "linux", Arch: "x64", CacheDir: filepath.Join(tempDir, "cache")}
+	environ := []string{"npm_config_target=30.0.0", "npm_config_runtime=electron", "npm_config_cache=/tmp/a", "PATH=/bin"}
+
+	key, err := computeNativeCacheKey(dependency, configuration, environ)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// build output and not relevant env don't affect key
+	writeTestFile(g, filepath.Join(packageDir, "build", "Release", "obj.target", "addon.o"), "obj")
+	g.Expect(computeNativeCacheKey(dependency, configuration, append(environ[:3:3], "npm_config_cache=/tmp/b"))).To(Equal(key))
+	g.Expect(computeNativeCacheKey(dependency, configuration, []string{"npm_config_target=31.0.0", "npm_config_runtime=electron"})).NotTo(Equal(key))
+	g.Expect(computeNativeCacheKey(dependency, &RebuildConfiguration{Platform: "linux", Arch: "arm64"}, environ)).NotTo(Equal(key))
+
+	// build inputs specified in configuration and not in env
+	g.Expect(computeNativeCacheKey(dependency, &RebuildConfiguration{Platform: "linux", Arch: "x64", ElectronVersion: "31.0.0"}, environ)).NotTo(Equal(key))
+	electronKey, err := computeNativeCacheKey(dependency, &RebuildConfiguration{Platform: "linux", Arch: "x64", ElectronVersion: "30.0.0"}, environ)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(computeNativeCacheKey(dependency, &RebuildConfiguration{Platform: "linux", Arch: "x64", ElectronVersion: "31.0.0"}, environ)).NotTo(Equal(electronKey))
+	g.Expect(computeNativeCacheKey(dependency, &RebuildConfiguration{Platform: "linux", Arch: "x64", HeadersUrl: "https://example.com/headers"}, environ)).NotTo(Equal(key))
+	g.Expect(computeNativeCacheKey(dependency, &RebuildConfiguration{Platform: "linux", Arch: "x64", UseNodeGyp: true}, environ)).NotTo(Equal(key))
+	g.Expect(computeNativeCacheKey(dependency, configuration, []string{"npm_config_target=30.0.0", "npm_config_runtime=node"})).NotTo(Equal(key))
+
+	// toolchain env is not in environ
+	withToolchain := func(toolchain *Toolchain) *RebuildConfiguration {
+		return &RebuildConfiguration{Platform: "linux", Arch: "x64", Toolchains: map[string]*Toolchain{"x64": toolchain}}
+	}
+	toolchainKey, err := computeNativeCacheKey(dependency, withToolchain(&Toolchain{Sysroot: "/opt/sysroot-a"}), environ)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(toolchainKey).NotTo(Equal(key))
+	g.Expect(computeNativeCacheKey(dependency, withToolchain(&Toolchain{Sysroot: "/opt/sysroot-a"}), environ)).To(Equal(toolchainKey))
+	g.Expect(computeNativeCacheKey(dependency, withToolchain(&Toolchain{Sysroot: "/opt/sysroot-b"}), environ)).NotTo(Equal(toolchainKey))
+	g.Expect(computeNativeCacheKey(dependency, withToolchain(&Toolchain{Sysroot: "/opt/sysroot-a", Env: map[string]string{"PKG_CONFIG_PATH": "/opt/pkgconfig"}}), environ)).NotTo(Equal(toolchainKey))
+	g.Expect(computeNativeCacheKey(dependency, withToolchain(&Toolchain{Sysroot: "/opt/sysroot-a", CC: "clang"}), environ)).NotTo(Equal(toolchainKey))
+
+	cache := newNativeModuleCache(configuration.CacheDir)
+	dependencies, entries, err := cache.restore([]*DepInfo{dependency}, configuration)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(dependencies).To(HaveLen(1))
+
+	buildStartTime := time.Now().Truncate(time.Second)
+	writeTestFile(g, filepath.Join(packageDir, "build", "Release", "addon.node"), "binary")
+	g.Expect(entries[0].store(buildStartTime)).To(Succeed())
+
+	g.Expect(os.RemoveAll(filepath.Join(packageDir, "build"))).To(Succeed())
+	dependencies, _, err = cache.restore([]*DepInfo{dependency}, configuration)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(dependencies).To(BeEmpty())
+	g.Expect(os.ReadFile(filepath.Join(packageDir, "build", "Release", "addon.node"))).To(Equal([]byte("binary")))
+	g.Expect(filepath.Join(packageDir, "build", "Release", "obj.target")).NotTo(BeADirectory())
+
+	// source is changed
+	writeTestFile(g, filepath.Join(packageDir, "src", "addon.cc"), `int main() { return 1; }`)
+	dependencies, _, err = cache.restore([]*DepInfo{dependency}, configuration)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(dependencies).To(HaveLen(1))
+}
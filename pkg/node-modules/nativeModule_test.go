@@ -0,0 +1,60 @@
+package node_modules
+
+import (
+	"path"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/log"
+	. "github.com/onsi/gomega"
+)
+
+func TestDetectNativeModules(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	nodeModuleDir := path.Join(Dirname(), "native-demo", "node_modules")
+	detect := func(platform string, arch string) map[string]*NativeModule {
+		configuration := &RebuildConfiguration{
+			Platform: platform,
+			Arch:     arch,
+			DependencyTreeInfo: []DependencyList{{Dir: nodeModuleDir, Dependencies: []DepInfo{
+				{Name: "gyp-module"}, {Name: "gyp-prebuild"}, {Name: "gyp-build-napi"}, {Name: "cmake-module"},
+				{Name: "napi-rs-module"}, {Name: "napi-rs-module-linux-x64-gnu", Optional: true}, {Name: "plain"},
+			}}},
+		}
+		dependencies, err := computeNativeDependencies(configuration)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		result := make(map[string]*NativeModule)
+		for _, dependency := range dependencies {
+			result[dependency.Name] = dependency.nativeModule
+		}
+		return result
+	}
+
+	modules := detect("linux", "x64")
+	g.Expect(modules).To(HaveLen(6))
+	g.Expect(modules).NotTo(HaveKey("plain"))
+	g.Expect(modules["gyp-module"].Kind).To(Equal(nativeKindCompile))
+	g.Expect(modules["gyp-prebuild"].Kind).To(Equal(nativeKindPrebuildInstall))
+	g.Expect(modules["gyp-build-napi"].Kind).To(Equal(nativeKindPrebuilt))
+	g.Expect(modules["gyp-build-napi"].Binaries).To(Equal([]string{"prebuilds/linux-x64/gyp-build-napi.napi.node"}))
+	g.Expect(modules["cmake-module"].Kind).To(Equal(nativeKindCompile))
+	g.Expect(modules["cmake-module"].BuildSystem).To(Equal(buildSystemCmakeJs))
+	g.Expect(modules["napi-rs-module"].Kind).To(Equal(nativeKindPrebuilt))
+	g.Expect(modules["napi-rs-module-linux-x64-gnu"].Kind).To(Equal(nativeKindPrebuilt))
+
+	// node.abi115.node is not suitable for Electron, platform package for darwin-arm64 is not installed
+	modules = detect("darwin", "arm64")
+	g.Expect(modules["gyp-build-napi"].Kind).To(Equal(nativeKindCompile))
+	g.Expect(modules["napi-rs-module"].Kind).To(Equal(nativeKindCompile))
+	g.Expect(modules["napi-rs-module"].BuildSystem).To(BeEmpty())
+
+	var dependencies []*DepInfo
+	for _, name := range []string{"gyp-module", "gyp-prebuild", "gyp-build-napi", "napi-rs-module"} {
+		dependencies = append(dependencies, &DepInfo{Name: name, nativeModule: modules[name]})
+	}
+	toRebuild := filterDependenciesToRebuild(dependencies)
+	g.Expect(toRebuild).To(HaveLen(3))
+	g.Expect(toRebuild[1].HasPrebuildInstall).To(BeTrue())
+}
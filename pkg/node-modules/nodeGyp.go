@@ -0,0 +1,273 @@
+package node_modules
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/develar/app-builder/pkg/download"
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"github.com/develar/go-fs-util"
+	"go.uber.org/zap"
+)
+
+const defaultElectronHeadersUrl = "https://electronjs.org/headers"
+
+// target of node-gyp build, the same for all modules
+type nodeGypTarget struct {
+	version string
+	runtime string
+	distUrl string
+	arch    string
+	// node-gyp expects headers in <devdir>/<version>
+	devDir string
+}
+
+func getNodeGypTarget(configuration *RebuildConfiguration) (*nodeGypTarget, error) {
+	version := configuration.ElectronVersion
+	if len(version) == 0 {
+		version = os.Getenv("npm_config_target")
+	}
+	if len(version) == 0 {
+		return nil, errors.New("electronVersion is not specified (and npm_config_target is not set)")
+	}
+
+	result := &nodeGypTarget{
+		version: strings.TrimPrefix(version, "v"),
+		runtime: util.GetEnvOrDefault("npm_config_runtime", "electron"),
+		distUrl: configuration.HeadersUrl,
+		arch:    toNodeArch(configuration.Arch),
+	}
+	if len(result.distUrl) == 0 {
+		result.distUrl = os.Getenv("npm_config_disturl")
+	}
+	if len(result.distUrl) == 0 {
+		if result.runtime == "node" {
+			result.distUrl = "https://nodejs.org/dist"
+		} else {
+			result.distUrl = defaultElectronHeadersUrl
+		}
+	}
+	result.distUrl = strings.TrimSuffix(result.distUrl, "/")
+
+	// node and electron versions can be the same, so, dir per runtime
+	devDir, err := download.GetCacheDirectoryForArtifactCustom(filepath.Join("node-gyp", result.runtime))
+	if err != nil {
+		return nil, err
+	}
+	result.devDir = devDir
+	return result, nil
+}
+
+func (t *nodeGypTarget) getHeadersUrl() string {
+	return t.distUrl + "/v" + t.version + "/" + t.getHeadersFileName()
+}
+
+func (t *nodeGypTarget) getHeadersFileName() string {
+	return "node-v" + t.version + "-headers.tar.gz"
+}
+
+// headers are downloaded once and installed into the devdir using node-gyp install --tarball
+func (t *nodeGypTarget) ensureHeaders(nodeGyp string, configuration *RebuildConfiguration) error {
+	if isFile(filepath.Join(t.devDir, t.version, "include", "node", "common.gypi")) {
+		return nil
+	}
+
+	cacheDir, err := download.GetCacheDirectoryForArtifactCustom("electron-headers")
+	if err != nil {
+		return err
+	}
+
+	headersFile := filepath.Join(cacheDir, t.runtime+"-"+t.getHeadersFileName())
+	if !isFile(headersFile) {
+		err = fsutil.EnsureDir(cacheDir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		tempFile, err := util.TempFile(cacheDir, ".tar.gz")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		url := t.getHeadersUrl()
+		err = download.NewDownloader().Download(url, tempFile, "")
+		if err != nil {
+			_ = os.Remove(tempFile)
+			return errors.WithStack(err)
+		}
+		download.RenameToFinalFile(tempFile, headersFile, log.LOG.With(zap.String("url", url), zap.String("path", headersFile)))
+	}
+
+	command := exec.Command(getNodeExec(configuration), nodeGyp, "install",
+		"--target="+t.version,
+		"--dist-url="+t.distUrl,
+		"--arch="+t.arch,
+		"--devdir="+t.devDir,
+		"--tarball="+headersFile,
+	)
+	applyToolchain(command, configuration)
+	_, err = util.Execute(command)
+	return err
+}
+
+func (t *nodeGypTarget) createBuildCommand(nodeGyp string, dependency *DepInfo, configuration *RebuildConfiguration) *exec.Cmd {
+	args := []string{
+		nodeGyp,
+		"rebuild",
+		"--target=" + t.version,
+		"--runtime=" + t.runtime,
+		"--dist-url=" + t.distUrl,
+		"--arch=" + t.arch,
+		"--devdir=" + t.devDir,
+	}
+	if log.IsDebugEnabled() {
+		args = append(args, "--verbose")
+	}
+	args = append(args, configuration.AdditionalArgs...)
+
+	command := exec.Command(getNodeExec(configuration), args...)
+	command.Dir = dependency.dir
+	applyToolchain(command, configuration)
+	return command
+}
+
+// node-gyp of the project is preferred, npm_config_node_gyp is set by npm
+func findNodeGyp(dependencies []*DepInfo) (string, error) {
+	for _, dependency := range dependencies {
+		nodeModuleDir := dependency.parentDir
+		for len(nodeModuleDir) != 0 {
+			file := filepath.Join(nodeModuleDir, "node-gyp", "bin", "node-gyp.js")
+			if isFile(file) {
+				return file, nil
+			}
+
+			var err error
+			nodeModuleDir, err = findNearestNodeModuleDir(filepath.Dir(filepath.Dir(nodeModuleDir)))
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	file := os.Getenv("npm_config_node_gyp")
+	if len(file) != 0 && isFile(file) {
+		return file, nil
+	}
+	return "", errors.New("cannot find node-gyp, add node-gyp to devDependencies")
+}
+
+// modules not built by node-gyp (cmake-js) are returned to be rebuilt using package manager
+func rebuildUsingNodeGyp(dependencies []*DepInfo, configuration *RebuildConfiguration) ([]*DepInfo, error) {
+	var nodeGypDependencies []*DepInfo
+	var otherDependencies []*DepInfo
+	for _, dependency := range dependencies {
+		if dependency.nativeModule != nil && dependency.nativeModule.BuildSystem == buildSystemNodeGyp {
+			nodeGypDependencies = append(nodeGypDependencies, dependency)
+		} else {
+			otherDependencies = append(otherDependencies, dependency)
+		}
+	}
+	if len(nodeGypDependencies) == 0 {
+		return otherDependencies, nil
+	}
+
+	target, err := getNodeGypTarget(configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeGyp, err := findNodeGyp(nodeGypDependencies)
+	if err != nil {
+		return nil, err
+	}
+
+	err = target.ensureHeaders(nodeGyp, configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	logDir := configuration.LogDir
+	if len(logDir) == 0 {
+		logDir, err = os.MkdirTemp("", "rebuild-node-modules-")
+	} else {
+		err = fsutil.EnsureDir(logDir)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	concurrency := configuration.Concurrency
+	if concurrency <= 0 {
+		concurrency = getRebuildConcurrency()
+	}
+
+	err = util.MapAsyncConcurrency(len(nodeGypDependencies), concurrency, func(index int) (func() error, error) {
+		dependency := nodeGypDependencies[index]
+		return func() error {
+			logFile := filepath.Join(logDir, strings.ReplaceAll(dependency.Name, "/", "+")+"@"+dependency.Version+".log")
+			logger := log.LOG.With(zap.String("name", dependency.Name), zap.String("version", dependency.Version), zap.String("log", logFile))
+			logger.Info("rebuilding native dependency using node-gyp")
+
+			err := executeWithLogFile(target.createBuildCommand(nodeGyp, dependency, configuration), logFile)
+			if err == nil {
+				return nil
+			}
+
+			execError, _ := err.(*util.ExecError)
+			if dependency.Optional && execError != nil {
+				logger.Warn("cannot build optional native dependency", util.CreateExecErrorLogEntry(execError)...)
+				return nil
+			}
+			return err
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return otherDependencies, nil
+}
+
+// output is not buffered in memory, tail of the log is reported on failure
+func executeWithLogFile(command *exec.Cmd, logFile string) error {
+	file, err := os.Create(logFile)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, _ = fmt.Fprintf(file, "> %s\n> cwd: %s\n\n", strings.Join(command.Args, " "), command.Dir)
+	command.Stdout = file
+	command.Stderr = file
+	if log.IsDebugEnabled() {
+		log.Debug("execute command", zap.String("command", strings.Join(command.Args, " ")), zap.String("workingDirectory", command.Dir), zap.String("log", logFile))
+	}
+	runError := command.Run()
+	err = file.Close()
+	if runError == nil {
+		return errors.WithStack(err)
+	}
+
+	return &util.ExecError{
+		Cause:            runError,
+		CommandAndArgs:   command.Args,
+		WorkingDirectory: command.Dir,
+		ErrorOutput:      readLogTail(logFile, 4096),
+		Message:          "cannot build native dependency",
+		ExtraFields:      []zap.Field{zap.String("log", logFile)},
+	}
+}
+
+func readLogTail(file string, size int64) []byte {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	if int64(len(data)) > size {
+		data = data[int64(len(data))-size:]
+	}
+	return data
+}
@@ -0,0 +1,65 @@
+package node_modules
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/log"
+	. "github.com/onsi/gomega"
+)
+
+func TestRebuildUsingNodeGyp(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script is used as node")
+	}
+
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	tempDir := t.TempDir()
+	t.Setenv("ELECTRON_BUILDER_CACHE", filepath.Join(tempDir, "cache"))
+	t.Setenv("npm_node_execpath", "")
+	t.Setenv("NODE_EXE", "")
+	t.Setenv("node", "")
+	t.Setenv("npm_config_runtime", "")
+	t.Setenv("npm_config_disturl", "")
+
+	// headers are already installed
+	writeTestFile(g, filepath.Join(tempDir, "cache", "node-gyp", "electron", "30.0.0", "include", "node", "common.gypi"), "{}")
+
+	nodeModuleDir := filepath.Join(tempDir, "node_modules")
+	writeTestFile(g, filepath.Join(nodeModuleDir, "node-gyp", "bin", "node-gyp.js"), "")
+	node := filepath.Join(tempDir, "node")
+	writeTestFile(g, node, "#!/bin/sh\necho \"$@\"\ncase \"$PWD\" in *fail*) exit 1;; esac\n")
+	g.Expect(os.Chmod(node, 0755)).To(Succeed())
+
+	var dependencies []*DepInfo
+	for _, name := range []string{"addon", "optional-fail", "cmake-addon"} {
+		buildSystem := buildSystemNodeGyp
+		if name == "cmake-addon" {
+			buildSystem = buildSystemCmakeJs
+		}
+		dir := filepath.Join(nodeModuleDir, name)
+		g.Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+		dependencies = append(dependencies, &DepInfo{Name: name, Version: "1.0.0", Optional: name == "optional-fail", parentDir: nodeModuleDir, dir: dir, nativeModule: &NativeModule{BuildSystem: buildSystem}})
+	}
+
+	logDir := filepath.Join(tempDir, "logs")
+	configuration := &RebuildConfiguration{Platform: "linux", Arch: "armv7l", ElectronVersion: "v30.0.0", NodeExecPath: node, LogDir: logDir}
+	rest, err := rebuildUsingNodeGyp(dependencies, configuration)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rest).To(HaveLen(1))
+	g.Expect(rest[0].Name).To(Equal("cmake-addon"))
+
+	data, err := os.ReadFile(filepath.Join(logDir, "addon@1.0.0.log"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(data)).To(ContainSubstring("rebuild --target=30.0.0 --runtime=electron --dist-url=https://electronjs.org/headers --arch=arm --devdir=" + filepath.Join(tempDir, "cache", "node-gyp", "electron")))
+	g.Expect(filepath.Join(logDir, "optional-fail@1.0.0.log")).To(BeARegularFile())
+
+	// required module failure is an error
+	dependencies[1].Optional = false
+	_, err = rebuildUsingNodeGyp(dependencies, configuration)
+	g.Expect(err).To(HaveOccurred())
+}
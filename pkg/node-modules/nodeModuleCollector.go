@@ -1,6 +1,7 @@
 package node_modules
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
@@ -23,26 +24,121 @@ type Dependency struct {
 	OptionalDependencies map[string]string `json:"optionalDependencies"`
 	Binary               *DependencyBinary `json:"binary"`
 
+	Os   []string `json:"os"`
+	Cpu  []string `json:"cpu"`
+	Libc []string `json:"libc"`
+
 	parent             *Dependency
 	conflictDependency map[string]*Dependency
 	dir                string
 	isOptional         int
 	alias              string
+	// key of the package in the lockfile (if dependency tree is built from lockfile)
+	lockKey string
+	// SRI from the lockfile (sha512-...), empty if not known
+	integrity string
+	// resolved dependencies and optional dependencies (parent links only one of the dependents)
+	children []*Dependency
+}
+
+// non-optional dependency that is not installed
+type UnresolvedDependency struct {
+	Name string `json:"name"`
+	Spec string `json:"spec"`
+	// name@version of the package that requires the dependency
+	RequiredBy string `json:"requiredBy"`
+	// dir of the package that requires the dependency
+	RequiredByDir string `json:"requiredByDir"`
+}
+
+// written to stdout as is in the strict mode
+type UnresolvedDependencyError struct {
+	Message      string                    `json:"error"`
+	Code         string                    `json:"errorCode"`
+	Dependencies []*UnresolvedDependency   `json:"unresolved"`
+	Incompatible []*IncompatibleDependency `json:"incompatible,omitempty"`
+}
+
+func NewUnresolvedDependencyError(dependencies []*UnresolvedDependency) *UnresolvedDependencyError {
+	return newDependencyTreeError(dependencies, nil)
+}
+
+// ERR_UNRESOLVED_DEPENDENCIES if some dependency is not installed, ERR_INCOMPATIBLE_DEPENDENCIES if all are installed, but some don't support the target platform
+func newDependencyTreeError(unresolved []*UnresolvedDependency, incompatible []*IncompatibleDependency) *UnresolvedDependencyError {
+	var messages []string
+	code := "ERR_INCOMPATIBLE_DEPENDENCIES"
+	if len(unresolved) != 0 {
+		code = "ERR_UNRESOLVED_DEPENDENCIES"
+		list := make([]string, len(unresolved))
+		for index, dependency := range unresolved {
+			list[index] = dependency.Name + " (required by " + dependency.RequiredBy + ")"
+		}
+		messages = append(messages, fmt.Sprintf("%d dependencies are not installed: %s", len(unresolved), strings.Join(list, ", ")))
+	}
+	if len(incompatible) != 0 {
+		list := make([]string, len(incompatible))
+		for index, dependency := range incompatible {
+			list[index] = dependency.Name + "@" + dependency.Version
+		}
+		messages = append(messages, fmt.Sprintf("%d dependencies don't support target platform: %s", len(incompatible), strings.Join(list, ", ")))
+	}
+	return &UnresolvedDependencyError{
+		Message:      strings.Join(messages, "; "),
+		Code:         code,
+		Dependencies: unresolved,
+		Incompatible: incompatible,
+	}
+}
+
+func (e *UnresolvedDependencyError) Error() string {
+	return e.Message
+}
+
+func (e *UnresolvedDependencyError) ErrorCode() string {
+	return e.Code
 }
 
 type Collector struct {
-	rootDependency         *Dependency
-	unresolvedDependencies map[string]bool
+	rootDependency *Dependency
+	// key is dir of the requiring package and name
+	unresolvedDependencies map[string]*UnresolvedDependency
 
 	excludedDependencies map[string]bool
 	allDependencies      []*Dependency
 	allDependenciesMap   map[string]*Dependency
 
+	// nil if node_modules is walked
+	lockfile lockfile
+	// package name to dir, loaded on demand to resolve workspace: specs
+	workspacePackages map[string]string
+
+	// nil if package.json files are read on demand (sequential walking)
+	packageCache *packageJsonCache
+
+	// nil if dependencies are not filtered by os, cpu and libc fields
+	platform                 *targetPlatform
+	incompatibleDependencies map[*Dependency]bool
+
 	NodeModuleDirToDependencyMap map[string]*map[string]*Dependency `json:"nodeModuleDirToDependencyMap"`
 
 	HoiestedDependencyMap map[string]*Dependency `json:"hoiestedDependencyMap"`
 }
 
+// sorted by requiring package dir and name
+func (t *Collector) getUnresolvedDependencies() []*UnresolvedDependency {
+	result := make([]*UnresolvedDependency, 0, len(t.unresolvedDependencies))
+	for _, dependency := range t.unresolvedDependencies {
+		result = append(result, dependency)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].RequiredByDir != result[j].RequiredByDir {
+			return result[i].RequiredByDir < result[j].RequiredByDir
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
 func (t *Collector) readDependencyTree(dependency *Dependency) error {
 	if t.rootDependency == nil {
 		t.rootDependency = dependency
@@ -62,28 +158,26 @@ func (t *Collector) readDependencyTree(dependency *Dependency) error {
 		return nil
 	}
 
-	nodeModuleDir, err := findNearestNodeModuleDir(dependency.dir)
+	nodeModuleDir, err := t.findNearestNodeModuleDir(dependency.dir)
 	if err != nil {
 		return err
 	}
 
 	if len(nodeModuleDir) == 0 {
-		for name := range dependency.Dependencies {
-			t.unresolvedDependencies[name] = true
-		}
-		return nil
+		// not installed - only workspace, link: and file: dependencies can be resolved, others will be reported as unresolved
+		nodeModuleDir = filepath.Join(dependency.dir, "node_modules")
 	}
 
 	// process direct children first
 	queue := make([]*Dependency, maxQueueSize)
 	queueIndex := 0
 
-	queueIndex, err = t.processDependencies(&dependency.Dependencies, nodeModuleDir, false, &queue, queueIndex)
+	queueIndex, err = t.processDependencies(&dependency.Dependencies, dependency, nodeModuleDir, false, &queue, queueIndex)
 	if err != nil {
 		return err
 	}
 
-	queueIndex, err = t.processDependencies(&dependency.OptionalDependencies, nodeModuleDir, true, &queue, queueIndex)
+	queueIndex, err = t.processDependencies(&dependency.OptionalDependencies, dependency, nodeModuleDir, true, &queue, queueIndex)
 	if err != nil {
 		return err
 	}
@@ -92,6 +186,8 @@ func (t *Collector) readDependencyTree(dependency *Dependency) error {
 		return nil
 	}
 
+	dependency.children = queue[:queueIndex]
+
 	// do not sort - final result will be sorted
 	for i := 0; i < queueIndex; i++ {
 		err = t.readDependencyTree(queue[i])
@@ -173,7 +269,7 @@ func (t *Collector) processHoistDependencyMap() {
 	}
 }
 
-func (t *Collector) processDependencies(list *map[string]string, nodeModuleDir string, isOptional bool, queue *[]*Dependency, queueIndex int) (int, error) {
+func (t *Collector) processDependencies(list *map[string]string, parent *Dependency, nodeModuleDir string, isOptional bool, queue *[]*Dependency, queueIndex int) (int, error) {
 	unresolved := make([]string, 0)
 
 	names := make([]string, 0, len(*list))
@@ -194,14 +290,29 @@ func (t *Collector) processDependencies(list *map[string]string, nodeModuleDir s
 			}
 		}
 
-		childDependency, err := t.resolveDependency(nodeModuleDir, name)
+		spec := (*list)[name]
+		if isLocalSpec(spec) {
+			childDependency, err := t.resolveLocalDependency(parent.dir, nodeModuleDir, name, spec)
+			if err != nil {
+				return queueIndex, err
+			}
+
+			if childDependency != nil && t.isPlatformCompatible(childDependency, isOptional) {
+				(*queue)[queueIndex] = childDependency
+				correctOptionalState(isOptional, childDependency)
+				queueIndex++
+				continue
+			}
+		}
+
+		childDependency, err := t.resolveDependency(nodeModuleDir, name, spec)
 		if err != nil {
 			return queueIndex, err
 		}
 
 		if childDependency == nil {
 			unresolved = append(unresolved, name)
-		} else {
+		} else if t.isPlatformCompatible(childDependency, isOptional) {
 			(*queue)[queueIndex] = childDependency
 			correctOptionalState(isOptional, childDependency)
 			queueIndex++
@@ -211,7 +322,7 @@ func (t *Collector) processDependencies(list *map[string]string, nodeModuleDir s
 	var err error
 	guardCount := 0
 	for len(unresolved) > 0 {
-		nodeModuleDir, err = findNearestNodeModuleDir(getParentDir(getParentDir(nodeModuleDir)))
+		nodeModuleDir, err = t.findNearestNodeModuleDir(getParentDir(getParentDir(nodeModuleDir)))
 		if err != nil {
 			return queueIndex, err
 		}
@@ -220,7 +331,12 @@ func (t *Collector) processDependencies(list *map[string]string, nodeModuleDir s
 			if !isOptional {
 				for _, name := range unresolved {
 					if len(name) != 0 {
-						t.unresolvedDependencies[name] = true
+						t.unresolvedDependencies[parent.dir+"\x00"+name] = &UnresolvedDependency{
+							Name:          name,
+							Spec:          (*list)[name],
+							RequiredBy:    parent.Name + "@" + parent.Version,
+							RequiredByDir: parent.dir,
+						}
 					}
 				}
 			}
@@ -237,7 +353,7 @@ func (t *Collector) processDependencies(list *map[string]string, nodeModuleDir s
 				continue
 			}
 
-			childDependency, err := t.resolveDependency(nodeModuleDir, name)
+			childDependency, err := t.resolveDependency(nodeModuleDir, name, (*list)[name])
 			if err != nil {
 				return queueIndex, err
 			}
@@ -245,10 +361,13 @@ func (t *Collector) processDependencies(list *map[string]string, nodeModuleDir s
 			if childDependency == nil {
 				hasUnresolved = true
 			} else {
+				unresolved[index] = ""
+				if !t.isPlatformCompatible(childDependency, isOptional) {
+					continue
+				}
 				(*queue)[queueIndex] = childDependency
 				correctOptionalState(isOptional, childDependency)
 				queueIndex++
-				unresolved[index] = ""
 			}
 		}
 
@@ -275,29 +394,31 @@ func correctOptionalState(isOptional bool, childDependency *Dependency) {
 	}
 }
 
-// nil if already handled
-func (t *Collector) resolveDependency(parentNodeModuleDir string, name string) (*Dependency, error) {
+// nil if not found or installed package is not a target of the npm: alias
+func (t *Collector) resolveDependency(parentNodeModuleDir string, name string, spec string) (*Dependency, error) {
+	aliasTarget, _ := getAliasTarget(spec)
+
 	dependencyNameToDependency := t.NodeModuleDirToDependencyMap[parentNodeModuleDir]
 	if dependencyNameToDependency != nil {
 		dependency := (*dependencyNameToDependency)[name]
 		if dependency != nil {
+			if len(aliasTarget) != 0 && dependency.Name != aliasTarget {
+				return nil, nil
+			}
 			return dependency, nil
 		}
 	}
 
 	dependencyDir := filepath.Join(parentNodeModuleDir, name)
-	info, err := os.Stat(dependencyDir)
-	if err == nil && !info.IsDir() {
-		return nil, nil
+	dependency, realDir, err := t.readPackage(dependencyDir)
+	if err != nil || dependency == nil {
+		return nil, err
 	}
 
-	dependency, err := readPackageJson(dependencyDir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		} else {
-			return nil, errors.WithStack(err)
-		}
+	if len(aliasTarget) != 0 && dependency.Name != aliasTarget {
+		// another package with the same name is installed here, the alias target must be located in the parent node_modules
+		log.Debug("installed package is not an alias target", zap.String("name", name), zap.String("spec", spec), zap.String("dir", dependencyDir))
+		return nil, nil
 	}
 
 	if name == "libui-node" {
@@ -314,10 +435,25 @@ func (t *Collector) resolveDependency(parentNodeModuleDir string, name string) (
 
 	(*dependencyNameToDependency)[name] = dependency
 	dependency.alias = name
-	dependency.dir = resolvePath(dependencyDir)
+	dependency.dir = realDir
 	return dependency, nil
 }
 
+// nil if dir doesn't exist or is not a dir
+func (t *Collector) readPackage(dir string) (*Dependency, string, error) {
+	if t.packageCache == nil {
+		return readPackageDir(dir)
+	}
+	return t.packageCache.readPackage(dir)
+}
+
+func (t *Collector) findNearestNodeModuleDir(dir string) (string, error) {
+	if t.packageCache == nil {
+		return findNearestNodeModuleDir(dir)
+	}
+	return t.packageCache.findNearestNodeModuleDir(dir)
+}
+
 func resolvePath(dir string) string {
 	// Check if the path is a symlink
 	info, err := os.Lstat(dir)
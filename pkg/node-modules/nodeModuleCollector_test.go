@@ -1,11 +1,14 @@
 package node_modules
 
 import (
+	"bytes"
 	"fmt"
 	"path"
 	"path/filepath"
 	"testing"
 
+	"github.com/develar/app-builder/pkg/log"
+	jsoniter "github.com/json-iterator/go"
 	. "github.com/onsi/gomega"
 	"github.com/samber/lo"
 )
@@ -14,7 +17,7 @@ func TestReadDependencyTreeByNpm(t *testing.T) {
 	g := NewGomegaWithT(t)
 
 	collector := &Collector{
-		unresolvedDependencies:       make(map[string]bool),
+		unresolvedDependencies:       make(map[string]*UnresolvedDependency),
 		excludedDependencies:         make(map[string]bool),
 		NodeModuleDirToDependencyMap: make(map[string]*map[string]*Dependency),
 	}
@@ -44,7 +47,7 @@ func TestReadDependencyTreeByPnpm(t *testing.T) {
 	g := NewGomegaWithT(t)
 
 	collector := &Collector{
-		unresolvedDependencies:       make(map[string]bool),
+		unresolvedDependencies:       make(map[string]*UnresolvedDependency),
 		excludedDependencies:         make(map[string]bool),
 		NodeModuleDirToDependencyMap: make(map[string]*map[string]*Dependency),
 	}
@@ -78,7 +81,7 @@ func TestReadDependencyTreeForTar(t *testing.T) {
 	g := NewGomegaWithT(t)
 
 	collector := &Collector{
-		unresolvedDependencies:       make(map[string]bool),
+		unresolvedDependencies:       make(map[string]*UnresolvedDependency),
 		excludedDependencies:         make(map[string]bool),
 		NodeModuleDirToDependencyMap: make(map[string]*map[string]*Dependency),
 	}
@@ -111,7 +114,7 @@ func TestReadDependencyTreeForYarn(t *testing.T) {
 	g := NewGomegaWithT(t)
 
 	collector := &Collector{
-		unresolvedDependencies:       make(map[string]bool),
+		unresolvedDependencies:       make(map[string]*UnresolvedDependency),
 		excludedDependencies:         make(map[string]bool),
 		NodeModuleDirToDependencyMap: make(map[string]*map[string]*Dependency),
 	}
@@ -139,7 +142,7 @@ func TestReadDependencyTreeForParse(t *testing.T) {
 	g := NewGomegaWithT(t)
 
 	collector := &Collector{
-		unresolvedDependencies:       make(map[string]bool),
+		unresolvedDependencies:       make(map[string]*UnresolvedDependency),
 		excludedDependencies:         make(map[string]bool),
 		NodeModuleDirToDependencyMap: make(map[string]*map[string]*Dependency),
 	}
@@ -166,7 +169,7 @@ func TestReadDependencyTreeForEs5(t *testing.T) {
 	g := NewGomegaWithT(t)
 
 	collector := &Collector{
-		unresolvedDependencies:       make(map[string]bool),
+		unresolvedDependencies:       make(map[string]*UnresolvedDependency),
 		excludedDependencies:         make(map[string]bool),
 		NodeModuleDirToDependencyMap: make(map[string]*map[string]*Dependency),
 	}
@@ -191,3 +194,54 @@ func TestReadDependencyTreeForEs5(t *testing.T) {
 	g.Expect(collector.HoiestedDependencyMap["d"].conflictDependency["es5-ext"].Version).To(Equal("0.10.64"))
 
 }
+
+func TestUnresolvedDependencies(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	dir := path.Join(Dirname(), "unresolved-demo")
+	collector, err := collect(&collectOptions{dir: dir})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// missing optional dependency is not reported
+	unresolved := collector.getUnresolvedDependencies()
+	g.Expect(unresolved).To(HaveLen(2))
+	g.Expect(unresolved[0].Name).To(Equal("missing"))
+	g.Expect(unresolved[0].Spec).To(Equal("^1.0.0"))
+	g.Expect(unresolved[0].RequiredBy).To(Equal("unresolved-demo@1.0.0"))
+	g.Expect(unresolved[1].Name).To(Equal("transitive-missing"))
+	g.Expect(unresolved[1].RequiredBy).To(Equal("present@1.2.0"))
+
+	unresolvedError := NewUnresolvedDependencyError(unresolved)
+	g.Expect(unresolvedError.ErrorCode()).To(Equal("ERR_UNRESOLVED_DEPENDENCIES"))
+	g.Expect(unresolvedError.Error()).To(Equal("2 dependencies are not installed: missing (required by unresolved-demo@1.0.0), transitive-missing (required by present@1.2.0)"))
+
+	buffer := &bytes.Buffer{}
+	jsonWriter := jsoniter.NewStream(jsoniter.ConfigFastest, buffer, 1024)
+	writeTreeResult(jsonWriter, collector, true)
+	g.Expect(jsonWriter.Flush()).To(Succeed())
+	var output struct {
+		Dependencies []*savedTreeEntry       `json:"dependencies"`
+		Unresolved   []*UnresolvedDependency `json:"unresolved"`
+	}
+	g.Expect(jsoniter.Unmarshal(buffer.Bytes(), &output)).To(Succeed())
+	g.Expect(output.Unresolved).To(Equal(unresolved))
+	// missing packages are not listed in the tree (there is no dir to copy)
+	g.Expect(output.Dependencies).To(HaveLen(1))
+	g.Expect(output.Dependencies[0].Name).To(Equal("present"))
+
+	// --why and --analyze report them too
+	query, err := parseWhyQuery("present")
+	g.Expect(err).NotTo(HaveOccurred())
+	buffer.Reset()
+	jsonWriter = jsoniter.NewStream(jsoniter.ConfigFastest, buffer, 1024)
+	writeWhyResult(jsonWriter, collector.why(query), collector)
+	g.Expect(jsonWriter.Flush()).To(Succeed())
+	g.Expect(buffer.String()).To(ContainSubstring(`"unresolved":[{"name":"missing","spec":"^1.0.0","requiredBy":"unresolved-demo@1.0.0"`))
+	g.Expect(buffer.String()).To(HavePrefix(`{"instances":[{"name":"present","version":"1.2.0"`))
+
+	report, err := collector.analyze()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(report.Unresolved).To(Equal(unresolved))
+	g.Expect(report.Incompatible).To(BeEmpty())
+}
@@ -0,0 +1,355 @@
+package node_modules
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/errors"
+	jsoniter "github.com/json-iterator/go"
+	"go.uber.org/zap"
+)
+
+// https://ossf.github.io/osv-schema/
+type osvAdvisory struct {
+	Id        string   `json:"id"`
+	Aliases   []string `json:"aliases"`
+	Summary   string   `json:"summary"`
+	Details   string   `json:"details"`
+	Withdrawn string   `json:"withdrawn"`
+
+	Severity         []osvSeverity       `json:"severity"`
+	Affected         []osvAffected       `json:"affected"`
+	References       []osvReference      `json:"references"`
+	DatabaseSpecific osvDatabaseSpecific `json:"database_specific"`
+}
+
+type osvSeverity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+type osvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Ranges           []osvRange          `json:"ranges"`
+	Versions         []string            `json:"versions"`
+	Severity         []osvSeverity       `json:"severity"`
+	DatabaseSpecific osvDatabaseSpecific `json:"database_specific"`
+}
+
+type osvRange struct {
+	Type   string     `json:"type"`
+	Events []osvEvent `json:"events"`
+}
+
+type osvEvent struct {
+	Introduced   string `json:"introduced"`
+	Fixed        string `json:"fixed"`
+	LastAffected string `json:"last_affected"`
+	Limit        string `json:"limit"`
+}
+
+type osvReference struct {
+	Type string `json:"type"`
+	Url  string `json:"url"`
+}
+
+type osvDatabaseSpecific struct {
+	// GitHub advisories: LOW, MODERATE, HIGH, CRITICAL
+	Severity string `json:"severity"`
+}
+
+// advisories by npm package name
+type osvDatabase struct {
+	packageToAdvisories map[string][]*osvAdvisory
+	advisoryCount       int
+}
+
+// only advisories for given packages are kept, full npm database is big
+func readOsvDatabase(path string, packageNames map[string]bool) (*osvDatabase, error) {
+	result := &osvDatabase{packageToAdvisories: make(map[string][]*osvAdvisory)}
+	add := func(name string, reader io.Reader) error {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		var advisory osvAdvisory
+		err = jsoniter.Unmarshal(data, &advisory)
+		if err != nil {
+			log.Warn("cannot parse advisory, skipped", zap.String("file", name), zap.Error(err))
+			return nil
+		}
+		if len(advisory.Withdrawn) != 0 {
+			return nil
+		}
+
+		result.advisoryCount++
+		seen := make(map[string]bool)
+		for _, affected := range advisory.Affected {
+			packageName := affected.Package.Name
+			if affected.Package.Ecosystem == "npm" && packageNames[packageName] && !seen[packageName] {
+				seen[packageName] = true
+				result.packageToAdvisories[packageName] = append(result.packageToAdvisories[packageName], &advisory)
+			}
+		}
+		return nil
+	}
+
+	if strings.HasSuffix(strings.ToLower(path), ".zip") {
+		// osv.dev provides npm/all.zip
+		reader, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		defer reader.Close()
+
+		for _, file := range reader.File {
+			if file.FileInfo().IsDir() || !strings.HasSuffix(file.Name, ".json") {
+				continue
+			}
+
+			entryReader, err := file.Open()
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			err = add(file.Name, entryReader)
+			_ = entryReader.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		err := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+				return nil
+			}
+
+			reader, err := os.Open(file)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			defer reader.Close()
+			return add(file, reader)
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if result.advisoryCount == 0 {
+		return nil, errors.New("no advisories found in " + path)
+	}
+	return result, nil
+}
+
+// nil if version is not affected
+func (t *osvAdvisory) findAffected(name string, version string) *osvAffected {
+	parsedVersion, err := semver.NewVersion(version)
+	if err != nil {
+		log.Debug("cannot parse version, not checked", zap.String("name", name), zap.String("version", version))
+		return nil
+	}
+
+	for index := range t.Affected {
+		affected := &t.Affected[index]
+		if affected.Package.Ecosystem != "npm" || affected.Package.Name != name {
+			continue
+		}
+
+		for _, affectedVersion := range affected.Versions {
+			if affectedVersion == version {
+				return affected
+			}
+		}
+
+		for _, versionRange := range affected.Ranges {
+			// npm ECOSYSTEM versions are semver
+			if (versionRange.Type == "SEMVER" || versionRange.Type == "ECOSYSTEM") && versionRange.isAffected(parsedVersion) {
+				return affected
+			}
+		}
+	}
+	return nil
+}
+
+// events are applied in version order: introduced starts affected interval, fixed (exclusive) and last_affected (inclusive) end it
+func (t *osvRange) isAffected(version *semver.Version) bool {
+	type event struct {
+		version *semver.Version
+		kind    string
+	}
+
+	var events []event
+	for _, item := range t.Events {
+		kind, value := "introduced", item.Introduced
+		switch {
+		case len(item.Fixed) != 0:
+			kind, value = "fixed", item.Fixed
+		case len(item.LastAffected) != 0:
+			kind, value = "last_affected", item.LastAffected
+		case len(item.Limit) != 0:
+			// limit is used only for git ranges
+			continue
+		}
+
+		parsed, err := semver.NewVersion(value)
+		if err != nil {
+			continue
+		}
+		events = append(events, event{version: parsed, kind: kind})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].version.LessThan(events[j].version)
+	})
+
+	isAffected := false
+	for _, item := range events {
+		switch item.kind {
+		case "introduced":
+			if item.version.GreaterThan(version) {
+				return isAffected
+			}
+			isAffected = true
+		case "fixed":
+			if item.version.GreaterThan(version) {
+				return isAffected
+			}
+			isAffected = false
+		case "last_affected":
+			if !item.version.LessThan(version) {
+				return isAffected
+			}
+			isAffected = false
+		}
+	}
+	return isAffected
+}
+
+func (t *osvAffected) getFixedVersions() []string {
+	var result []string
+	for _, versionRange := range t.Ranges {
+		for _, item := range versionRange.Events {
+			if len(item.Fixed) != 0 {
+				result = append(result, item.Fixed)
+			}
+		}
+	}
+	return result
+}
+
+// database_specific severity of GitHub advisories, otherwise computed from CVSS v3 vector, empty if unknown
+func (t *osvAdvisory) getSeverity(affected *osvAffected) (string, float64) {
+	score := -1.0
+	for _, list := range [][]osvSeverity{affected.Severity, t.Severity} {
+		for _, item := range list {
+			if score < 0 && item.Type == "CVSS_V3" {
+				score = computeCvss3Score(item.Score)
+			}
+		}
+	}
+
+	severity := affected.DatabaseSpecific.Severity
+	if len(severity) == 0 {
+		severity = t.DatabaseSpecific.Severity
+	}
+	severity = strings.ToLower(severity)
+	if severity == "medium" {
+		severity = "moderate"
+	}
+	if _, ok := severityLevels[severity]; !ok {
+		severity = ""
+	}
+
+	if len(severity) == 0 && score >= 0 {
+		switch {
+		case score >= 9:
+			severity = "critical"
+		case score >= 7:
+			severity = "high"
+		case score >= 4:
+			severity = "moderate"
+		case score > 0:
+			severity = "low"
+		}
+	}
+	return severity, math.Max(score, 0)
+}
+
+// CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H -> 9.8, -1 if vector is not valid
+func computeCvss3Score(vector string) float64 {
+	if !strings.HasPrefix(vector, "CVSS:3.") {
+		return -1
+	}
+
+	metrics := make(map[string]string)
+	for _, part := range strings.Split(vector, "/")[1:] {
+		name, value, ok := strings.Cut(part, ":")
+		if ok {
+			metrics[name] = value
+		}
+	}
+
+	isScopeChanged := metrics["S"] == "C"
+	weights := map[string]map[string]float64{
+		"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+		"AC": {"L": 0.77, "H": 0.44},
+		"PR": {"N": 0.85, "L": 0.62, "H": 0.27},
+		"UI": {"N": 0.85, "R": 0.62},
+		"C":  {"H": 0.56, "L": 0.22, "N": 0},
+		"I":  {"H": 0.56, "L": 0.22, "N": 0},
+		"A":  {"H": 0.56, "L": 0.22, "N": 0},
+	}
+	if isScopeChanged {
+		weights["PR"] = map[string]float64{"N": 0.85, "L": 0.68, "H": 0.5}
+	}
+
+	values := make(map[string]float64, len(weights))
+	for name, valueToWeight := range weights {
+		weight, ok := valueToWeight[metrics[name]]
+		if !ok {
+			return -1
+		}
+		values[name] = weight
+	}
+
+	impactSubScore := 1 - (1-values["C"])*(1-values["I"])*(1-values["A"])
+	var impact float64
+	if isScopeChanged {
+		impact = 7.52*(impactSubScore-0.029) - 3.25*math.Pow(impactSubScore-0.02, 15)
+	} else {
+		impact = 6.42 * impactSubScore
+	}
+	if impact <= 0 {
+		return 0
+	}
+
+	exploitability := 8.22 * values["AV"] * values["AC"] * values["PR"] * values["UI"]
+	if isScopeChanged {
+		return roundUpCvss(math.Min(1.08*(impact+exploitability), 10))
+	}
+	return roundUpCvss(math.Min(impact+exploitability, 10))
+}
+
+// Roundup as defined by CVSS v3.1 specification (avoids floating point artifacts)
+func roundUpCvss(value float64) float64 {
+	intValue := int64(math.Round(value * 100000))
+	if intValue%10000 == 0 {
+		return float64(intValue) / 100000
+	}
+	return float64(intValue/10000+1) / 10
+}
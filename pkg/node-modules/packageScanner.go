@@ -0,0 +1,191 @@
+package node_modules
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+)
+
+// package.json files are read concurrently before the tree is built (reads are slow on network file systems and big pnpm stores),
+// tree is built sequentially from the cache, so, result is the same as for sequential walking
+type packageJsonCache struct {
+	mutex sync.Mutex
+	// by package dir as requested
+	packages map[string]*cachedPackage
+	// by dir, nearest node_modules dir
+	nodeModuleDirs map[string]*cachedNodeModuleDir
+}
+
+type cachedPackage struct {
+	once sync.Once
+	// never modified, copy is returned
+	dependency *Dependency
+	realDir    string
+	err        error
+}
+
+type cachedNodeModuleDir struct {
+	once          sync.Once
+	nodeModuleDir string
+	err           error
+}
+
+func newPackageJsonCache() *packageJsonCache {
+	return &packageJsonCache{
+		packages:       make(map[string]*cachedPackage),
+		nodeModuleDirs: make(map[string]*cachedNodeModuleDir),
+	}
+}
+
+func getDefaultScanConcurrency() int {
+	// IO bound, so, more than CPU count
+	return max(runtime.NumCPU()*2, 8)
+}
+
+// nil if dir doesn't exist or is not a dir
+func readPackageDir(dir string) (*Dependency, string, error) {
+	info, err := os.Stat(dir)
+	if err == nil && !info.IsDir() {
+		return nil, "", nil
+	}
+
+	dependency, err := readPackageJson(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", nil
+		}
+		return nil, "", errors.WithStack(err)
+	}
+	return dependency, resolvePath(dir), nil
+}
+
+func (t *packageJsonCache) getPackage(dir string) *cachedPackage {
+	t.mutex.Lock()
+	entry := t.packages[dir]
+	if entry == nil {
+		entry = &cachedPackage{}
+		t.packages[dir] = entry
+	}
+	t.mutex.Unlock()
+
+	entry.once.Do(func() {
+		// package linked several times (pnpm) is parsed once
+		realDir := resolvePath(dir)
+		if realDir != dir {
+			realEntry := t.getPackage(realDir)
+			entry.dependency, entry.realDir, entry.err = realEntry.dependency, realEntry.realDir, realEntry.err
+			return
+		}
+		entry.dependency, entry.realDir, entry.err = readPackageDir(dir)
+	})
+	return entry
+}
+
+// caller modifies dependency (alias, dir, dependencies of libui-node), so, copy is returned
+func (t *packageJsonCache) readPackage(dir string) (*Dependency, string, error) {
+	entry := t.getPackage(dir)
+	if entry.dependency == nil || entry.err != nil {
+		return nil, "", entry.err
+	}
+
+	result := *entry.dependency
+	result.Dependencies = maps.Clone(entry.dependency.Dependencies)
+	return &result, entry.realDir, nil
+}
+
+func (t *packageJsonCache) findNearestNodeModuleDir(dir string) (string, error) {
+	t.mutex.Lock()
+	entry := t.nodeModuleDirs[dir]
+	if entry == nil {
+		entry = &cachedNodeModuleDir{}
+		t.nodeModuleDirs[dir] = entry
+	}
+	t.mutex.Unlock()
+
+	entry.once.Do(func() {
+		entry.nodeModuleDir, entry.err = findNearestNodeModuleDir(dir)
+	})
+	return entry.nodeModuleDir, entry.err
+}
+
+// level by level, the same lookup as readDependencyTree (nearest node_modules, then parent ones), errors are reported by tree building
+func (t *packageJsonCache) prefetch(root *Dependency, excludedDependencies map[string]bool, concurrency int) {
+	visited := map[string]bool{root.dir: true}
+	level := []*Dependency{root}
+	for len(level) != 0 {
+		children := make([][]*Dependency, len(level))
+		_ = util.MapAsyncConcurrency(len(level), concurrency, func(index int) (func() error, error) {
+			return func() error {
+				children[index] = t.prefetchChildren(level[index], excludedDependencies)
+				return nil
+			}, nil
+		})
+
+		var nextLevel []*Dependency
+		for _, list := range children {
+			for _, child := range list {
+				if !visited[child.dir] {
+					visited[child.dir] = true
+					nextLevel = append(nextLevel, child)
+				}
+			}
+		}
+		level = nextLevel
+	}
+}
+
+func (t *packageJsonCache) prefetchChildren(dependency *Dependency, excludedDependencies map[string]bool) []*Dependency {
+	nodeModuleDir, err := t.findNearestNodeModuleDir(dependency.dir)
+	if err != nil {
+		return nil
+	}
+	if len(nodeModuleDir) == 0 {
+		nodeModuleDir = filepath.Join(dependency.dir, "node_modules")
+	}
+
+	var result []*Dependency
+	for _, list := range []map[string]string{dependency.Dependencies, dependency.OptionalDependencies} {
+		for name, spec := range list {
+			if strings.HasPrefix(name, "@types/") || excludedDependencies[name] || isLocalSpec(spec) {
+				continue
+			}
+
+			child := t.prefetchDependency(nodeModuleDir, name, spec)
+			if child != nil {
+				result = append(result, child)
+			}
+		}
+	}
+	return result
+}
+
+func (t *packageJsonCache) prefetchDependency(nodeModuleDir string, name string, spec string) *Dependency {
+	aliasTarget, _ := getAliasTarget(spec)
+	for guardCount := 0; len(nodeModuleDir) != 0 && guardCount < 1000; guardCount++ {
+		entry := t.getPackage(filepath.Join(nodeModuleDir, name))
+		if entry.err != nil {
+			return nil
+		}
+
+		if entry.dependency != nil && (len(aliasTarget) == 0 || entry.dependency.Name == aliasTarget) {
+			return &Dependency{
+				Dependencies:         entry.dependency.Dependencies,
+				OptionalDependencies: entry.dependency.OptionalDependencies,
+				dir:                  entry.realDir,
+			}
+		}
+
+		var err error
+		nodeModuleDir, err = t.findNearestNodeModuleDir(getParentDir(getParentDir(nodeModuleDir)))
+		if err != nil {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package node_modules
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/log"
+	jsoniter "github.com/json-iterator/go"
+	. "github.com/onsi/gomega"
+)
+
+// returns tree, flatten tree and unresolved dependencies
+func writeScannedTrees(g *GomegaWithT, dir string, concurrency int) (string, string, []*UnresolvedDependency) {
+	collector, err := collect(&collectOptions{dir: dir, concurrency: concurrency})
+	g.Expect(err).NotTo(HaveOccurred())
+	if concurrency == 1 {
+		g.Expect(collector.packageCache).To(BeNil())
+	} else {
+		g.Expect(collector.packageCache).NotTo(BeNil())
+	}
+
+	tree := &bytes.Buffer{}
+	jsonWriter := jsoniter.NewStream(jsoniter.ConfigFastest, tree, 1024)
+	writeResult(jsonWriter, collector)
+	g.Expect(jsonWriter.Flush()).To(Succeed())
+
+	collector.processHoistDependencyMap()
+	flatten := &bytes.Buffer{}
+	jsonWriter = jsoniter.NewStream(jsoniter.ConfigFastest, flatten, 1024)
+	writeFlattenResult(jsonWriter, collector.HoiestedDependencyMap)
+	g.Expect(jsonWriter.Flush()).To(Succeed())
+	return tree.String(), flatten.String(), collector.getUnresolvedDependencies()
+}
+
+func expectConcurrentScanMatchesSequential(g *GomegaWithT, dir string) {
+	expectedTree, expectedFlatten, expectedUnresolved := writeScannedTrees(g, dir, 1)
+	g.Expect(expectedTree).NotTo(Equal("[]"), dir)
+
+	// several times to catch order depending on scheduling
+	for i := 0; i < 3; i++ {
+		tree, flatten, unresolved := writeScannedTrees(g, dir, 4)
+		g.Expect(tree).To(Equal(expectedTree), dir)
+		g.Expect(flatten).To(Equal(expectedFlatten), dir)
+		g.Expect(unresolved).To(Equal(expectedUnresolved), dir)
+	}
+}
+
+func TestConcurrentScanMatchesSequential(t *testing.T) {
+	log.InitLogger()
+
+	// dependencies of these fixtures are not committed, they are checked only if installed (as for TestReadDependencyTreeByNpm and so on)
+	notInstalledFixtures := map[string]bool{"npm-demo": true, "pnpm-demo": true, "yarn-demo": true, "es5-demo": true, "tar-demo": true, "parse-demo": true}
+	for _, name := range []string{"npm-demo", "pnpm-demo", "yarn-demo", "es5-demo", "tar-demo", "parse-demo", "alias-demo", "licenses-demo", "platform-demo", "sbom-demo", "stage-demo", "unresolved-demo", "workspace-demo/packages/app", "pnpm-workspace-demo/apps/app"} {
+		t.Run(name, func(t *testing.T) {
+			dir := filepath.Join(Dirname(), filepath.FromSlash(name))
+			if _, err := os.Stat(filepath.Join(dir, "node_modules")); os.IsNotExist(err) && notInstalledFixtures[name] {
+				t.Skip("dependencies are not installed, run install in " + dir)
+			}
+			expectConcurrentScanMatchesSequential(NewGomegaWithT(t), dir)
+		})
+	}
+}
+
+// pnpm layout - packages are symlinks to the store, the same package is linked several times
+func TestConcurrentScanOfSymlinkedPackages(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	dir := t.TempDir()
+	link := func(target string, file string) {
+		g.Expect(os.MkdirAll(filepath.Dir(file), 0755)).To(Succeed())
+		g.Expect(os.Symlink(target, file)).To(Succeed())
+	}
+
+	store := filepath.Join(dir, "node_modules", ".pnpm")
+	writeTestFile(g, filepath.Join(dir, "package.json"), `{"name": "app", "version": "1.0.0", "dependencies": {"a": "^1.0.0", "b": "^1.0.0"}}`)
+	writeTestFile(g, filepath.Join(store, "a@1.0.0", "node_modules", "a", "package.json"), `{"name": "a", "version": "1.0.0", "dependencies": {"shared": "^1.0.0"}}`)
+	writeTestFile(g, filepath.Join(store, "b@1.0.0", "node_modules", "b", "package.json"), `{"name": "b", "version": "1.0.0", "dependencies": {"shared": "^1.0.0"}}`)
+	writeTestFile(g, filepath.Join(store, "shared@1.0.0", "node_modules", "shared", "package.json"), `{"name": "shared", "version": "1.0.0"}`)
+	link(filepath.Join(store, "a@1.0.0", "node_modules", "a"), filepath.Join(dir, "node_modules", "a"))
+	link(filepath.Join(store, "b@1.0.0", "node_modules", "b"), filepath.Join(dir, "node_modules", "b"))
+	link(filepath.Join(store, "shared@1.0.0", "node_modules", "shared"), filepath.Join(store, "a@1.0.0", "node_modules", "shared"))
+	link(filepath.Join(store, "shared@1.0.0", "node_modules", "shared"), filepath.Join(store, "b@1.0.0", "node_modules", "shared"))
+
+	expectConcurrentScanMatchesSequential(g, dir)
+
+	// package linked several times is parsed once
+	cache := newPackageJsonCache()
+	first := cache.getPackage(filepath.Join(store, "a@1.0.0", "node_modules", "shared"))
+	second := cache.getPackage(filepath.Join(store, "b@1.0.0", "node_modules", "shared"))
+	g.Expect(first.err).NotTo(HaveOccurred())
+	g.Expect(first.dependency).NotTo(BeNil())
+	g.Expect(second.dependency).To(BeIdenticalTo(first.dependency))
+	g.Expect(second.realDir).To(Equal(resolvePath(filepath.Join(store, "shared@1.0.0", "node_modules", "shared"))))
+}
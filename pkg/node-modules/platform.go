@@ -0,0 +1,193 @@
+package node_modules
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/app-builder/pkg/util"
+	"go.uber.org/zap"
+)
+
+// required dependency that doesn't support the target platform, it is kept in the tree
+type IncompatibleDependency struct {
+	Name    string   `json:"name"`
+	Version string   `json:"version"`
+	Dir     string   `json:"dir"`
+	Os      []string `json:"os,omitempty"`
+	Cpu     []string `json:"cpu,omitempty"`
+	Libc    []string `json:"libc,omitempty"`
+
+	// node_modules dir where dependency is installed
+	nodeModuleDir string
+}
+
+// target of the build, empty field is not checked
+type targetPlatform struct {
+	platform string
+	arch     string
+	libc     string
+}
+
+func configurePlatformFlags(command *kingpin.CmdClause) func() *targetPlatform {
+	platform := command.Flag("platform", "target platform (darwin, linux, win32), optional dependencies with not matching os field are skipped").String()
+	arch := command.Flag("arch", "target arch (x64, ia32, arm64, armv7l, universal), optional dependencies with not matching cpu field are skipped").String()
+	libc := command.Flag("libc", "target libc (glibc, musl), checked only for linux").String()
+	return func() *targetPlatform {
+		if len(*platform) == 0 && len(*arch) == 0 && len(*libc) == 0 {
+			return nil
+		}
+		return &targetPlatform{
+			platform: toNodePlatform(*platform),
+			arch:     toNodeArch(*arch),
+			libc:     *libc,
+		}
+	}
+}
+
+// process.platform
+func toNodePlatform(name string) string {
+	if len(name) == 0 {
+		return name
+	}
+
+	switch util.ToOsName(name) {
+	case util.MAC:
+		return "darwin"
+	case util.WINDOWS:
+		return "win32"
+	default:
+		// freebsd and so on are kept as is
+		return strings.ToLower(name)
+	}
+}
+
+// process.arch
+func toNodeArch(name string) string {
+	switch name {
+	case "armv7l":
+		return "arm"
+	case "x86_64", "amd64":
+		return "x64"
+	case "aarch64":
+		return "arm64"
+	case "386":
+		return "ia32"
+	default:
+		return name
+	}
+}
+
+// the same as checkPlatform in npm-install-checks
+func (t *targetPlatform) isMatched(dependency *Dependency) bool {
+	if len(t.platform) != 0 && !isPlatformListMatched(dependency.Os, func(value string) bool {
+		return value == t.platform
+	}) {
+		return false
+	}
+
+	if len(t.arch) != 0 && !isPlatformListMatched(dependency.Cpu, func(value string) bool {
+		// universal mac app contains both
+		return value == t.arch || (t.arch == "universal" && (value == "x64" || value == "arm64"))
+	}) {
+		return false
+	}
+
+	if len(t.libc) != 0 && (len(t.platform) == 0 || t.platform == "linux") && !isPlatformListMatched(dependency.Libc, func(value string) bool {
+		return value == t.libc
+	}) {
+		return false
+	}
+	return true
+}
+
+// empty list matches everything, "!value" excludes
+func isPlatformListMatched(list []string, isMatched func(value string) bool) bool {
+	if len(list) == 0 {
+		return true
+	}
+
+	hasPositive := false
+	isPositiveMatched := false
+	for _, value := range list {
+		if strings.HasPrefix(value, "!") {
+			if isMatched(value[1:]) {
+				return false
+			}
+			continue
+		}
+
+		hasPositive = true
+		if isMatched(value) {
+			isPositiveMatched = true
+		}
+	}
+	return !hasPositive || isPositiveMatched
+}
+
+// not matching optional dependency is skipped, not matching required dependency is reported (but kept)
+func (t *Collector) isPlatformCompatible(dependency *Dependency, isOptional bool) bool {
+	if t.platform == nil || t.platform.isMatched(dependency) {
+		return true
+	}
+
+	// optional and not required by another dependency
+	if isOptional && dependency.isOptional != 2 {
+		log.Debug("optional dependency is skipped, platform doesn't match", zap.String("name", dependency.alias), zap.String("version", dependency.Version),
+			zap.Strings("os", dependency.Os), zap.Strings("cpu", dependency.Cpu), zap.Strings("libc", dependency.Libc))
+		t.unregisterDependency(dependency)
+		return false
+	}
+
+	if !t.incompatibleDependencies[dependency] {
+		t.incompatibleDependencies[dependency] = true
+		log.Warn("required dependency doesn't support target platform", zap.String("name", dependency.alias), zap.String("version", dependency.Version), zap.String("dir", dependency.dir),
+			zap.Strings("os", dependency.Os), zap.Strings("cpu", dependency.Cpu), zap.Strings("libc", dependency.Libc))
+	}
+	return true
+}
+
+// skipped dependency must be not listed in the node_modules dirs
+func (t *Collector) unregisterDependency(dependency *Dependency) {
+	for dir, dependencyNameToDependency := range t.NodeModuleDirToDependencyMap {
+		if (*dependencyNameToDependency)[dependency.alias] == dependency {
+			delete(*dependencyNameToDependency, dependency.alias)
+			if len(*dependencyNameToDependency) == 0 {
+				delete(t.NodeModuleDirToDependencyMap, dir)
+			}
+		}
+	}
+}
+
+// sorted by node_modules dir and name, dependency is reported for each node_modules dir where it is listed
+func (t *Collector) getIncompatibleDependencies() []*IncompatibleDependency {
+	result := make([]*IncompatibleDependency, 0)
+	if len(t.incompatibleDependencies) == 0 {
+		return result
+	}
+
+	for dir, dependencyNameToDependency := range t.NodeModuleDirToDependencyMap {
+		for name, dependency := range *dependencyNameToDependency {
+			if t.incompatibleDependencies[dependency] {
+				result = append(result, &IncompatibleDependency{
+					Name:          name,
+					Version:       dependency.Version,
+					Dir:           dependency.dir,
+					Os:            dependency.Os,
+					Cpu:           dependency.Cpu,
+					Libc:          dependency.Libc,
+					nodeModuleDir: dir,
+				})
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].nodeModuleDir != result[j].nodeModuleDir {
+			return pathSorter(strings.Split(result[i].nodeModuleDir, string(filepath.Separator)), strings.Split(result[j].nodeModuleDir, string(filepath.Separator)))
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
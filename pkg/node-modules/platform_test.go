@@ -0,0 +1,55 @@
+package node_modules
+
+import (
+	"bytes"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/log"
+	jsoniter "github.com/json-iterator/go"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+)
+
+func TestPlatformFilter(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	dir := path.Join(Dirname(), "platform-demo")
+	collectNames := func(platform *targetPlatform) []string {
+		collector, err := collect(&collectOptions{dir: dir, platform: platform})
+		g.Expect(err).NotTo(HaveOccurred())
+		return lo.FlatMap(lo.Values(collector.NodeModuleDirToDependencyMap), func(it *map[string]*Dependency, i int) []string {
+			return lo.Keys(*it)
+		})
+	}
+
+	g.Expect(collectNames(nil)).To(HaveLen(6))
+	g.Expect(collectNames(&targetPlatform{platform: "linux", arch: "x64", libc: "glibc"})).To(ConsistOf("tool", "only-mac", "@tool/linux-x64"))
+	g.Expect(collectNames(&targetPlatform{platform: "linux", arch: "x64"})).To(ConsistOf("tool", "only-mac", "@tool/linux-x64", "@tool/linux-x64-musl"))
+	g.Expect(collectNames(&targetPlatform{platform: toNodePlatform("mac"), arch: "universal"})).To(ConsistOf("tool", "only-mac", "@tool/darwin-arm64"))
+	g.Expect(collectNames(&targetPlatform{platform: toNodePlatform("windows"), arch: "x64"})).To(ConsistOf("tool", "only-mac", "@tool/win32-x64"))
+
+	collector, err := collect(&collectOptions{dir: dir, platform: &targetPlatform{platform: "win32"}})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(lo.MapToSlice(collector.incompatibleDependencies, func(it *Dependency, _ bool) string {
+		return it.Name
+	})).To(Equal([]string{"only-mac"}))
+
+	incompatible := collector.getIncompatibleDependencies()
+	g.Expect(incompatible).To(HaveLen(1))
+	g.Expect(incompatible[0].Name).To(Equal("only-mac"))
+	g.Expect(incompatible[0].Os).To(Equal([]string{"darwin"}))
+	g.Expect(incompatible[0].nodeModuleDir).To(Equal(filepath.Join(dir, "node_modules")))
+
+	treeError := newDependencyTreeError(collector.getUnresolvedDependencies(), incompatible)
+	g.Expect(treeError.ErrorCode()).To(Equal("ERR_INCOMPATIBLE_DEPENDENCIES"))
+	g.Expect(treeError.Error()).To(Equal("1 dependencies don't support target platform: only-mac@1.0.0"))
+
+	buffer := &bytes.Buffer{}
+	jsonWriter := jsoniter.NewStream(jsoniter.ConfigFastest, buffer, 1024)
+	writeTreeResult(jsonWriter, collector, true)
+	g.Expect(jsonWriter.Flush()).To(Succeed())
+	g.Expect(buffer.String()).To(HaveSuffix(`"unresolved":[],"incompatible":[{"name":"only-mac","version":"1.0.0","dir":"` + filepath.Join(dir, "node_modules", "only-mac") + `","os":["darwin"]}]}`))
+}
@@ -0,0 +1,201 @@
+#!/usr/bin/env node
+/* eslint-disable */
+"use strict";
+
+const RAW_RUNTIME_STATE =
+'{\
+  "__info": [\
+    "This file is automatically generated. Do not touch it, or risk",\
+    "your modifications being lost."\
+  ],\
+  "dependencyTreeRoots": [\
+    {\
+      "name": "pnp-demo",\
+      "reference": "workspace:."\
+    }\
+  ],\
+  "enableTopLevelFallback": true,\
+  "ignorePatternData": "(^(?:\\\\.yarn\\\\/sdks(?:\\\\/(?!\\\\.{1,2}(?:\\\\/|$))(?:(?:(?!(?:^|\\\\/)\\\\.{1,2}(?:\\\\/|$)).)*?)|$))$)",\
+  "fallbackExclusionList": [\
+    [\
+      "pnp-demo",\
+      [\
+        "workspace:."\
+      ]\
+    ]\
+  ],\
+  "fallbackPool": [],\
+  "packageRegistryData": [\
+    [\
+      null,\
+      [\
+        [\
+          null,\
+          {\
+            "packageLocation": "./",\
+            "packageDependencies": [\
+              [\
+                "react",\
+                "npm:18.2.0"\
+              ],\
+              [\
+                "remote",\
+                [\
+                  "@electron/remote",\
+                  "virtual:5a4b2c1d#npm:2.1.2"\
+                ]\
+              ],\
+              [\
+                "typescript",\
+                "patch:typescript@npm%3A5.4.5#optional!builtin<compat/typescript>::version=5.4.5&hash=5adc0c"\
+              ]\
+            ],\
+            "linkType": "SOFT"\
+          }\
+        ]\
+      ]\
+    ],\
+    [\
+      "@electron/remote",\
+      [\
+        [\
+          "npm:2.1.2",\
+          {\
+            "packageLocation": "./.yarn/cache/@electron-remote-npm-2.1.2-2ef34fd3b5-ba9e1fe71d.zip/node_modules/@electron/remote/",\
+            "packageDependencies": [\
+              [\
+                "@electron/remote",\
+                "npm:2.1.2"\
+              ]\
+            ],\
+            "linkType": "HARD"\
+          }\
+        ],\
+        [\
+          "virtual:5a4b2c1d#npm:2.1.2",\
+          {\
+            "packageLocation": "./.yarn/__virtual__/@electron-remote-virtual-5a4b2c1d/1/.yarn/cache/@electron-remote-npm-2.1.2-2ef34fd3b5-ba9e1fe71d.zip/node_modules/@electron/remote/",\
+            "packageDependencies": [\
+              [\
+                "@electron/remote",\
+                "virtual:5a4b2c1d#npm:2.1.2"\
+              ],\
+              [\
+                "@types/electron",\
+                null\
+              ],\
+              [\
+                "electron",\
+                null\
+              ]\
+            ],\
+            "packagePeers": [\
+              "@types/electron",\
+              "electron"\
+            ],\
+            "linkType": "HARD"\
+          }\
+        ]\
+      ]\
+    ],\
+    [\
+      "js-tokens",\
+      [\
+        [\
+          "npm:4.0.0",\
+          {\
+            "packageLocation": "./.yarn/cache/js-tokens-npm-4.0.0-0ac852e9e2-8a95213a5a.zip/node_modules/js-tokens/",\
+            "packageDependencies": [\
+              [\
+                "js-tokens",\
+                "npm:4.0.0"\
+              ]\
+            ],\
+            "linkType": "HARD"\
+          }\
+        ]\
+      ]\
+    ],\
+    [\
+      "loose-envify",\
+      [\
+        [\
+          "npm:1.4.0",\
+          {\
+            "packageLocation": "./.yarn/cache/loose-envify-npm-1.4.0-6307b72ccf-6517e24e0c.zip/node_modules/loose-envify/",\
+            "packageDependencies": [\
+              [\
+                "loose-envify",\
+                "npm:1.4.0"\
+              ],\
+              [\
+                "js-tokens",\
+                "npm:4.0.0"\
+              ]\
+            ],\
+            "linkType": "HARD"\
+          }\
+        ]\
+      ]\
+    ],\
+    [\
+      "pnp-demo",\
+      [\
+        [\
+          "workspace:.",\
+          {\
+            "packageLocation": "./",\
+            "packageDependencies": [\
+              [\
+                "pnp-demo",\
+                "workspace:."\
+              ],\
+              [\
+                "react",\
+                "npm:18.2.0"\
+              ],\
+              [\
+                "remote",\
+                [\
+                  "@electron/remote",\
+                  "virtual:5a4b2c1d#npm:2.1.2"\
+                ]\
+              ],\
+              [\
+                "typescript",\
+                "patch:typescript@npm%3A5.4.5#optional!builtin<compat/typescript>::version=5.4.5&hash=5adc0c"\
+              ]\
+            ],\
+            "linkType": "SOFT"\
+          }\
+        ]\
+      ]\
+    ],\
+    [\
+      "react",\
+      [\
+        [\
+          "npm:18.2.0",\
+          {\
+            "packageLocation": "./.yarn/cache/react-npm-18.2.0-1eb1cd0ee5-88e38092da.zip/node_modules/react/",\
+            "packageDependencies": [\
+              [\
+                "react",\
+                "npm:18.2.0"\
+              ],\
+              [\
+                "loose-envify",\
+                "npm:1.4.0"\
+              ]\
+            ],\
+            "linkType": "HARD"\
+          }\
+        ]\
+      ]\
+    ]\
+  ]\
+}';
+
+function $$SETUP_STATE(hydrateRuntimeState, basePath) {
+  return hydrateRuntimeState(JSON.parse(RAW_RUNTIME_STATE), {basePath: basePath || __dirname});
+}
@@ -0,0 +1,405 @@
+package node_modules
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/develar/app-builder/pkg/archive/zipx"
+	"github.com/develar/app-builder/pkg/download"
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/errors"
+	jsoniter "github.com/json-iterator/go"
+	"go.uber.org/zap"
+)
+
+// .pnp.data.json is written if pnpEnableInlining is false, otherwise data is inlined into .pnp.cjs
+var pnpFileNames = []string{".pnp.data.json", ".pnp.cjs"}
+
+// Yarn Plug'n'Play - packages are not installed into node_modules, but stored as zip files in the cache,
+// zip files of production packages are unpacked to the app-builder cache
+type pnpResolver struct {
+	// dir of .pnp.cjs
+	dir string
+	// name@reference to package
+	locatorToPackage map[string]*pnpPackage
+	unpackDir        string
+}
+
+type pnpPackage struct {
+	name      string
+	reference string
+	// absolute, can point into a zip file
+	location string
+	// name to locator, empty locator for not satisfied peer dependency
+	dependencies map[string]string
+}
+
+type pnpRawData struct {
+	PackageRegistryData [][]jsoniter.RawMessage `json:"packageRegistryData"`
+}
+
+type pnpRawPackage struct {
+	PackageLocation     string                  `json:"packageLocation"`
+	PackageDependencies [][]jsoniter.RawMessage `json:"packageDependencies"`
+}
+
+// nil if project doesn't use PnP, search stops at the root of the project (so, unrelated PnP data in a parent dir is not used)
+func findPnp(projectDir string) (*pnpResolver, error) {
+	dir := projectDir
+	guardCount := 0
+	for len(dir) != 0 {
+		for _, name := range pnpFileNames {
+			file := filepath.Join(dir, name)
+			_, err := os.Stat(file)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, errors.WithStack(err)
+			}
+
+			log.Debug("yarn PnP data found", zap.String("file", file))
+			return readPnp(file)
+		}
+
+		isRoot, err := isPnpSearchRoot(dir)
+		if err != nil || isRoot {
+			return nil, err
+		}
+
+		dir = getParentDir(dir)
+
+		guardCount++
+		if guardCount > 999 {
+			return nil, errors.New("infinite loop: " + dir)
+		}
+	}
+	return nil, nil
+}
+
+// dir with package.json that is a workspace root or has node_modules (PnP data is located in the workspace root, PnP project doesn't have node_modules)
+func isPnpSearchRoot(dir string) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.WithStack(err)
+	}
+
+	var packageJson workspacePackageJson
+	if jsoniter.Unmarshal(data, &packageJson) == nil && len(packageJson.Workspaces) != 0 {
+		return true, nil
+	}
+
+	fileInfo, err := os.Stat(filepath.Join(dir, "node_modules"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.WithStack(err)
+	}
+	return fileInfo.IsDir(), nil
+}
+
+func readPnp(file string) (*pnpResolver, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if strings.HasSuffix(file, ".cjs") {
+		data, err = extractPnpRuntimeState(data)
+		if err != nil {
+			return nil, errors.WithMessage(err, "cannot read "+file+" (set pnpEnableInlining to false to get .pnp.data.json)")
+		}
+	}
+
+	unpackDir, err := download.GetCacheDirectoryForArtifactCustom("yarn-pnp")
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := parsePnpData(data, filepath.Dir(file))
+	if err != nil {
+		return nil, errors.WithMessage(err, "cannot read "+file)
+	}
+	result.unpackDir = unpackDir
+	return result, nil
+}
+
+// const RAW_RUNTIME_STATE = '{...}'; - JSON in JS string literal
+func extractPnpRuntimeState(data []byte) ([]byte, error) {
+	text := string(data)
+	index := strings.Index(text, "RAW_RUNTIME_STATE")
+	if index < 0 {
+		return nil, errors.New("RAW_RUNTIME_STATE is not found")
+	}
+
+	text = text[index:]
+	start := strings.IndexAny(text, "'\"")
+	if start < 0 {
+		return nil, errors.New("RAW_RUNTIME_STATE is not a string")
+	}
+
+	quote := text[start]
+	var result strings.Builder
+	for i := start + 1; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == quote:
+			return []byte(result.String()), nil
+		case c == '\\' && i+1 < len(text):
+			i++
+			switch text[i] {
+			case '\n':
+				// line continuation
+			case '\r':
+				if i+1 < len(text) && text[i+1] == '\n' {
+					i++
+				}
+			case 'n':
+				result.WriteByte('\n')
+			case 't':
+				result.WriteByte('\t')
+			default:
+				result.WriteByte(text[i])
+			}
+		default:
+			result.WriteByte(c)
+		}
+	}
+	return nil, errors.New("RAW_RUNTIME_STATE is not terminated")
+}
+
+func parsePnpData(data []byte, dir string) (*pnpResolver, error) {
+	var rawData pnpRawData
+	err := jsoniter.Unmarshal(data, &rawData)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	result := &pnpResolver{dir: dir, locatorToPackage: make(map[string]*pnpPackage)}
+	for _, nameEntry := range rawData.PackageRegistryData {
+		if len(nameEntry) != 2 {
+			return nil, errors.New("unexpected package registry entry")
+		}
+
+		// top-level entry (project itself)
+		if isPnpNull(nameEntry[0]) {
+			continue
+		}
+
+		var name string
+		var references [][]jsoniter.RawMessage
+		err = jsoniter.Unmarshal(nameEntry[0], &name)
+		if err == nil {
+			err = jsoniter.Unmarshal(nameEntry[1], &references)
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		for _, referenceEntry := range references {
+			if len(referenceEntry) != 2 {
+				return nil, errors.New("unexpected package registry entry of " + name)
+			}
+
+			var reference string
+			var rawPackage pnpRawPackage
+			err = jsoniter.Unmarshal(referenceEntry[0], &reference)
+			if err == nil {
+				err = jsoniter.Unmarshal(referenceEntry[1], &rawPackage)
+			}
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			info := &pnpPackage{
+				name:         name,
+				reference:    reference,
+				location:     filepath.Join(dir, filepath.FromSlash(resolvePnpVirtualPath(rawPackage.PackageLocation))),
+				dependencies: make(map[string]string, len(rawPackage.PackageDependencies)),
+			}
+			for _, dependencyEntry := range rawPackage.PackageDependencies {
+				if len(dependencyEntry) != 2 {
+					return nil, errors.New("unexpected dependency entry of " + name)
+				}
+
+				var dependencyName string
+				err = jsoniter.Unmarshal(dependencyEntry[0], &dependencyName)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				info.dependencies[dependencyName] = toPnpLocator(dependencyName, dependencyEntry[1])
+			}
+			result.locatorToPackage[info.name+"@"+reference] = info
+		}
+	}
+	return result, nil
+}
+
+// "npm:1.0.0" or ["real-name", "npm:1.0.0"] for alias, null for not satisfied peer dependency
+func toPnpLocator(name string, raw jsoniter.RawMessage) string {
+	if isPnpNull(raw) {
+		return ""
+	}
+
+	var reference string
+	if jsoniter.Unmarshal(raw, &reference) == nil {
+		return name + "@" + reference
+	}
+
+	var alias []string
+	if jsoniter.Unmarshal(raw, &alias) == nil && len(alias) == 2 {
+		return alias[0] + "@" + alias[1]
+	}
+	return ""
+}
+
+// jsoniter unmarshals null to empty RawMessage
+func isPnpNull(raw jsoniter.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
+
+// ./.yarn/__virtual__/<hash>/<depth>/<path> -> ./.yarn/<../ * depth>/<path>
+func resolvePnpVirtualPath(location string) string {
+	index := strings.Index(location, "/__virtual__/")
+	if index < 0 {
+		return location
+	}
+
+	base := location[:index]
+	segments := strings.SplitN(location[index+len("/__virtual__/"):], "/", 3)
+	if len(segments) < 3 {
+		return location
+	}
+
+	depth, err := strconv.Atoi(segments[1])
+	if err != nil {
+		return location
+	}
+
+	result := base
+	for i := 0; i < depth; i++ {
+		result += "/.."
+	}
+	return path.Clean(result+"/"+segments[2]) + "/"
+}
+
+// empty if project dir is not a workspace of the PnP data
+func (t *pnpResolver) findWorkspaceLocator(projectDir string) string {
+	projectDir = filepath.Clean(projectDir)
+	for locator, info := range t.locatorToPackage {
+		if filepath.Clean(info.location) == projectDir && strings.HasPrefix(info.reference, "workspace:") {
+			return locator
+		}
+	}
+	return ""
+}
+
+func (t *pnpResolver) configureRoot(root *Dependency) {
+	root.lockKey = t.findWorkspaceLocator(root.dir)
+	if len(root.lockKey) == 0 {
+		log.Warn("project is not found in the yarn PnP data", zap.String("dir", root.dir))
+	}
+}
+
+func (t *pnpResolver) resolve(parent *Dependency, name string, _ string) (*Dependency, string, error) {
+	parentPackage := t.locatorToPackage[parent.lockKey]
+	if parentPackage == nil {
+		return nil, "", nil
+	}
+
+	locator := parentPackage.dependencies[name]
+	info := t.locatorToPackage[locator]
+	if info == nil {
+		return nil, "", nil
+	}
+
+	dir, isZip, err := t.getPackageDir(info)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dependency, err := readPackageJson(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// optional dependency for another platform is listed, but not fetched
+			log.Debug("package is not found", zap.String("name", info.name), zap.String("dir", dir))
+			return nil, "", nil
+		}
+		return nil, "", errors.WithStack(err)
+	}
+
+	dependency.dir = dir
+	dependency.alias = name
+	dependency.lockKey = locator
+	if len(dependency.Name) == 0 {
+		dependency.Name = info.name
+	}
+
+	var nodeModuleDir string
+	if isZip {
+		// zip contains node_modules/<name>
+		nodeModuleDir = strings.TrimSuffix(dir, string(filepath.Separator)+filepath.FromSlash(info.name))
+	} else {
+		// workspace or unplugged package - as for pnpm link:
+		nodeModuleDir = filepath.Join(parent.dir, "node_modules")
+	}
+	return dependency, nodeModuleDir, nil
+}
+
+// zip file is unpacked once, cache file name contains checksum, so, unpacked dir is never outdated
+func (t *pnpResolver) getPackageDir(info *pnpPackage) (string, bool, error) {
+	location := filepath.ToSlash(info.location)
+	zipIndex := strings.Index(location, ".zip/")
+	if zipIndex < 0 {
+		// workspace or unplugged package
+		return filepath.Clean(info.location), false, nil
+	}
+
+	zipFile := filepath.FromSlash(location[:zipIndex+len(".zip")])
+	outDir := filepath.Join(t.unpackDir, strings.TrimSuffix(filepath.Base(zipFile), ".zip"))
+	dir := filepath.Join(outDir, filepath.FromSlash(strings.TrimSuffix(location[zipIndex+len(".zip/"):], "/")))
+
+	_, err := os.Stat(outDir)
+	if err == nil {
+		return dir, true, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", false, errors.WithStack(err)
+	}
+
+	_, err = os.Stat(zipFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Debug("package zip file is not found", zap.String("name", info.name), zap.String("file", zipFile))
+			return dir, true, nil
+		}
+		return "", false, errors.WithStack(err)
+	}
+
+	// unpacked to temp dir and renamed, so, partially unpacked dir is never used
+	tempDir := outDir + ".tmp-" + strconv.Itoa(os.Getpid())
+	_ = os.RemoveAll(tempDir)
+	err = zipx.Unzip(zipFile, tempDir, nil)
+	if err != nil {
+		_ = os.RemoveAll(tempDir)
+		return "", false, errors.WithMessage(err, "cannot unpack "+zipFile)
+	}
+
+	err = os.Rename(tempDir, outDir)
+	if err != nil {
+		_ = os.RemoveAll(tempDir)
+		if _, statError := os.Stat(outDir); statError != nil {
+			return "", false, errors.WithStack(err)
+		}
+	}
+
+	log.Debug("package unpacked", zap.String("name", info.name), zap.String("file", zipFile), zap.String("dir", outDir))
+	return dir, true, nil
+}
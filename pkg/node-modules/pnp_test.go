@@ -0,0 +1,93 @@
+package node_modules
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/log"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+)
+
+func TestReadDependencyTreeForPnp(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	cacheDir := t.TempDir()
+	t.Setenv("ELECTRON_BUILDER_CACHE", cacheDir)
+
+	// PnP data is used even if --lockfile is not specified
+	collector, err := collect(&collectOptions{dir: filepath.Join(Dirname(), "pnp-demo")})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(collector.lockfile).To(BeAssignableToTypeOf(&pnpResolver{}))
+	collector.processHoistDependencyMap()
+
+	r := lo.FlatMap(lo.Values(collector.NodeModuleDirToDependencyMap), func(it *map[string]*Dependency, i int) []string {
+		return lo.Keys(*it)
+	})
+	g.Expect(r).To(ConsistOf([]string{
+		"js-tokens", "react", "remote", "loose-envify",
+	}))
+
+	remoteModule := collector.HoiestedDependencyMap["remote"]
+	g.Expect(remoteModule.Name).To(Equal("@electron/remote"))
+	g.Expect(remoteModule.dir).To(Equal(filepath.Join(cacheDir, "yarn-pnp", "@electron-remote-npm-2.1.2-2ef34fd3b5-ba9e1fe71d", "node_modules", "@electron", "remote")))
+	g.Expect(filepath.Join(remoteModule.dir, "index.js")).To(BeARegularFile())
+
+	jsTokens := collector.HoiestedDependencyMap["js-tokens"]
+	g.Expect(jsTokens.Version).To(Equal("4.0.0"))
+	g.Expect(filepath.Join(jsTokens.dir, "package.json")).To(BeARegularFile())
+}
+
+func TestResolvePnpVirtualPath(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(resolvePnpVirtualPath("./.yarn/cache/a.zip/node_modules/a/")).To(Equal("./.yarn/cache/a.zip/node_modules/a/"))
+	g.Expect(resolvePnpVirtualPath("./.yarn/__virtual__/a-virtual-1/0/cache/a.zip/node_modules/a/")).To(Equal(".yarn/cache/a.zip/node_modules/a/"))
+	g.Expect(resolvePnpVirtualPath("./.yarn/__virtual__/a-virtual-1/3/.yarn/berry/cache/a.zip/node_modules/a/")).To(Equal("../../.yarn/berry/cache/a.zip/node_modules/a/"))
+}
+
+func TestFindPnp(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+	t.Setenv("ELECTRON_BUILDER_CACHE", t.TempDir())
+
+	pnpData, err := os.ReadFile(filepath.Join(Dirname(), "pnp-demo", ".pnp.cjs"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	dir := t.TempDir()
+	writeTestFile(g, filepath.Join(dir, ".pnp.cjs"), string(pnpData))
+	writeTestFile(g, filepath.Join(dir, "package.json"), `{"name": "pnp-demo", "version": "1.0.0"}`)
+
+	// project with node_modules
+	writeTestFile(g, filepath.Join(dir, "app", "package.json"), `{"name": "app", "version": "1.0.0", "dependencies": {"a": "^1.0.0"}}`)
+	writeTestFile(g, filepath.Join(dir, "app", "node_modules", "a", "package.json"), `{"name": "a", "version": "1.0.0"}`)
+	g.Expect(findPnp(filepath.Join(dir, "app"))).To(BeNil())
+
+	// workspace root
+	writeTestFile(g, filepath.Join(dir, "monorepo", "package.json"), `{"name": "monorepo", "private": true, "workspaces": ["packages/*"]}`)
+	writeTestFile(g, filepath.Join(dir, "monorepo", "packages", "a", "package.json"), `{"name": "a", "version": "1.0.0"}`)
+	g.Expect(findPnp(filepath.Join(dir, "monorepo", "packages", "a"))).To(BeNil())
+
+	// PnP data is found, but project is not a workspace of it
+	writeTestFile(g, filepath.Join(dir, "other", "package.json"), `{"name": "other", "version": "1.0.0"}`)
+	pnp, err := findPnp(filepath.Join(dir, "other"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pnp).NotTo(BeNil())
+	g.Expect(pnp.findWorkspaceLocator(filepath.Join(dir, "other"))).To(BeEmpty())
+	g.Expect(pnp.findWorkspaceLocator(dir)).To(Equal("pnp-demo@workspace:."))
+
+	collector, err := collect(&collectOptions{dir: filepath.Join(dir, "other")})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(collector.lockfile).To(BeNil())
+
+	// not readable PnP data is ignored as a not readable lockfile
+	writeTestFile(g, filepath.Join(dir, "broken", ".pnp.cjs"), "module.exports = {}")
+	writeTestFile(g, filepath.Join(dir, "broken", "package.json"), `{"name": "broken", "version": "1.0.0", "dependencies": {"a": "^1.0.0"}}`)
+	writeTestFile(g, filepath.Join(dir, "broken", "node_modules", "a", "package.json"), `{"name": "a", "version": "1.0.0"}`)
+	collector, err = collect(&collectOptions{dir: filepath.Join(dir, "broken")})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(collector.lockfile).To(BeNil())
+	g.Expect(lo.Keys(*collector.NodeModuleDirToDependencyMap[filepath.Join(dir, "broken", "node_modules")])).To(Equal([]string{"a"}))
+}
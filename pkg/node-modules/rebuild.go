@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/alecthomas/kingpin"
 	"github.com/develar/app-builder/pkg/log"
@@ -28,6 +29,21 @@ type RebuildConfiguration struct {
 	NodeExecPath string `json:"nodeExecPath"`
 
 	AdditionalArgs []string `json:"additionalArgs"`
+
+	// compiled modules are cached in this dir, cache is not used if not specified
+	CacheDir string `json:"cacheDir"`
+
+	// node-gyp is executed directly for each module instead of npm rebuild or yarn run install
+	UseNodeGyp      bool   `json:"useNodeGyp"`
+	ElectronVersion string `json:"electronVersion"`
+	// npm_config_disturl or https://electronjs.org/headers if not specified
+	HeadersUrl string `json:"headersUrl"`
+	// dir for node-gyp logs (file per module), temp dir if not specified
+	LogDir      string `json:"logDir"`
+	Concurrency int    `json:"concurrency"`
+
+	// key is the target arch
+	Toolchains map[string]*Toolchain `json:"toolchains"`
 }
 
 type DependencyList struct {
@@ -44,10 +60,17 @@ type DepInfo struct {
 
 	parentDir string
 	dir string
+
+	nativeModule *NativeModule
 }
 
 func ConfigureRebuildCommand(app *kingpin.Application) {
 	command := app.Command("rebuild-node-modules", "")
+	cacheDir := command.Flag("cache-dir", "dir to cache compiled native modules (can be shared between builds on CI), overrides cacheDir of configuration").String()
+	isUseNodeGyp := command.Flag("node-gyp", "execute node-gyp directly for each module (Electron headers are downloaded once into the cache)").Bool()
+	logDir := command.Flag("log-dir", "dir for node-gyp logs, file per module").String()
+	concurrency := command.Flag("concurrency", "max number of modules built in parallel").Int()
+	isReport := command.Flag("report", "print detected native modules and how each will be handled (prebuilt, prebuild-install, compile) as JSON, nothing is rebuilt").Bool()
 	command.Action(func(context *kingpin.ParseContext) error {
 		var configuration RebuildConfiguration
 		err := jsoniter.NewDecoder(os.Stdin).Decode(&configuration)
@@ -55,6 +78,27 @@ func ConfigureRebuildCommand(app *kingpin.Application) {
 			return err
 		}
 
+		if len(*cacheDir) != 0 {
+			configuration.CacheDir = *cacheDir
+		}
+		if *isUseNodeGyp {
+			configuration.UseNodeGyp = true
+		}
+		if len(*logDir) != 0 {
+			configuration.LogDir = *logDir
+		}
+		if *concurrency > 0 {
+			configuration.Concurrency = *concurrency
+		}
+
+		if *isReport {
+			dependencies, err := computeNativeDependencies(&configuration)
+			if err != nil {
+				return err
+			}
+			return writeNativeModuleReport(dependencies, &configuration)
+		}
+
 		err = rebuild(&configuration)
 		if err != nil {
 			return err
@@ -69,8 +113,9 @@ func rebuild(configuration *RebuildConfiguration) error {
 		return err
 	}
 
+	dependencies = filterDependenciesToRebuild(dependencies)
 	if len(dependencies) == 0 {
-		log.Debug("no native dependencies")
+		log.Debug("no native dependencies to rebuild")
 		return nil
 	}
 
@@ -90,28 +135,86 @@ func rebuild(configuration *RebuildConfiguration) error {
 		zap.String("arch", configuration.Arch),
 	)
 
-	dependencies, err = installUsingPrebuild(dependencies, configuration)
+	// list is modified by installUsingPrebuild
+	rebuiltDependencies := append([]*DepInfo(nil), dependencies...)
+	cacheEntries, buildStartTime, err := buildNativeDependencies(dependencies, configuration)
 	if err != nil {
 		return err
 	}
 
+	// wrong binary must be not cached, nothing is built if build start time is not set (prebuilt or restored from cache)
+	if !buildStartTime.IsZero() {
+		err = checkNativeBinaries(rebuiltDependencies, configuration, buildStartTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range cacheEntries {
+		err = entry.store(buildStartTime)
+		if err != nil {
+			log.Warn("cannot store native module in cache", zap.String("name", entry.dependency.Name), zap.String("dir", entry.dir), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// returned cache entries must be stored after the check of build result
+func buildNativeDependencies(dependencies []*DepInfo, configuration *RebuildConfiguration) ([]*nativeCacheEntry, time.Time, error) {
+	dependencies, err := installUsingPrebuild(dependencies, configuration)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
 	if len(dependencies) == 0 {
 		log.Debug("all native deps were installed using prebuild-install")
-		return nil
+		return nil, time.Time{}, nil
+	}
+
+	var cacheEntries []*nativeCacheEntry
+	if cache := newNativeModuleCache(configuration.CacheDir); cache != nil {
+		dependencies, cacheEntries, err = cache.restore(dependencies, configuration)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if len(dependencies) == 0 {
+			log.Debug("all native deps were restored from cache")
+			return nil, time.Time{}, nil
+		}
+	}
+
+	// file time resolution can be 1 second
+	buildStartTime := time.Now().Truncate(time.Second)
+
+	if configuration.UseNodeGyp {
+		dependencies, err = rebuildUsingNodeGyp(dependencies, configuration)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
 	}
 
-	execPath, execArgs, isRunningYarn, err := computeExecPath(configuration)
+	if len(dependencies) != 0 {
+		err = rebuildUsingPackageManager(dependencies, configuration)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	return cacheEntries, buildStartTime, nil
+}
+
+func rebuildUsingPackageManager(dependencies []*DepInfo, configuration *RebuildConfiguration) error {
+	execPath, execArgs, packageManager, err := computeExecPath(configuration)
 	if err != nil {
 		return fmt.Errorf("Could not compute exec path: %w", err)
 	}
-	if isRunningYarn {
-		err := rebuildUsingYarn(dependencies, execPath, execArgs, configuration)
+	if packageManager == packageManagerYarn || packageManager == packageManagerBun {
+		err := rebuildUsingInstallScript(dependencies, execPath, execArgs, configuration)
 		if err != nil {
 			return err
 		}
 	} else {
 		execArgs = append(execArgs, "rebuild")
-		if log.IsDebugEnabled() {
+		if log.IsDebugEnabled() && packageManager == packageManagerNpm {
 			execArgs = append(execArgs, "--verbose")
 		}
 		if configuration.AdditionalArgs != nil {
@@ -119,20 +222,52 @@ func rebuild(configuration *RebuildConfiguration) error {
 		}
 
 		for _, item := range dependencies {
-			execArgs = append(execArgs, item.Name+"@"+item.Version)
+			// pnpm rebuild accepts only names
+			if packageManager == packageManagerPnpm {
+				execArgs = append(execArgs, item.Name)
+			} else {
+				execArgs = append(execArgs, item.Name+"@"+item.Version)
+			}
 		}
 
 		command := exec.Command(execPath, execArgs...)
+		applyToolchain(command, configuration)
 		_, err := util.Execute(command)
 		if err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
-func rebuildUsingYarn(dependencies []*DepInfo, execPath string, execArgs []string, configuration *RebuildConfiguration) error {
+// prebuilt modules are skipped, modules that cannot be built are reported
+func filterDependenciesToRebuild(dependencies []*DepInfo) []*DepInfo {
+	result := make([]*DepInfo, 0, len(dependencies))
+	for _, dependency := range dependencies {
+		nativeModule := dependency.nativeModule
+		logger := log.LOG.With(zap.String("name", dependency.Name), zap.String("version", dependency.Version), zap.String("reason", nativeModule.Reason))
+		switch {
+		case nativeModule.Kind == nativeKindPrebuilt:
+			logger.Debug("native dependency is already prebuilt", zap.Strings("binaries", nativeModule.Binaries))
+			continue
+
+		case nativeModule.Kind == nativeKindCompile && len(nativeModule.BuildSystem) == 0:
+			if dependency.Optional {
+				logger.Warn("optional native dependency has no binary for target platform and cannot be built from sources")
+			} else {
+				logger.Warn("native dependency has no binary for target platform and cannot be built from sources")
+			}
+			continue
+		}
+
+		dependency.HasPrebuildInstall = nativeModule.Kind == nativeKindPrebuildInstall
+		result = append(result, dependency)
+	}
+	return result
+}
+
+// install script is executed in the package dir (yarn and bun don't have rebuild command)
+func rebuildUsingInstallScript(dependencies []*DepInfo, execPath string, execArgs []string, configuration *RebuildConfiguration) error {
 	execArgs = append(execArgs, "run", "install")
 	if configuration.AdditionalArgs != nil {
 		execArgs = append(execArgs, configuration.AdditionalArgs...)
@@ -150,6 +285,7 @@ func rebuildUsingYarn(dependencies []*DepInfo, execPath string, execArgs []strin
 
 			command := exec.Command(execPath, execArgs...)
 			command.Dir = dependency.dir
+			applyToolchain(command, configuration)
 			_, err := util.Execute(command)
 			if err != nil {
 				if dependency.Optional {
@@ -283,6 +419,7 @@ func createPrebuildInstallCommand(bin string, extraFlag string, dependency *DepI
 	}
 	command := exec.Command(getNodeExec(configuration), args...)
 	command.Dir = dependency.dir
+	applyToolchain(command, configuration)
 	return command
 }
 
@@ -295,7 +432,7 @@ func checkRebuildPossible(configuration *RebuildConfiguration) bool {
 	case currentOs == util.MAC:
 		return nodePlatform == "darwin"
 	default:
-		return nodePlatform != "win32" && nodePlatform != "darwin"
+		return nodePlatform != "win32" && nodePlatform != "darwin" && isCrossCompileConfigured(configuration)
 	}
 }
 
@@ -304,7 +441,7 @@ func computeNativeDependencies(configuration *RebuildConfiguration) ([]*DepInfo,
 	err := util.MapAsync(len(configuration.DependencyTreeInfo), func(index int) (func() error, error) {
 		dirInfo := configuration.DependencyTreeInfo[index]
 		return func() error {
-			nativeDependencies, err := computeNativeDependenciesFromNameList(&dirInfo)
+			nativeDependencies, err := computeNativeDependenciesFromNameList(&dirInfo, configuration)
 			if err != nil {
 				return err
 			}
@@ -328,18 +465,22 @@ func computeNativeDependencies(configuration *RebuildConfiguration) ([]*DepInfo,
 	return nativeDependencies, nil
 }
 
-func computeNativeDependenciesFromNameList(dirInfo *DependencyList) ([]*DepInfo, error) {
+func computeNativeDependenciesFromNameList(dirInfo *DependencyList, configuration *RebuildConfiguration) ([]*DepInfo, error) {
 	result := make([]*DepInfo, len(dirInfo.Dependencies))
 	err := util.MapAsync(len(dirInfo.Dependencies), func(index int) (func() error, error) {
 		item := dirInfo.Dependencies[index]
 		item.parentDir = dirInfo.Dir
 		item.dir = filepath.Join(dirInfo.Dir, item.Name)
 		return func() error {
-			info, err := os.Stat(filepath.Join(item.dir, "binding.gyp"))
-			if err != nil || info.IsDir() {
+			nativeModule, err := detectNativeModule(&item, configuration)
+			if err != nil {
+				return err
+			}
+			if nativeModule == nil {
 				return nil
 			}
 
+			item.nativeModule = nativeModule
 			result[index] = &item
 			return nil
 		}, nil
@@ -358,44 +499,37 @@ func computeNativeDependenciesFromNameList(dirInfo *DependencyList) ([]*DepInfo,
 	return nativeDependencies, nil
 }
 
-func computeExecPath(configuration *RebuildConfiguration) (string, []string, bool, error) {
+type packageManager string
+
+const (
+	packageManagerNpm  packageManager = "npm"
+	packageManagerYarn packageManager = "yarn"
+	packageManagerPnpm packageManager = "pnpm"
+	packageManagerBun  packageManager = "bun"
+)
+
+func computeExecPath(configuration *RebuildConfiguration) (string, []string, packageManager, error) {
 	//noinspection SpellCheckingInspection
 	execPath := os.Getenv("npm_execpath")
 	if execPath == "" {
 		execPath = os.Getenv("NPM_CLI_JS")
 	}
 
-	forceYarn := util.IsEnvTrue("FORCE_YARN")
-
-	isRunningYarn := false
-	if forceYarn {
-		isRunningYarn = true
-	} else {
-		if execPath != "" && strings.HasPrefix(filepath.Base(execPath), "yarn") {
-			isRunningYarn = true
-		} else {
-			isRunningYarn = strings.Contains(os.Getenv("npm_config_user_agent"), "yarn")
-		}
-	}
+	manager := detectPackageManager(execPath, os.Getenv("npm_config_user_agent"))
 
 	var execArgs []string
 
 	if execPath == "" {
 		suffix := ""
-		if util.GetCurrentOs() == util.WINDOWS {
+		if util.GetCurrentOs() == util.WINDOWS && manager != packageManagerBun {
 			suffix = ".cmd"
 		}
-
-		if isRunningYarn {
-			execPath = "yarn" + suffix
-		} else {
-			execPath = "npm" + suffix
-		}
+		execPath = string(manager) + suffix
 	} else {
 		// Wrap with `node` interpreter if needed
 		isJs, err := isJavascriptFile(execPath)
 		if err != nil {
-			return execPath, execArgs, isRunningYarn, err
+			return execPath, execArgs, manager, err
 		}
 		if isJs {
 			execArgs = append(execArgs, execPath)
@@ -403,7 +537,33 @@ func computeExecPath(configuration *RebuildConfiguration) (string, []string, boo
 		}
 	}
 
-	return execPath, execArgs, isRunningYarn, nil
+	return execPath, execArgs, manager, nil
+}
+
+// by exec path name (yarn.js, pnpm.cjs, bun) or by user agent (pnpm/8.15.0 npm/? node/v20.11.0 darwin arm64)
+func detectPackageManager(execPath string, userAgent string) packageManager {
+	if util.IsEnvTrue("FORCE_YARN") {
+		return packageManagerYarn
+	}
+
+	if execPath != "" {
+		name := strings.ToLower(filepath.Base(execPath))
+		for _, manager := range []packageManager{packageManagerYarn, packageManagerPnpm, packageManagerBun} {
+			if strings.HasPrefix(name, string(manager)) {
+				return manager
+			}
+		}
+	}
+
+	for _, manager := range []packageManager{packageManagerPnpm, packageManagerBun} {
+		if strings.HasPrefix(userAgent, string(manager)+"/") {
+			return manager
+		}
+	}
+	if strings.Contains(userAgent, "yarn") {
+		return packageManagerYarn
+	}
+	return packageManagerNpm
 }
 
 func getNodeExec(configuration *RebuildConfiguration) string {
@@ -468,7 +628,8 @@ func isJavascriptFile(path string) (bool, error) {
 
 	// Check if this is a '.js' file, in which case
 	// we should return `true` without further considerations
-	if strings.HasSuffix(strings.ToLower(path), ".js") {
+	lowerCasePath := strings.ToLower(path)
+	if strings.HasSuffix(lowerCasePath, ".js") || strings.HasSuffix(lowerCasePath, ".cjs") || strings.HasSuffix(lowerCasePath, ".mjs") {
 		return true, nil
 	}
 
@@ -0,0 +1,143 @@
+package node_modules
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/develar/app-builder/pkg/log"
+	. "github.com/onsi/gomega"
+)
+
+func TestDetectPackageManager(t *testing.T) {
+	g := NewGomegaWithT(t)
+	t.Setenv("FORCE_YARN", "false")
+
+	g.Expect(detectPackageManager("", "")).To(Equal(packageManagerNpm))
+	g.Expect(detectPackageManager("/usr/lib/node_modules/npm/bin/npm-cli.js", "npm/10.2.4 node/v20.11.0 darwin arm64 workspaces/false")).To(Equal(packageManagerNpm))
+	g.Expect(detectPackageManager("/usr/lib/node_modules/yarn/bin/yarn.js", "")).To(Equal(packageManagerYarn))
+	g.Expect(detectPackageManager("", "yarn/1.22.19 npm/? node/v20.11.0 darwin arm64")).To(Equal(packageManagerYarn))
+	g.Expect(detectPackageManager("/usr/lib/node_modules/pnpm/bin/pnpm.cjs", "")).To(Equal(packageManagerPnpm))
+	g.Expect(detectPackageManager("", "pnpm/8.15.0 npm/? node/v20.11.0 darwin arm64")).To(Equal(packageManagerPnpm))
+	g.Expect(detectPackageManager("/home/user/.bun/bin/bun", "")).To(Equal(packageManagerBun))
+	g.Expect(detectPackageManager("", "bun/1.0.25 npm/? node/v21.6.0 linux x64")).To(Equal(packageManagerBun))
+
+	t.Setenv("FORCE_YARN", "true")
+	g.Expect(detectPackageManager("/home/user/.bun/bin/bun", "")).To(Equal(packageManagerYarn))
+}
+
+func TestRebuildUsingPackageManager(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script is used as package manager")
+	}
+
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	tempDir := t.TempDir()
+	logFile := filepath.Join(tempDir, "calls.log")
+	t.Setenv("FORCE_YARN", "false")
+	t.Setenv("npm_config_user_agent", "")
+	t.Setenv("REBUILD_LOG", logFile)
+
+	nodeModuleDir := filepath.Join(tempDir, "node_modules")
+	var dependencies []*DepInfo
+	for _, name := range []string{"addon", "@scope/other"} {
+		dir := filepath.Join(nodeModuleDir, filepath.FromSlash(name))
+		g.Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+		dependencies = append(dependencies, &DepInfo{Name: name, Version: "1.0.0", parentDir: nodeModuleDir, dir: dir})
+	}
+
+	rebuildUsing := func(execName string) []string {
+		execPath := filepath.Join(tempDir, "bin", execName)
+		g.Expect(os.MkdirAll(filepath.Dir(execPath), 0755)).To(Succeed())
+		g.Expect(os.WriteFile(execPath, []byte("#!/bin/sh\necho \"$(basename \"$PWD\") $*\" >> \"$REBUILD_LOG\"\n"), 0755)).To(Succeed())
+		t.Setenv("npm_execpath", execPath)
+		_ = os.Remove(logFile)
+
+		g.Expect(rebuildUsingPackageManager(dependencies, &RebuildConfiguration{})).To(Succeed())
+		data, err := os.ReadFile(logFile)
+		g.Expect(err).NotTo(HaveOccurred())
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	// npm and pnpm are executed in the current dir
+	workingDir, err := os.Getwd()
+	g.Expect(err).NotTo(HaveOccurred())
+	currentDirName := filepath.Base(workingDir)
+	g.Expect(rebuildUsing("npm")).To(Equal([]string{currentDirName + " rebuild addon@1.0.0 @scope/other@1.0.0"}))
+	g.Expect(rebuildUsing("pnpm")).To(Equal([]string{currentDirName + " rebuild addon @scope/other"}))
+	g.Expect(rebuildUsing("yarn")).To(ConsistOf("addon run install", "other run install"))
+	g.Expect(rebuildUsing("bun")).To(ConsistOf("addon run install", "other run install"))
+}
+
+func TestToolchainEnv(t *testing.T) {
+	g := NewGomegaWithT(t)
+	t.Setenv("CFLAGS", "-O2")
+	t.Setenv("CXXFLAGS", "")
+	t.Setenv("LDFLAGS", "")
+
+	configuration := &RebuildConfiguration{Platform: "linux", Arch: "armv7l", Toolchains: map[string]*Toolchain{
+		"arm": {CC: "arm-linux-gnueabihf-gcc", CXX: "arm-linux-gnueabihf-g++", Sysroot: "/opt/sysroot", Env: map[string]string{"PKG_CONFIG_PATH": "/opt/sysroot/usr/lib/pkgconfig"}},
+	}}
+	g.Expect(configuration.getToolchainEnv()).To(Equal([]string{
+		"CC=arm-linux-gnueabihf-gcc",
+		"CXX=arm-linux-gnueabihf-g++",
+		"CFLAGS=-O2 --sysroot=/opt/sysroot",
+		"CXXFLAGS=--sysroot=/opt/sysroot",
+		"LDFLAGS=--sysroot=/opt/sysroot",
+		"npm_config_arch=arm",
+		"npm_config_target_arch=arm",
+		"PKG_CONFIG_PATH=/opt/sysroot/usr/lib/pkgconfig",
+	}))
+	g.Expect((&RebuildConfiguration{Arch: "arm64"}).getToolchainEnv()).To(BeNil())
+}
+
+func TestCheckNativeBinaries(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("test executable is used as ELF binary")
+	}
+
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	executable, err := os.Executable()
+	g.Expect(err).NotTo(HaveOccurred())
+	data, err := os.ReadFile(executable)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	dir := filepath.Join(t.TempDir(), "addon")
+	writeBinary := func(file string, modTime time.Time) {
+		file = filepath.Join(dir, filepath.FromSlash(file))
+		g.Expect(os.MkdirAll(filepath.Dir(file), 0755)).To(Succeed())
+		g.Expect(os.WriteFile(file, data, 0644)).To(Succeed())
+		g.Expect(os.Chtimes(file, modTime, modTime)).To(Succeed())
+	}
+	buildStartTime := time.Now().Truncate(time.Second)
+	writeBinary("build/Release/addon.node", buildStartTime)
+	// shipped prebuilt binaries and output of previous host build are not checked
+	writeBinary("lib/binding/napi-v6-linux-glibc-x64/addon.node", buildStartTime)
+	writeBinary("build/Release/old.node", buildStartTime.Add(-time.Hour))
+	dependencies := []*DepInfo{{Name: "addon", Version: "1.0.0", dir: dir}}
+
+	hostArch := toNodeArch(runtime.GOARCH)
+	otherArch := "arm64"
+	if hostArch == otherArch {
+		otherArch = "x64"
+	}
+
+	g.Expect(checkNativeBinaries(dependencies, &RebuildConfiguration{Platform: "linux", Arch: hostArch}, buildStartTime)).To(Succeed())
+	g.Expect(checkNativeBinaries(dependencies, &RebuildConfiguration{Platform: "darwin", Arch: otherArch}, buildStartTime)).To(Succeed())
+
+	err = checkNativeBinaries(dependencies, &RebuildConfiguration{Platform: "linux", Arch: otherArch}, buildStartTime)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("addon@1.0.0: build/Release/addon.node"))
+	g.Expect(err.Error()).NotTo(ContainSubstring("old.node"))
+	g.Expect(err.Error()).NotTo(ContainSubstring("lib/binding"))
+
+	dependencies[0].Optional = true
+	g.Expect(checkNativeBinaries(dependencies, &RebuildConfiguration{Platform: "linux", Arch: otherArch}, buildStartTime)).To(Succeed())
+}
@@ -0,0 +1,458 @@
+package node_modules
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/develar/app-builder/pkg/electron"
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// package of the SBOM, one per name@version
+type sbomPackage struct {
+	name       string
+	version    string
+	dir        string
+	license    string
+	repository string
+	integrity  string
+	purl       string
+	// CycloneDX component type
+	componentType string
+
+	dependsOn map[string]bool
+}
+
+type sbom struct {
+	root     *sbomPackage
+	packages []*sbomPackage
+	created  time.Time
+}
+
+type CycloneDxBom struct {
+	BomFormat    string                `json:"bomFormat"`
+	SpecVersion  string                `json:"specVersion"`
+	SerialNumber string                `json:"serialNumber"`
+	Version      int                   `json:"version"`
+	Metadata     CycloneDxMetadata     `json:"metadata"`
+	Components   []*CycloneDxComponent `json:"components"`
+	Dependencies []CycloneDxDependency `json:"dependencies"`
+}
+
+type CycloneDxMetadata struct {
+	Timestamp string `json:"timestamp"`
+	Tools     struct {
+		Components []*CycloneDxComponent `json:"components"`
+	} `json:"tools"`
+	Component *CycloneDxComponent `json:"component"`
+}
+
+type CycloneDxComponent struct {
+	Type               string               `json:"type"`
+	BomRef             string               `json:"bom-ref,omitempty"`
+	Group              string               `json:"group,omitempty"`
+	Name               string               `json:"name"`
+	Version            string               `json:"version,omitempty"`
+	Purl               string               `json:"purl,omitempty"`
+	Licenses           []CycloneDxLicense   `json:"licenses,omitempty"`
+	Hashes             []CycloneDxHash      `json:"hashes,omitempty"`
+	ExternalReferences []CycloneDxReference `json:"externalReferences,omitempty"`
+}
+
+// either license id or expression
+type CycloneDxLicense struct {
+	License    *CycloneDxLicenseId `json:"license,omitempty"`
+	Expression string              `json:"expression,omitempty"`
+}
+
+type CycloneDxLicenseId struct {
+	Id string `json:"id"`
+}
+
+type CycloneDxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type CycloneDxReference struct {
+	Type string `json:"type"`
+	Url  string `json:"url"`
+}
+
+type CycloneDxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+type SpdxDocument struct {
+	SpdxVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SpdxId            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      SpdxCreationInfo   `json:"creationInfo"`
+	Packages          []*SpdxPackage     `json:"packages"`
+	Relationships     []SpdxRelationship `json:"relationships"`
+}
+
+type SpdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type SpdxPackage struct {
+	Name             string            `json:"name"`
+	SpdxId           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	Checksums        []SpdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs     []SpdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type SpdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type SpdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type SpdxRelationship struct {
+	SpdxElementId      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSpdxElement string `json:"relatedSpdxElement"`
+}
+
+var spdxIdRegExp = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+func ConfigureSbomCommand(app *kingpin.Application) {
+	command := app.Command("sbom", "write software bill of materials (CycloneDX and SPDX JSON) for production dependencies and Electron")
+
+	dir := command.Flag("dir", "project dir").Required().String()
+	cycloneDxFile := command.Flag("cyclonedx", "CycloneDX 1.5 JSON output file").String()
+	spdxFile := command.Flag("spdx", "SPDX 2.3 JSON output file").String()
+	useLockfile := command.Flag("lockfile", "build tree from lockfile (package integrity is known only in this mode)").Default("true").Bool()
+	excludedDependencies := command.Flag("exclude-dep", "").Strings()
+	electronConfiguration := command.Flag("electron-configuration", "Electron download options (JSON), Electron is added as a component").String()
+	getPlatform := configurePlatformFlags(command)
+
+	command.Action(func(context *kingpin.ParseContext) error {
+		var electronOptions *electron.ElectronDownloadOptions
+		if len(*electronConfiguration) != 0 {
+			var configs []electron.ElectronDownloadOptions
+			err := jsoniter.UnmarshalFromString(*electronConfiguration, &configs)
+			if err != nil {
+				configs = make([]electron.ElectronDownloadOptions, 1)
+				err = jsoniter.UnmarshalFromString(*electronConfiguration, &configs[0])
+				if err != nil {
+					return errors.WithMessage(err, "cannot parse electron configuration")
+				}
+			}
+
+			if len(configs) != 0 {
+				err = electron.ResolveVersions(configs[:1])
+				if err != nil {
+					return err
+				}
+				electronOptions = &configs[0]
+			}
+		}
+
+		collector, err := collect(&collectOptions{
+			dir:                  *dir,
+			excludedDependencies: *excludedDependencies,
+			useLockfile:          *useLockfile,
+			platform:             getPlatform(),
+		})
+		if err != nil {
+			return err
+		}
+
+		document, err := createSbom(collector, electronOptions)
+		if err != nil {
+			return err
+		}
+
+		if len(*cycloneDxFile) == 0 && len(*spdxFile) == 0 {
+			return util.WriteJsonToStdOut(document.toCycloneDx())
+		}
+		if len(*cycloneDxFile) != 0 {
+			err = writeJsonFile(*cycloneDxFile, document.toCycloneDx())
+			if err != nil {
+				return err
+			}
+		}
+		if len(*spdxFile) != 0 {
+			err = writeJsonFile(*spdxFile, document.toSpdx())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func writeJsonFile(file string, value interface{}) error {
+	data, err := jsoniter.ConfigFastest.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(file, data, 0644))
+}
+
+func createSbom(collector *Collector, electronOptions *electron.ElectronDownloadOptions) (*sbom, error) {
+	result := &sbom{created: getSbomCreationTime()}
+	keyToPackage := make(map[string]*sbomPackage)
+	getPackage := func(dependency *Dependency) *sbomPackage {
+		key := dependency.Name + "@" + dependency.Version
+		info := keyToPackage[key]
+		if info == nil {
+			info = &sbomPackage{name: dependency.Name, version: dependency.Version, dir: dependency.dir, purl: toNpmPurl(dependency.Name, dependency.Version), componentType: "library", dependsOn: make(map[string]bool)}
+			keyToPackage[key] = info
+			if dependency != collector.rootDependency {
+				result.packages = append(result.packages, info)
+			}
+		}
+		if len(info.integrity) == 0 {
+			info.integrity = dependency.integrity
+		}
+		return info
+	}
+
+	result.root = getPackage(collector.rootDependency)
+	result.root.componentType = "application"
+	visited := map[*Dependency]bool{collector.rootDependency: true}
+	for queue := []*Dependency{collector.rootDependency}; len(queue) != 0; queue = queue[1:] {
+		dependency := queue[0]
+		info := getPackage(dependency)
+		for _, child := range dependency.children {
+			info.dependsOn[getPackage(child).purl] = true
+			if !visited[child] {
+				visited[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	sort.Slice(result.packages, func(i, j int) bool {
+		return result.packages[i].purl < result.packages[j].purl
+	})
+
+	all := append([]*sbomPackage{result.root}, result.packages...)
+	err := util.MapAsync(len(all), func(taskIndex int) (func() error, error) {
+		info := all[taskIndex]
+		return func() error {
+			license := &PackageLicense{Name: info.name, Version: info.version, Dir: info.dir}
+			err := readPackageLicense(license)
+			if err != nil {
+				return err
+			}
+			info.license = license.License
+			info.repository = license.Repository
+			return nil
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if electronOptions != nil && len(electronOptions.Version) != 0 {
+		version := strings.TrimPrefix(electronOptions.Version, "v")
+		electronPackage := &sbomPackage{
+			name:       "electron",
+			version:    version,
+			license:    "MIT",
+			repository: "https://github.com/electron/electron",
+			purl:       "pkg:github/electron/electron@v" + version,
+
+			componentType: "framework",
+		}
+		result.packages = append(result.packages, electronPackage)
+		result.root.dependsOn[electronPackage.purl] = true
+	}
+	return result, nil
+}
+
+// SOURCE_DATE_EPOCH for reproducible builds
+func getSbomCreationTime() time.Time {
+	epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64)
+	if err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// pkg:npm/%40scope/name@version
+func toNpmPurl(name string, version string) string {
+	if strings.HasPrefix(name, "@") {
+		name = "%40" + name[1:]
+	}
+	return "pkg:npm/" + name + "@" + url.PathEscape(version)
+}
+
+// sha512-base64 to algorithm and hex
+func parseIntegrity(integrity string) (string, string, bool) {
+	// several hashes can be specified, the first one is used
+	hashes := strings.Fields(integrity)
+	if len(hashes) == 0 {
+		return "", "", false
+	}
+	integrity = hashes[0]
+	dashIndex := strings.IndexRune(integrity, '-')
+	if dashIndex <= 0 {
+		return "", "", false
+	}
+
+	data, err := base64.StdEncoding.DecodeString(integrity[dashIndex+1:])
+	if err != nil {
+		return "", "", false
+	}
+
+	switch integrity[:dashIndex] {
+	case "sha512":
+		return "SHA-512", hex.EncodeToString(data), true
+	case "sha384":
+		return "SHA-384", hex.EncodeToString(data), true
+	case "sha256":
+		return "SHA-256", hex.EncodeToString(data), true
+	case "sha1":
+		return "SHA-1", hex.EncodeToString(data), true
+	default:
+		return "", "", false
+	}
+}
+
+func (t *sbomPackage) getSortedDependsOn() []string {
+	result := make([]string, 0, len(t.dependsOn))
+	for ref := range t.dependsOn {
+		result = append(result, ref)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (t *sbom) toCycloneDx() *CycloneDxBom {
+	result := &CycloneDxBom{
+		BomFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + newUuid(),
+		Version:      1,
+		Components:   make([]*CycloneDxComponent, 0, len(t.packages)),
+	}
+	result.Metadata.Timestamp = t.created.Format(time.RFC3339)
+	result.Metadata.Tools.Components = []*CycloneDxComponent{{Type: "application", Name: "app-builder"}}
+	result.Metadata.Component = toCycloneDxComponent(t.root)
+	for _, info := range t.packages {
+		result.Components = append(result.Components, toCycloneDxComponent(info))
+	}
+
+	for _, info := range append([]*sbomPackage{t.root}, t.packages...) {
+		result.Dependencies = append(result.Dependencies, CycloneDxDependency{Ref: info.purl, DependsOn: info.getSortedDependsOn()})
+	}
+	return result
+}
+
+func toCycloneDxComponent(info *sbomPackage) *CycloneDxComponent {
+	result := &CycloneDxComponent{Type: info.componentType, BomRef: info.purl, Name: info.name, Version: info.version, Purl: info.purl}
+	if strings.HasPrefix(info.name, "@") {
+		slashIndex := strings.IndexRune(info.name, '/')
+		if slashIndex > 0 {
+			result.Group = info.name[:slashIndex]
+			result.Name = info.name[slashIndex+1:]
+		}
+	}
+
+	if len(info.license) != 0 && info.license != unknownLicense {
+		expression, err := parseSpdxExpression(info.license)
+		switch {
+		case err == nil && len(expression.operator) == 0 && !strings.Contains(expression.license, " "):
+			result.Licenses = []CycloneDxLicense{{License: &CycloneDxLicenseId{Id: expression.license}}}
+		case err == nil:
+			result.Licenses = []CycloneDxLicense{{Expression: info.license}}
+		}
+	}
+
+	if algorithm, value, ok := parseIntegrity(info.integrity); ok {
+		result.Hashes = []CycloneDxHash{{Alg: algorithm, Content: value}}
+	}
+	if len(info.repository) != 0 {
+		result.ExternalReferences = []CycloneDxReference{{Type: "vcs", Url: info.repository}}
+	}
+	return result
+}
+
+func (t *sbom) toSpdx() *SpdxDocument {
+	result := &SpdxDocument{
+		SpdxVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SpdxId:            "SPDXRef-DOCUMENT",
+		Name:              t.root.name + "@" + t.root.version,
+		DocumentNamespace: "https://spdx.org/spdxdocs/" + url.PathEscape(t.root.name+"-"+t.root.version) + "-" + newUuid(),
+		CreationInfo: SpdxCreationInfo{
+			Created:  t.created.Format(time.RFC3339),
+			Creators: []string{"Tool: app-builder"},
+		},
+	}
+
+	all := append([]*sbomPackage{t.root}, t.packages...)
+	purlToId := make(map[string]string, len(all))
+	for index, info := range all {
+		purlToId[info.purl] = "SPDXRef-Package-" + strings.Trim(spdxIdRegExp.ReplaceAllString(info.name+"-"+info.version, "-"), "-") + "-" + strconv.Itoa(index)
+	}
+
+	for _, info := range all {
+		spdxPackage := &SpdxPackage{
+			Name:             info.name,
+			SpdxId:           purlToId[info.purl],
+			VersionInfo:      info.version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+			ExternalRefs:     []SpdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: info.purl}},
+		}
+		if _, err := parseSpdxExpression(info.license); err == nil && info.license != unknownLicense {
+			spdxPackage.LicenseDeclared = info.license
+		}
+		if algorithm, value, ok := parseIntegrity(info.integrity); ok {
+			spdxPackage.Checksums = []SpdxChecksum{{Algorithm: strings.ReplaceAll(algorithm, "-", ""), ChecksumValue: value}}
+		}
+		result.Packages = append(result.Packages, spdxPackage)
+	}
+
+	result.Relationships = append(result.Relationships, SpdxRelationship{SpdxElementId: result.SpdxId, RelationshipType: "DESCRIBES", RelatedSpdxElement: purlToId[t.root.purl]})
+	for _, info := range all {
+		for _, ref := range info.getSortedDependsOn() {
+			result.Relationships = append(result.Relationships, SpdxRelationship{SpdxElementId: purlToId[info.purl], RelationshipType: "DEPENDS_ON", RelatedSpdxElement: purlToId[ref]})
+		}
+	}
+	return result
+}
+
+// random (version 4)
+func newUuid() string {
+	var data [16]byte
+	_, _ = rand.Read(data[:])
+	data[6] = (data[6] & 0x0f) | 0x40
+	data[8] = (data[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", data[0:4], data[4:6], data[6:8], data[8:10], data[10:])
+}
@@ -0,0 +1,58 @@
+package node_modules
+
+import (
+	"path"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/electron"
+	"github.com/develar/app-builder/pkg/log"
+	jsoniter "github.com/json-iterator/go"
+	. "github.com/onsi/gomega"
+)
+
+func TestSbom(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+
+	collector, err := collect(&collectOptions{dir: path.Join(Dirname(), "sbom-demo"), useLockfile: true})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	document, err := createSbom(collector, &electron.ElectronDownloadOptions{Version: "v30.1.0"})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	bom := document.toCycloneDx()
+	g.Expect(bom.Metadata.Timestamp).To(Equal("2023-11-14T22:13:20Z"))
+	g.Expect(bom.Metadata.Component.Purl).To(Equal("pkg:npm/sbom-demo@1.0.0"))
+	g.Expect(bom.Components).To(HaveLen(3))
+
+	scoped := bom.Components[0]
+	g.Expect(scoped.Group).To(Equal("@scope"))
+	g.Expect(scoped.Name).To(Equal("a"))
+	g.Expect(scoped.Purl).To(Equal("pkg:npm/%40scope/a@1.0.0"))
+	g.Expect(scoped.Licenses[0].License.Id).To(Equal("MIT"))
+	g.Expect(scoped.Hashes[0].Alg).To(Equal("SHA-512"))
+	g.Expect(scoped.Hashes[0].Content).To(HavePrefix("1f40fc92da241694"))
+
+	g.Expect(bom.Components[1].Licenses[0].Expression).To(Equal("(MIT OR Apache-2.0)"))
+	g.Expect(bom.Components[1].Hashes).To(Equal([]CycloneDxHash{{Alg: "SHA-1", Content: "e9d71f5ee7c92d6dc9e92ffdad17b8bd49418f98"}}))
+
+	g.Expect(bom.Components[2].Type).To(Equal("framework"))
+	g.Expect(bom.Components[2].Purl).To(Equal("pkg:github/electron/electron@v30.1.0"))
+	g.Expect(bom.Dependencies[0]).To(Equal(CycloneDxDependency{Ref: "pkg:npm/sbom-demo@1.0.0", DependsOn: []string{"pkg:github/electron/electron@v30.1.0", "pkg:npm/%40scope/a@1.0.0"}}))
+	g.Expect(bom.Dependencies[1]).To(Equal(CycloneDxDependency{Ref: "pkg:npm/%40scope/a@1.0.0", DependsOn: []string{"pkg:npm/b@2.0.0"}}))
+
+	// SBOM is published with the app, local paths must not leak
+	data, err := jsoniter.ConfigFastest.Marshal(bom)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(data)).NotTo(ContainSubstring(Dirname()))
+
+	spdx := document.toSpdx()
+	g.Expect(spdx.Packages).To(HaveLen(4))
+	g.Expect(spdx.Packages[1].SpdxId).To(Equal("SPDXRef-Package-scope-a-1.0.0-1"))
+	g.Expect(spdx.Packages[1].Checksums).To(HaveLen(1))
+	g.Expect(spdx.Packages[1].Checksums[0].Algorithm).To(Equal("SHA512"))
+	g.Expect(spdx.Packages[2].LicenseDeclared).To(Equal("(MIT OR Apache-2.0)"))
+	g.Expect(spdx.Relationships[0]).To(Equal(SpdxRelationship{SpdxElementId: "SPDXRef-DOCUMENT", RelationshipType: "DESCRIBES", RelatedSpdxElement: "SPDXRef-Package-sbom-demo-1.0.0-0"}))
+	g.Expect(spdx.Relationships).To(ContainElement(SpdxRelationship{SpdxElementId: "SPDXRef-Package-scope-a-1.0.0-1", RelationshipType: "DEPENDS_ON", RelatedSpdxElement: "SPDXRef-Package-b-2.0.0-2"}))
+}
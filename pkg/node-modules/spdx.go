@@ -0,0 +1,136 @@
+package node_modules
+
+import (
+	"strings"
+
+	"github.com/develar/errors"
+)
+
+// SPDX license expression: license ids combined using AND, OR, WITH and parentheses
+type spdxExpression struct {
+	// AND or OR, empty for license id
+	operator string
+	operands []*spdxExpression
+	// "MIT", "Apache-2.0+", "GPL-2.0-only WITH Classpath-exception-2.0"
+	license string
+}
+
+func parseSpdxExpression(text string) (*spdxExpression, error) {
+	parser := &spdxParser{tokens: tokenizeSpdx(text)}
+	if len(parser.tokens) == 0 {
+		return nil, errors.New("license expression is empty")
+	}
+
+	result, err := parser.parseOr()
+	if err != nil {
+		return nil, errors.WithMessage(err, "cannot parse license expression "+text)
+	}
+	if parser.index != len(parser.tokens) {
+		return nil, errors.Errorf("cannot parse license expression %s: unexpected %s", text, parser.tokens[parser.index])
+	}
+	return result, nil
+}
+
+func tokenizeSpdx(text string) []string {
+	text = strings.ReplaceAll(text, "(", " ( ")
+	text = strings.ReplaceAll(text, ")", " ) ")
+	return strings.Fields(text)
+}
+
+type spdxParser struct {
+	tokens []string
+	index  int
+}
+
+func (t *spdxParser) peek() string {
+	if t.index < len(t.tokens) {
+		return t.tokens[t.index]
+	}
+	return ""
+}
+
+func (t *spdxParser) parseOr() (*spdxExpression, error) {
+	return t.parseBinary("OR", t.parseAnd)
+}
+
+func (t *spdxParser) parseAnd() (*spdxExpression, error) {
+	return t.parseBinary("AND", t.parsePrimary)
+}
+
+func (t *spdxParser) parseBinary(operator string, parseOperand func() (*spdxExpression, error)) (*spdxExpression, error) {
+	operand, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	operands := []*spdxExpression{operand}
+	for strings.EqualFold(t.peek(), operator) {
+		t.index++
+		operand, err = parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+
+	if len(operands) == 1 {
+		return operand, nil
+	}
+	return &spdxExpression{operator: operator, operands: operands}, nil
+}
+
+func (t *spdxParser) parsePrimary() (*spdxExpression, error) {
+	token := t.peek()
+	switch {
+	case len(token) == 0:
+		return nil, errors.New("unexpected end")
+	case token == "(":
+		t.index++
+		result, err := t.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t.peek() != ")" {
+			return nil, errors.New("missing )")
+		}
+		t.index++
+		return result, nil
+	case token == ")" || strings.EqualFold(token, "AND") || strings.EqualFold(token, "OR") || strings.EqualFold(token, "WITH"):
+		return nil, errors.Errorf("unexpected %s", token)
+	}
+
+	t.index++
+	license := token
+	if strings.EqualFold(t.peek(), "WITH") {
+		t.index++
+		exception := t.peek()
+		if len(exception) == 0 || exception == "(" || exception == ")" {
+			return nil, errors.New("exception is expected after WITH")
+		}
+		t.index++
+		license += " WITH " + exception
+	}
+	return &spdxExpression{license: license}, nil
+}
+
+// expression is satisfied if license can be used choosing allowed OR branches
+func (t *spdxExpression) isSatisfied(isAllowed func(license string) bool) bool {
+	switch t.operator {
+	case "OR":
+		for _, operand := range t.operands {
+			if operand.isSatisfied(isAllowed) {
+				return true
+			}
+		}
+		return false
+	case "AND":
+		for _, operand := range t.operands {
+			if !operand.isSatisfied(isAllowed) {
+				return false
+			}
+		}
+		return true
+	default:
+		return isAllowed(t.license)
+	}
+}
@@ -0,0 +1,315 @@
+package node_modules
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin"
+	appfs "github.com/develar/app-builder/pkg/fs"
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"github.com/develar/go-fs-util"
+	jsoniter "github.com/json-iterator/go"
+	"go.uber.org/zap"
+)
+
+// files that are not needed at runtime
+var defaultPrunePatterns = []string{
+	"test/", "tests/", "__tests__/", "__mocks__/", "powered-test/",
+	"example/", "examples/", "doc/", "docs/", "website/",
+	"coverage/", ".nyc_output/", ".github/", ".idea/", ".vscode/",
+	"*.md", "*.markdown", "*.d.ts", "*.d.mts", "*.d.cts", "*.map", "*.tsbuildinfo",
+	".npmignore", ".gitignore", ".gitattributes", ".editorconfig", ".eslintrc*", ".prettierrc*", ".travis.yml", "appveyor.yml",
+}
+
+type StageManifest struct {
+	Dir      string           `json:"dir"`
+	Packages []*StagedPackage `json:"packages"`
+	Size     int64            `json:"size"`
+	Pruned   int64            `json:"prunedSize"`
+}
+
+type StagedPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// source dir
+	Source string `json:"source"`
+	// relative to the staging dir
+	Destination string `json:"destination"`
+
+	Files  []StagedFile `json:"files"`
+	Size   int64        `json:"size"`
+	Pruned int64        `json:"prunedSize"`
+}
+
+type StagedFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+type packageFiles struct {
+	Main  string   `json:"main"`
+	Files []string `json:"files"`
+}
+
+type stageOptions struct {
+	outDir string
+	prune  []globPattern
+}
+
+func ConfigureStageCommand(app *kingpin.Application) {
+	command := app.Command("stage-node-modules", "copy production dependencies (hard links if possible) into the staging dir and write manifest of what was kept")
+
+	dir := command.Flag("dir", "project dir").Required().String()
+	outDir := command.Flag("out", "staging dir, node_modules is created in this dir").Required().String()
+	useLockfile := command.Flag("lockfile", "build tree from lockfile (package-lock.json, yarn.lock or pnpm-lock.yaml) instead of walking node_modules").Bool()
+	excludedDependencies := command.Flag("exclude-dep", "").Strings()
+	prunePatterns := command.Flag("prune", "glob of files to not copy (relative to the package dir, pattern without / matches name at any depth, trailing / matches only dirs)").Strings()
+	isDefaultPrune := command.Flag("default-prune", "apply built-in prune rules (docs, tests, examples, *.md, *.d.ts, *.map)").Default("true").Bool()
+	manifestFile := command.Flag("manifest", "write manifest to the file instead of stdout").String()
+	getPlatform := configurePlatformFlags(command)
+
+	command.Action(func(context *kingpin.ParseContext) error {
+		collector, err := collect(&collectOptions{
+			dir:                  *dir,
+			excludedDependencies: *excludedDependencies,
+			useLockfile:          *useLockfile,
+			platform:             getPlatform(),
+		})
+		if err != nil {
+			return err
+		}
+
+		var patterns []string
+		if *isDefaultPrune {
+			patterns = append(patterns, defaultPrunePatterns...)
+		}
+		patterns = append(patterns, *prunePatterns...)
+
+		manifest, err := stage(collector, &stageOptions{
+			outDir: *outDir,
+			prune:  compileGlobs(patterns),
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(*manifestFile) == 0 {
+			return util.WriteJsonToStdOut(manifest)
+		}
+
+		data, err := jsoniter.ConfigFastest.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(os.WriteFile(*manifestFile, data, 0644))
+	})
+}
+
+func stage(collector *Collector, options *stageOptions) (*StageManifest, error) {
+	collector.processHoistDependencyMap()
+
+	nodeModuleDir := filepath.Join(options.outDir, "node_modules")
+	err := fsutil.EnsureEmptyDir(nodeModuleDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var packages []*StagedPackage
+	var addPackages func(dependencyMap map[string]*Dependency, destination string)
+	addPackages = func(dependencyMap map[string]*Dependency, destination string) {
+		for alias, dependency := range dependencyMap {
+			packageDestination := path.Join(destination, alias)
+			packages = append(packages, &StagedPackage{
+				Name:        alias,
+				Version:     dependency.Version,
+				Source:      dependency.dir,
+				Destination: packageDestination,
+			})
+			if dependency.conflictDependency != nil {
+				addPackages(dependency.conflictDependency, packageDestination+"/node_modules")
+			}
+		}
+	}
+	addPackages(collector.HoiestedDependencyMap, "node_modules")
+
+	err = util.MapAsync(len(packages), func(taskIndex int) (func() error, error) {
+		stagedPackage := packages[taskIndex]
+		return func() error {
+			return stagePackage(stagedPackage, filepath.Join(options.outDir, filepath.FromSlash(stagedPackage.Destination)), options.prune)
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Destination < packages[j].Destination
+	})
+
+	manifest := &StageManifest{Dir: options.outDir, Packages: packages}
+	for _, stagedPackage := range packages {
+		manifest.Size += stagedPackage.Size
+		manifest.Pruned += stagedPackage.Pruned
+	}
+
+	log.Debug("node modules staged", zap.String("dir", options.outDir), zap.Int("packages", len(packages)), zap.Int64("size", manifest.Size), zap.Int64("prunedSize", manifest.Pruned))
+	return manifest, nil
+}
+
+func stagePackage(stagedPackage *StagedPackage, destination string, prune []globPattern) error {
+	sourceDir := stagedPackage.Source
+	var info packageFiles
+	data, err := os.ReadFile(filepath.Join(sourceDir, "package.json"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = jsoniter.Unmarshal(data, &info)
+	if err != nil {
+		return errors.WithMessage(err, "cannot read "+filepath.Join(sourceDir, "package.json"))
+	}
+
+	mainFile := path.Clean(strings.TrimPrefix(filepath.ToSlash(info.Main), "./"))
+	files := compileFilesField(info.Files)
+
+	// not shared - FileCopier disables hard links after the first failure
+	copier := &appfs.FileCopier{IsUseHardLinks: true}
+	stagedPackage.Files = make([]StagedFile, 0)
+	return filepath.WalkDir(sourceDir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if file == sourceDir {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(sourceDir, file)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		relativePath = filepath.ToSlash(relativePath)
+
+		if entry.IsDir() {
+			// nested dependencies are staged separately according to the hoisting result
+			if entry.Name() == "node_modules" || entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if matchGlobs(prune, relativePath, true) {
+				stagedPackage.Pruned += dirSize(file)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		isRequired := relativePath == "package.json" || isMainFile(relativePath, mainFile) || isLicenseFile(relativePath)
+		if !isRequired && (!isIncludedByFilesField(files, relativePath) || matchGlobs(prune, relativePath, false)) {
+			stagedPackage.Pruned += fileInfo.Size()
+			return nil
+		}
+
+		err = copier.CopyDirOrFile(file, filepath.Join(destination, filepath.FromSlash(relativePath)))
+		if err != nil {
+			return err
+		}
+
+		stagedPackage.Files = append(stagedPackage.Files, StagedFile{Path: relativePath, Size: fileInfo.Size()})
+		stagedPackage.Size += fileInfo.Size()
+		return nil
+	})
+}
+
+// main can be specified without extension or as dir
+func isMainFile(relativePath string, mainFile string) bool {
+	return relativePath == mainFile || relativePath == mainFile+".js" || relativePath == mainFile+"/index.js"
+}
+
+// "files" entries are relative to the package root, nil if field is not specified (all files are included)
+func compileFilesField(files []string) []globPattern {
+	if len(files) == 0 {
+		return nil
+	}
+
+	patterns := make([]string, 0, len(files))
+	for _, pattern := range files {
+		prefix := ""
+		if strings.HasPrefix(pattern, "!") {
+			prefix = "!"
+			pattern = pattern[1:]
+		}
+		pattern = strings.TrimPrefix(pattern, "./")
+		if !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "**") {
+			pattern = "/" + pattern
+		}
+		patterns = append(patterns, prefix+pattern)
+	}
+	return compileGlobs(patterns)
+}
+
+// pattern matches file if matches it or any of parent dirs, the last matched pattern wins (negated pattern excludes)
+func isIncludedByFilesField(files []globPattern, relativePath string) bool {
+	if files == nil {
+		return true
+	}
+
+	result := false
+	for index := range files {
+		pattern := &files[index]
+		if pattern.isNegated != result {
+			continue
+		}
+
+		isMatched := pattern.match(relativePath, false)
+		for parent := relativePath; !isMatched; {
+			slashIndex := strings.LastIndex(parent, "/")
+			if slashIndex <= 0 {
+				break
+			}
+			parent = parent[:slashIndex]
+			isMatched = pattern.match(parent, true)
+		}
+
+		if isMatched {
+			result = !pattern.isNegated
+		}
+	}
+	return result
+}
+
+// license and notice in the package root are always kept
+func isLicenseFile(relativePath string) bool {
+	if strings.Contains(relativePath, "/") {
+		return false
+	}
+
+	name := strings.ToUpper(relativePath)
+	for _, prefix := range []string{"LICENSE", "LICENCE", "NOTICE", "COPYING"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func dirSize(dir string) int64 {
+	var result int64
+	_ = filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			info, err := entry.Info()
+			if err == nil {
+				result += info.Size()
+			}
+		}
+		return nil
+	})
+	return result
+}
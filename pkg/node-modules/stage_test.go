@@ -0,0 +1,53 @@
+package node_modules
+
+import (
+	"path"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/log"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+)
+
+func TestStage(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	collector, err := collect(&collectOptions{dir: path.Join(Dirname(), "stage-demo")})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	manifest, err := stage(collector, &stageOptions{
+		outDir: t.TempDir(),
+		prune:  compileGlobs(append(defaultPrunePatterns, "*.txt")),
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	destinationToFiles := make(map[string][]string)
+	for _, stagedPackage := range manifest.Packages {
+		destinationToFiles[stagedPackage.Destination] = lo.Map(stagedPackage.Files, func(it StagedFile, index int) string {
+			return it.Path
+		})
+	}
+	g.Expect(destinationToFiles).To(Equal(map[string][]string{
+		"node_modules/a":                {"LICENSE", "lib/index.js", "package.json"},
+		"node_modules/b":                {"index.js", "package.json"},
+		"node_modules/b/node_modules/a": {"index.js", "package.json"},
+	}))
+	g.Expect(manifest.Pruned).To(BeNumerically(">", 0))
+	g.Expect(manifest.Size).To(Equal(lo.SumBy(manifest.Packages, func(it *StagedPackage) int64 {
+		return it.Size
+	})))
+}
+
+func TestGlob(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	patterns := compileGlobs([]string{"*.md", "docs/", "/lib/**/*.js", "!lib/keep.js"})
+	g.Expect(matchGlobs(patterns, "a/b/README.md", false)).To(BeTrue())
+	g.Expect(matchGlobs(patterns, "docs", true)).To(BeTrue())
+	g.Expect(matchGlobs(patterns, "docs", false)).To(BeFalse())
+	g.Expect(matchGlobs(patterns, "lib/a/b.js", false)).To(BeTrue())
+	g.Expect(matchGlobs(patterns, "lib/b.js", false)).To(BeTrue())
+	g.Expect(matchGlobs(patterns, "src/lib/b.js", false)).To(BeFalse())
+	g.Expect(matchGlobs(patterns, "lib/keep.js", false)).To(BeFalse())
+}
@@ -0,0 +1,176 @@
+package node_modules
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/app-builder/pkg/util"
+	"go.uber.org/zap"
+)
+
+// compiler settings to build for not host arch, env of every rebuild and prebuild-install invocation
+type Toolchain struct {
+	CC   string `json:"cc"`
+	CXX  string `json:"cxx"`
+	AR   string `json:"ar"`
+	LINK string `json:"link"`
+	// --sysroot is added to CFLAGS, CXXFLAGS and LDFLAGS
+	Sysroot string `json:"sysroot"`
+	// target arch if not specified
+	NpmConfigArch string `json:"npmConfigArch"`
+	// any additional env (PKG_CONFIG_PATH and so on)
+	Env map[string]string `json:"env"`
+}
+
+var elfMachines = map[string]elf.Machine{
+	"x64":   elf.EM_X86_64,
+	"ia32":  elf.EM_386,
+	"arm64": elf.EM_AARCH64,
+	"arm":   elf.EM_ARM,
+}
+
+// toolchain for the target arch (key is arch as specified or as process.arch)
+func (t *RebuildConfiguration) getToolchain() *Toolchain {
+	if toolchain := t.Toolchains[t.Arch]; toolchain != nil {
+		return toolchain
+	}
+	return t.Toolchains[toNodeArch(t.Arch)]
+}
+
+// nil if toolchain is not configured
+func (t *RebuildConfiguration) getToolchainEnv() []string {
+	toolchain := t.getToolchain()
+	if toolchain == nil {
+		return nil
+	}
+
+	var result []string
+	addEnv := func(name string, value string) {
+		if len(value) != 0 {
+			result = append(result, name+"="+value)
+		}
+	}
+
+	addEnv("CC", toolchain.CC)
+	addEnv("CXX", toolchain.CXX)
+	addEnv("AR", toolchain.AR)
+	addEnv("LINK", toolchain.LINK)
+	if len(toolchain.Sysroot) != 0 {
+		for _, name := range []string{"CFLAGS", "CXXFLAGS", "LDFLAGS"} {
+			addEnv(name, strings.TrimSpace(os.Getenv(name)+" --sysroot="+toolchain.Sysroot))
+		}
+	}
+
+	arch := toolchain.NpmConfigArch
+	if len(arch) == 0 {
+		arch = toNodeArch(t.Arch)
+	}
+	addEnv("npm_config_arch", arch)
+	addEnv("npm_config_target_arch", arch)
+
+	var names []string
+	for name := range toolchain.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		addEnv(name, toolchain.Env[name])
+	}
+	return result
+}
+
+// later value wins, so toolchain overrides inherited env
+func applyToolchain(command *exec.Cmd, configuration *RebuildConfiguration) {
+	env := configuration.getToolchainEnv()
+	if env == nil {
+		return
+	}
+
+	if command.Env == nil {
+		command.Env = os.Environ()
+	}
+	command.Env = append(command.Env, env...)
+}
+
+// host compiler produces binaries only for host arch (gcc is not a cross compiler), explicit toolchain or CC is required
+func isCrossCompileConfigured(configuration *RebuildConfiguration) bool {
+	if toNodeArch(configuration.Arch) == toNodeArch(runtime.GOARCH) {
+		return true
+	}
+	return configuration.getToolchain() != nil || len(os.Getenv("CC")) != 0
+}
+
+// linux only - clang on macOS and msvc are able to build for another arch.
+// Only output of this build is checked - packages also ship binaries for other platforms (node-pre-gyp lib/binding/<platform-arch>) or contain output of previous host build.
+func checkNativeBinaries(dependencies []*DepInfo, configuration *RebuildConfiguration, buildStartTime time.Time) error {
+	if configuration.Platform != "linux" {
+		return nil
+	}
+
+	expectedMachine, ok := elfMachines[toNodeArch(configuration.Arch)]
+	if !ok {
+		return nil
+	}
+
+	mismatches := make([][]string, len(dependencies))
+	err := util.MapAsync(len(dependencies), func(index int) (func() error, error) {
+		dependency := dependencies[index]
+		return func() error {
+			files, err := findNativeOutputFiles(dependency.dir, buildStartTime)
+			if err != nil {
+				return err
+			}
+
+			for _, file := range files {
+				machine, ok := readElfMachine(filepath.Join(dependency.dir, filepath.FromSlash(file)))
+				if ok && machine != expectedMachine {
+					mismatches[index] = append(mismatches[index], fmt.Sprintf("%s (%s)", file, machine))
+				}
+			}
+			return nil
+		}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var messages []string
+	for index, files := range mismatches {
+		if len(files) == 0 {
+			continue
+		}
+
+		dependency := dependencies[index]
+		if dependency.Optional {
+			log.Warn("optional native dependency is built for another arch", zap.String("name", dependency.Name), zap.String("version", dependency.Version),
+				zap.String("expected", expectedMachine.String()), zap.Strings("files", files))
+			continue
+		}
+		messages = append(messages, dependency.Name+"@"+dependency.Version+": "+strings.Join(files, ", "))
+	}
+
+	if len(messages) != 0 {
+		return util.NewMessageError(fmt.Sprintf("native dependencies are built not for %s (%s), configure toolchain for cross-compilation: %s",
+			configuration.Arch, expectedMachine, strings.Join(messages, "; ")), "ERR_NATIVE_ARCH_MISMATCH")
+	}
+	return nil
+}
+
+// false if file is not ELF
+func readElfMachine(file string) (elf.Machine, bool) {
+	elfFile, err := elf.Open(file)
+	if err != nil {
+		log.Debug("native binary is not ELF", zap.String("file", file), zap.Error(err))
+		return 0, false
+	}
+	defer elfFile.Close()
+	return elfFile.Machine, true
+}
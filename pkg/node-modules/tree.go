@@ -7,7 +7,10 @@ import (
 	"strings"
 
 	"github.com/alecthomas/kingpin"
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/app-builder/pkg/util"
 	jsoniter "github.com/json-iterator/go"
+	"go.uber.org/zap"
 )
 
 func ConfigureCommand(app *kingpin.Application) {
@@ -16,49 +19,173 @@ func ConfigureCommand(app *kingpin.Application) {
 	dir := command.Flag("dir", "").Required().String()
 	flatten := command.Flag("flatten", "").Bool()
 	excludedDependencies := command.Flag("exclude-dep", "").Strings()
+	useLockfile := command.Flag("lockfile", "build tree from lockfile (package-lock.json, yarn.lock or pnpm-lock.yaml) instead of walking node_modules").Bool()
+	why := command.Flag("why", "print every dependency chain from the project to each instance of package (name or name@version, use --why=@scope/name for scoped packages)").String()
+	analyze := command.Flag("analyze", "print size of packages (with and without transitive dependencies), packages with several versions and treemap").Bool()
+	format := command.Flag("format", "output format of --why and --analyze").Default("json").Enum("json", "text")
+	concurrency := command.Flag("concurrency", "number of concurrent package.json reads (1 to read sequentially)").Int()
+	strict := command.Flag("strict", "fail if a non-optional dependency is not installed or doesn't support the target platform (error with unresolved and incompatible dependencies is written to stdout)").Bool()
+	getPlatform := configurePlatformFlags(command)
 
 	command.Action(func(context *kingpin.ParseContext) error {
-		var excluded map[string]bool
-		if excludedDependencies == nil || len(*excludedDependencies) == 0 {
-			excluded = nil
-		} else {
-			excluded = make(map[string]bool, len(*excludedDependencies))
-			for _, name := range *excludedDependencies {
-				excluded[name] = true
+		collector, err := collect(&collectOptions{
+			dir:                  *dir,
+			excludedDependencies: *excludedDependencies,
+			useLockfile:          *useLockfile,
+			platform:             getPlatform(),
+			concurrency:          *concurrency,
+		})
+		if err != nil {
+			return err
+		}
+
+		unresolved := collector.getUnresolvedDependencies()
+		// warning is already logged on collect
+		incompatible := collector.getIncompatibleDependencies()
+		if *strict && (len(unresolved) != 0 || len(incompatible) != 0) {
+			treeError := newDependencyTreeError(unresolved, incompatible)
+			err = util.WriteJsonToStdOut(treeError)
+			if err != nil {
+				return err
 			}
+			return treeError
 		}
 
-		collector := &Collector{
-			unresolvedDependencies:       make(map[string]bool),
-			excludedDependencies:         excluded,
-			NodeModuleDirToDependencyMap: make(map[string]*map[string]*Dependency),
+		for _, dependency := range unresolved {
+			log.Warn("dependency is not installed", zap.String("name", dependency.Name), zap.String("spec", dependency.Spec), zap.String("requiredBy", dependency.RequiredBy))
 		}
-		dependency, err := readPackageJson(*dir)
-		if err != nil {
-			return err
+
+		if len(*why) != 0 {
+			query, err := parseWhyQuery(*why)
+			if err != nil {
+				return err
+			}
+
+			instances := collector.why(query)
+			if *format == "text" {
+				return writeWhyText(os.Stdout, *why, instances)
+			}
+
+			jsonWriter := jsoniter.NewStream(jsoniter.ConfigFastest, os.Stdout, 32*1024)
+			writeWhyResult(jsonWriter, instances, collector)
+			return jsonWriter.Flush()
 		}
-		dependency.dir = *dir
 
-		err = collector.readDependencyTree(dependency)
-		if err != nil {
-			return err
+		if *analyze {
+			report, err := collector.analyze()
+			if err != nil {
+				return err
+			}
+
+			if *format == "text" {
+				return writeSizeReportText(os.Stdout, report)
+			}
+			return util.WriteJsonToStdOut(report)
 		}
 
 		jsonWriter := jsoniter.NewStream(jsoniter.ConfigFastest, os.Stdout, 32*1024)
-		if *flatten {
-			collector.processHoistDependencyMap()
-			writeFlattenResult(jsonWriter, collector.HoiestedDependencyMap)
-		} else {
-			writeResult(jsonWriter, collector)
+		writeTreeResult(jsonWriter, collector, *flatten)
+		return jsonWriter.Flush()
+	})
+}
 
+type collectOptions struct {
+	dir                  string
+	excludedDependencies []string
+	useLockfile          bool
+	platform             *targetPlatform
+	// number of concurrent package.json reads, 1 to read on demand
+	concurrency int
+}
+
+func collect(options *collectOptions) (*Collector, error) {
+	var excluded map[string]bool
+	if len(options.excludedDependencies) == 0 {
+		excluded = nil
+	} else {
+		excluded = make(map[string]bool, len(options.excludedDependencies))
+		for _, name := range options.excludedDependencies {
+			excluded[name] = true
 		}
-		err = jsonWriter.Flush()
+	}
+
+	collector := &Collector{
+		unresolvedDependencies:       make(map[string]*UnresolvedDependency),
+		excludedDependencies:         excluded,
+		NodeModuleDirToDependencyMap: make(map[string]*map[string]*Dependency),
+		platform:                     options.platform,
+		incompatibleDependencies:     make(map[*Dependency]bool),
+	}
+	dependency, err := readPackageJson(options.dir)
+	if err != nil {
+		return nil, err
+	}
+	dependency.dir = options.dir
+
+	// yarn PnP project doesn't have node_modules, so, PnP data is always used
+	pnp, err := findPnp(options.dir)
+	if err != nil {
+		log.Warn("cannot read yarn PnP data, node_modules will be walked", zap.Error(err))
+		pnp = nil
+	} else if pnp != nil && len(pnp.findWorkspaceLocator(options.dir)) == 0 {
+		log.Warn("project is not found in the yarn PnP data, node_modules will be walked", zap.String("dir", options.dir), zap.String("pnpDir", pnp.dir))
+		pnp = nil
+	}
+
+	if pnp != nil {
+		collector.lockfile = pnp
+	} else if options.useLockfile {
+		collector.lockfile, err = findLockfile(options.dir)
 		if err != nil {
-			return err
+			log.Warn("cannot read lockfile, node_modules will be walked", zap.Error(err))
+			collector.lockfile = nil
+		} else if collector.lockfile == nil {
+			log.Warn("lockfile not found, node_modules will be walked", zap.String("dir", options.dir))
 		}
+	}
 
-		return nil
-	})
+	if collector.lockfile == nil {
+		concurrency := options.concurrency
+		if concurrency <= 0 {
+			concurrency = getDefaultScanConcurrency()
+		}
+		if concurrency > 1 {
+			collector.packageCache = newPackageJsonCache()
+			collector.packageCache.prefetch(dependency, excluded, concurrency)
+		}
+		err = collector.readDependencyTree(dependency)
+	} else {
+		err = collector.readLockfileDependencyTree(dependency)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return collector, nil
+}
+
+// dependency tree (flatten or grouped by node_modules dir) and dependencies that are not installed or don't support the target platform (the same fields as in the strict mode error)
+func writeTreeResult(jsonWriter *jsoniter.Stream, collector *Collector, flatten bool) {
+	jsonWriter.WriteObjectStart()
+	jsonWriter.WriteObjectField("dependencies")
+	if flatten {
+		collector.processHoistDependencyMap()
+		writeFlattenResult(jsonWriter, collector.HoiestedDependencyMap)
+	} else {
+		writeResult(jsonWriter, collector)
+	}
+	writeDependencyTreeWarnings(jsonWriter, collector)
+	jsonWriter.WriteObjectEnd()
+}
+
+// fields of the current object, lists are always written (empty if there are no warnings)
+func writeDependencyTreeWarnings(jsonWriter *jsoniter.Stream, collector *Collector) {
+	jsonWriter.WriteMore()
+	jsonWriter.WriteObjectField("unresolved")
+	jsonWriter.WriteVal(collector.getUnresolvedDependencies())
+
+	jsonWriter.WriteMore()
+	jsonWriter.WriteObjectField("incompatible")
+	jsonWriter.WriteVal(collector.getIncompatibleDependencies())
 }
 
 func writeFlattenResult(jsonWriter *jsoniter.Stream, dependencyMap map[string]*Dependency) {
@@ -0,0 +1,332 @@
+package node_modules
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// production packages of the release, saved to compare with the next release
+type DependencyTreeSnapshot struct {
+	Name     string             `json:"name"`
+	Version  string             `json:"version"`
+	Packages []*SnapshotPackage `json:"packages"`
+}
+
+type SnapshotPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// top-level dependencies (as named in the project package.json) that bring the package
+	Via []string `json:"via"`
+}
+
+type DependencyTreeDiff struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+
+	Added   []*PackageChange `json:"added"`
+	Removed []*PackageChange `json:"removed"`
+	Changed []*PackageChange `json:"changed"`
+}
+
+type PackageChange struct {
+	Name        string   `json:"name"`
+	OldVersions []string `json:"oldVersions,omitempty"`
+	NewVersions []string `json:"newVersions,omitempty"`
+	// for removed package - top-level dependencies of the old release
+	Via []string `json:"via"`
+}
+
+func ConfigureTreeDiffCommand(app *kingpin.Application) {
+	command := app.Command("node-dep-tree-diff", "list added, removed and version-changed production packages between two releases")
+
+	oldDir := command.Flag("old", "project dir, snapshot file (written by --save) or saved node-dep-tree output of the previous release").Required().String()
+	newDir := command.Flag("new", "project dir of the new release").Required().String()
+	saveFile := command.Flag("save", "write snapshot of the new release to compare with the next one").String()
+	format := command.Flag("format", "").Default("json").Enum("json", "markdown")
+	useLockfile := command.Flag("lockfile", "build tree from lockfile instead of walking node_modules").Bool()
+	excludedDependencies := command.Flag("exclude-dep", "").Strings()
+	getPlatform := configurePlatformFlags(command)
+
+	command.Action(func(context *kingpin.ParseContext) error {
+		readSnapshot := func(path string) (*DependencyTreeSnapshot, error) {
+			if isFile(path) {
+				return readDependencyTreeSnapshot(path)
+			}
+
+			collector, err := collect(&collectOptions{
+				dir:                  path,
+				excludedDependencies: *excludedDependencies,
+				useLockfile:          *useLockfile,
+				platform:             getPlatform(),
+			})
+			if err != nil {
+				return nil, err
+			}
+			return collector.createSnapshot(), nil
+		}
+
+		oldSnapshot, err := readSnapshot(*oldDir)
+		if err != nil {
+			return errors.WithMessage(err, "cannot read old dependency tree")
+		}
+
+		newSnapshot, err := readSnapshot(*newDir)
+		if err != nil {
+			return errors.WithMessage(err, "cannot read new dependency tree")
+		}
+
+		if len(*saveFile) != 0 {
+			err = writeJsonFile(*saveFile, newSnapshot)
+			if err != nil {
+				return err
+			}
+		}
+
+		diff := diffDependencyTrees(oldSnapshot, newSnapshot)
+		if *format == "markdown" {
+			return writeTreeDiffMarkdown(os.Stdout, diff)
+		}
+		return util.WriteJsonToStdOut(diff)
+	})
+}
+
+// entry of saved node-dep-tree output, name and version are set for --flatten output
+type savedTreeEntry struct {
+	Dir          string    `json:"dir"`
+	Dependencies []DepInfo `json:"deps"`
+
+	Name               string            `json:"name"`
+	Version            string            `json:"version"`
+	ConflictDependency []*savedTreeEntry `json:"conflictDependency"`
+}
+
+// snapshot written by --save or saved node-dep-tree output (with or without --flatten)
+func readDependencyTreeSnapshot(file string) (*DependencyTreeSnapshot, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var entries []*savedTreeEntry
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) != 0 && trimmed[0] == '[' {
+		// output of app-builder versions without unresolved and incompatible dependencies
+		err = jsoniter.Unmarshal(data, &entries)
+		if err != nil {
+			return nil, errors.WithMessage(err, "cannot parse dependency tree "+file)
+		}
+		return createSnapshotFromSavedTree(filepath.Base(file), entries), nil
+	}
+
+	var output struct {
+		DependencyTreeSnapshot
+		Dependencies []*savedTreeEntry `json:"dependencies"`
+	}
+	err = jsoniter.Unmarshal(data, &output)
+	if err != nil {
+		return nil, errors.WithMessage(err, "cannot parse snapshot "+file)
+	}
+	if output.Dependencies != nil {
+		return createSnapshotFromSavedTree(filepath.Base(file), output.Dependencies), nil
+	}
+	return &output.DependencyTreeSnapshot, nil
+}
+
+// node-dep-tree output doesn't contain project, real names of aliased packages and dependency chains, so, packages are listed by alias and without via
+func createSnapshotFromSavedTree(name string, entries []*savedTreeEntry) *DependencyTreeSnapshot {
+	keyToPackage := make(map[string]*SnapshotPackage)
+	add := func(name string, version string) {
+		key := name + "@" + version
+		if keyToPackage[key] == nil {
+			keyToPackage[key] = &SnapshotPackage{Name: name, Version: version, Via: make([]string, 0)}
+		}
+	}
+
+	var addEntries func(entries []*savedTreeEntry)
+	addEntries = func(entries []*savedTreeEntry) {
+		for _, entry := range entries {
+			if len(entry.Name) != 0 {
+				add(entry.Name, entry.Version)
+			}
+			for _, dependency := range entry.Dependencies {
+				add(dependency.Name, dependency.Version)
+			}
+			addEntries(entry.ConflictDependency)
+		}
+	}
+	addEntries(entries)
+
+	result := &DependencyTreeSnapshot{Name: name, Packages: make([]*SnapshotPackage, 0, len(keyToPackage))}
+	for _, info := range keyToPackage {
+		result.Packages = append(result.Packages, info)
+	}
+	sortSnapshotPackages(result.Packages)
+	return result
+}
+
+func sortSnapshotPackages(packages []*SnapshotPackage) {
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Name != packages[j].Name {
+			return packages[i].Name < packages[j].Name
+		}
+		return packages[i].Version < packages[j].Version
+	})
+}
+
+// one entry per name and version, sorted by name and version
+func (t *Collector) createSnapshot() *DependencyTreeSnapshot {
+	root := t.rootDependency
+	keyToPackage := make(map[string]*SnapshotPackage)
+	keyToVia := make(map[string]map[string]bool)
+
+	// every subtree of the top-level dependency is walked, so, package shared by several top-level dependencies lists all of them
+	for _, topLevel := range root.children {
+		visited := map[*Dependency]bool{root: true, topLevel: true}
+		for queue := []*Dependency{topLevel}; len(queue) != 0; queue = queue[1:] {
+			dependency := queue[0]
+			key := dependency.Name + "@" + dependency.Version
+			if keyToPackage[key] == nil {
+				keyToPackage[key] = &SnapshotPackage{Name: dependency.Name, Version: dependency.Version}
+				keyToVia[key] = make(map[string]bool)
+			}
+			keyToVia[key][topLevel.alias] = true
+
+			for _, child := range dependency.children {
+				if !visited[child] {
+					visited[child] = true
+					queue = append(queue, child)
+				}
+			}
+		}
+	}
+
+	result := &DependencyTreeSnapshot{Name: root.Name, Version: root.Version, Packages: make([]*SnapshotPackage, 0, len(keyToPackage))}
+	for key, info := range keyToPackage {
+		info.Via = sortedKeys(keyToVia[key])
+		result.Packages = append(result.Packages, info)
+	}
+	sortSnapshotPackages(result.Packages)
+	return result
+}
+
+// version is not known for snapshot created from saved node-dep-tree output
+func (t *DependencyTreeSnapshot) getLabel() string {
+	if len(t.Version) == 0 {
+		return t.Name
+	}
+	return t.Name + "@" + t.Version
+}
+
+// packages are compared by real name (not alias), package is changed if set of installed versions is changed
+func diffDependencyTrees(oldSnapshot *DependencyTreeSnapshot, newSnapshot *DependencyTreeSnapshot) *DependencyTreeDiff {
+	type packageVersions struct {
+		versions []string
+		via      map[string]bool
+	}
+	group := func(snapshot *DependencyTreeSnapshot) (map[string]*packageVersions, []string) {
+		result := make(map[string]*packageVersions)
+		var names []string
+		for _, info := range snapshot.Packages {
+			item := result[info.Name]
+			if item == nil {
+				item = &packageVersions{via: make(map[string]bool)}
+				result[info.Name] = item
+				names = append(names, info.Name)
+			}
+			item.versions = append(item.versions, info.Version)
+			for _, name := range info.Via {
+				item.via[name] = true
+			}
+		}
+		for _, item := range result {
+			sort.Strings(item.versions)
+		}
+		sort.Strings(names)
+		return result, names
+	}
+
+	oldPackages, oldNames := group(oldSnapshot)
+	newPackages, newNames := group(newSnapshot)
+
+	result := &DependencyTreeDiff{
+		Old:     oldSnapshot.getLabel(),
+		New:     newSnapshot.getLabel(),
+		Added:   make([]*PackageChange, 0),
+		Removed: make([]*PackageChange, 0),
+		Changed: make([]*PackageChange, 0),
+	}
+
+	for _, name := range newNames {
+		newInfo := newPackages[name]
+		oldInfo := oldPackages[name]
+		if oldInfo == nil {
+			result.Added = append(result.Added, &PackageChange{Name: name, NewVersions: newInfo.versions, Via: sortedKeys(newInfo.via)})
+		} else if strings.Join(oldInfo.versions, " ") != strings.Join(newInfo.versions, " ") {
+			result.Changed = append(result.Changed, &PackageChange{Name: name, OldVersions: oldInfo.versions, NewVersions: newInfo.versions, Via: sortedKeys(newInfo.via)})
+		}
+	}
+
+	for _, name := range oldNames {
+		if newPackages[name] == nil {
+			oldInfo := oldPackages[name]
+			result.Removed = append(result.Removed, &PackageChange{Name: name, OldVersions: oldInfo.versions, Via: sortedKeys(oldInfo.via)})
+		}
+	}
+	return result
+}
+
+func sortedKeys(set map[string]bool) []string {
+	result := make([]string, 0, len(set))
+	for key := range set {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func writeTreeDiffMarkdown(writer io.Writer, diff *DependencyTreeDiff) error {
+	builder := &strings.Builder{}
+	_, _ = fmt.Fprintf(builder, "## Dependency changes: %s → %s\n", diff.Old, diff.New)
+	if len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0 {
+		builder.WriteString("\nNo changes in production dependencies.\n")
+	}
+
+	writeTable := func(title string, changes []*PackageChange, header string, toRow func(change *PackageChange) string) {
+		if len(changes) == 0 {
+			return
+		}
+
+		_, _ = fmt.Fprintf(builder, "\n### %s (%d)\n\n%s\n", title, len(changes), header)
+		for _, change := range changes {
+			builder.WriteString(toRow(change))
+			builder.WriteString(" | " + escapeMarkdownCell(strings.Join(change.Via, ", ")) + " |\n")
+		}
+	}
+
+	writeTable("Added", diff.Added, "| Package | Version | Via |\n| --- | --- | --- |", func(change *PackageChange) string {
+		return "| " + escapeMarkdownCell(change.Name) + " | " + strings.Join(change.NewVersions, ", ")
+	})
+	writeTable("Removed", diff.Removed, "| Package | Version | Via |\n| --- | --- | --- |", func(change *PackageChange) string {
+		return "| " + escapeMarkdownCell(change.Name) + " | " + strings.Join(change.OldVersions, ", ")
+	})
+	writeTable("Changed", diff.Changed, "| Package | Old | New | Via |\n| --- | --- | --- | --- |", func(change *PackageChange) string {
+		return "| " + escapeMarkdownCell(change.Name) + " | " + strings.Join(change.OldVersions, ", ") + " | " + strings.Join(change.NewVersions, ", ")
+	})
+
+	_, err := io.WriteString(writer, builder.String())
+	return errors.WithStack(err)
+}
+
+func escapeMarkdownCell(value string) string {
+	return strings.ReplaceAll(value, "|", "\\|")
+}
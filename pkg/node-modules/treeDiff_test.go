@@ -0,0 +1,102 @@
+package node_modules
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/log"
+	jsoniter "github.com/json-iterator/go"
+	. "github.com/onsi/gomega"
+)
+
+func TestDependencyTreeDiff(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	collector, err := collect(&collectOptions{dir: path.Join(Dirname(), "sbom-demo"), useLockfile: true})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	newSnapshot := collector.createSnapshot()
+	g.Expect(newSnapshot.Packages).To(Equal([]*SnapshotPackage{
+		{Name: "@scope/a", Version: "1.0.0", Via: []string{"@scope/a"}},
+		{Name: "b", Version: "2.0.0", Via: []string{"@scope/a"}},
+	}))
+
+	oldSnapshot := &DependencyTreeSnapshot{Name: "sbom-demo", Version: "0.9.0", Packages: []*SnapshotPackage{
+		{Name: "@scope/a", Version: "1.0.0", Via: []string{"@scope/a"}},
+		{Name: "b", Version: "1.0.0", Via: []string{"@scope/a"}},
+		{Name: "c", Version: "1.0.0", Via: []string{"c"}},
+	}}
+
+	diff := diffDependencyTrees(oldSnapshot, newSnapshot)
+	g.Expect(diff.Added).To(BeEmpty())
+	g.Expect(diff.Removed).To(Equal([]*PackageChange{{Name: "c", OldVersions: []string{"1.0.0"}, Via: []string{"c"}}}))
+	g.Expect(diff.Changed).To(Equal([]*PackageChange{{Name: "b", OldVersions: []string{"1.0.0"}, NewVersions: []string{"2.0.0"}, Via: []string{"@scope/a"}}}))
+
+	// reversed - removed becomes added
+	g.Expect(diffDependencyTrees(newSnapshot, oldSnapshot).Added).To(Equal([]*PackageChange{{Name: "c", NewVersions: []string{"1.0.0"}, Via: []string{"c"}}}))
+
+	markdown := &strings.Builder{}
+	g.Expect(writeTreeDiffMarkdown(markdown, diff)).To(Succeed())
+	g.Expect(markdown.String()).To(ContainSubstring("### Changed (1)"))
+	g.Expect(markdown.String()).To(ContainSubstring("| b | 1.0.0 | 2.0.0 | @scope/a |"))
+	g.Expect(markdown.String()).NotTo(ContainSubstring("### Added"))
+}
+
+func TestReadSavedDependencyTree(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	collector, err := collect(&collectOptions{dir: path.Join(Dirname(), "sbom-demo"), useLockfile: true})
+	g.Expect(err).NotTo(HaveOccurred())
+	newSnapshot := collector.createSnapshot()
+
+	dir := t.TempDir()
+	snapshotFile := filepath.Join(dir, "snapshot.json")
+	g.Expect(writeJsonFile(snapshotFile, newSnapshot)).To(Succeed())
+	g.Expect(readDependencyTreeSnapshot(snapshotFile)).To(Equal(newSnapshot))
+
+	// output of node-dep-tree saved for the previous release
+	writeSavedTree := func(name string, write func(jsonWriter *jsoniter.Stream)) string {
+		buffer := &bytes.Buffer{}
+		jsonWriter := jsoniter.NewStream(jsoniter.ConfigFastest, buffer, 1024)
+		write(jsonWriter)
+		g.Expect(jsonWriter.Flush()).To(Succeed())
+		file := filepath.Join(dir, name)
+		g.Expect(os.WriteFile(file, buffer.Bytes(), 0644)).To(Succeed())
+		return file
+	}
+
+	treeFile := writeSavedTree("tree.json", func(jsonWriter *jsoniter.Stream) {
+		writeTreeResult(jsonWriter, collector, false)
+	})
+	flattenFile := writeSavedTree("flatten.json", func(jsonWriter *jsoniter.Stream) {
+		writeTreeResult(jsonWriter, collector, true)
+	})
+	// output of previous versions is a list without unresolved and incompatible dependencies
+	legacyTreeFile := writeSavedTree("legacy-tree.json", func(jsonWriter *jsoniter.Stream) {
+		writeResult(jsonWriter, collector)
+	})
+	legacyFlattenFile := writeSavedTree("legacy-flatten.json", func(jsonWriter *jsoniter.Stream) {
+		writeFlattenResult(jsonWriter, collector.HoiestedDependencyMap)
+	})
+
+	for _, file := range []string{treeFile, flattenFile, legacyTreeFile, legacyFlattenFile} {
+		oldSnapshot, err := readDependencyTreeSnapshot(file)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(oldSnapshot.Packages).To(Equal([]*SnapshotPackage{
+			{Name: "@scope/a", Version: "1.0.0", Via: []string{}},
+			{Name: "b", Version: "2.0.0", Via: []string{}},
+		}))
+
+		diff := diffDependencyTrees(oldSnapshot, newSnapshot)
+		g.Expect(diff.Old).To(Equal(filepath.Base(file)))
+		g.Expect(diff.Added).To(BeEmpty())
+		g.Expect(diff.Removed).To(BeEmpty())
+		g.Expect(diff.Changed).To(BeEmpty())
+	}
+}
@@ -0,0 +1,257 @@
+package node_modules
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/develar/errors"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// chains are enumerated for every instance, total limit to not hang on huge graphs
+const maxWhyChains = 1000
+
+type WhyLink struct {
+	// alias (name in the parent dependencies)
+	Name string `json:"name"`
+	// real package name, only if differs from the alias (npm: alias)
+	PackageName string `json:"packageName,omitempty"`
+	Version     string `json:"version"`
+	// requested as optional dependency by the previous link
+	Optional bool `json:"optional,omitempty"`
+}
+
+type WhyInstance struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Dir     string `json:"dir"`
+
+	Chains [][]WhyLink `json:"chains"`
+	// chains limit is reached, chains of the instance may be not all listed
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type whyQuery struct {
+	name       string
+	version    string
+	constraint *semver.Constraints
+}
+
+// "name", "name@1.2.3" or "name@^1.2.0"
+func parseWhyQuery(query string) (*whyQuery, error) {
+	name, version := splitDescriptor(strings.TrimSpace(query))
+	if len(name) == 0 {
+		return nil, errors.New("package name is not specified")
+	}
+
+	result := &whyQuery{name: name, version: version}
+	if len(version) != 0 && !isExactVersion(version) {
+		constraint, err := semver.NewConstraint(version)
+		if err != nil {
+			return nil, errors.Errorf("%s is neither exact version, nor range: %s", version, err.Error())
+		}
+		result.constraint = constraint
+	}
+	return result, nil
+}
+
+func isExactVersion(version string) bool {
+	_, err := semver.StrictNewVersion(version)
+	return err == nil
+}
+
+func (t *whyQuery) isMatched(dependency *Dependency) bool {
+	if dependency.alias != t.name && dependency.Name != t.name {
+		return false
+	}
+
+	switch {
+	case len(t.version) == 0:
+		return true
+	case t.constraint == nil:
+		return dependency.Version == t.version
+	default:
+		version, err := semver.NewVersion(dependency.Version)
+		return err == nil && t.constraint.Check(version)
+	}
+}
+
+// every chain from the root to each matching instance, instances are sorted by version, dir and alias
+func (t *Collector) why(query *whyQuery) []*WhyInstance {
+	dirToInstance := make(map[string]*WhyInstance)
+	var result []*WhyInstance
+
+	// subtrees without matching instances are not enumerated - ancestors of matching instances are collected using reversed edges
+	dependents := make(map[*Dependency][]*Dependency)
+	var queue []*Dependency
+	visited := map[*Dependency]bool{t.rootDependency: true}
+	for stack := []*Dependency{t.rootDependency}; len(stack) != 0; {
+		dependency := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if dependency != t.rootDependency && query.isMatched(dependency) {
+			queue = append(queue, dependency)
+
+			// instances are created before enumeration of chains - enumeration is stopped on the chains limit, but every instance must be listed
+			key := dependency.alias + "@" + dependency.dir
+			if dirToInstance[key] == nil {
+				instance := &WhyInstance{Name: dependency.alias, Version: dependency.Version, Dir: dependency.dir}
+				dirToInstance[key] = instance
+				result = append(result, instance)
+			}
+		}
+
+		for _, child := range dependency.children {
+			dependents[child] = append(dependents[child], dependency)
+			if !visited[child] {
+				visited[child] = true
+				stack = append(stack, child)
+			}
+		}
+	}
+
+	isReachable := make(map[*Dependency]bool)
+	for len(queue) != 0 {
+		dependency := queue[0]
+		queue = queue[1:]
+		if isReachable[dependency] {
+			continue
+		}
+
+		isReachable[dependency] = true
+		queue = append(queue, dependents[dependency]...)
+	}
+
+	// number of simple paths grows exponentially on diamond-heavy trees, so, enumeration is stopped (and not only recording) on limit
+	chainCount := 0
+	isTruncated := false
+	var path []*Dependency
+	onPath := make(map[*Dependency]bool)
+	var visit func(dependency *Dependency)
+	visit = func(dependency *Dependency) {
+		if chainCount >= maxWhyChains {
+			isTruncated = true
+			return
+		}
+
+		path = append(path, dependency)
+		onPath[dependency] = true
+
+		if len(path) > 1 && query.isMatched(dependency) {
+			instance := dirToInstance[dependency.alias+"@"+dependency.dir]
+			instance.Chains = append(instance.Chains, toWhyChain(path))
+			chainCount++
+		}
+
+		for _, child := range dependency.children {
+			// cycles are possible (a -> b -> a)
+			if !onPath[child] && isReachable[child] {
+				visit(child)
+			}
+		}
+
+		delete(onPath, dependency)
+		path = path[:len(path)-1]
+	}
+	visit(t.rootDependency)
+
+	if isTruncated {
+		for _, instance := range result {
+			instance.Truncated = true
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Version != result[j].Version {
+			return compareVersions(result[i].Version, result[j].Version) < 0
+		}
+		if result[i].Dir != result[j].Dir {
+			return result[i].Dir < result[j].Dir
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// semver order, string order if some version is not valid
+func compareVersions(a string, b string) int {
+	versionA, errA := semver.NewVersion(a)
+	versionB, errB := semver.NewVersion(b)
+	if errA != nil || errB != nil {
+		return strings.Compare(a, b)
+	}
+	return versionA.Compare(versionB)
+}
+
+func toWhyChain(path []*Dependency) []WhyLink {
+	result := make([]WhyLink, len(path))
+	for index, dependency := range path {
+		link := WhyLink{Name: dependency.alias, Version: dependency.Version}
+		if index == 0 {
+			// root
+			link.Name = dependency.Name
+		} else {
+			if dependency.Name != dependency.alias {
+				link.PackageName = dependency.Name
+			}
+			_, link.Optional = path[index-1].OptionalDependencies[dependency.alias]
+		}
+		result[index] = link
+	}
+	return result
+}
+
+// instances and dependencies that are not installed or don't support the target platform (chains to them are not known)
+func writeWhyResult(jsonWriter *jsoniter.Stream, instances []*WhyInstance, collector *Collector) {
+	if instances == nil {
+		instances = make([]*WhyInstance, 0)
+	}
+	jsonWriter.WriteObjectStart()
+	jsonWriter.WriteObjectField("instances")
+	jsonWriter.WriteVal(instances)
+	writeDependencyTreeWarnings(jsonWriter, collector)
+	jsonWriter.WriteObjectEnd()
+}
+
+func writeWhyText(writer io.Writer, query string, instances []*WhyInstance) error {
+	if len(instances) == 0 {
+		_, err := fmt.Fprintf(writer, "%s is not in the production dependencies\n", query)
+		return errors.WithStack(err)
+	}
+
+	for index, instance := range instances {
+		if index != 0 {
+			_, _ = fmt.Fprintln(writer)
+		}
+
+		_, err := fmt.Fprintf(writer, "%s@%s (%s)\n", instance.Name, instance.Version, instance.Dir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, chain := range instance.Chains {
+			links := make([]string, len(chain))
+			for linkIndex, link := range chain {
+				text := link.Name + "@" + link.Version
+				if len(link.PackageName) != 0 {
+					text = link.Name + " (" + link.PackageName + ")@" + link.Version
+				}
+				if link.Optional {
+					text += " [optional]"
+				}
+				links[linkIndex] = text
+			}
+			_, err = fmt.Fprintf(writer, "  %s\n", strings.Join(links, " > "))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		if instance.Truncated {
+			_, _ = fmt.Fprintln(writer, "  ... (chains limit is reached, not all chains are listed)")
+		}
+	}
+	return nil
+}
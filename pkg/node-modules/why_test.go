@@ -0,0 +1,84 @@
+package node_modules
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/log"
+	. "github.com/onsi/gomega"
+)
+
+func TestWhy(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	rootDir := path.Join(Dirname(), "workspace-demo")
+	collector, err := collect(&collectOptions{dir: filepath.Join(rootDir, "packages", "app")})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	query, err := parseWhyQuery("@demo/util@^0.1.0")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	instances := collector.why(query)
+	g.Expect(instances).To(HaveLen(2))
+	g.Expect(instances[0].Name).To(Equal("@demo/util"))
+	g.Expect(instances[0].Chains).To(Equal([][]WhyLink{{
+		{Name: "app", Version: "1.0.0"},
+		{Name: "@demo/lib", Version: "1.2.0"},
+		{Name: "@demo/util", Version: "0.1.0"},
+	}}))
+	g.Expect(instances[1].Chains[0][1]).To(Equal(WhyLink{Name: "renamed", PackageName: "@demo/util", Version: "0.1.0"}))
+
+	query, err = parseWhyQuery("@demo/util@2.0.0")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(collector.why(query)).To(BeEmpty())
+
+	var buffer bytes.Buffer
+	err = writeWhyText(&buffer, "@demo/util", instances)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(buffer.String()).To(ContainSubstring("  app@1.0.0 > renamed (@demo/util)@0.1.0\n"))
+}
+
+func TestWhyChainsLimit(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	// 2^40 chains - enumeration must be stopped on the limit
+	root := &Dependency{Name: "app", Version: "1.0.0", alias: "app", dir: "/app"}
+	targets := []*Dependency{
+		{Name: "target", Version: "9.0.0", alias: "target", dir: "/app/node_modules/a/node_modules/target"},
+		{Name: "target", Version: "10.0.0", alias: "target", dir: "/app/node_modules/target"},
+	}
+	layer := []*Dependency{root}
+	for i := 0; i < 40; i++ {
+		next := []*Dependency{
+			{Name: "a", Version: "1.0.0", alias: "a", dir: fmt.Sprintf("/app/node_modules/a%d", i)},
+			{Name: "b", Version: "1.0.0", alias: "b", dir: fmt.Sprintf("/app/node_modules/b%d", i)},
+		}
+		for _, dependency := range layer {
+			dependency.children = next
+		}
+		layer = next
+	}
+	for _, dependency := range layer {
+		dependency.children = targets
+	}
+
+	collector := &Collector{rootDependency: root}
+	query, err := parseWhyQuery("target")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	instances := collector.why(query)
+	g.Expect(instances).To(HaveLen(2))
+	// semver order, not string order
+	g.Expect(instances[0].Version).To(Equal("9.0.0"))
+	g.Expect(instances[1].Version).To(Equal("10.0.0"))
+	g.Expect(len(instances[0].Chains) + len(instances[1].Chains)).To(Equal(maxWhyChains))
+	g.Expect(instances[0].Truncated).To(BeTrue())
+	g.Expect(instances[1].Truncated).To(BeTrue())
+
+	g.Expect(compareVersions("1.0.0-beta.1", "1.0.0")).To(Equal(-1))
+	g.Expect(compareVersions("next", "1.0.0")).To(Equal(1))
+}
@@ -0,0 +1,303 @@
+package node_modules
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/develar/app-builder/pkg/log"
+	"github.com/develar/errors"
+	jsoniter "github.com/json-iterator/go"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// workspace:, link: and file: specs point to the directory instead of the registry package
+func isLocalSpec(spec string) bool {
+	return strings.HasPrefix(spec, "workspace:") || strings.HasPrefix(spec, "link:") || strings.HasPrefix(spec, "file:")
+}
+
+// nil if spec cannot be resolved to a directory (e.g. file: tarball), node_modules is used to resolve it in this case
+func (t *Collector) resolveLocalDependency(parentDir string, nodeModuleDir string, name string, spec string) (*Dependency, error) {
+	targetDir, err := t.resolveLocalSpec(parentDir, name, spec)
+	if err != nil || len(targetDir) == 0 {
+		return nil, err
+	}
+	targetDir = resolvePath(targetDir)
+
+	dependencyNameToDependency := t.NodeModuleDirToDependencyMap[nodeModuleDir]
+	if dependencyNameToDependency != nil {
+		dependency := (*dependencyNameToDependency)[name]
+		if dependency != nil && dependency.dir == targetDir {
+			return dependency, nil
+		}
+	}
+
+	if strings.HasPrefix(spec, "file:") {
+		// yarn classic copies file: directory into node_modules, copy is used as is
+		info, err := os.Lstat(filepath.Join(nodeModuleDir, name))
+		if err == nil && info.IsDir() {
+			return t.resolveDependency(nodeModuleDir, name, "")
+		}
+	}
+
+	dependency, err := readPackageJson(targetDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	if dependencyNameToDependency == nil {
+		m := make(map[string]*Dependency)
+		t.NodeModuleDirToDependencyMap[nodeModuleDir] = &m
+		dependencyNameToDependency = &m
+	}
+
+	// replaces stale node_modules entry if any - spec defines location
+	(*dependencyNameToDependency)[name] = dependency
+	dependency.alias = name
+	dependency.dir = targetDir
+	return dependency, nil
+}
+
+// empty if spec is not a local directory
+func (t *Collector) resolveLocalSpec(parentDir string, name string, spec string) (string, error) {
+	var path string
+	switch {
+	case strings.HasPrefix(spec, "link:"):
+		path = strings.TrimPrefix(spec, "link:")
+	case strings.HasPrefix(spec, "file:"):
+		path = strings.TrimPrefix(spec, "file:")
+	default:
+		reference := strings.TrimPrefix(spec, "workspace:")
+		if strings.HasPrefix(reference, ".") || filepath.IsAbs(reference) {
+			// yarn "workspace:../foo"
+			path = reference
+			break
+		}
+
+		// pnpm "workspace:@scope/real@*" alias
+		if len(reference) != 0 && (reference[0] == '@' || isLetter(reference[0])) {
+			targetName, _ := splitDescriptor(reference)
+			if targetName != reference {
+				name = targetName
+			}
+		}
+
+		workspacePackages, err := t.getWorkspacePackages()
+		if err != nil {
+			return "", err
+		}
+
+		dir := workspacePackages[name]
+		if len(dir) == 0 {
+			log.Debug("workspace package not found", zap.String("name", name), zap.String("spec", spec))
+		}
+		return dir, nil
+	}
+
+	path = filepath.FromSlash(strings.TrimPrefix(path, "//"))
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(parentDir, path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", errors.WithStack(err)
+	}
+
+	if !info.IsDir() {
+		// tarball
+		return "", nil
+	}
+	return path, nil
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// package name to dir, workspace root is found starting from the project dir
+func (t *Collector) getWorkspacePackages() (map[string]string, error) {
+	if t.workspacePackages != nil {
+		return t.workspacePackages, nil
+	}
+
+	t.workspacePackages = make(map[string]string)
+	if t.rootDependency == nil {
+		return t.workspacePackages, nil
+	}
+
+	rootDir, patterns, err := findWorkspaceRoot(t.rootDependency.dir)
+	if err != nil || len(rootDir) == 0 {
+		return t.workspacePackages, err
+	}
+
+	var excluded []string
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			excluded = append(excluded, filepath.Join(rootDir, filepath.FromSlash(strings.TrimPrefix(pattern, "!"))))
+		}
+	}
+
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			continue
+		}
+
+		dirs, err := expandWorkspacePattern(rootDir, pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dir := range dirs {
+			if isExcludedWorkspaceDir(dir, excluded) {
+				continue
+			}
+
+			packageJson, err := readPackageJson(dir)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+
+			if len(packageJson.Name) != 0 {
+				t.workspacePackages[packageJson.Name] = dir
+			}
+		}
+	}
+	return t.workspacePackages, nil
+}
+
+func isExcludedWorkspaceDir(dir string, excluded []string) bool {
+	for _, pattern := range excluded {
+		matched, _ := filepath.Match(pattern, dir)
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+type workspacePackageJson struct {
+	Workspaces jsoniter.RawMessage `json:"workspaces"`
+}
+
+type pnpmWorkspace struct {
+	Packages []string `yaml:"packages"`
+}
+
+// pnpm-workspace.yaml or package.json "workspaces" (array or object with "packages" field)
+func findWorkspaceRoot(projectDir string) (string, []string, error) {
+	dir := projectDir
+	for len(dir) != 0 {
+		data, err := os.ReadFile(filepath.Join(dir, "pnpm-workspace.yaml"))
+		if err == nil {
+			var workspace pnpmWorkspace
+			err = yaml.Unmarshal(data, &workspace)
+			if err != nil {
+				return "", nil, errors.WithMessage(err, "cannot parse "+filepath.Join(dir, "pnpm-workspace.yaml"))
+			}
+			return dir, workspace.Packages, nil
+		} else if !os.IsNotExist(err) {
+			return "", nil, errors.WithStack(err)
+		}
+
+		data, err = os.ReadFile(filepath.Join(dir, "package.json"))
+		if err == nil {
+			var packageJson workspacePackageJson
+			err = jsoniter.Unmarshal(data, &packageJson)
+			if err == nil && len(packageJson.Workspaces) != 0 {
+				var patterns []string
+				if jsoniter.Unmarshal(packageJson.Workspaces, &patterns) != nil {
+					var object struct {
+						Packages []string `json:"packages"`
+					}
+					_ = jsoniter.Unmarshal(packageJson.Workspaces, &object)
+					patterns = object.Packages
+				}
+				return dir, patterns, nil
+			}
+		} else if !os.IsNotExist(err) {
+			return "", nil, errors.WithStack(err)
+		}
+
+		dir = getParentDir(dir)
+	}
+	return "", nil, nil
+}
+
+// "**" matches any number of dirs (node_modules are skipped), segments after it are matched against the end of the path below it
+func expandWorkspacePattern(rootDir string, pattern string) ([]string, error) {
+	pattern = strings.TrimSuffix(filepath.FromSlash(pattern), string(filepath.Separator))
+	index := strings.Index(pattern, "**")
+	if index < 0 {
+		result, err := filepath.Glob(filepath.Join(rootDir, pattern))
+		return result, errors.WithStack(err)
+	}
+
+	var suffix []string
+	if rest := strings.Trim(pattern[index+2:], string(filepath.Separator)); len(rest) != 0 {
+		suffix = strings.Split(rest, string(filepath.Separator))
+	}
+	for _, segment := range suffix {
+		if strings.Contains(segment, "**") {
+			return nil, errors.Errorf("workspace pattern %q is not supported: only one ** is allowed", pattern)
+		}
+	}
+
+	baseDir := filepath.Join(rootDir, pattern[:index])
+	var result []string
+	err := filepath.WalkDir(baseDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if !entry.IsDir() {
+			return nil
+		}
+		if entry.Name() == "node_modules" || (strings.HasPrefix(entry.Name(), ".") && path != baseDir) {
+			return filepath.SkipDir
+		}
+		if path == baseDir {
+			return nil
+		}
+
+		isMatched, err := isPathSuffixMatched(path[len(baseDir):], suffix)
+		if err != nil {
+			return err
+		}
+		if isMatched {
+			result = append(result, path)
+		}
+		return nil
+	})
+	return result, errors.WithStack(err)
+}
+
+// last segments of relative path are matched against suffix patterns
+func isPathSuffixMatched(relativePath string, suffix []string) (bool, error) {
+	segments := strings.Split(strings.Trim(relativePath, string(filepath.Separator)), string(filepath.Separator))
+	if len(segments) < len(suffix) {
+		return false, nil
+	}
+
+	segments = segments[len(segments)-len(suffix):]
+	for index, pattern := range suffix {
+		isMatched, err := filepath.Match(pattern, segments[index])
+		if err != nil || !isMatched {
+			return false, errors.WithStack(err)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,97 @@
+package node_modules
+
+import (
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/develar/app-builder/pkg/log"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+)
+
+func TestReadDependencyTreeForWorkspace(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	rootDir := path.Join(Dirname(), "workspace-demo")
+	dir := filepath.Join(rootDir, "packages", "app")
+	collector, err := collect(&collectOptions{dir: dir})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(collector.unresolvedDependencies).To(BeEmpty())
+
+	appDeps := *collector.NodeModuleDirToDependencyMap[filepath.Join(dir, "node_modules")]
+	g.Expect(appDeps).To(HaveKey("@demo/lib"))
+	g.Expect(appDeps["@demo/lib"].dir).To(Equal(filepath.Join(rootDir, "packages", "lib")))
+	g.Expect(appDeps["helper"].dir).To(Equal(filepath.Join(rootDir, "tools", "helper")))
+	g.Expect(appDeps["assets"].dir).To(Equal(filepath.Join(rootDir, "tools", "assets")))
+	g.Expect(appDeps["renamed"].Name).To(Equal("@demo/util"))
+	g.Expect(appDeps["renamed"].dir).To(Equal(filepath.Join(rootDir, "packages", "util")))
+
+	libDeps := *collector.NodeModuleDirToDependencyMap[filepath.Join(rootDir, "packages", "lib", "node_modules")]
+	g.Expect(libDeps["@demo/util"].Version).To(Equal("0.1.0"))
+
+	collector.processHoistDependencyMap()
+	g.Expect(collector.HoiestedDependencyMap).To(HaveLen(5))
+	g.Expect(collector.HoiestedDependencyMap["@demo/util"].dir).To(Equal(filepath.Join(rootDir, "packages", "util")))
+	g.Expect(collector.HoiestedDependencyMap["@demo/lib"].conflictDependency).To(BeNil())
+}
+
+func TestReadDependencyTreeForPnpmWorkspace(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	rootDir := path.Join(Dirname(), "pnpm-workspace-demo")
+	collector, err := collect(&collectOptions{dir: filepath.Join(rootDir, "apps", "app")})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	collector.processHoistDependencyMap()
+	g.Expect(collector.HoiestedDependencyMap["lib-a"].dir).To(Equal(filepath.Join(rootDir, "libs", "nested", "lib-a")))
+}
+
+func TestReadDependencyTreeForAlias(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log.InitLogger()
+
+	dir := path.Join(Dirname(), "alias-demo")
+	collector, err := collect(&collectOptions{dir: dir})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(collector.unresolvedDependencies).To(BeEmpty())
+
+	// stale/node_modules/ms-old is not the alias target, hoisted one is used
+	g.Expect(collector.NodeModuleDirToDependencyMap).NotTo(HaveKey(filepath.Join(dir, "node_modules", "stale", "node_modules")))
+
+	collector.processHoistDependencyMap()
+	msModule := collector.HoiestedDependencyMap["ms-old"]
+	g.Expect(msModule.Name).To(Equal("ms"))
+	g.Expect(msModule.dir).To(Equal(filepath.Join(dir, "node_modules", "ms-old")))
+	g.Expect(collector.HoiestedDependencyMap["stale"].conflictDependency).To(BeNil())
+}
+
+func TestExpandWorkspacePattern(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir := t.TempDir()
+	for _, name := range []string{"a/app", "b/nested/app", "b/nested/app-test", "c", "d/app/node_modules/app"} {
+		writeTestFile(g, filepath.Join(dir, "packages", filepath.FromSlash(name), "package.json"), `{}`)
+	}
+	expand := func(pattern string) []string {
+		dirs, err := expandWorkspacePattern(dir, pattern)
+		g.Expect(err).NotTo(HaveOccurred())
+		return lo.Map(dirs, func(it string, _ int) string {
+			relativePath, _ := filepath.Rel(dir, it)
+			return filepath.ToSlash(relativePath)
+		})
+	}
+
+	g.Expect(expand("packages/**/app")).To(Equal([]string{"packages/a/app", "packages/b/nested/app", "packages/d/app"}))
+	g.Expect(expand("packages/**/app-*")).To(Equal([]string{"packages/b/nested/app-test"}))
+	g.Expect(expand("packages/**/nested/app")).To(Equal([]string{"packages/b/nested/app"}))
+	g.Expect(expand("packages/**")).To(Equal([]string{
+		"packages/a", "packages/a/app", "packages/b", "packages/b/nested", "packages/b/nested/app", "packages/b/nested/app-test", "packages/c", "packages/d", "packages/d/app",
+	}))
+	g.Expect(expand("packages/*")).To(Equal([]string{"packages/a", "packages/b", "packages/c", "packages/d"}))
+
+	_, err := expandWorkspacePattern(dir, "packages/**/src/**")
+	g.Expect(err).To(HaveOccurred())
+}